@@ -0,0 +1,118 @@
+package chat
+
+import (
+	"encoding/hex"
+	"sync"
+
+	"github.com/keybase/client/go/protocol/chat1"
+)
+
+// BodyHashChecker lets a Boxer apply a configurable policy to a message
+// whose body hash was already claimed by an earlier message in the same
+// conversation. A legitimate sender never reuses a hash -- it's called from
+// verifyMessageHeader only after a message has already passed its own
+// individual body-hash-matches-ciphertext check, so every hash reaching
+// Check is one this Boxer has already vouched for on its own terms. A
+// repeat is most plausibly a server (or other man in the middle) replaying
+// an old, captured ciphertext under a new message ID, which is why it's
+// worth a second, cross-message opinion.
+type BodyHashChecker interface {
+	// Check is told that msgID in conv claims bodyHash. self is non-nil if
+	// msgID itself should be rejected. alsoSuspect lists the message IDs of
+	// any earlier claimants of the same hash that a stricter caller might
+	// want to distrust too; a checker scoped to a single Check call has no
+	// way to retroactively invalidate a message it already returned
+	// successfully, so this package only logs alsoSuspect today (see
+	// verifyMessageHeader) rather than acting on it -- the earlier message
+	// itself is not revoked or re-marked.
+	Check(conv chat1.ConversationIDTriple, msgID chat1.MessageID, bodyHash chat1.Hash) (self UnboxingError, alsoSuspect []chat1.MessageID)
+}
+
+// NoopBodyHashChecker is the default BodyHashChecker: it never flags a
+// repeated body hash, leaving the existing per-message hash-matches-
+// ciphertext check as the only defense against a replayed ciphertext --
+// which a byte-for-byte replay passes by construction. This matches this
+// package's behavior before BodyHashChecker existed.
+type NoopBodyHashChecker struct{}
+
+func (NoopBodyHashChecker) Check(conv chat1.ConversationIDTriple, msgID chat1.MessageID, bodyHash chat1.Hash) (UnboxingError, []chat1.MessageID) {
+	return nil, nil
+}
+
+// bodyHashClaimKey identifies a body hash claim within a single
+// conversation. Two different conversations independently claiming the
+// same hash is unremarkable -- nothing ties their senders or keys together
+// -- so claims are only ever compared within the conversation that made
+// them.
+type bodyHashClaimKey struct {
+	conv string
+	hash string
+}
+
+func newBodyHashClaimKey(conv chat1.ConversationIDTriple, bodyHash chat1.Hash) bodyHashClaimKey {
+	return bodyHashClaimKey{
+		conv: hex.EncodeToString(conv.Hash()),
+		hash: hex.EncodeToString(bodyHash),
+	}
+}
+
+// DuplicateInvalidatesChecker is a BodyHashChecker that, on finding a body
+// hash claimed by two different message IDs in the same conversation,
+// rejects the second claimant outright and names the first as alsoSuspect --
+// neither message's sender can be trusted to have actually sent it, since
+// one of them is a replay of the other and this checker has no way to tell
+// which. This is stricter than NoopBodyHashChecker's default of trusting
+// whichever claimant is unboxed, and is meant for TLFs where letting a
+// replayed message slip through as if it were new is worse than flagging
+// both.
+//
+// The first claim of a given hash is remembered, not distrusted, until a
+// second one arrives -- so Check's first call for any given hash always
+// succeeds, and it's the second (and any later) call for that hash that
+// fails. As of this writing verifyMessageHeader only logs alsoSuspect
+// rather than acting on it, so in practice the first message stays
+// unboxed; callers that need the stronger guarantee of retroactively
+// invalidating it will need to act on alsoSuspect themselves.
+type DuplicateInvalidatesChecker struct {
+	sync.Mutex
+	claims map[bodyHashClaimKey]chat1.MessageID
+}
+
+func NewDuplicateInvalidatesChecker() *DuplicateInvalidatesChecker {
+	return &DuplicateInvalidatesChecker{
+		claims: make(map[bodyHashClaimKey]chat1.MessageID),
+	}
+}
+
+func (c *DuplicateInvalidatesChecker) Check(conv chat1.ConversationIDTriple, msgID chat1.MessageID, bodyHash chat1.Hash) (UnboxingError, []chat1.MessageID) {
+	key := newBodyHashClaimKey(conv, bodyHash)
+
+	c.Lock()
+	defer c.Unlock()
+
+	first, claimed := c.claims[key]
+	if !claimed {
+		c.claims[key] = msgID
+		return nil, nil
+	}
+	if first == msgID {
+		// the same message unboxed twice (e.g. a retry), not a replay
+		return nil, nil
+	}
+	return NewPermanentUnboxingError(DuplicateBodyHashError{
+		MessageID:      msgID,
+		FirstMessageID: first,
+	}), []chat1.MessageID{first}
+}
+
+// DuplicateBodyHashError is returned by DuplicateInvalidatesChecker when a
+// message's body hash was already claimed by a different message ID in the
+// same conversation.
+type DuplicateBodyHashError struct {
+	MessageID      chat1.MessageID
+	FirstMessageID chat1.MessageID
+}
+
+func (e DuplicateBodyHashError) Error() string {
+	return "chat body hash duplicated by an earlier message in this conversation"
+}
@@ -4,15 +4,18 @@
 package chat
 
 import (
+	"bytes"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"golang.org/x/crypto/nacl/secretbox"
 	"golang.org/x/net/context"
+	"golang.org/x/sync/errgroup"
 
 	"github.com/keybase/client/go/chat/utils"
 	"github.com/keybase/client/go/libkb"
@@ -20,7 +23,7 @@ import (
 	"github.com/keybase/client/go/protocol/chat1"
 	"github.com/keybase/client/go/protocol/gregor1"
 	"github.com/keybase/client/go/protocol/keybase1"
-	"github.com/keybase/go-codec/codec"
+	"github.com/keybase/clockwork"
 )
 
 var publicCryptKey keybase1.CryptKey
@@ -37,20 +40,214 @@ func init() {
 type Boxer struct {
 	utils.DebugLabeler
 
-	tlf    func() keybase1.TlfInterface
-	hashV1 func(data []byte) chat1.Hash
-	sign   func(msg []byte, kp libkb.NaclSigningKeyPair, prefix libkb.SignaturePrefix) (chat1.SignatureInfo, error) // replaceable for testing
+	tlf            func() keybase1.TlfInterface
+	hashV1         func(data []byte) chat1.Hash
+	sign           func(msg []byte, kp libkb.NaclSigningKeyPair, prefix libkb.SignaturePrefix) (chat1.SignatureInfo, error) // replaceable for testing
+	merkleRoot     func(ctx context.Context) (*chat1.MerkleRoot, error)                                                     // replaceable for testing; defaults to latestMerkleRoot
+	senderKeyCache *senderKeyCache
+
+	// bodyHashChecker decides what happens when a message's body hash was
+	// already claimed by an earlier message in the same conversation.
+	// Defaults to NoopBodyHashChecker; see SetBodyHashChecker.
+	bodyHashChecker BodyHashChecker
+
+	// requireCurrentlyActiveSenderKey, when set, makes UnboxMessage reject
+	// a message whose signing key isn't among the sender's
+	// currently-active sigchain keys -- stronger than the default check
+	// (ValidSenderKey), which only requires the key to have been valid at
+	// the message's ctime. See SetRequireCurrentlyActiveSenderKey.
+	requireCurrentlyActiveSenderKey bool
+
+	// includeSignedHeaderBytes, when set, makes UnboxMessage populate
+	// MessageUnboxedValid.HeaderSignedBytes with the exact bytes that were
+	// signed to produce HeaderSignature, so an external auditor can
+	// reproduce the signature check without reimplementing this package's
+	// header marshaling. See SetIncludeSignedHeaderBytes.
+	includeSignedHeaderBytes bool
+
+	// unboxMessagesConcurrency is the worker count UnboxMessages fans out
+	// across. Zero (the default for a freshly-constructed Boxer) means
+	// DefaultUnboxMessagesConcurrency; see SetUnboxMessagesConcurrency.
+	unboxMessagesConcurrency int
+
+	// keyFinder, when set, is used for every key lookup in place of the
+	// context-based CtxKeyFinder. See SetKeyFinder.
+	keyFinder KeyFinder
+
+	// checkMerkleRootFreshness, when set, makes UnboxMessage reject a
+	// message whose claimed merkle root is far ahead of the latest root
+	// this client has cached, on the theory that a real message can't be
+	// anchored to a root this client hasn't heard of yet. Off by default
+	// since a client that's fallen behind on its own merkle root cache
+	// would otherwise start rejecting perfectly good messages. See
+	// SetCheckMerkleRootFreshness.
+	checkMerkleRootFreshness bool
+
+	// logUnboxTiming, when set, makes UnboxMessage log how long each of
+	// its phases took for every message, not just accumulate the batch
+	// totals UnboxMessages always logs. Off by default, since a busy
+	// thread's worth of per-message timing lines is too noisy for normal
+	// operation. See SetLogUnboxTiming.
+	logUnboxTiming bool
+
+	// clock is used for timing UnboxMessage's phases, replaceable for
+	// testing. See SetClock.
+	clock clockwork.Clock
+
+	// unboxRetries is how many additional times UnboxMessages retries a
+	// single message's UnboxMessage call after a transient error, before
+	// giving up and aborting the batch. Zero (the default) disables
+	// retrying: a transient error aborts the batch immediately, same as
+	// before retries existed. See SetUnboxRetries.
+	unboxRetries int
+
+	// unboxRetryBaseDelay is the delay before the first retry a transient
+	// error triggers; each subsequent retry on the same message doubles
+	// it. See SetUnboxRetries.
+	unboxRetryBaseDelay time.Duration
+
 	libkb.Contextified
 }
 
-func NewBoxer(g *libkb.GlobalContext, tlf func() keybase1.TlfInterface) *Boxer {
-	return &Boxer{
-		DebugLabeler: utils.NewDebugLabeler(g, "Boxer", false),
-		tlf:          tlf,
-		hashV1:       hashSha256V1,
-		sign:         sign,
-		Contextified: libkb.NewContextified(g),
+// SetRequireCurrentlyActiveSenderKey configures whether UnboxMessage holds
+// every message's signing key to the stricter "still active today"
+// standard (CurrentlyActiveSenderKey) rather than the default "was valid
+// when sent" standard (ValidSenderKey). A key that was valid at a
+// message's ctime but has since been revoked passes the default check but
+// fails this one; enabling it is for deployments that only want to show
+// messages they could still cryptographically re-verify right now.
+func (b *Boxer) SetRequireCurrentlyActiveSenderKey(require bool) {
+	b.requireCurrentlyActiveSenderKey = require
+}
+
+// SetIncludeSignedHeaderBytes configures whether UnboxMessage retains the
+// exact signed-header bytes it already computes and verifies internally,
+// exposing them via MessageUnboxedValid.HeaderSignedBytes. It's off by
+// default since it adds memory per unboxed message that most callers
+// never look at; turn it on for tooling that needs to independently
+// re-verify a message's signature outside this package.
+func (b *Boxer) SetIncludeSignedHeaderBytes(include bool) {
+	b.includeSignedHeaderBytes = include
+}
+
+// SetKeyFinder configures a KeyFinder for this Boxer to use in place of
+// CtxKeyFinder(ctx) on every call that looks up TLF crypt keys. This is for
+// tools that want to unbox against a fixed, pre-populated key set -- e.g. a
+// cached key bundle loaded from disk -- without wiring up a context that
+// carries one, and without ever touching the TLF interface, online or not.
+// Pass nil (the default) to keep using CtxKeyFinder.
+func (b *Boxer) SetKeyFinder(kf KeyFinder) {
+	b.keyFinder = kf
+}
+
+// SetCheckMerkleRootFreshness configures whether UnboxMessage rejects a
+// message whose claimed merkle root looks fabricated -- specifically, one
+// whose seqno is far beyond the latest root this client has cached (see
+// maxMerkleRootSeqnoSlack). It's off by default: a client that hasn't
+// refreshed its own cached root in a while would otherwise start
+// rejecting legitimate, more-recently-anchored messages.
+func (b *Boxer) SetCheckMerkleRootFreshness(check bool) {
+	b.checkMerkleRootFreshness = check
+}
+
+// SetBodyHashChecker configures the policy UnboxMessage applies when a
+// message's body hash was already claimed by an earlier message in the
+// same conversation. Defaults to NoopBodyHashChecker, which never flags a
+// repeat; pass a DuplicateInvalidatesChecker for TLFs where a replayed
+// ciphertext must not slip through under a new message ID. That checker
+// rejects every later claimant of a body hash; the message that claimed it
+// first still unboxes, and its ID is only logged as also suspect.
+func (b *Boxer) SetBodyHashChecker(checker BodyHashChecker) {
+	b.bodyHashChecker = checker
+}
+
+// SetLogUnboxTiming configures whether UnboxMessage logs a per-phase
+// timing breakdown (key find, body decrypt, header decrypt, verify,
+// sender lookup) for every message it unboxes. UnboxMessages always logs
+// the batch's accumulated totals once at completion regardless of this
+// setting; this only controls the noisier per-message lines, for when
+// even that isn't enough detail to diagnose a slow thread load.
+func (b *Boxer) SetLogUnboxTiming(log bool) {
+	b.logUnboxTiming = log
+}
+
+// SetClock configures the clock UnboxMessage uses to time its phases, in
+// place of the real wall clock. Tests use this to control what duration
+// the timing log lines report.
+func (b *Boxer) SetClock(clock clockwork.Clock) {
+	b.clock = clock
+}
+
+// SetUnboxRetries configures UnboxMessages to retry a message up to
+// retries additional times, with exponential backoff starting at
+// baseDelay and doubling each attempt, before giving up on it -- but only
+// for a transient error (rekey, network hiccup); a permanent error still
+// short-circuits to a MessageUnboxedError value on the first try, same as
+// always, since retrying it would just fail again. retries <= 0 disables
+// retrying, which is the default: a transient error aborts the whole
+// batch immediately.
+func (b *Boxer) SetUnboxRetries(retries int, baseDelay time.Duration) {
+	b.unboxRetries = retries
+	b.unboxRetryBaseDelay = baseDelay
+}
+
+// unboxMessageWithRetries is UnboxMessage, but retries a transient error
+// up to b.unboxRetries additional times with exponential backoff (see
+// SetUnboxRetries) before returning it. A permanent error is returned
+// immediately, unretried. The wait between retries uses b.clock, so it's
+// replaceable for testing, and is abandoned early if ctx is canceled.
+func (b *Boxer) unboxMessageWithRetries(ctx context.Context, boxed chat1.MessageBoxed, finalizeInfo *chat1.ConversationFinalizeInfo) (chat1.MessageUnboxed, UnboxingError) {
+	delay := b.unboxRetryBaseDelay
+	for attempt := 0; ; attempt++ {
+		decmsg, ierr := b.UnboxMessage(ctx, boxed, finalizeInfo, nil, nil)
+		if ierr == nil || ierr.IsPermanent() || attempt >= b.unboxRetries {
+			return decmsg, ierr
+		}
+
+		b.Debug(ctx, "unboxMessageWithRetries: msgID: %d: transient error on attempt %d, retrying in %s: %s",
+			boxed.GetMessageID(), attempt+1, delay, ierr)
+		select {
+		case <-b.clock.After(delay):
+		case <-ctx.Done():
+			return decmsg, ierr
+		}
+		delay *= 2
+	}
+}
+
+// keyFinderFor returns b.keyFinder if one was configured via SetKeyFinder,
+// falling back to CtxKeyFinder(ctx) otherwise.
+func (b *Boxer) keyFinderFor(ctx context.Context) KeyFinder {
+	if b.keyFinder != nil {
+		return b.keyFinder
 	}
+	return CtxKeyFinder(ctx)
+}
+
+// errNoTlfInterfaceConfigured is returned by UnboxMessage and BoxMessage
+// when this Boxer was constructed with a nil tlf, since both need it to
+// resolve crypt keys. The *WithKeys entry points below don't use tlf at
+// all, so they work fine on a Boxer constructed this way.
+const errNoTlfInterfaceConfigured = "no TLF interface configured for this Boxer"
+
+// NewBoxer creates a Boxer. tlf may be nil for offline tooling that only
+// calls BoxMessageWithKeys/UnboxMessageWithKeys: those take the crypt key
+// directly and never need to resolve one. UnboxMessage and BoxMessage,
+// which do need resolution, return a clear error instead of panicking if
+// called on a Boxer with no tlf configured.
+func NewBoxer(g *libkb.GlobalContext, tlf func() keybase1.TlfInterface) *Boxer {
+	b := &Boxer{
+		DebugLabeler:    utils.NewDebugLabeler(g, "Boxer", false),
+		tlf:             tlf,
+		hashV1:          hashSha256V1,
+		sign:            sign,
+		senderKeyCache:  newSenderKeyCache(),
+		bodyHashChecker: NoopBodyHashChecker{},
+		clock:           clockwork.NewRealClock(),
+		Contextified:    libkb.NewContextified(g),
+	}
+	b.merkleRoot = b.latestMerkleRoot
+	return b
 }
 
 func (b *Boxer) log() logger.Logger {
@@ -64,6 +261,7 @@ func (b *Boxer) makeErrorMessage(msg chat1.MessageBoxed, err UnboxingError) chat
 		MessageID:   msg.GetMessageID(),
 		MessageType: msg.GetMessageType(),
 		Ctime:       msg.ServerHeader.Ctime,
+		Details:     err.ExportDetails(),
 	})
 }
 
@@ -73,14 +271,86 @@ func (b *Boxer) makeErrorMessage(msg chat1.MessageBoxed, err UnboxingError) chat
 // Returns (_, err) for non-permanent errors, and (MessageUnboxedError, nil) for permanent errors.
 // Permanent errors can be cached and must be treated as a value to deal with.
 // Whereas temporary errors are transient failures.
-func (b *Boxer) UnboxMessage(ctx context.Context, boxed chat1.MessageBoxed, finalizeInfo *chat1.ConversationFinalizeInfo) (chat1.MessageUnboxed, UnboxingError) {
+//
+// asOf, when non-nil, is used in place of msg.ServerHeader.Ctime as the
+// authoritative time against which the sender's key validity window is
+// checked. This lets a caller who trusts some external timeline (e.g. one
+// derived from merkle history) catch a message that a colluding server
+// backdated to land before a key revocation. When asOf is nil, ctime is
+// used, as before.
+//
+// expectedOutboxIDs, when non-empty, is used to reconcile this message
+// against a caller's own outbox: if the header carries an OutboxID (i.e.
+// this was sent by the local user) and it isn't one of expectedOutboxIDs,
+// the returned MessageUnboxedValid.OutboxIDMismatch is set. This is a
+// sanity check, not a security check -- a mismatch doesn't fail unboxing,
+// it just flags that the caller's optimistic-send bookkeeping and the
+// server's confirmed copy of the message disagree about which outbox
+// message this is. Callers that don't send messages, or don't need this
+// reconciliation, can pass nil.
+//
+// The TLF ID resolved from the header's TLF name, public flag, and
+// finalizeInfo is checked against boxed.ClientHeader.Conv.Tlfid, the TLF
+// ID the server attached to this message's conversation triple; a
+// mismatch is a permanent TLFIDMismatchError. This catches a server that
+// routes a message into, or claims it came from, the wrong TLF -- which
+// the header signature alone wouldn't catch, since it covers the header's
+// own fields, not the triple the server sends alongside it.
+//
+// A message whose header carries an EphemeralLifetime is ephemeral:
+// MessageUnboxedValid.IsEphemeral is set, and Etime reports when it
+// explodes (ServerHeader.Ctime + EphemeralLifetime). Once the current time
+// passes Etime, MessageBody comes back empty -- the rest of the message
+// (ClientHeader, signatures, sender info) is still returned, the same way
+// a superseded message's deleted body is handled.
+//
+// ctx is checked for cancellation before each of its major phases (key
+// find, merkle root freshness, decrypt/verify, sender info lookup), so a
+// caller that gives up partway through doesn't leave this running every
+// remaining step on a link that's no longer being waited on. A canceled
+// ctx comes back as a transient UnboxingError wrapping ctx.Err().
+func (b *Boxer) UnboxMessage(ctx context.Context, boxed chat1.MessageBoxed, finalizeInfo *chat1.ConversationFinalizeInfo, asOf *gregor1.Time, expectedOutboxIDs []chat1.OutboxID) (chat1.MessageUnboxed, UnboxingError) {
+	if b.tlf == nil {
+		return chat1.MessageUnboxed{}, NewPermanentUnboxingError(errors.New(errNoTlfInterfaceConfigured))
+	}
+
+	if err := ctx.Err(); err != nil {
+		return chat1.MessageUnboxed{}, NewTransientUnboxingError(err)
+	}
+
+	var timings unboxPhaseTimings
+	keyFindStart := b.clock.Now()
 	tlfName := boxed.ClientHeader.TLFNameExpanded(finalizeInfo)
 	tlfPublic := boxed.ClientHeader.TlfPublic
-	keys, err := CtxKeyFinder(ctx).Find(ctx, b.tlf(), tlfName, tlfPublic)
+	keys, err := b.keyFinderFor(ctx).Find(ctx, b.tlf(), tlfName, tlfPublic)
 	if err != nil {
 		// transient error. Rekey errors come through here
 		return chat1.MessageUnboxed{}, NewTransientUnboxingError(err)
 	}
+	timings.keyFind = b.clock.Now().Sub(keyFindStart)
+
+	resolvedTlfID := chat1.TLFID(keys.NameIDBreaks.TlfID.ToBytes())
+	if !resolvedTlfID.Eq(boxed.ClientHeader.Conv.Tlfid) {
+		return b.makeErrorMessage(boxed, NewPermanentUnboxingError(TLFIDMismatchError{
+			TlfName:  tlfName,
+			Expected: resolvedTlfID,
+			Actual:   boxed.ClientHeader.Conv.Tlfid,
+		})), nil
+	}
+
+	if err := checkCryptKeysForDuplicateGenerations(keys.CryptKeys); err != nil {
+		return chat1.MessageUnboxed{}, NewTransientUnboxingError(err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return chat1.MessageUnboxed{}, NewTransientUnboxingError(err)
+	}
+
+	if b.checkMerkleRootFreshness {
+		if ierr := b.verifyMerkleRootFreshness(ctx, boxed.ClientHeader.MerkleRoot); ierr != nil {
+			return b.makeErrorMessage(boxed, ierr), nil
+		}
+	}
 
 	var matchKey *keybase1.CryptKey
 	for _, key := range keys.CryptKeys {
@@ -95,7 +365,11 @@ func (b *Boxer) UnboxMessage(ctx context.Context, boxed chat1.MessageBoxed, fina
 		return chat1.MessageUnboxed{}, NewTransientUnboxingError(err)
 	}
 
-	umwkr, ierr := b.unboxMessageWithKey(ctx, boxed, matchKey)
+	if err := ctx.Err(); err != nil {
+		return chat1.MessageUnboxed{}, NewTransientUnboxingError(err)
+	}
+
+	umwkr, ierr := b.unboxMessageWithKey(ctx, boxed, matchKey, asOf)
 	if ierr != nil {
 		b.Debug(ctx, "failed to unbox message: msgID: %d err: %s", boxed.ServerHeader.MessageID,
 			ierr.Error())
@@ -106,6 +380,11 @@ func (b *Boxer) UnboxMessage(ctx context.Context, boxed chat1.MessageBoxed, fina
 	}
 	pt := umwkr.messagePlaintext
 
+	if err := ctx.Err(); err != nil {
+		return chat1.MessageUnboxed{}, NewTransientUnboxingError(err)
+	}
+
+	senderLookupStart := b.clock.Now()
 	username, deviceName, deviceType, err := b.getSenderInfoLocal(ctx, pt.ClientHeader)
 	if err != nil {
 		b.Debug(ctx, "unable to fetch sender and device informaton: UID: %s deviceID: %s",
@@ -117,34 +396,179 @@ func (b *Boxer) UnboxMessage(ctx context.Context, boxed chat1.MessageBoxed, fina
 		}
 	}
 
+	timings.bodyDecrypt = umwkr.timings.bodyDecrypt
+	timings.headerDecrypt = umwkr.timings.headerDecrypt
+	timings.verify = umwkr.timings.verify
+	timings.senderLookup = b.clock.Now().Sub(senderLookupStart)
+
+	if b.logUnboxTiming {
+		b.Debug(ctx, "UnboxMessage: timings: msgID: %d keyFind: %s bodyDecrypt: %s headerDecrypt: %s verify: %s senderLookup: %s",
+			boxed.ServerHeader.MessageID, timings.keyFind, timings.bodyDecrypt, timings.headerDecrypt,
+			timings.verify, timings.senderLookup)
+	}
+	if batchTimings := ctxUnboxBatchTimings(ctx); batchTimings != nil {
+		batchTimings.add(timings)
+	}
+
+	messageBody := pt.MessageBody
+	isEphemeral := pt.ClientHeader.EphemeralLifetime != nil
+	var etime *gregor1.Time
+	if isEphemeral {
+		e := boxed.ServerHeader.Ctime + gregor1.Time(*pt.ClientHeader.EphemeralLifetime)
+		etime = &e
+		if gregor1.ToTime(b.clock.Now()).After(e) {
+			// past its expiration: drop the body, but keep clientHeader and
+			// every other bit of metadata, the same way skipBodyVerification
+			// does for a superseded message's empty body.
+			messageBody = chat1.MessageBody{}
+		}
+	}
+
 	return chat1.NewMessageUnboxedWithValid(chat1.MessageUnboxedValid{
 		ClientHeader:          pt.ClientHeader,
 		ServerHeader:          *boxed.ServerHeader,
-		MessageBody:           pt.MessageBody,
+		MessageBody:           messageBody,
 		SenderUsername:        username,
 		SenderDeviceName:      deviceName,
 		SenderDeviceType:      deviceType,
+		SenderDeviceTypeEnum:  b.parseSenderDeviceType(ctx, deviceType),
 		HeaderHash:            umwkr.headerHash,
 		HeaderSignature:       umwkr.headerSignature,
+		HeaderSignedBytes:     umwkr.headerSignedBytes,
 		SenderDeviceRevokedAt: umwkr.senderDeviceRevokedAt,
+		HasMerkleRoot:         pt.ClientHeader.MerkleRoot != nil,
+		OutboxIDMismatch:      outboxIDMismatch(pt.ClientHeader.OutboxID, expectedOutboxIDs),
+		IsEphemeral:           isEphemeral,
+		Etime:                 etime,
+		AssuranceLevel: deriveAssuranceLevel(assuranceInputs{
+			senderDeviceRevokedAt: umwkr.senderDeviceRevokedAt,
+			hasMerkleRoot:         pt.ClientHeader.MerkleRoot != nil,
+			senderUnresolved:      username == "",
+			outboxIDMismatch:      outboxIDMismatch(pt.ClientHeader.OutboxID, expectedOutboxIDs),
+		}),
 	}), nil
 
 }
 
+// assuranceInputs collects the individual verification results
+// deriveAssuranceLevel combines into a single AssuranceLevel. As further
+// TODO checks land (e.g. something stronger than merkle presence), add a
+// field here and account for it in deriveAssuranceLevel, rather than
+// growing UnboxMessage's own parameter list.
+type assuranceInputs struct {
+	senderDeviceRevokedAt *gregor1.Time
+	hasMerkleRoot         bool
+	senderUnresolved      bool
+	outboxIDMismatch      bool
+}
+
+// deriveAssuranceLevel computes the single "how much should I trust this"
+// signal UIs want, from the individual results UnboxMessage already
+// computes:
+//
+//   - SUSPECT: the sender's device was revoked (possibly after sending,
+//     but we can't be sure it was before), or the sender's identity
+//     couldn't be resolved at all. Either way, we don't know who really
+//     sent this.
+//   - REDUCED: the sender checks out, but the message isn't merkle-
+//     anchored (so its timing can't be checked against key revocation
+//     history), or it claims to be this client's own outbox send but
+//     doesn't match any outbox entry the caller expected. Probably fine,
+//     but missing a check a fully-verified message has.
+//   - FULL: none of the above -- every check that ran came back clean.
+//
+// SUSPECT always wins over REDUCED: an unresolved or revoked sender is a
+// reason to distrust the message outright, regardless of what else is or
+// isn't verified about it.
+func deriveAssuranceLevel(in assuranceInputs) chat1.AssuranceLevel {
+	if in.senderDeviceRevokedAt != nil || in.senderUnresolved {
+		return chat1.AssuranceLevel_SUSPECT
+	}
+	if !in.hasMerkleRoot || in.outboxIDMismatch {
+		return chat1.AssuranceLevel_REDUCED
+	}
+	return chat1.AssuranceLevel_FULL
+}
+
+// outboxIDMismatch reports whether headerOutboxID is set but isn't one of
+// expected. An empty expected (the common case: the caller isn't doing
+// outbox reconciliation, or this message has no OutboxID at all) is never
+// a mismatch.
+func outboxIDMismatch(headerOutboxID *chat1.OutboxID, expected []chat1.OutboxID) bool {
+	if headerOutboxID == nil || len(expected) == 0 {
+		return false
+	}
+	for _, id := range expected {
+		if bytes.Equal(*headerOutboxID, id) {
+			return false
+		}
+	}
+	return true
+}
+
 type unboxMessageWithKeyRes struct {
 	messagePlaintext      chat1.MessagePlaintext
 	headerHash            chat1.Hash
 	headerSignature       *chat1.SignatureInfo
 	senderDeviceRevokedAt *gregor1.Time
+	headerSignedBytes     []byte
+	timings               unboxPhaseTimings
+}
+
+// unboxPhaseTimings records how long each phase of unboxing a single
+// message took, for diagnosing slow thread loads -- see
+// Boxer.SetLogUnboxTiming. senderLookup is filled in by UnboxMessage
+// itself, after unboxMessageWithKey returns, since sender info resolution
+// happens above unboxMessageWithKey in the call stack.
+type unboxPhaseTimings struct {
+	keyFind       time.Duration
+	bodyDecrypt   time.Duration
+	headerDecrypt time.Duration
+	verify        time.Duration
+	senderLookup  time.Duration
+}
+
+// unboxBatchTimings accumulates unboxPhaseTimings across every message in
+// a single UnboxMessages call, plus that call's own checkPrevPointers
+// pass, so UnboxMessages can log one aggregate line for the whole batch
+// regardless of whether per-message logging (see Boxer.SetLogUnboxTiming)
+// is turned on. It's installed on ctx the same way callSenderKeyCache is
+// -- see ctxUnboxBatchTimings -- and thrown away once the batch finishes.
+type unboxBatchTimings struct {
+	sync.Mutex
+	count            int
+	keyFind          time.Duration
+	bodyDecrypt      time.Duration
+	headerDecrypt    time.Duration
+	verify           time.Duration
+	senderLookup     time.Duration
+	prevPointerCheck time.Duration
+}
+
+func newUnboxBatchTimings() *unboxBatchTimings {
+	return &unboxBatchTimings{}
+}
+
+func (u *unboxBatchTimings) add(t unboxPhaseTimings) {
+	u.Lock()
+	defer u.Unlock()
+	u.count++
+	u.keyFind += t.keyFind
+	u.bodyDecrypt += t.bodyDecrypt
+	u.headerDecrypt += t.headerDecrypt
+	u.verify += t.verify
+	u.senderLookup += t.senderLookup
+}
+
+func (u *unboxBatchTimings) setPrevPointerCheck(d time.Duration) {
+	u.Lock()
+	defer u.Unlock()
+	u.prevPointerCheck = d
 }
 
 func (b *Boxer) headerUnsupported(ctx context.Context, headerVersion chat1.HeaderPlaintextVersion,
 	header chat1.HeaderPlaintext) chat1.HeaderPlaintextUnsupported {
 	switch headerVersion {
-	case chat1.HeaderPlaintextVersion_V2:
-		return header.V2()
-	case chat1.HeaderPlaintextVersion_V3:
-		return header.V3()
 	case chat1.HeaderPlaintextVersion_V4:
 		return header.V4()
 	case chat1.HeaderPlaintextVersion_V5:
@@ -172,10 +596,6 @@ func (b *Boxer) headerUnsupported(ctx context.Context, headerVersion chat1.Heade
 func (b *Boxer) bodyUnsupported(ctx context.Context, bodyVersion chat1.BodyPlaintextVersion,
 	body chat1.BodyPlaintext) chat1.BodyPlaintextUnsupported {
 	switch bodyVersion {
-	case chat1.BodyPlaintextVersion_V2:
-		return body.V2()
-	case chat1.BodyPlaintextVersion_V3:
-		return body.V3()
 	case chat1.BodyPlaintextVersion_V4:
 		return body.V4()
 	case chat1.BodyPlaintextVersion_V5:
@@ -200,9 +620,41 @@ func (b *Boxer) bodyUnsupported(ctx context.Context, bodyVersion chat1.BodyPlain
 	}
 }
 
+// UnboxMessageWithKeysResult is the result of UnboxMessageWithKeys: the
+// decrypted, verified plaintext plus the header metadata UnboxMessage
+// mixes in from the crypto layer. Unlike chat1.MessageUnboxedValid, it
+// carries no sender username or device name, since resolving those needs
+// the identify machinery that this entry point has no dependency on.
+type UnboxMessageWithKeysResult struct {
+	MessagePlaintext      chat1.MessagePlaintext
+	HeaderHash            chat1.Hash
+	HeaderSignature       *chat1.SignatureInfo
+	SenderDeviceRevokedAt *gregor1.Time
+	HeaderSignedBytes     []byte
+}
+
+// UnboxMessageWithKeys decrypts and verifies boxed using key directly,
+// skipping UnboxMessage's TLF-based key lookup. It's meant for offline
+// tooling that already has the right chat1.CryptKey in hand and has no
+// TLF interface to call out to; it works on a Boxer constructed with a
+// nil tlf.
+func (b *Boxer) UnboxMessageWithKeys(ctx context.Context, boxed chat1.MessageBoxed, key *keybase1.CryptKey, asOf *gregor1.Time) (UnboxMessageWithKeysResult, UnboxingError) {
+	umwkr, ierr := b.unboxMessageWithKey(ctx, boxed, key, asOf)
+	if ierr != nil {
+		return UnboxMessageWithKeysResult{}, ierr
+	}
+	return UnboxMessageWithKeysResult{
+		MessagePlaintext:      umwkr.messagePlaintext,
+		HeaderHash:            umwkr.headerHash,
+		HeaderSignature:       umwkr.headerSignature,
+		SenderDeviceRevokedAt: umwkr.senderDeviceRevokedAt,
+		HeaderSignedBytes:     umwkr.headerSignedBytes,
+	}, nil
+}
+
 // unboxMessageWithKey unboxes a chat1.MessageBoxed into a keybase1.Message given
-// a keybase1.CryptKey.
-func (b *Boxer) unboxMessageWithKey(ctx context.Context, msg chat1.MessageBoxed, key *keybase1.CryptKey) (unboxMessageWithKeyRes, UnboxingError) {
+// a keybase1.CryptKey. See UnboxMessage for the meaning of asOf.
+func (b *Boxer) unboxMessageWithKey(ctx context.Context, msg chat1.MessageBoxed, key *keybase1.CryptKey, asOf *gregor1.Time) (unboxMessageWithKeyRes, UnboxingError) {
 	var err error
 	if msg.ServerHeader == nil {
 		return unboxMessageWithKeyRes{}, NewPermanentUnboxingError(errors.New("nil ServerHeader in MessageBoxed"))
@@ -211,15 +663,27 @@ func (b *Boxer) unboxMessageWithKey(ctx context.Context, msg chat1.MessageBoxed,
 	// compute the header hash
 	headerHash := b.hashV1(msg.HeaderCiphertext.E)
 
+	var timings unboxPhaseTimings
+
 	// decrypt body
+	bodyStart := b.clock.Now()
 	var body chat1.BodyPlaintext
 	skipBodyVerification := false
-	if len(msg.BodyCiphertext.E) == 0 {
+	switch {
+	case len(msg.BodyCiphertextChunks) > 0:
+		packedBody, err := b.openBodyChunked(msg.BodyCiphertextChunks, key)
+		if err != nil {
+			return unboxMessageWithKeyRes{}, NewPermanentUnboxingError(err)
+		}
+		if err := b.unmarshal(packedBody, &body); err != nil {
+			return unboxMessageWithKeyRes{}, NewPermanentUnboxingError(err)
+		}
+	case len(msg.BodyCiphertext.E) == 0:
 		if msg.ServerHeader.SupersededBy == 0 {
-			return unboxMessageWithKeyRes{}, NewPermanentUnboxingError(errors.New("empty body and not superseded in MessageBoxed"))
+			return unboxMessageWithKeyRes{}, NewPermanentUnboxingError(EmptyBodyUnsupersededError{})
 		}
 		skipBodyVerification = true
-	} else {
+	default:
 		packedBody, err := b.open(msg.BodyCiphertext, key)
 		if err != nil {
 			return unboxMessageWithKeyRes{}, NewPermanentUnboxingError(err)
@@ -228,8 +692,10 @@ func (b *Boxer) unboxMessageWithKey(ctx context.Context, msg chat1.MessageBoxed,
 			return unboxMessageWithKeyRes{}, NewPermanentUnboxingError(err)
 		}
 	}
+	timings.bodyDecrypt = b.clock.Now().Sub(bodyStart)
 
 	// decrypt header
+	headerStart := b.clock.Now()
 	packedHeader, err := b.open(msg.HeaderCiphertext, key)
 	if err != nil {
 		return unboxMessageWithKeyRes{}, NewPermanentUnboxingError(err)
@@ -238,12 +704,15 @@ func (b *Boxer) unboxMessageWithKey(ctx context.Context, msg chat1.MessageBoxed,
 	if err := b.unmarshal(packedHeader, &header); err != nil {
 		return unboxMessageWithKeyRes{}, NewPermanentUnboxingError(err)
 	}
+	timings.headerDecrypt = b.clock.Now().Sub(headerStart)
 
 	// verify the message
-	validity, ierr := b.verifyMessage(ctx, header, msg, skipBodyVerification)
+	verifyStart := b.clock.Now()
+	validity, ierr := b.verifyMessage(ctx, header, msg, key, skipBodyVerification, asOf)
 	if ierr != nil {
 		return unboxMessageWithKeyRes{}, ierr
 	}
+	timings.verify = b.clock.Now().Sub(verifyStart)
 
 	// create a chat1.MessageClientHeader from versioned HeaderPlaintext
 	var clientHeader chat1.MessageClientHeader
@@ -268,21 +737,59 @@ func (b *Boxer) unboxMessageWithKey(ctx context.Context, msg chat1.MessageBoxed,
 			OutboxInfo:   hp.OutboxInfo,
 			OutboxID:     hp.OutboxID,
 		}
+	case chat1.HeaderPlaintextVersion_V2:
+		headerSignature = header.V2().HeaderSignature
+		hp := header.V2()
+		clientHeader = chat1.MessageClientHeader{
+			Conv:         hp.Conv,
+			TlfName:      hp.TlfName,
+			TlfPublic:    hp.TlfPublic,
+			MessageType:  hp.MessageType,
+			Prev:         hp.Prev,
+			Sender:       hp.Sender,
+			SenderDevice: hp.SenderDevice,
+			OutboxInfo:   hp.OutboxInfo,
+			OutboxID:     hp.OutboxID,
+		}
+	case chat1.HeaderPlaintextVersion_V3:
+		headerSignature = header.V3().HeaderSignature
+		hp := header.V3()
+		clientHeader = chat1.MessageClientHeader{
+			Conv:              hp.Conv,
+			TlfName:           hp.TlfName,
+			TlfPublic:         hp.TlfPublic,
+			MessageType:       hp.MessageType,
+			Prev:              hp.Prev,
+			Sender:            hp.Sender,
+			SenderDevice:      hp.SenderDevice,
+			OutboxInfo:        hp.OutboxInfo,
+			OutboxID:          hp.OutboxID,
+			EphemeralLifetime: hp.EphemeralLifetime,
+		}
 	default:
 		return unboxMessageWithKeyRes{},
 			NewPermanentUnboxingError(NewHeaderVersionError(headerVersion,
 				b.headerUnsupported(ctx, headerVersion, header)))
 	}
 
+	if clientHeader.TlfPublic != msg.ClientHeader.TlfPublic {
+		return unboxMessageWithKeyRes{}, NewPermanentUnboxingError(TlfPublicMismatchError{
+			HeaderTlfPublic: clientHeader.TlfPublic,
+			OuterTlfPublic:  msg.ClientHeader.TlfPublic,
+		})
+	}
+
 	if skipBodyVerification {
 		// body was deleted, so return empty body that matches header version
 		switch headerVersion {
-		case chat1.HeaderPlaintextVersion_V1:
+		case chat1.HeaderPlaintextVersion_V1, chat1.HeaderPlaintextVersion_V2, chat1.HeaderPlaintextVersion_V3:
 			return unboxMessageWithKeyRes{
 				messagePlaintext:      chat1.MessagePlaintext{ClientHeader: clientHeader},
 				headerHash:            headerHash,
 				headerSignature:       headerSignature,
 				senderDeviceRevokedAt: validity.senderDeviceRevokedAt,
+				headerSignedBytes:     validity.headerSignedBytes,
+				timings:               timings,
 			}, nil
 		default:
 			return unboxMessageWithKeyRes{},
@@ -306,6 +813,41 @@ func (b *Boxer) unboxMessageWithKey(ctx context.Context, msg chat1.MessageBoxed,
 			headerHash:            headerHash,
 			headerSignature:       headerSignature,
 			senderDeviceRevokedAt: validity.senderDeviceRevokedAt,
+			headerSignedBytes:     validity.headerSignedBytes,
+			timings:               timings,
+		}, nil
+	case chat1.BodyPlaintextVersion_V2:
+		// V2 is field-for-field identical to V1; it only exists to mark
+		// that the sender used the compact struct-as-array encoding when
+		// sealing the body (see marshalBodyPlaintext). unmarshal already accepts
+		// either encoding for the same struct, so there's nothing special
+		// to do here beyond reading the fields back out.
+		return unboxMessageWithKeyRes{
+			messagePlaintext: chat1.MessagePlaintext{
+				ClientHeader: clientHeader,
+				MessageBody:  body.V2().MessageBody,
+			},
+			headerHash:            headerHash,
+			headerSignature:       headerSignature,
+			senderDeviceRevokedAt: validity.senderDeviceRevokedAt,
+			headerSignedBytes:     validity.headerSignedBytes,
+			timings:               timings,
+		}, nil
+	case chat1.BodyPlaintextVersion_V3:
+		// V3 marks a body that was sealed as bodyCiphertextChunks rather
+		// than a single bodyCiphertext blob; openBodyChunked has already
+		// reassembled and verified the chunks by this point, so from here
+		// it's identical to V1/V2.
+		return unboxMessageWithKeyRes{
+			messagePlaintext: chat1.MessagePlaintext{
+				ClientHeader: clientHeader,
+				MessageBody:  body.V3().MessageBody,
+			},
+			headerHash:            headerHash,
+			headerSignature:       headerSignature,
+			senderDeviceRevokedAt: validity.senderDeviceRevokedAt,
+			headerSignedBytes:     validity.headerSignedBytes,
+			timings:               timings,
 		}, nil
 	default:
 		return unboxMessageWithKeyRes{},
@@ -314,20 +856,234 @@ func (b *Boxer) unboxMessageWithKey(ctx context.Context, msg chat1.MessageBoxed,
 	}
 }
 
+// UnboxedHeaderOnly is the result of UnboxHeaderOnly: just enough to index a
+// message by sender, type, and ctime, without ever decrypting its body.
+//
+// Because the body is never decrypted, the body hash claimed in the header
+// is never checked against it either -- an attacker who can tamper with
+// BodyCiphertext without re-signing the header (which ValidSenderKey's
+// checks still catch) would go undetected here. Callers that need that
+// guarantee must fall back to UnboxMessage/UnboxMessageWithKeys for the
+// messages they actually care about.
+type UnboxedHeaderOnly struct {
+	ClientHeader          chat1.MessageClientHeader
+	HeaderHash            chat1.Hash
+	HeaderSignature       *chat1.SignatureInfo
+	SenderDeviceRevokedAt *gregor1.Time
+}
+
+// UnboxHeaderOnly decrypts and verifies only boxed's header, for callers
+// like thread indexers that need sender/type/ctime metadata but never
+// touch the body: it's faster than UnboxMessage, and it never exposes body
+// plaintext. See UnboxedHeaderOnly for the assurance this gives up to get
+// there, and UnboxMessage for the meaning of asOf.
+func (b *Boxer) UnboxHeaderOnly(ctx context.Context, boxed chat1.MessageBoxed, finalizeInfo *chat1.ConversationFinalizeInfo, asOf *gregor1.Time) (UnboxedHeaderOnly, UnboxingError) {
+	if b.tlf == nil {
+		return UnboxedHeaderOnly{}, NewPermanentUnboxingError(errors.New(errNoTlfInterfaceConfigured))
+	}
+
+	tlfName := boxed.ClientHeader.TLFNameExpanded(finalizeInfo)
+	tlfPublic := boxed.ClientHeader.TlfPublic
+	keys, err := b.keyFinderFor(ctx).Find(ctx, b.tlf(), tlfName, tlfPublic)
+	if err != nil {
+		// transient error. Rekey errors come through here
+		return UnboxedHeaderOnly{}, NewTransientUnboxingError(err)
+	}
+
+	if err := checkCryptKeysForDuplicateGenerations(keys.CryptKeys); err != nil {
+		return UnboxedHeaderOnly{}, NewTransientUnboxingError(err)
+	}
+
+	var matchKey *keybase1.CryptKey
+	for _, key := range keys.CryptKeys {
+		if key.KeyGeneration == boxed.KeyGeneration {
+			matchKey = &key
+			break
+		}
+	}
+
+	if matchKey == nil {
+		err := fmt.Errorf("no key found for generation %d", boxed.KeyGeneration)
+		return UnboxedHeaderOnly{}, NewTransientUnboxingError(err)
+	}
+
+	return b.unboxHeaderOnlyWithKey(ctx, boxed, matchKey, asOf)
+}
+
+// unboxHeaderOnlyWithKey is UnboxHeaderOnly's body: the same header
+// decrypt-and-verify steps unboxMessageWithKey does, minus every step
+// touching msg.BodyCiphertext.
+func (b *Boxer) unboxHeaderOnlyWithKey(ctx context.Context, msg chat1.MessageBoxed, key *keybase1.CryptKey, asOf *gregor1.Time) (UnboxedHeaderOnly, UnboxingError) {
+	if msg.ServerHeader == nil {
+		return UnboxedHeaderOnly{}, NewPermanentUnboxingError(errors.New("nil ServerHeader in MessageBoxed"))
+	}
+
+	headerHash := b.hashV1(msg.HeaderCiphertext.E)
+
+	packedHeader, err := b.open(msg.HeaderCiphertext, key)
+	if err != nil {
+		return UnboxedHeaderOnly{}, NewPermanentUnboxingError(err)
+	}
+	var header chat1.HeaderPlaintext
+	if err := b.unmarshal(packedHeader, &header); err != nil {
+		return UnboxedHeaderOnly{}, NewPermanentUnboxingError(err)
+	}
+
+	// skipBodyVerification=true: there is no decrypted body to check the
+	// claimed body hash against, and we have no intention of decrypting
+	// one just to run that check.
+	validity, ierr := b.verifyMessage(ctx, header, msg, key, true, asOf)
+	if ierr != nil {
+		return UnboxedHeaderOnly{}, ierr
+	}
+
+	headerVersion, err := header.Version()
+	if err != nil {
+		return UnboxedHeaderOnly{}, NewPermanentUnboxingError(err)
+	}
+
+	switch headerVersion {
+	case chat1.HeaderPlaintextVersion_V1:
+		hp := header.V1()
+		return UnboxedHeaderOnly{
+			ClientHeader: chat1.MessageClientHeader{
+				Conv:         hp.Conv,
+				TlfName:      hp.TlfName,
+				TlfPublic:    hp.TlfPublic,
+				MessageType:  hp.MessageType,
+				Prev:         hp.Prev,
+				Sender:       hp.Sender,
+				SenderDevice: hp.SenderDevice,
+				OutboxInfo:   hp.OutboxInfo,
+				OutboxID:     hp.OutboxID,
+			},
+			HeaderHash:            headerHash,
+			HeaderSignature:       hp.HeaderSignature,
+			SenderDeviceRevokedAt: validity.senderDeviceRevokedAt,
+		}, nil
+	case chat1.HeaderPlaintextVersion_V2:
+		hp := header.V2()
+		return UnboxedHeaderOnly{
+			ClientHeader: chat1.MessageClientHeader{
+				Conv:         hp.Conv,
+				TlfName:      hp.TlfName,
+				TlfPublic:    hp.TlfPublic,
+				MessageType:  hp.MessageType,
+				Prev:         hp.Prev,
+				Sender:       hp.Sender,
+				SenderDevice: hp.SenderDevice,
+				OutboxInfo:   hp.OutboxInfo,
+				OutboxID:     hp.OutboxID,
+			},
+			HeaderHash:            headerHash,
+			HeaderSignature:       hp.HeaderSignature,
+			SenderDeviceRevokedAt: validity.senderDeviceRevokedAt,
+		}, nil
+	case chat1.HeaderPlaintextVersion_V3:
+		hp := header.V3()
+		return UnboxedHeaderOnly{
+			ClientHeader: chat1.MessageClientHeader{
+				Conv:              hp.Conv,
+				TlfName:           hp.TlfName,
+				TlfPublic:         hp.TlfPublic,
+				MessageType:       hp.MessageType,
+				Prev:              hp.Prev,
+				Sender:            hp.Sender,
+				SenderDevice:      hp.SenderDevice,
+				OutboxInfo:        hp.OutboxInfo,
+				OutboxID:          hp.OutboxID,
+				EphemeralLifetime: hp.EphemeralLifetime,
+			},
+			HeaderHash:            headerHash,
+			HeaderSignature:       hp.HeaderSignature,
+			SenderDeviceRevokedAt: validity.senderDeviceRevokedAt,
+		}, nil
+	default:
+		return UnboxedHeaderOnly{},
+			NewPermanentUnboxingError(NewHeaderVersionError(headerVersion,
+				b.headerUnsupported(ctx, headerVersion, header)))
+	}
+}
+
 // unboxThread transforms a chat1.ThreadViewBoxed to a keybase1.ThreadView.
-func (b *Boxer) UnboxThread(ctx context.Context, boxed chat1.ThreadViewBoxed, convID chat1.ConversationID, finalizeInfo *chat1.ConversationFinalizeInfo) (thread chat1.ThreadView, err error) {
+// If idRange is non-nil, messages outside it are returned undecrypted as
+// absentMessage placeholders rather than being unboxed; boxed.Pagination is
+// passed through unchanged either way, since it describes the underlying
+// fetch rather than what got decrypted.
+func (b *Boxer) UnboxThread(ctx context.Context, boxed chat1.ThreadViewBoxed, convID chat1.ConversationID, finalizeInfo *chat1.ConversationFinalizeInfo, idRange *MessageIDRange, opts UnboxMessagesOptions) (thread chat1.ThreadView, err error) {
 
 	thread = chat1.ThreadView{
 		Pagination: boxed.Pagination,
 	}
 
-	if thread.Messages, err = b.UnboxMessages(ctx, boxed.Messages, finalizeInfo); err != nil {
+	if thread.Messages, err = b.UnboxMessages(ctx, boxed.Messages, finalizeInfo, idRange, opts); err != nil {
 		return chat1.ThreadView{}, err
 	}
 
 	return thread, nil
 }
 
+// UnboxMessagesOptions configures UnboxMessages/UnboxThread.
+type UnboxMessagesOptions struct {
+	// StopOnPermanentError makes UnboxMessages abort as soon as it hits a
+	// message that fails unboxing with a permanent error, returning a
+	// UnboxMessagesError identifying the offending message instead of
+	// converting it to a MessageUnboxedError value and continuing on to
+	// the rest of the batch. Integrity auditors that need to treat any
+	// permanently-invalid message in a thread as fatal want this; the
+	// default (false) keeps the historical behavior of collecting
+	// permanent errors as values, so a thread with one bad message can
+	// still be displayed with the rest intact.
+	//
+	// Since UnboxMessages fans its work out across a worker pool (see
+	// SetUnboxMessagesConcurrency), other messages can finish unboxing
+	// before or after the one that trips this -- there's no well-defined
+	// "everything before it" to return as a partial result, so an abort
+	// returns a nil slice alongside the error, same as a transient error.
+	StopOnPermanentError bool
+}
+
+// MessageIDRange restricts UnboxMessages/UnboxThread to decrypting only
+// messages whose ID falls within [MinID, MaxID]. A zero value for either
+// bound is treated as unbounded on that side. Messages outside the range
+// are returned as MessageUnboxedError placeholders (tagged with the
+// ABSENT error type) built entirely from the unencrypted ClientHeader and
+// ServerHeader, so the boxer never touches their ciphertext or keys.
+type MessageIDRange struct {
+	MinID chat1.MessageID
+	MaxID chat1.MessageID
+}
+
+func (r MessageIDRange) contains(id chat1.MessageID) bool {
+	if r.MinID != 0 && id < r.MinID {
+		return false
+	}
+	if r.MaxID != 0 && id > r.MaxID {
+		return false
+	}
+	return true
+}
+
+// absentMessage builds the lightweight, non-decrypted placeholder used for
+// messages that UnboxMessages skips because they fall outside a requested
+// MessageIDRange. It carries just enough of the server/client headers for
+// pagination and message-type bookkeeping to stay consistent.
+func absentMessage(msg chat1.MessageBoxed) chat1.MessageUnboxed {
+	var messageID chat1.MessageID
+	var ctime gregor1.Time
+	if msg.ServerHeader != nil {
+		messageID = msg.ServerHeader.MessageID
+		ctime = msg.ServerHeader.Ctime
+	}
+	return chat1.NewMessageUnboxedWithError(chat1.MessageUnboxedError{
+		ErrType:     chat1.MessageUnboxedErrorType_ABSENT,
+		ErrMsg:      "message outside requested range; not decrypted",
+		MessageID:   messageID,
+		MessageType: msg.ClientHeader.MessageType,
+		Ctime:       ctime,
+	})
+}
+
 func (b *Boxer) getUsernameAndDevice(ctx context.Context, uid keybase1.UID, deviceID keybase1.DeviceID) (string, string, string, error) {
 	nun, devName, devType, err := b.G().GetUPAKLoader().LookupUsernameAndDevice(ctx, uid, deviceID)
 	if err != nil {
@@ -344,107 +1100,592 @@ func (b *Boxer) getSenderUsername(ctx context.Context, clientHeader chat1.Messag
 	return name.String(), nil
 }
 
+// getSenderInfoLocal looks up the username and device name/type for the
+// sender named in clientHeader. The header's Sender and SenderDevice are
+// untrusted input -- a malformed or truncated message could carry bytes
+// that don't decode to a valid UID/DeviceID at all -- so this validates
+// them with the same UIDFromString/DeviceIDFromString ValidSenderKey
+// uses, rather than handing the raw string straight to the UPAK lookup
+// and letting a confusing "user not found" error come back instead.
 func (b *Boxer) getSenderInfoLocal(ctx context.Context, clientHeader chat1.MessageClientHeader) (senderUsername string, senderDeviceName string, senderDeviceType string, err error) {
-	uid := keybase1.UID(clientHeader.Sender.String())
-	did := keybase1.DeviceID(clientHeader.SenderDevice.String())
+	uid, err := keybase1.UIDFromString(clientHeader.Sender.String())
+	if err != nil {
+		return "", "", "", fmt.Errorf("invalid sender UID in header: %s", err)
+	}
+	did, err := keybase1.DeviceIDFromString(clientHeader.SenderDevice.String())
+	if err != nil {
+		return "", "", "", fmt.Errorf("invalid sender device ID in header: %s", err)
+	}
 	return b.getUsernameAndDevice(ctx, uid, did)
 }
 
-func (b *Boxer) UnboxMessages(ctx context.Context, boxed []chat1.MessageBoxed, finalizeInfo *chat1.ConversationFinalizeInfo) (unboxed []chat1.MessageUnboxed, err error) {
-	for _, msg := range boxed {
-		decmsg, err := b.UnboxMessage(ctx, msg, finalizeInfo)
-		if err != nil {
-			return unboxed, err
-		}
-		unboxed = append(unboxed, decmsg)
-	}
-
-	return unboxed, nil
+// DefaultUnboxMessagesConcurrency is the number of messages UnboxMessages
+// decrypts at once when the Boxer wasn't given a different limit via
+// SetUnboxMessagesConcurrency. Chosen empirically: large enough to hide a
+// message's key lookup, decryption, signature check, and UPAK loader call
+// behind each other's latency, small enough not to flood the UPAK loader
+// with concurrent lookups for a huge thread.
+const DefaultUnboxMessagesConcurrency = 10
+
+// SetUnboxMessagesConcurrency configures how many messages UnboxMessages
+// unboxes at once. n < 0 is treated as 1 (fully sequential); n == 0, like
+// a Boxer not yet given an explicit limit, means
+// DefaultUnboxMessagesConcurrency (see unboxConcurrency).
+func (b *Boxer) SetUnboxMessagesConcurrency(n int) {
+	b.unboxMessagesConcurrency = n
 }
 
-// Can return (nil, nil) if there is no saved merkle root.
-func (b *Boxer) latestMerkleRoot() (*chat1.MerkleRoot, error) {
-	merkleClient := b.G().GetMerkleClient()
-	if merkleClient == nil {
-		return nil, fmt.Errorf("no MerkleClient available")
+// unboxConcurrency is the effective worker count UnboxMessages
+// should use, applying the DefaultUnboxMessagesConcurrency/n<0 rules
+// documented on SetUnboxMessagesConcurrency.
+func (b *Boxer) unboxConcurrency() int {
+	switch {
+	case b.unboxMessagesConcurrency > 0:
+		return b.unboxMessagesConcurrency
+	case b.unboxMessagesConcurrency < 0:
+		return 1
+	default:
+		return DefaultUnboxMessagesConcurrency
 	}
-	merkleRoot, err := merkleClient.LastRootInfo()
-	if err != nil {
-		return nil, err
+}
+
+// UnboxMessages unboxes every message in boxed, fanned out across up to
+// unboxConcurrency() workers (see SetUnboxMessagesConcurrency) -- each
+// message's key lookup, decryption, signature verification, and UPAK
+// loader call otherwise serialize behind each other's latency, which is
+// what makes loading a large thread sequentially slow. The returned slice
+// preserves boxed's order regardless of which worker finished first.
+//
+// If idRange is non-nil, only messages whose ID falls within it are
+// actually decrypted; the rest come back as absentMessage placeholders,
+// and unboxMessageWithKey (and thus the KeyFinder and seal/open calls it
+// makes) is never invoked for them.
+//
+// A permanent error on one message still produces a MessageUnboxedError
+// value in that message's slot (same as the old sequential loop). A
+// transient error retries that message alone, with backoff, up to
+// b.unboxRetries times (see SetUnboxRetries; off by default); if it's
+// still transient after that, or opts.StopOnPermanentError tripping,
+// aborts the whole batch and returns the error, with a nil slice.
+//
+// Every message's TLF key lookup goes through b.keyFinderFor(ctx), which
+// already memoizes GetTLFCryptKeysRes by (tlfName, tlfPublic) -- but only
+// within a single KeyFinder instance. If this Boxer has no KeyFinder
+// configured via SetKeyFinder, and ctx doesn't already carry one
+// (CtxKeyFinder falls back to a fresh one on every call when it doesn't),
+// UnboxMessages installs one for the duration of this call so all the
+// messages it fans out still share a single cache and hit the TLF
+// interface once per distinct TLF, not once per message. The cache lives
+// on this local ctx, not on the Boxer, so it's thrown away once the batch
+// finishes and concurrent callers never share keys.
+func (b *Boxer) UnboxMessages(ctx context.Context, boxed []chat1.MessageBoxed, finalizeInfo *chat1.ConversationFinalizeInfo, idRange *MessageIDRange, opts UnboxMessagesOptions) (unboxed []chat1.MessageUnboxed, err error) {
+	if b.keyFinder == nil {
+		if _, ok := ctx.Value(kfKey).(KeyFinder); !ok {
+			ctx = context.WithValue(ctx, kfKey, NewKeyFinder())
+		}
 	}
-	if merkleRoot == nil {
-		b.log().Debug("No merkle root available for chat header")
+	if ctxCallSenderKeyCache(ctx) == nil {
+		ctx = context.WithValue(ctx, skcKey, newCallSenderKeyCache())
 	}
-	return merkleRoot, nil
-}
+	batchTimings := newUnboxBatchTimings()
+	ctx = context.WithValue(ctx, ubtKey, batchTimings)
 
-// boxMessage encrypts a keybase1.MessagePlaintext into a chat1.MessageBoxed.  It
-// finds the most recent key for the TLF.
-func (b *Boxer) BoxMessage(ctx context.Context, msg chat1.MessagePlaintext, signingKeyPair libkb.NaclSigningKeyPair) (*chat1.MessageBoxed, error) {
-	tlfName := msg.ClientHeader.TlfName
-	var recentKey *keybase1.CryptKey
+	results := make([]chat1.MessageUnboxed, len(boxed))
+	sem := make(chan struct{}, b.unboxConcurrency())
+	eg, ectx := errgroup.WithContext(ctx)
 
-	if len(tlfName) == 0 {
-		return nil, NewBoxingError("blank TLF name given", true)
-	}
+	for i, msg := range boxed {
+		i, msg := i, msg
+		if idRange != nil && msg.ServerHeader != nil && !idRange.contains(msg.ServerHeader.MessageID) {
+			results[i] = absentMessage(msg)
+			continue
+		}
 
-	cres, err := CtxKeyFinder(ctx).Find(ctx, b.tlf(), tlfName, msg.ClientHeader.TlfPublic)
-	if err != nil {
-		return nil, NewBoxingCryptKeysError(err)
-	}
-	msg.ClientHeader.TlfName = string(cres.NameIDBreaks.CanonicalName)
-	if msg.ClientHeader.TlfPublic {
-		recentKey = &publicCryptKey
-	} else {
-		for _, key := range cres.CryptKeys {
-			if recentKey == nil || key.KeyGeneration > recentKey.KeyGeneration {
-				recentKey = &key
+		eg.Go(func() error {
+			select {
+			case sem <- struct{}{}:
+			case <-ectx.Done():
+				return ectx.Err()
 			}
-		}
-	}
+			defer func() { <-sem }()
 
-	merkleRoot, err := b.latestMerkleRoot()
-	if err != nil {
-		return nil, NewBoxingError(err.Error(), false)
-	}
-	msg.ClientHeader.MerkleRoot = merkleRoot
+			decmsg, ierr := b.unboxMessageWithRetries(ectx, msg, finalizeInfo)
+			if ierr != nil {
+				return NewUnboxMessagesError(i, msg.GetMessageID(), ierr)
+			}
 
-	if len(msg.ClientHeader.TlfName) == 0 {
-		msg := fmt.Sprintf("blank TLF name received: original: %s canonical: %s", tlfName,
-			msg.ClientHeader.TlfName)
-		return nil, NewBoxingError(msg, true)
+			if state, _ := decmsg.State(); opts.StopOnPermanentError && state == chat1.MessageUnboxedState_ERROR {
+				if errInfo := decmsg.Error(); errInfo.ErrType != chat1.MessageUnboxedErrorType_ABSENT {
+					return NewUnboxMessagesError(i, errInfo.MessageID,
+						NewPermanentUnboxingError(errors.New(errInfo.ErrMsg)))
+				}
+			}
+
+			results[i] = decmsg
+			return nil
+		})
 	}
 
-	if recentKey == nil {
-		msg := fmt.Sprintf("no key found for tlf %q (public: %v)", tlfName, msg.ClientHeader.TlfPublic)
-		return nil, NewBoxingError(msg, false)
+	if err := eg.Wait(); err != nil {
+		return nil, err
 	}
 
-	boxed, err := b.boxMessageWithKeys(msg, recentKey, signingKeyPair)
+	prevPointerCheckStart := b.clock.Now()
+	final := b.checkPrevPointers(boxed, results)
+	batchTimings.setPrevPointerCheck(b.clock.Now().Sub(prevPointerCheckStart))
+
+	b.Debug(ctx, "UnboxMessages: batch timings: msgs: %d keyFind: %s bodyDecrypt: %s headerDecrypt: %s verify: %s senderLookup: %s prevPointerCheck: %s",
+		batchTimings.count, batchTimings.keyFind, batchTimings.bodyDecrypt, batchTimings.headerDecrypt,
+		batchTimings.verify, batchTimings.senderLookup, batchTimings.prevPointerCheck)
+
+	return final, nil
+}
+
+// UnboxStreamResult is one message's outcome from UnboxMessagesStream,
+// carrying its position in that call's boxed argument alongside either
+// Unboxed (success, including the MessageUnboxedError placeholder value
+// UnboxMessages itself would produce for a message that failed unboxing)
+// or Err (a failure that kept Unboxed from being produced at all).
+// Results can arrive in any order, since they're produced by a pool of
+// workers racing each other -- Index is what lets a caller put them back
+// in order.
+type UnboxStreamResult struct {
+	Index   int
+	Unboxed chat1.MessageUnboxed
+	Err     error
+}
+
+// UnboxMessagesStream is like UnboxMessages, but streams its results back
+// one at a time over a channel as they finish, rather than collecting the
+// whole batch into a slice first -- useful for a very long thread the UI
+// wants to start rendering before every message has decrypted. It fans
+// work out across unboxConcurrency() workers the same way UnboxMessages
+// does, but has no equivalent of MessageIDRange, UnboxMessagesOptions, or
+// checkPrevPointers, since each of those needs every message's result at
+// once to decide or apply, defeating the point of streaming them.
+// convID is used only to tag this call's debug logging.
+//
+// The returned channel is always closed once there's no more work left to
+// do for it, whether that's because every message finished or because ctx
+// was canceled: a canceled ctx stops new workers from starting and lets
+// any already in flight finish (so nothing is left trying to send to a
+// channel nobody closes), rather than abandoning them to leak.
+func (b *Boxer) UnboxMessagesStream(ctx context.Context, boxed []chat1.MessageBoxed, convID chat1.ConversationID, finalizeInfo *chat1.ConversationFinalizeInfo) <-chan UnboxStreamResult {
+	if b.keyFinder == nil {
+		if _, ok := ctx.Value(kfKey).(KeyFinder); !ok {
+			ctx = context.WithValue(ctx, kfKey, NewKeyFinder())
+		}
+	}
+	if ctxCallSenderKeyCache(ctx) == nil {
+		ctx = context.WithValue(ctx, skcKey, newCallSenderKeyCache())
+	}
+
+	results := make(chan UnboxStreamResult)
+	sem := make(chan struct{}, b.unboxConcurrency())
+
+	go func() {
+		defer close(results)
+
+		var wg sync.WaitGroup
+		defer wg.Wait()
+
+		for i, msg := range boxed {
+			i, msg := i, msg
+
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				b.Debug(ctx, "UnboxMessagesStream: conv: %s: context canceled before index %d; stopping early",
+					convID, i)
+				return
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				decmsg, ierr := b.UnboxMessage(ctx, msg, finalizeInfo, nil, nil)
+				res := UnboxStreamResult{Index: i, Unboxed: decmsg}
+				if ierr != nil {
+					res = UnboxStreamResult{Index: i, Err: NewUnboxMessagesError(i, msg.GetMessageID(), ierr)}
+				}
+
+				select {
+				case results <- res:
+				case <-ctx.Done():
+				}
+			}()
+		}
+	}()
+
+	return results
+}
+
+// checkPrevPointers validates, for every successfully-unboxed message in
+// unboxed, that its prev pointers agree with the header hashes this same
+// batch already computed for the messages they point to. A message whose
+// prev pointer disagrees is replaced with a permanent error, the same as
+// any other unboxing failure; a prev pointer to a message outside this
+// batch is left unchecked here -- that's CheckPrevPointersAndGetUnpreved's
+// job, once the whole thread has been assembled.
+//
+// It's built on CheckPrevPointersBatch so that a dense batch where many
+// messages repeat the same prev pointer only validates each distinct one
+// once.
+func (b *Boxer) checkPrevPointers(boxed []chat1.MessageBoxed, unboxed []chat1.MessageUnboxed) []chat1.MessageUnboxed {
+	var valids []chat1.MessageUnboxedValid
+	headerHashes := make(map[chat1.MessageID]chat1.Hash)
+	for _, msg := range unboxed {
+		if msg.IsValid() {
+			v := msg.Valid()
+			valids = append(valids, v)
+			headerHashes[v.ServerHeader.MessageID] = v.HeaderHash
+		}
+	}
+
+	checker := func(prev chat1.MessagePreviousPointer) error {
+		actual, ok := headerHashes[prev.Id]
+		if !ok {
+			return nil
+		}
+		if !actual.Eq(prev.Hash) {
+			return NewChatThreadConsistencyError(IncorrectHash,
+				"prev pointer to message %d claims hash %s, but its actual header hash in this batch is %s",
+				prev.Id, prev.Hash.String(), actual.String())
+		}
+		return nil
+	}
+
+	bad := CheckPrevPointersBatch(valids, checker)
+	if len(bad) == 0 {
+		return unboxed
+	}
+
+	out := make([]chat1.MessageUnboxed, len(unboxed))
+	copy(out, unboxed)
+	for i, msg := range boxed {
+		if err, isBad := bad[msg.GetMessageID()]; isBad {
+			out[i] = b.makeErrorMessage(msg, NewPermanentUnboxingError(err))
+		}
+	}
+	return out
+}
+
+// checkCryptKeysForDuplicateGenerations returns an error if keys contains two
+// or more entries with the same KeyGeneration. A server returning such a
+// set (maliciously or otherwise) would otherwise be resolved ambiguously,
+// depending on map/slice iteration order.
+func checkCryptKeysForDuplicateGenerations(keys []keybase1.CryptKey) error {
+	seen := make(map[int]bool, len(keys))
+	for _, key := range keys {
+		if seen[key.KeyGeneration] {
+			return DuplicateKeyGenerationError{KeyGeneration: key.KeyGeneration}
+		}
+		seen[key.KeyGeneration] = true
+	}
+	return nil
+}
+
+// Can return (nil, nil) if there is no saved merkle root.
+func (b *Boxer) latestMerkleRoot(ctx context.Context) (*chat1.MerkleRoot, error) {
+	merkleClient := b.G().GetMerkleClient()
+	if merkleClient == nil {
+		return nil, fmt.Errorf("no MerkleClient available")
+	}
+	merkleRoot, err := merkleClient.LastRootInfo(ctx)
 	if err != nil {
-		return nil, NewBoxingError(err.Error(), true)
+		return nil, err
+	}
+	if merkleRoot == nil {
+		b.log().Debug("No merkle root available for chat header")
 	}
+	return merkleRoot, nil
+}
 
-	return boxed, nil
+// maxMerkleRootSeqnoSlack bounds how far ahead of this client's cached
+// merkle root (b.merkleRoot) a message's claimed root is allowed to be
+// before SetCheckMerkleRootFreshness treats it as fabricated. It's large
+// enough that a client whose own cache has gone stale for a while won't
+// start flagging real messages anchored to roots it simply hasn't caught
+// up to yet, but small enough that a root claiming a seqno thousands
+// beyond the latest one this client has ever seen is far more likely to
+// be made up than real.
+const maxMerkleRootSeqnoSlack = 1000
+
+// verifyMerkleRootFreshness checks claimed (a message's
+// ClientHeader.MerkleRoot) against the latest root this client has
+// cached via b.merkleRoot. A missing claimed root, or a failure to fetch
+// this client's own cached root, means there's nothing to compare, so
+// it's reported fresh -- this check is about catching a root that's
+// obviously too far ahead, not about requiring every message to carry
+// one.
+func (b *Boxer) verifyMerkleRootFreshness(ctx context.Context, claimed *chat1.MerkleRoot) UnboxingError {
+	if claimed == nil {
+		return nil
+	}
+	cached, err := b.merkleRoot(ctx)
+	if err != nil || cached == nil {
+		return nil
+	}
+	if claimed.Seqno > cached.Seqno+maxMerkleRootSeqnoSlack {
+		return NewPermanentUnboxingError(MerkleRootFreshnessError{
+			ClaimedSeqno: claimed.Seqno,
+			CachedSeqno:  cached.Seqno,
+		})
+	}
+	return nil
+}
+
+// BoxMessageOptions carries optional, non-default behavior for BoxMessage.
+type BoxMessageOptions struct {
+	// KeyGeneration pins BoxMessage to a specific CryptKey generation
+	// instead of the TLF's most recent one. The zero value (the default)
+	// keeps the historical "always use the latest generation" behavior.
+	// This is for testing key-rotation scenarios and for re-encrypting
+	// historical messages under the key generation they were originally
+	// sealed with; everyday sending should leave this unset. Ignored for
+	// a public TLF, which always boxes against publicCryptKey regardless.
+	KeyGeneration int
+}
+
+// boxMessage encrypts a keybase1.MessagePlaintext into a chat1.MessageBoxed.  It
+// finds the most recent key for the TLF, or the one opts.KeyGeneration
+// pins to, if set. It also returns the header
+// ciphertext hash it computed, so that a caller sending several messages in
+// a row can build the next message's prev pointer immediately, without
+// waiting for a server round-trip to learn it. This is the same hash
+// UnboxMessage computes from the boxed message it returns, as
+// headerHash.
+//
+// expectedConvID, when non-nil, is checked against msg.ClientHeader.Conv:
+// the conversation triple the caller is boxing for must derive exactly
+// that conversation ID, or BoxMessage refuses to box rather than risk
+// sending the message into the wrong conversation. This is the boxing-side
+// counterpart of the Derivable check inboxsource.go runs on unbox. Callers
+// that don't yet know their conversation ID (e.g. creating the first
+// message of a brand new conversation) pass nil to skip the check.
+func (b *Boxer) BoxMessage(ctx context.Context, msg chat1.MessagePlaintext, signingKeyPair libkb.NaclSigningKeyPair, expectedConvID *chat1.ConversationID, opts BoxMessageOptions) (*chat1.MessageBoxed, chat1.Hash, error) {
+	tlfName := msg.ClientHeader.TlfName
+	var recentKey *keybase1.CryptKey
+
+	if len(tlfName) == 0 {
+		return nil, nil, NewBlankTLFNameError()
+	}
+
+	if expectedConvID != nil && !msg.ClientHeader.Conv.Derivable(*expectedConvID) {
+		errMsg := fmt.Sprintf("conversation ID triple %#+v does not derive expected conversation ID %s",
+			msg.ClientHeader.Conv, expectedConvID.String())
+		return nil, nil, NewBoxingError(errMsg, true)
+	}
+
+	if b.tlf == nil {
+		return nil, nil, NewBoxingError(errNoTlfInterfaceConfigured, true)
+	}
+
+	cres, err := b.keyFinderFor(ctx).Find(ctx, b.tlf(), tlfName, msg.ClientHeader.TlfPublic)
+	if err != nil {
+		return nil, nil, NewBoxingCryptKeysError(err)
+	}
+	if err := checkCryptKeysForDuplicateGenerations(cres.CryptKeys); err != nil {
+		return nil, nil, NewBoxingError(err.Error(), false)
+	}
+	msg.ClientHeader.TlfName = string(cres.NameIDBreaks.CanonicalName)
+	if msg.ClientHeader.TlfPublic {
+		recentKey = &publicCryptKey
+	} else if opts.KeyGeneration != 0 {
+		for _, key := range cres.CryptKeys {
+			if key.KeyGeneration == opts.KeyGeneration {
+				pinnedKey := key
+				recentKey = &pinnedKey
+				break
+			}
+		}
+		if recentKey == nil {
+			msg := fmt.Sprintf("key generation %d not found for tlf %q", opts.KeyGeneration, tlfName)
+			return nil, nil, NewBoxingError(msg, false)
+		}
+	} else {
+		for _, key := range cres.CryptKeys {
+			if recentKey == nil || key.KeyGeneration > recentKey.KeyGeneration {
+				recentKey = &key
+			}
+		}
+	}
+
+	merkleRoot, err := b.latestMerkleRoot(ctx)
+	if err != nil {
+		return nil, nil, NewBoxingError(err.Error(), false)
+	}
+	msg.ClientHeader.MerkleRoot = merkleRoot
+
+	if len(msg.ClientHeader.TlfName) == 0 {
+		return nil, nil, NewBlankCanonicalTLFNameError(tlfName)
+	}
+
+	if recentKey == nil {
+		msg := fmt.Sprintf("no key found for tlf %q (public: %v)", tlfName, msg.ClientHeader.TlfPublic)
+		return nil, nil, NewBoxingError(msg, false)
+	}
+
+	boxed, headerHash, err := b.boxMessageWithKeys(msg, recentKey, signingKeyPair)
+	if err != nil {
+		return nil, nil, NewBoxingError(err.Error(), true)
+	}
+
+	return boxed, headerHash, nil
+}
+
+// BoxMessageWithKeys encrypts and signs msg using key directly, skipping
+// BoxMessage's TLF-based key lookup and merkle root fetch. It's the box
+// side counterpart to UnboxMessageWithKeys, for the same offline-tooling
+// use case: the caller already has the right chat1.CryptKey and has no
+// TLF interface to resolve one from. It works on a Boxer constructed with
+// a nil tlf.
+func (b *Boxer) BoxMessageWithKeys(msg chat1.MessagePlaintext, key *keybase1.CryptKey,
+	signingKeyPair libkb.NaclSigningKeyPair) (*chat1.MessageBoxed, chat1.Hash, error) {
+	return b.boxMessageWithKeys(msg, key, signingKeyPair)
 }
 
 // boxMessageWithKeys encrypts and signs a keybase1.MessagePlaintext into a
-// chat1.MessageBoxed given a keybase1.CryptKey.
+// chat1.MessageBoxed given a keybase1.CryptKey. It also returns the header
+// ciphertext hash (see BoxMessage).
 func (b *Boxer) boxMessageWithKeys(msg chat1.MessagePlaintext, key *keybase1.CryptKey,
-	signingKeyPair libkb.NaclSigningKeyPair) (*chat1.MessageBoxed, error) {
+	signingKeyPair libkb.NaclSigningKeyPair) (*chat1.MessageBoxed, chat1.Hash, error) {
+
+	bodyPlaintext, err := b.marshalBodyPlaintext(msg.MessageBody)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var encryptedBody chat1.EncryptedData
+	var bodyCiphertextChunks []chat1.BodyCiphertextChunk
+	var bodyHash chat1.Hash
+	if len(bodyPlaintext) >= bodyChunkThreshold {
+		bodyCiphertextChunks, bodyHash, err = b.sealBodyChunked(msg.MessageBody, key)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else {
+		sealed, err := b.sealBytes(bodyPlaintext, key)
+		if err != nil {
+			return nil, nil, err
+		}
+		encryptedBody = *sealed
+		bh := b.hashV1(encryptedBody.E)
+		bodyHash = bh[:]
+	}
+
+	// A message only needs the V3 header -- the one that carries
+	// EphemeralLifetime -- when it's actually ephemeral. Every other
+	// message keeps boxing as V1, unchanged, so existing callers and their
+	// recorded wire format don't shift just because this feature exists.
+	var plaintextHeader chat1.HeaderPlaintext
+	if msg.ClientHeader.EphemeralLifetime != nil && *msg.ClientHeader.EphemeralLifetime > 0 {
+		header := chat1.HeaderPlaintextV3{
+			Conv:              msg.ClientHeader.Conv,
+			TlfName:           msg.ClientHeader.TlfName,
+			TlfPublic:         msg.ClientHeader.TlfPublic,
+			MessageType:       msg.ClientHeader.MessageType,
+			Prev:              msg.ClientHeader.Prev,
+			Sender:            msg.ClientHeader.Sender,
+			SenderDevice:      msg.ClientHeader.SenderDevice,
+			BodyHash:          bodyHash[:],
+			OutboxInfo:        msg.ClientHeader.OutboxInfo,
+			OutboxID:          msg.ClientHeader.OutboxID,
+			EphemeralLifetime: msg.ClientHeader.EphemeralLifetime,
+		}
+		sig, err := b.signMarshal(header, signingKeyPair, libkb.SignaturePrefixChat)
+		if err != nil {
+			return nil, nil, err
+		}
+		header.HeaderSignature = &sig
+		plaintextHeader = chat1.NewHeaderPlaintextWithV3(header)
+	} else {
+		header := chat1.HeaderPlaintextV1{
+			Conv:         msg.ClientHeader.Conv,
+			TlfName:      msg.ClientHeader.TlfName,
+			TlfPublic:    msg.ClientHeader.TlfPublic,
+			MessageType:  msg.ClientHeader.MessageType,
+			Prev:         msg.ClientHeader.Prev,
+			Sender:       msg.ClientHeader.Sender,
+			SenderDevice: msg.ClientHeader.SenderDevice,
+			BodyHash:     bodyHash[:],
+			OutboxInfo:   msg.ClientHeader.OutboxInfo,
+			OutboxID:     msg.ClientHeader.OutboxID,
+		}
+		sig, err := b.signMarshal(header, signingKeyPair, libkb.SignaturePrefixChat)
+		if err != nil {
+			return nil, nil, err
+		}
+		header.HeaderSignature = &sig
+		plaintextHeader = chat1.NewHeaderPlaintextWithV1(header)
+	}
+
+	encryptedHeader, err := b.seal(plaintextHeader, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	boxed := &chat1.MessageBoxed{
+		ClientHeader:         msg.ClientHeader,
+		BodyCiphertext:       encryptedBody,
+		BodyCiphertextChunks: bodyCiphertextChunks,
+		HeaderCiphertext:     *encryptedHeader,
+		KeyGeneration:        key.KeyGeneration,
+	}
+
+	headerHash := b.hashV1(encryptedHeader.E)
+	return boxed, headerHash, nil
+}
+
+// BoxedSizeEstimate holds the estimated wire size of a chat1.MessageBoxed,
+// broken down the same way boxMessageWithKeys builds one.
+type BoxedSizeEstimate struct {
+	// BodyCiphertextSize is the estimated size of MessageBoxed.BodyCiphertext.E.
+	BodyCiphertextSize int
+	// HeaderCiphertextSize is the estimated size of MessageBoxed.HeaderCiphertext.E.
+	HeaderCiphertextSize int
+	// TotalSize is BodyCiphertextSize + HeaderCiphertextSize, plus the
+	// nonces that accompany each (MessageBoxed.{Body,Header}Ciphertext.N).
+	TotalSize int
+}
 
+// EstimateBoxedSize estimates the size of the chat1.MessageBoxed that
+// BoxMessage would produce for msg, without performing any encryption or
+// making a network call for the encryption key or signing key. This lets a
+// caller decide, e.g., whether a message is large enough to ship as an
+// attachment instead of inline, before paying for the real boxing.
+//
+// The body ciphertext size is exact for bodies small enough that
+// boxMessageWithKeys would seal them as BodyPlaintextV1, since
+// msgpack-marshaling is deterministic and secretbox.Seal always adds
+// exactly secretbox.Overhead bytes. It's only an upper bound for a body
+// large enough to cross marshalBodyPlaintext's threshold into the more
+// compact BodyPlaintextV2 encoding, since this always estimates off the
+// V1 size. A body at or above bodyChunkThreshold is sealed as
+// BodyCiphertextChunks with an empty BodyCiphertext; the per-chunk nonces,
+// hashes and overhead are not counted here, so BodyCiphertextSize and
+// TotalSize are not a bound for such a body. The header ciphertext size is an estimate, because the real
+// header embeds a HeaderSignature signed with the sender's actual signing
+// key; this estimates that signature's size (using a throwaway signing
+// key, since NaCl signatures and the KIDs that accompany them are always
+// the same size) rather than the real one, which EstimateBoxedSize never
+// has access to.
+func (b *Boxer) EstimateBoxedSize(msg chat1.MessagePlaintext) (BoxedSizeEstimate, error) {
 	body := chat1.BodyPlaintextV1{
 		MessageBody: msg.MessageBody,
 	}
 	plaintextBody := chat1.NewBodyPlaintextWithV1(body)
-	encryptedBody, err := b.seal(plaintextBody, key)
+	bodyPlaintext, err := b.marshal(plaintextBody)
 	if err != nil {
-		return nil, err
+		return BoxedSizeEstimate{}, err
 	}
+	bodyCiphertextSize := len(bodyPlaintext) + secretbox.Overhead
 
-	bodyHash := b.hashV1(encryptedBody.E)
-
-	// create the v1 header, adding hash
+	// The body hash is a fixed-size sha256 sum regardless of the body's
+	// actual ciphertext, so a zero-valued placeholder is exact, not just
+	// an estimate.
 	header := chat1.HeaderPlaintextV1{
 		Conv:         msg.ClientHeader.Conv,
 		TlfName:      msg.ClientHeader.TlfName,
@@ -453,33 +1694,70 @@ func (b *Boxer) boxMessageWithKeys(msg chat1.MessagePlaintext, key *keybase1.Cry
 		Prev:         msg.ClientHeader.Prev,
 		Sender:       msg.ClientHeader.Sender,
 		SenderDevice: msg.ClientHeader.SenderDevice,
-		BodyHash:     bodyHash[:],
+		BodyHash:     make(chat1.Hash, sha256.Size),
 		OutboxInfo:   msg.ClientHeader.OutboxInfo,
 		OutboxID:     msg.ClientHeader.OutboxID,
 	}
 
-	// sign the header and insert the signature
-	sig, err := b.signMarshal(header, signingKeyPair, libkb.SignaturePrefixChat)
+	signKP, err := libkb.GenerateNaclSigningKeyPair()
 	if err != nil {
-		return nil, err
+		return BoxedSizeEstimate{}, err
+	}
+	sig, err := b.signMarshal(header, signKP, libkb.SignaturePrefixChat)
+	if err != nil {
+		return BoxedSizeEstimate{}, err
 	}
 	header.HeaderSignature = &sig
 
-	// create a plaintext header
-	plaintextHeader := chat1.NewHeaderPlaintextWithV1(header)
-	encryptedHeader, err := b.seal(plaintextHeader, key)
+	headerPlaintext, err := b.marshal(chat1.NewHeaderPlaintextWithV1(header))
 	if err != nil {
-		return nil, err
+		return BoxedSizeEstimate{}, err
 	}
+	headerCiphertextSize := len(headerPlaintext) + secretbox.Overhead
 
-	boxed := &chat1.MessageBoxed{
-		ClientHeader:     msg.ClientHeader,
-		BodyCiphertext:   *encryptedBody,
-		HeaderCiphertext: *encryptedHeader,
-		KeyGeneration:    key.KeyGeneration,
+	return BoxedSizeEstimate{
+		BodyCiphertextSize:   bodyCiphertextSize,
+		HeaderCiphertextSize: headerCiphertextSize,
+		TotalSize:            bodyCiphertextSize + headerCiphertextSize + 2*libkb.NaclDHNonceSize,
+	}, nil
+}
+
+// ReBox re-encrypts the plaintext content of a previously-unboxed message
+// under the TLF's current (highest-generation) key, signing it fresh with
+// signingKeyPair. It exists for migrations that need to move old messages
+// off a key generation that's being retired after a rekey, not for
+// everyday sending.
+//
+// unboxed must be in the VALID state: that's the only state that reflects
+// a message whose signature and sender key were actually checked by
+// UnboxMessage, so it's the only input ReBox trusts enough to re-seal
+// under a fresh key and a fresh signature. An ERROR or OUTBOX message is
+// refused outright.
+//
+// The original ClientHeader -- in particular Sender and SenderDevice -- is
+// carried over unchanged, so the re-boxed message still attributes to
+// whoever actually sent it; only the encryption key and the header
+// signature are new. Ctime isn't part of ClientHeader at all: it's
+// assigned by the server when a message is posted, so re-boxing doesn't
+// touch it.
+func (b *Boxer) ReBox(ctx context.Context, unboxed chat1.MessageUnboxed, signingKeyPair libkb.NaclSigningKeyPair) (*chat1.MessageBoxed, chat1.Hash, error) {
+	state, err := unboxed.State()
+	if err != nil {
+		return nil, nil, NewBoxingError(err.Error(), true)
+	}
+	if state != chat1.MessageUnboxedState_VALID {
+		return nil, nil, NewBoxingError(
+			fmt.Sprintf("refusing to re-box a message in state %v: only a fully valid message can be re-boxed", state),
+			true)
+	}
+
+	valid := unboxed.Valid()
+	plaintext := chat1.MessagePlaintext{
+		ClientHeader: valid.ClientHeader,
+		MessageBody:  valid.MessageBody,
 	}
 
-	return boxed, nil
+	return b.BoxMessage(ctx, plaintext, signingKeyPair, nil, BoxMessageOptions{})
 }
 
 // seal encrypts data into chat1.EncryptedData.
@@ -488,13 +1766,19 @@ func (b *Boxer) seal(data interface{}, key *keybase1.CryptKey) (*chat1.Encrypted
 	if err != nil {
 		return nil, err
 	}
+	return b.sealBytes(s, key)
+}
 
+// sealBytes encrypts already-marshaled plaintext into chat1.EncryptedData.
+// It exists alongside seal for callers like marshalBodyPlaintext that need
+// to choose how plaintext gets marshaled themselves.
+func (b *Boxer) sealBytes(plaintext []byte, key *keybase1.CryptKey) (*chat1.EncryptedData, error) {
 	var nonce [libkb.NaclDHNonceSize]byte
 	if _, err := rand.Read(nonce[:]); err != nil {
 		return nil, err
 	}
 
-	sealed := secretbox.Seal(nil, []byte(s), &nonce, ((*[32]byte)(&key.Key)))
+	sealed := secretbox.Seal(nil, plaintext, &nonce, ((*[32]byte)(&key.Key)))
 	enc := &chat1.EncryptedData{
 		V: 1,
 		E: sealed,
@@ -504,6 +1788,117 @@ func (b *Boxer) seal(data interface{}, key *keybase1.CryptKey) (*chat1.Encrypted
 	return enc, nil
 }
 
+// bodyChunkThreshold is the plaintext size, in bytes, at or above which
+// boxMessageWithKeys seals a body as an ordered list of independently
+// sealed chunks (chat1.BodyCiphertextChunk) instead of a single secretbox
+// blob -- a first cut at the cutoff, chosen high enough that only unusually
+// large bodies (giant system messages, sprawling edits) ever take the
+// chunked path, since each chunk boundary costs a little extra overhead
+// (its own nonce and per-chunk hash) that a single-blob body doesn't pay.
+const bodyChunkThreshold = 5 * 1024 * 1024
+
+// bodyChunkSize is the plaintext size of each chunk sealBodyChunked seals
+// independently.
+const bodyChunkSize = 1 * 1024 * 1024
+
+// sealBodyChunked seals msgBody as a chat1.BodyPlaintextV3, split into an
+// ordered list of chunks of at most bodyChunkSize plaintext bytes each,
+// sealed independently, for use when the body is too large to comfortably
+// seal as a single secretbox blob (see bodyChunkThreshold). It returns the
+// sealed chunks in order, and an aggregate body hash suitable for
+// HeaderPlaintextV1.BodyHash: the hash of the concatenation of the
+// chunks' own BodyCiphertextChunkHeader.Hash values, in order. Hashing the
+// per-chunk hashes, rather than the concatenated ciphertext itself, means
+// computing the aggregate never requires holding the full ciphertext in
+// memory at once.
+func (b *Boxer) sealBodyChunked(msgBody chat1.MessageBody, key *keybase1.CryptKey) ([]chat1.BodyCiphertextChunk, chat1.Hash, error) {
+	v3 := chat1.NewBodyPlaintextWithV3(chat1.BodyPlaintextV3{MessageBody: msgBody})
+	plaintext, err := b.marshal(v3)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	total := (len(plaintext) + bodyChunkSize - 1) / bodyChunkSize
+	if total == 0 {
+		total = 1
+	}
+	chunks := make([]chat1.BodyCiphertextChunk, 0, total)
+	var aggregateInput []byte
+	for i := 0; i < total; i++ {
+		start := i * bodyChunkSize
+		end := start + bodyChunkSize
+		if end > len(plaintext) {
+			end = len(plaintext)
+		}
+		sealed, err := b.sealBytes(plaintext[start:end], key)
+		if err != nil {
+			return nil, nil, err
+		}
+		chunkHash := b.hashV1(sealed.E)
+		aggregateInput = append(aggregateInput, chunkHash...)
+		chunks = append(chunks, chat1.BodyCiphertextChunk{
+			Header: chat1.BodyCiphertextChunkHeader{
+				Index: i,
+				Total: total,
+				Hash:  chunkHash,
+			},
+			Ciphertext: *sealed,
+		})
+	}
+	return chunks, b.hashV1(aggregateInput), nil
+}
+
+// verifyBodyCiphertextChunks checks that chunks is a complete,
+// correctly-ordered sequence whose claimed per-chunk hashes match their
+// actual ciphertexts, and returns the aggregate body hash -- the same
+// quantity sealBodyChunked computed when boxing -- for comparison against
+// HeaderPlaintextV1.BodyHash.
+func (b *Boxer) verifyBodyCiphertextChunks(chunks []chat1.BodyCiphertextChunk) (chat1.Hash, error) {
+	var aggregateInput []byte
+	for i, chunk := range chunks {
+		if chunk.Header.Total != len(chunks) {
+			return nil, fmt.Errorf("chat: chunk %d claims %d total chunks, but got %d", i, chunk.Header.Total, len(chunks))
+		}
+		if chunk.Header.Index != i {
+			return nil, fmt.Errorf("chat: chunk at position %d claims index %d", i, chunk.Header.Index)
+		}
+		actualHash := b.hashV1(chunk.Ciphertext.E)
+		if !libkb.SecureByteArrayEq(actualHash, chunk.Header.Hash) {
+			return nil, fmt.Errorf("chat: chunk %d ciphertext hash mismatch", i)
+		}
+		aggregateInput = append(aggregateInput, actualHash...)
+	}
+	return b.hashV1(aggregateInput), nil
+}
+
+// openBodyChunked verifies and reassembles a chunked body, returning the
+// concatenated plaintext (the marshaled chat1.BodyPlaintextV3) in order.
+func (b *Boxer) openBodyChunked(chunks []chat1.BodyCiphertextChunk, key *keybase1.CryptKey) ([]byte, error) {
+	if _, err := b.verifyBodyCiphertextChunks(chunks); err != nil {
+		return nil, err
+	}
+	var plaintext []byte
+	for _, chunk := range chunks {
+		chunkPlaintext, err := b.open(chunk.Ciphertext, key)
+		if err != nil {
+			return nil, err
+		}
+		plaintext = append(plaintext, chunkPlaintext...)
+	}
+	return plaintext, nil
+}
+
+// computeBodyHash recomputes the body hash that should match
+// HeaderPlaintextV1.BodyHash for msg, whether its body is a single
+// bodyCiphertext blob or a chunked bodyCiphertextChunks sequence.
+func (b *Boxer) computeBodyHash(msg chat1.MessageBoxed) (chat1.Hash, error) {
+	if len(msg.BodyCiphertextChunks) > 0 {
+		return b.verifyBodyCiphertextChunks(msg.BodyCiphertextChunks)
+	}
+	bh := b.hashV1(msg.BodyCiphertext.E)
+	return bh[:], nil
+}
+
 // open decrypts chat1.EncryptedData.
 func (b *Boxer) open(data chat1.EncryptedData, key *keybase1.CryptKey) ([]byte, error) {
 	if len(data.N) != libkb.NaclDHNonceSize {
@@ -514,15 +1909,48 @@ func (b *Boxer) open(data chat1.EncryptedData, key *keybase1.CryptKey) ([]byte,
 
 	plain, ok := secretbox.Open(nil, data.E, &nonce, ((*[32]byte)(&key.Key)))
 	if !ok {
-		return nil, libkb.DecryptOpenError{}
+		return nil, NewDecryptOpenError(key.KeyGeneration)
 	}
 	return plain, nil
 }
 
+// SealForKey is an exported, key-parameterized wrapper around seal for
+// debugging and migration tooling that needs to produce chat1.EncryptedData
+// payloads outside of the normal BoxMessage path. Internal callers should
+// keep using the unexported seal. SealForKey does not sign its output; it
+// is not a substitute for BoxMessage when message authenticity matters.
+func (b *Boxer) SealForKey(data interface{}, key *keybase1.CryptKey) (*chat1.EncryptedData, error) {
+	return b.seal(data, key)
+}
+
+// OpenWithKey is an exported, key-parameterized wrapper around open for
+// debugging and migration tooling that needs to decrypt chat1.EncryptedData
+// payloads outside of the normal UnboxMessage path. Internal callers should
+// keep using the unexported open. OpenWithKey does not verify any
+// signature over the plaintext; callers that need that guarantee should go
+// through UnboxMessage instead.
+func (b *Boxer) OpenWithKey(data chat1.EncryptedData, key *keybase1.CryptKey) ([]byte, error) {
+	return b.open(data, key)
+}
+
+// marshalCanonicalHeader is the one encoding boxMessageWithKeys signs a
+// header with and verifyMessageHeaderV1/V2/V3 re-derive to check that
+// signature against -- it's just b.marshal, given its own name and doc
+// comment so both call sites are provably using the same encoding instead
+// of two separate calls to a general-purpose helper that happen to agree
+// today. If a codec config change or dependency bump ever made those two
+// calls diverge, TestChatCanonicalHeaderEncodingGolden in
+// canonical_header_test.go is what
+// should catch it, not a signature failure in production.
+func (b *Boxer) marshalCanonicalHeader(header interface{}) ([]byte, error) {
+	return b.marshal(header)
+}
+
 // signMarshal signs data with a NaclSigningKeyPair, returning a chat1.SignatureInfo.
-// It marshals data before signing.
+// It marshals data before signing, using the same canonical encoding
+// verifyMessageHeaderV1/V2/V3 use to check the result.
 func (b *Boxer) signMarshal(data interface{}, kp libkb.NaclSigningKeyPair, prefix libkb.SignaturePrefix) (chat1.SignatureInfo, error) {
-	encoded, err := b.marshal(data)
+	encoded, err := b.marshalCanonicalHeader(data)
 	if err != nil {
 		return chat1.SignatureInfo{}, err
 	}
@@ -546,10 +1974,16 @@ func sign(msg []byte, kp libkb.NaclSigningKeyPair, prefix libkb.SignaturePrefix)
 
 type verifyMessageRes struct {
 	senderDeviceRevokedAt *gregor1.Time
+
+	// headerSignedBytes holds the exact bytes that were signature-checked
+	// for this message, when Boxer.includeSignedHeaderBytes is set; nil
+	// otherwise.
+	headerSignedBytes []byte
 }
 
-// verifyMessage checks that a message is valid.
-func (b *Boxer) verifyMessage(ctx context.Context, header chat1.HeaderPlaintext, msg chat1.MessageBoxed, skipBodyVerification bool) (verifyMessageRes, UnboxingError) {
+// verifyMessage checks that a message is valid. See UnboxMessage for the
+// meaning of asOf.
+func (b *Boxer) verifyMessage(ctx context.Context, header chat1.HeaderPlaintext, msg chat1.MessageBoxed, key *keybase1.CryptKey, skipBodyVerification bool, asOf *gregor1.Time) (verifyMessageRes, UnboxingError) {
 	headerVersion, err := header.Version()
 	if err != nil {
 		return verifyMessageRes{}, NewPermanentUnboxingError(err)
@@ -557,7 +1991,11 @@ func (b *Boxer) verifyMessage(ctx context.Context, header chat1.HeaderPlaintext,
 
 	switch headerVersion {
 	case chat1.HeaderPlaintextVersion_V1:
-		return b.verifyMessageHeaderV1(ctx, header.V1(), msg, skipBodyVerification)
+		return b.verifyMessageHeaderV1(ctx, header.V1(), msg, key, skipBodyVerification, asOf)
+	case chat1.HeaderPlaintextVersion_V2:
+		return b.verifyMessageHeaderV2(ctx, header.V2(), msg, key, skipBodyVerification, asOf)
+	case chat1.HeaderPlaintextVersion_V3:
+		return b.verifyMessageHeaderV3(ctx, header.V3(), msg, key, skipBodyVerification, asOf)
 	default:
 		return verifyMessageRes{},
 			NewPermanentUnboxingError(NewHeaderVersionError(headerVersion,
@@ -565,46 +2003,297 @@ func (b *Boxer) verifyMessage(ctx context.Context, header chat1.HeaderPlaintext,
 	}
 }
 
-// verifyMessageHeaderV1 checks the body hash, header signature, and signing key validity.
-func (b *Boxer) verifyMessageHeaderV1(ctx context.Context, header chat1.HeaderPlaintextV1, msg chat1.MessageBoxed, skipBodyVerification bool) (verifyMessageRes, UnboxingError) {
-	if !skipBodyVerification {
-		// check body hash
-		bh := b.hashV1(msg.BodyCiphertext.E)
-		if !libkb.SecureByteArrayEq(bh[:], header.BodyHash) {
-			return verifyMessageRes{}, NewPermanentUnboxingError(BodyHashInvalid{})
-		}
+// verifyMessageHeaderFields holds the fields of a versioned HeaderPlaintext
+// variant that verifyMessageHeader needs once its caller has already
+// produced hpack (the version-specific struct, signature stripped and
+// marshaled back out) for the signature check. Every version so far (V1,
+// V2, V3) shares these fields exactly; adding a version that doesn't is a
+// matter of giving it its own version-specific mapping into this struct, the
+// same way verifyMessageHeaderV1, verifyMessageHeaderV2 and
+// verifyMessageHeaderV3 do.
+type verifyMessageHeaderFields struct {
+	conv            chat1.ConversationIDTriple
+	sender          gregor1.UID
+	senderDevice    gregor1.DeviceID
+	bodyHash        chat1.Hash
+	headerSignature *chat1.SignatureInfo
+}
+
+// logVerifyFailure centralizes the context logged (at debug level) for every
+// verifyMessageHeader failure path, so a failed verification always logs
+// enough to reproduce it in a tool: the message id, conversation id triple,
+// key generation used, claimed vs. computed body hash, signing KID, and
+// sender UID/device. It never logs the plaintext body or any key material.
+func (b *Boxer) logVerifyFailure(ctx context.Context, msg chat1.MessageBoxed, fields verifyMessageHeaderFields, key *keybase1.CryptKey, claimedBodyHash, computedBodyHash chat1.Hash, reason string) {
+	var keyGen int
+	if key != nil {
+		keyGen = key.KeyGeneration
+	}
+	var signingKID []byte
+	if fields.headerSignature != nil {
+		signingKID = fields.headerSignature.K
+	}
+	var msgID chat1.MessageID
+	if msg.ServerHeader != nil {
+		msgID = msg.ServerHeader.MessageID
+	}
+	b.Debug(ctx, "verifyMessageHeader failed: reason: %s msgID: %d conv: %+v keyGeneration: %d "+
+		"claimedBodyHash: %x computedBodyHash: %x signingKID: %x sender: %s senderDevice: %s",
+		reason, msgID, fields.conv, keyGen, claimedBodyHash, computedBodyHash, signingKID,
+		fields.sender, fields.senderDevice)
+}
+
+// verifyMessageHeaderV1 checks the body hash, header signature, and signing
+// key validity of a V1 header. See UnboxMessage for the meaning of asOf.
+func (b *Boxer) verifyMessageHeaderV1(ctx context.Context, header chat1.HeaderPlaintextV1, msg chat1.MessageBoxed, key *keybase1.CryptKey, skipBodyVerification bool, asOf *gregor1.Time) (verifyMessageRes, UnboxingError) {
+	hcopy := header
+	hcopy.HeaderSignature = nil
+	hpack, err := b.marshalCanonicalHeader(hcopy)
+	if err != nil {
+		b.logVerifyFailure(ctx, msg, verifyMessageHeaderFieldsFromV1(header), key, nil, nil,
+			fmt.Sprintf("marshaling header for signature check: %s", err))
+		return verifyMessageRes{}, NewPermanentUnboxingError(err)
 	}
+	return b.verifyMessageHeader(ctx, verifyMessageHeaderFieldsFromV1(header), hpack, msg, key, skipBodyVerification, asOf)
+}
 
-	// check signature
+func verifyMessageHeaderFieldsFromV1(header chat1.HeaderPlaintextV1) verifyMessageHeaderFields {
+	return verifyMessageHeaderFields{
+		conv:            header.Conv,
+		sender:          header.Sender,
+		senderDevice:    header.SenderDevice,
+		bodyHash:        header.BodyHash,
+		headerSignature: header.HeaderSignature,
+	}
+}
+
+// verifyMessageHeaderV2 checks the body hash, header signature, and signing
+// key validity of a V2 header. V2 is field-for-field identical to V1, so
+// this is the V1 function above with the header type swapped; see
+// verifyMessageHeaderFields for what to do when that stops being true.
+func (b *Boxer) verifyMessageHeaderV2(ctx context.Context, header chat1.HeaderPlaintextV2, msg chat1.MessageBoxed, key *keybase1.CryptKey, skipBodyVerification bool, asOf *gregor1.Time) (verifyMessageRes, UnboxingError) {
+	hcopy := header
+	hcopy.HeaderSignature = nil
+	hpack, err := b.marshalCanonicalHeader(hcopy)
+	if err != nil {
+		b.logVerifyFailure(ctx, msg, verifyMessageHeaderFieldsFromV2(header), key, nil, nil,
+			fmt.Sprintf("marshaling header for signature check: %s", err))
+		return verifyMessageRes{}, NewPermanentUnboxingError(err)
+	}
+	return b.verifyMessageHeader(ctx, verifyMessageHeaderFieldsFromV2(header), hpack, msg, key, skipBodyVerification, asOf)
+}
+
+func verifyMessageHeaderFieldsFromV2(header chat1.HeaderPlaintextV2) verifyMessageHeaderFields {
+	return verifyMessageHeaderFields{
+		conv:            header.Conv,
+		sender:          header.Sender,
+		senderDevice:    header.SenderDevice,
+		bodyHash:        header.BodyHash,
+		headerSignature: header.HeaderSignature,
+	}
+}
+
+// verifyMessageHeaderV3 checks the body hash, header signature, and signing
+// key validity of a V3 header. V3 adds EphemeralLifetime to V2's fields,
+// none of which verifyMessageHeader needs, so this is otherwise identical
+// to verifyMessageHeaderV1/V2.
+func (b *Boxer) verifyMessageHeaderV3(ctx context.Context, header chat1.HeaderPlaintextV3, msg chat1.MessageBoxed, key *keybase1.CryptKey, skipBodyVerification bool, asOf *gregor1.Time) (verifyMessageRes, UnboxingError) {
 	hcopy := header
 	hcopy.HeaderSignature = nil
-	hpack, err := b.marshal(hcopy)
+	hpack, err := b.marshalCanonicalHeader(hcopy)
 	if err != nil {
+		b.logVerifyFailure(ctx, msg, verifyMessageHeaderFieldsFromV3(header), key, nil, nil,
+			fmt.Sprintf("marshaling header for signature check: %s", err))
 		return verifyMessageRes{}, NewPermanentUnboxingError(err)
 	}
-	if !b.verify(hpack, *header.HeaderSignature, libkb.SignaturePrefixChat) {
-		return verifyMessageRes{}, NewPermanentUnboxingError(libkb.BadSigError{E: "header signature invalid"})
+	return b.verifyMessageHeader(ctx, verifyMessageHeaderFieldsFromV3(header), hpack, msg, key, skipBodyVerification, asOf)
+}
+
+func verifyMessageHeaderFieldsFromV3(header chat1.HeaderPlaintextV3) verifyMessageHeaderFields {
+	return verifyMessageHeaderFields{
+		conv:            header.Conv,
+		sender:          header.Sender,
+		senderDevice:    header.SenderDevice,
+		bodyHash:        header.BodyHash,
+		headerSignature: header.HeaderSignature,
+	}
+}
+
+// verifyMessageHeader runs the version-independent half of header
+// verification shared by every verifyMessageHeaderV*: the body hash check,
+// the header signature check against hpack (the version-specific header
+// struct, signature stripped, marshaled by the caller so the bytes match
+// what the sender actually signed), and the sender's key validity. See
+// UnboxMessage for the meaning of asOf.
+func (b *Boxer) verifyMessageHeader(ctx context.Context, fields verifyMessageHeaderFields, hpack []byte, msg chat1.MessageBoxed, key *keybase1.CryptKey, skipBodyVerification bool, asOf *gregor1.Time) (verifyMessageRes, UnboxingError) {
+	if !skipBodyVerification {
+		// check body hash
+		bh, err := b.computeBodyHash(msg)
+		if err != nil {
+			b.logVerifyFailure(ctx, msg, fields, key, fields.bodyHash, nil, fmt.Sprintf("computing body hash: %s", err))
+			return verifyMessageRes{}, NewPermanentUnboxingError(BodyHashInvalid{})
+		}
+		if !libkb.SecureByteArrayEq(bh, fields.bodyHash) {
+			b.logVerifyFailure(ctx, msg, fields, key, fields.bodyHash, bh, "body hash mismatch")
+			return verifyMessageRes{}, NewPermanentUnboxingError(BodyHashInvalid{})
+		}
+		if msg.ServerHeader != nil {
+			if ierr, alsoSuspect := b.bodyHashChecker.Check(fields.conv, msg.ServerHeader.MessageID, fields.bodyHash); ierr != nil {
+				b.logVerifyFailure(ctx, msg, fields, key, fields.bodyHash, bh,
+					fmt.Sprintf("body hash checker rejected message (also suspect: %v)", alsoSuspect))
+				return verifyMessageRes{}, ierr
+			}
+		}
 	}
 
-	// check key validity
-	found, validAtCtime, revoked, ierr := b.ValidSenderKey(ctx, header.Sender, header.HeaderSignature.K, msg.ServerHeader.Ctime)
+	// check signature
+	if err := b.verify(hpack, *fields.headerSignature, libkb.SignaturePrefixChat); err != nil {
+		b.logVerifyFailure(ctx, msg, fields, key, nil, nil, fmt.Sprintf("header signature invalid: %s", err))
+		return verifyMessageRes{}, NewPermanentUnboxingError(NewHeaderSignatureError(err, fields.headerSignature.K))
+	}
+
+	// check key validity, against the caller-supplied asOf time if given,
+	// falling back to the (server-controlled) ctime otherwise
+	validityCheckTime := msg.ServerHeader.Ctime
+	if asOf != nil {
+		validityCheckTime = *asOf
+	}
+	found, validAtCtime, revoked, ierr := b.ValidSenderKey(ctx, fields.sender, fields.headerSignature.K, validityCheckTime)
 	if ierr != nil {
+		b.logVerifyFailure(ctx, msg, fields, key, nil, nil, fmt.Sprintf("checking sender key validity: %s", ierr.Error()))
 		return verifyMessageRes{}, ierr
 	}
 	if !found {
-		return verifyMessageRes{}, NewPermanentUnboxingError(libkb.NoKeyError{Msg: "sender key not found"})
+		b.logVerifyFailure(ctx, msg, fields, key, nil, nil, "sender key not found")
+		return verifyMessageRes{}, NewPermanentUnboxingError(SenderKeyNotFoundError{
+			Reason: "key does not belong to sender",
+			KID:    fields.headerSignature.K,
+		})
 	}
 	if !validAtCtime {
-		return verifyMessageRes{}, NewPermanentUnboxingError(libkb.NoKeyError{Msg: "key invalid for sender at message ctime"})
+		b.logVerifyFailure(ctx, msg, fields, key, nil, nil, "key invalid for sender at message ctime")
+		return verifyMessageRes{}, NewPermanentUnboxingError(SenderKeyNotFoundError{
+			Reason: "key invalid for sender at message ctime",
+			KID:    fields.headerSignature.K,
+		})
+	}
+
+	deviceValid, ierr := b.ValidSenderDeviceKey(ctx, fields.sender, fields.senderDevice, fields.headerSignature.K)
+	if ierr != nil {
+		b.logVerifyFailure(ctx, msg, fields, key, nil, nil, fmt.Sprintf("checking sender device key binding: %s", ierr.Error()))
+		return verifyMessageRes{}, ierr
+	}
+	if !deviceValid {
+		b.logVerifyFailure(ctx, msg, fields, key, nil, nil, "sender key does not belong to the claimed sending device")
+		return verifyMessageRes{}, NewPermanentUnboxingError(SenderKeyDeviceMismatchError{KID: fields.headerSignature.K})
 	}
 
-	return verifyMessageRes{
+	if b.requireCurrentlyActiveSenderKey {
+		_, active, ierr := b.CurrentlyActiveSenderKey(ctx, fields.sender, fields.headerSignature.K)
+		if ierr != nil {
+			b.logVerifyFailure(ctx, msg, fields, key, nil, nil, fmt.Sprintf("checking sender key current activeness: %s", ierr.Error()))
+			return verifyMessageRes{}, ierr
+		}
+		if !active {
+			b.logVerifyFailure(ctx, msg, fields, key, nil, nil, "sender key no longer currently active")
+			return verifyMessageRes{}, NewPermanentUnboxingError(SenderKeyNotCurrentlyActiveError{})
+		}
+	}
+
+	res := verifyMessageRes{
 		senderDeviceRevokedAt: revoked,
+	}
+	if b.includeSignedHeaderBytes {
+		res.headerSignedBytes = hpack
+	}
+	return res, nil
+}
+
+// BodyHashResult is the result of Boxer.CheckBodyHash: the hash the header
+// claims for the body ciphertext, the hash actually computed from it, and
+// whether they match.
+type BodyHashResult struct {
+	Match    bool
+	Claimed  chat1.Hash
+	Computed chat1.Hash
+}
+
+// CheckBodyHash decrypts boxed's header with key and isolates the specific
+// check that fires BodyHashInvalid in verifyMessageHeader: it recomputes
+// the hash of boxed.BodyCiphertext.E and compares it against the BodyHash
+// the header claims. This is meant for support tooling diagnosing a
+// "tampered message" report -- it doesn't check the header signature or
+// sender key validity, so a mismatch here isolates body tampering from
+// those other failure modes.
+func (b *Boxer) CheckBodyHash(boxed chat1.MessageBoxed, key *keybase1.CryptKey) (BodyHashResult, error) {
+	packedHeader, err := b.open(boxed.HeaderCiphertext, key)
+	if err != nil {
+		return BodyHashResult{}, err
+	}
+	var header chat1.HeaderPlaintext
+	if err := b.unmarshal(packedHeader, &header); err != nil {
+		return BodyHashResult{}, err
+	}
+
+	headerVersion, err := header.Version()
+	if err != nil {
+		return BodyHashResult{}, err
+	}
+	var claimed chat1.Hash
+	switch headerVersion {
+	case chat1.HeaderPlaintextVersion_V1:
+		claimed = header.V1().BodyHash
+	case chat1.HeaderPlaintextVersion_V2:
+		claimed = header.V2().BodyHash
+	case chat1.HeaderPlaintextVersion_V3:
+		claimed = header.V3().BodyHash
+	default:
+		return BodyHashResult{}, NewHeaderVersionError(headerVersion, b.headerUnsupported(context.Background(), headerVersion, header))
+	}
+
+	computed, err := b.computeBodyHash(boxed)
+	if err != nil {
+		return BodyHashResult{}, err
+	}
+
+	return BodyHashResult{
+		Match:    libkb.SecureByteArrayEq(claimed, computed),
+		Claimed:  claimed,
+		Computed: computed,
 	}, nil
 }
 
+// VerifyHeaderResult is the exported subset of verifyMessage's internal
+// result that VerifyHeader hands back to callers outside this package.
+type VerifyHeaderResult struct {
+	// SenderDeviceRevokedAt is set if the sender's key was revoked as of
+	// the verification time, even if it was still valid then (see
+	// ValidSenderKey).
+	SenderDeviceRevokedAt *gregor1.Time
+}
+
+// VerifyHeader checks header's signature and sender key validity for a
+// caller that already has the decrypted header in hand -- a cache
+// re-verifying a plaintext it decrypted earlier against updated
+// revocation info, for example -- without going through UnboxMessage's
+// decrypt path. skipBody controls whether header's claimed body hash is
+// checked against boxed's body ciphertext as well.
+func (b *Boxer) VerifyHeader(ctx context.Context, header chat1.HeaderPlaintext, boxed chat1.MessageBoxed, skipBody bool) (VerifyHeaderResult, UnboxingError) {
+	res, ierr := b.verifyMessage(ctx, header, boxed, nil, skipBody, nil)
+	if ierr != nil {
+		return VerifyHeaderResult{}, ierr
+	}
+	return VerifyHeaderResult{SenderDeviceRevokedAt: res.senderDeviceRevokedAt}, nil
+}
+
 // verify verifies the signature of data using SignatureInfo.
-func (b *Boxer) verify(data []byte, si chat1.SignatureInfo, prefix libkb.SignaturePrefix) bool {
+// verify checks data against a chat1.SignatureInfo, returning the
+// underlying libkb verification error -- a malformed key, a signature
+// that doesn't match the payload, or an unsupported signature version --
+// rather than collapsing every failure into a single bool. This lets
+// verifyMessageHeaderV1 report which of those actually happened instead of
+// a single undifferentiated "header signature invalid".
+func (b *Boxer) verify(data []byte, si chat1.SignatureInfo, prefix libkb.SignaturePrefix) error {
 	sigInfo := libkb.NaclSigInfo{
 		Version: si.V,
 		Prefix:  prefix,
@@ -613,29 +2302,25 @@ func (b *Boxer) verify(data []byte, si chat1.SignatureInfo, prefix libkb.Signatu
 	}
 	copy(sigInfo.Sig[:], si.S)
 	_, err := sigInfo.Verify()
-	return (err == nil)
+	return err
 }
 
 // ValidSenderKey checks that the key was active for sender at ctime.
 // This trusts the server for ctime, so a colluding server could use a revoked key and this check wouldn't notice.
 // Returns (validAtCtime, revoked, err)
+//
+// The underlying CheckKIDForUID lookup is memoized in b.senderKeyCache,
+// keyed on the current merkle seqno, since its answer for a given
+// (sender, key) can't change within a merkle era. A thread with many
+// messages from the same few senders only pays for the lookup once per
+// sender per era.
 func (b *Boxer) ValidSenderKey(ctx context.Context, sender gregor1.UID, key []byte, ctime gregor1.Time) (found, validAtCTime bool, revoked *gregor1.Time, unboxErr UnboxingError) {
-	kbSender, err := keybase1.UIDFromString(hex.EncodeToString(sender.Bytes()))
-	if err != nil {
-		return false, false, nil, NewPermanentUnboxingError(err)
+	kbSender, kid, entry, unboxErr := b.lookupSenderKeyEntry(ctx, sender, key)
+	if unboxErr != nil {
+		return false, false, nil, unboxErr
 	}
-	kid := keybase1.KIDFromSlice(key)
-	ctime2 := gregor1.FromTime(ctime)
+	found, revokedAt, deleted := entry.found, entry.revokedAt, entry.deleted
 
-	cachedUserLoader := b.G().GetUPAKLoader()
-	if cachedUserLoader == nil {
-		return false, false, nil, NewTransientUnboxingError(fmt.Errorf("no CachedUserLoader available in context"))
-	}
-
-	found, revokedAt, deleted, err := cachedUserLoader.CheckKIDForUID(ctx, kbSender, kid)
-	if err != nil {
-		return false, false, nil, NewTransientUnboxingError(err)
-	}
 	if !found {
 		return false, false, nil, nil
 	}
@@ -656,12 +2341,173 @@ func (b *Boxer) ValidSenderKey(ctx context.Context, sender gregor1.UID, key []by
 		t := b.keybase1KeybaseTimeToTime(*revokedAt)
 		revokedTime := gregor1.ToTime(t)
 		revoked = &revokedTime
+		ctime2 := gregor1.FromTime(ctime)
 		validAtCtime = t.After(ctime2)
 	}
 
 	return true, validAtCtime, revoked, nil
 }
 
+// RecheckRevocation re-runs the revocation portion of ValidSenderKey against
+// a message that's already been unboxed and cached as valid, using the
+// sender, KID, and ctime valid carries, instead of decrypting the message
+// again. It's for a caller like HybridConversationSource's updateMessage
+// that wants to refresh a cached message's SenderDeviceRevokedAt after a
+// device revocation that happened since the message was unboxed, without
+// paying for a full re-unbox. ValidSenderKey's found/validAtCtime checks
+// still run -- a key that's since been found not to belong to sender at
+// all, or to have been invalid even at ctime, is reported as a permanent
+// error the same way it would be from a fresh unbox -- but revocation is
+// the only outcome that can actually change here, since a message that
+// already unboxed successfully can't regress on those other two.
+//
+// valid.HeaderSignature must be set; a message cached before
+// HeaderSignature was added to MessageUnboxedValid has nothing to recheck
+// against, and the caller should leave it alone instead of calling this.
+func (b *Boxer) RecheckRevocation(ctx context.Context, valid chat1.MessageUnboxedValid) (revoked *gregor1.Time, unboxErr UnboxingError) {
+	if valid.HeaderSignature == nil {
+		return nil, NewPermanentUnboxingError(fmt.Errorf("RecheckRevocation: no cached HeaderSignature to recheck"))
+	}
+
+	sender := valid.ClientHeader.Sender
+	key := valid.HeaderSignature.K
+	ctime := valid.ServerHeader.Ctime
+
+	found, validAtCtime, revoked, unboxErr := b.ValidSenderKey(ctx, sender, key, ctime)
+	if unboxErr != nil {
+		return nil, unboxErr
+	}
+	if !found {
+		return nil, NewPermanentUnboxingError(SenderKeyNotFoundError{
+			Reason: "key does not belong to sender",
+			KID:    key,
+		})
+	}
+	if !validAtCtime {
+		return nil, NewPermanentUnboxingError(SenderKeyNotFoundError{
+			Reason: "key invalid for sender at message ctime",
+			KID:    key,
+		})
+	}
+	return revoked, nil
+}
+
+// ValidSenderDeviceKey checks that key is one of senderDevice's own device
+// keys, not just a key that's valid somewhere in sender's account.
+// ValidSenderKey only confirms the latter -- a key that's valid for
+// sender's account as a whole, but was actually issued to a different one
+// of sender's devices, would pass it while still being attributed to a
+// device it has no business signing for. This closes that gap: a key
+// compromised on one device can no longer forge messages that claim to
+// come from another.
+//
+// A message with no SenderDevice at all has no binding to check, so it's
+// reported valid; ValidSenderKey's account-wide check still applies to it.
+func (b *Boxer) ValidSenderDeviceKey(ctx context.Context, sender gregor1.UID, senderDevice gregor1.DeviceID, key []byte) (valid bool, unboxErr UnboxingError) {
+	if len(senderDevice) == 0 {
+		return true, nil
+	}
+
+	kbSender, err := keybase1.UIDFromString(hex.EncodeToString(sender.Bytes()))
+	if err != nil {
+		return false, NewPermanentUnboxingError(err)
+	}
+	did, err := keybase1.DeviceIDFromString(hex.EncodeToString(senderDevice.Bytes()))
+	if err != nil {
+		return false, NewPermanentUnboxingError(err)
+	}
+
+	cachedUserLoader := b.G().GetUPAKLoader()
+	if cachedUserLoader == nil {
+		return false, NewTransientUnboxingError(fmt.Errorf("no CachedUserLoader available in context"))
+	}
+
+	_, deviceKey, _, err := cachedUserLoader.LoadDeviceKey(ctx, kbSender, did)
+	if err != nil {
+		return false, NewTransientUnboxingError(err)
+	}
+	kid := keybase1.KIDFromSlice(key)
+	return deviceKey.KID.Equal(kid), nil
+}
+
+// lookupSenderKeyEntry is the shared CheckKIDForUID lookup (memoized in
+// b.senderKeyCache for the current merkle era, and in ctx's
+// callSenderKeyCache -- see ctxCallSenderKeyCache -- for the lifetime of
+// the enclosing UnboxMessages/UnboxMessagesStream call) that both
+// ValidSenderKey and CurrentlyActiveSenderKey build their answers from.
+func (b *Boxer) lookupSenderKeyEntry(ctx context.Context, sender gregor1.UID, key []byte) (kbSender keybase1.UID, kid keybase1.KID, entry senderKeyCacheEntry, unboxErr UnboxingError) {
+	kbSender, err := keybase1.UIDFromString(hex.EncodeToString(sender.Bytes()))
+	if err != nil {
+		return kbSender, kid, entry, NewPermanentUnboxingError(err)
+	}
+	kid = keybase1.KIDFromSlice(key)
+
+	cachedUserLoader := b.G().GetUPAKLoader()
+	if cachedUserLoader == nil {
+		return kbSender, kid, entry, NewTransientUnboxingError(fmt.Errorf("no CachedUserLoader available in context"))
+	}
+
+	cacheKey := senderKeyCacheKey{uid: kbSender.String(), kid: kid.String()}
+	callCache := ctxCallSenderKeyCache(ctx)
+	if callCache != nil {
+		if entry, hit := callCache.get(cacheKey); hit {
+			return kbSender, kid, entry, nil
+		}
+	}
+
+	// A merkle root fetch failure just means this lookup isn't cacheable
+	// in b.senderKeyCache, not that it should fail -- caching is a
+	// performance optimization, not a correctness requirement.
+	var seqno int64
+	cacheable := false
+	if root, rootErr := b.merkleRoot(ctx); rootErr == nil && root != nil {
+		seqno = root.Seqno
+		cacheable = true
+	}
+
+	hit := false
+	if cacheable {
+		entry, hit = b.senderKeyCache.get(seqno, cacheKey)
+	}
+	if !hit {
+		entryFound, revokedAt, deleted, err := cachedUserLoader.CheckKIDForUID(ctx, kbSender, kid)
+		if err != nil {
+			return kbSender, kid, entry, NewTransientUnboxingError(err)
+		}
+		entry = senderKeyCacheEntry{found: entryFound, revokedAt: revokedAt, deleted: deleted}
+		if cacheable {
+			b.senderKeyCache.put(seqno, cacheKey, entry)
+		}
+	}
+	if callCache != nil {
+		callCache.put(cacheKey, entry)
+	}
+	return kbSender, kid, entry, nil
+}
+
+// CurrentlyActiveSenderKey checks whether key is among sender's
+// currently-active sigchain keys, rather than merely having been valid at
+// some point in the past (ValidSenderKey's weaker, ctime-scoped check). A
+// key that was valid when a message was sent but has since been revoked
+// or deleted passes ValidSenderKey but fails this.
+//
+// It reuses ValidSenderKey's underlying CheckKIDForUID lookup (and its
+// b.senderKeyCache memoization), since the data that answers "was this key
+// ever revoked" already answers "is this key still active" too.
+func (b *Boxer) CurrentlyActiveSenderKey(ctx context.Context, sender gregor1.UID, key []byte) (found, active bool, unboxErr UnboxingError) {
+	_, _, entry, unboxErr := b.lookupSenderKeyEntry(ctx, sender, key)
+	if unboxErr != nil {
+		return false, false, unboxErr
+	}
+	if !entry.found {
+		return false, false, nil
+	}
+	if entry.deleted {
+		return true, false, nil
+	}
+	return true, entry.revokedAt == nil, nil
+}
+
 func (b *Boxer) keybase1KeybaseTimeToTime(t1 keybase1.KeybaseTime) time.Time {
 	// u is in milliseconds
 	u := int64(t1.Unix)
@@ -670,22 +2516,74 @@ func (b *Boxer) keybase1KeybaseTimeToTime(t1 keybase1.KeybaseTime) time.Time {
 }
 
 func (b *Boxer) marshal(v interface{}) ([]byte, error) {
-	mh := codec.MsgpackHandle{WriteExt: true}
-	var data []byte
-	enc := codec.NewEncoderBytes(&data, &mh)
-	if err := enc.Encode(v); err != nil {
+	return chatMarshalWithPool(&chatEncoderPool, v)
+}
+
+// marshalCompact is like marshal, but encodes structs as msgpack arrays
+// instead of maps, dropping field names from the wire. unmarshal accepts
+// either encoding for the same struct (the msgpack decoder tells them
+// apart from the container type on the wire), so nothing need be told
+// which one a given blob used to read it back -- marshalCompact only
+// changes how something is written, never how it's read.
+func (b *Boxer) marshalCompact(v interface{}) ([]byte, error) {
+	return chatMarshalWithPool(&chatEncoderCompactPool, v)
+}
+
+// bodyPlaintextCompactThreshold is the plaintext size, in bytes, above
+// which marshalBodyPlaintext switches a message body to the more compact
+// BodyPlaintextV2 encoding. This is a first cut at the cutoff -- it hasn't
+// been tuned against real body size distributions -- chosen so that small,
+// everyday bodies (plain text messages, reactions, typical edits) stay on
+// the conservative, maximally-debuggable V1 encoding, and only the bodies
+// where the savings are actually worth paying a second encoding pass for
+// (large edits, system messages carrying embedded data) take the V2 path.
+const bodyPlaintextCompactThreshold = 16 * 1024
+
+// marshalBodyPlaintext marshals msgBody the way boxMessageWithKeys seals
+// it: as a chat1.BodyPlaintextV1 using the normal struct-as-map encoding,
+// unless that encoding is at least bodyPlaintextCompactThreshold bytes, in
+// which case it instead marshals the field-for-field identical
+// chat1.BodyPlaintextV2 with marshalCompact. Switching encodings changes
+// the body's ciphertext and therefore its BodyHash, which is why this is
+// gated behind a body version rather than applied unconditionally: a V1
+// body's hash is stable forever, and a V2 body's hash is stable within V2,
+// but the two are never expected to collide with each other.
+func (b *Boxer) marshalBodyPlaintext(msgBody chat1.MessageBody) ([]byte, error) {
+	v1 := chat1.NewBodyPlaintextWithV1(chat1.BodyPlaintextV1{MessageBody: msgBody})
+	plaintext, err := b.marshal(v1)
+	if err != nil {
 		return nil, err
 	}
-	return data, nil
+	if len(plaintext) < bodyPlaintextCompactThreshold {
+		return plaintext, nil
+	}
+
+	v2 := chat1.NewBodyPlaintextWithV2(chat1.BodyPlaintextV2{MessageBody: msgBody})
+	return b.marshalCompact(v2)
 }
 
 func (b *Boxer) unmarshal(data []byte, v interface{}) error {
-	mh := codec.MsgpackHandle{WriteExt: true}
-	dec := codec.NewDecoderBytes(data, &mh)
-	return dec.Decode(&v)
+	return chatUnmarshal(data, v)
 }
 
 func hashSha256V1(data []byte) chat1.Hash {
 	sum := sha256.Sum256(data)
 	return sum[:]
 }
+
+// ComputeHeaderHash returns the header ciphertext hash boxMessageWithKeys
+// computes and BoxMessage reports back to its caller -- the same hash that
+// ends up recorded server-side as MessageBoxed.ServerHeader.HeaderHash.
+// It delegates to the same versioned hasher (currently hashSha256V1) the
+// Boxer itself uses, so external tooling that reconstructs or audits
+// messages stays in lockstep with production hashing without duplicating
+// it.
+func ComputeHeaderHash(ciphertext []byte) chat1.Hash {
+	return hashSha256V1(ciphertext)
+}
+
+// ComputeBodyHash returns the body ciphertext hash boxMessageWithKeys
+// stores in HeaderPlaintext.BodyHash. See ComputeHeaderHash.
+func ComputeBodyHash(ciphertext []byte) chat1.Hash {
+	return hashSha256V1(ciphertext)
+}
@@ -5,7 +5,12 @@ package chat
 
 import (
 	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"runtime"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -16,12 +21,14 @@ import (
 	"github.com/keybase/client/go/externals"
 	"github.com/keybase/client/go/kbtest"
 	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/logger"
 	"github.com/keybase/client/go/protocol/chat1"
 	"github.com/keybase/client/go/protocol/gregor1"
 	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/clockwork"
 )
 
-func cryptKey(t *testing.T) *keybase1.CryptKey {
+func cryptKey(t testing.TB) *keybase1.CryptKey {
 	kp, err := libkb.GenerateNaclDHKeyPair()
 	if err != nil {
 		t.Fatal(err)
@@ -113,7 +120,7 @@ func TestChatMessageBox(t *testing.T) {
 	msg := textMsg(t, "hello")
 	tc, boxer := setupChatTest(t, "box")
 	defer tc.Cleanup()
-	boxed, err := boxer.boxMessageWithKeys(msg, key, getSigningKeyPairForTest(t, tc, nil))
+	boxed, _, err := boxer.boxMessageWithKeys(msg, key, getSigningKeyPairForTest(t, tc, nil))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -122,6 +129,111 @@ func TestChatMessageBox(t *testing.T) {
 	}
 }
 
+func TestChatMessageComputeHeaderAndBodyHash(t *testing.T) {
+	key := cryptKey(t)
+	msg := textMsg(t, "hello")
+	tc, boxer := setupChatTest(t, "box")
+	defer tc.Cleanup()
+	boxed, headerHash, err := boxer.boxMessageWithKeys(msg, key, getSigningKeyPairForTest(t, tc, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := ComputeHeaderHash(boxed.HeaderCiphertext.E); !libkb.SecureByteArrayEq(got, headerHash) {
+		t.Errorf("ComputeHeaderHash gave %v, want %v (what boxMessageWithKeys returned)", got, headerHash)
+	}
+
+	hashRes, err := boxer.CheckBodyHash(*boxed, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ComputeBodyHash(boxed.BodyCiphertext.E); !libkb.SecureByteArrayEq(got, hashRes.Claimed) {
+		t.Errorf("ComputeBodyHash gave %v, want %v (the BodyHash boxMessageWithKeys signed into the header)", got, hashRes.Claimed)
+	}
+}
+
+func TestChatMessageEmptyBodyUnsuperseded(t *testing.T) {
+	key := cryptKey(t)
+	msg := textMsg(t, "hello")
+	tc, boxer := setupChatTest(t, "box")
+	defer tc.Cleanup()
+
+	signKP, err := libkb.GenerateNaclSigningKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	boxed, _, err := boxer.boxMessageWithKeys(msg, key, signKP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a deleted message: no body ciphertext, and no SupersededBy
+	// pointer yet (as if the delete's supersede pointer hasn't propagated).
+	boxed.BodyCiphertext = chat1.EncryptedData{}
+	boxed.ServerHeader = &chat1.MessageServerHeader{
+		Ctime: gregor1.ToTime(time.Now()),
+	}
+
+	_, ierr := boxer.unboxMessageWithKey(context.TODO(), *boxed, key, nil)
+	if ierr == nil {
+		t.Fatal("expected an error unboxing an empty, unsuperseded body")
+	}
+	if _, ok := ierr.Inner().(EmptyBodyUnsupersededError); !ok {
+		t.Fatalf("expected EmptyBodyUnsupersededError, got %T: %s", ierr.Inner(), ierr)
+	}
+}
+
+func TestChatMessageTlfPublicMatchesUnboxesCleanly(t *testing.T) {
+	key := cryptKey(t)
+	boxer, cleanup, sender, signKP, _ := setupConcurrentUnboxTest(t, key)
+	defer cleanup()
+
+	header := chat1.MessageClientHeader{Sender: sender, TlfPublic: true}
+	msg := textMsgWithHeader(t, "hello", header)
+	boxed, _, err := boxer.boxMessageWithKeys(msg, key, signKP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	boxed.ServerHeader = &chat1.MessageServerHeader{
+		Ctime: gregor1.ToTime(time.Now()),
+	}
+
+	if _, ierr := boxer.unboxMessageWithKey(context.TODO(), *boxed, key, nil); ierr != nil {
+		t.Fatalf("expected no error when the header and outer TlfPublic flags agree, got %s", ierr)
+	}
+}
+
+func TestChatMessageTlfPublicMismatchIsRejected(t *testing.T) {
+	key := cryptKey(t)
+	boxer, cleanup, sender, signKP, _ := setupConcurrentUnboxTest(t, key)
+	defer cleanup()
+
+	header := chat1.MessageClientHeader{Sender: sender, TlfPublic: true}
+	msg := textMsgWithHeader(t, "hello", header)
+	boxed, _, err := boxer.boxMessageWithKeys(msg, key, signKP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	boxed.ServerHeader = &chat1.MessageServerHeader{
+		Ctime: gregor1.ToTime(time.Now()),
+	}
+
+	// Simulate a server that flips the outer, unsigned TlfPublic flag after
+	// the header was signed with the original value.
+	boxed.ClientHeader.TlfPublic = false
+
+	_, ierr := boxer.unboxMessageWithKey(context.TODO(), *boxed, key, nil)
+	if ierr == nil {
+		t.Fatal("expected an error when the header and outer TlfPublic flags disagree")
+	}
+	if !ierr.IsPermanent() {
+		t.Fatalf("expected a permanent error, got %s", ierr)
+	}
+	if _, ok := ierr.Inner().(TlfPublicMismatchError); !ok {
+		t.Fatalf("expected TlfPublicMismatchError, got %T: %s", ierr.Inner(), ierr)
+	}
+}
+
 func TestChatMessageUnbox(t *testing.T) {
 	key := cryptKey(t)
 	text := "hi"
@@ -137,7 +249,7 @@ func TestChatMessageUnbox(t *testing.T) {
 
 	signKP := getSigningKeyPairForTest(t, tc, u)
 
-	boxed, err := boxer.boxMessageWithKeys(msg, key, signKP)
+	boxed, _, err := boxer.boxMessageWithKeys(msg, key, signKP)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -147,7 +259,7 @@ func TestChatMessageUnbox(t *testing.T) {
 		Ctime: gregor1.ToTime(time.Now()),
 	}
 
-	umwkr, err := boxer.unboxMessageWithKey(context.TODO(), *boxed, key)
+	umwkr, err := boxer.unboxMessageWithKey(context.TODO(), *boxed, key, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -162,6 +274,122 @@ func TestChatMessageUnbox(t *testing.T) {
 	require.Nil(t, umwkr.senderDeviceRevokedAt, "message should not be from revoked device")
 }
 
+func TestChatMessageUnboxHasMerkleRootStamped(t *testing.T) {
+	key := cryptKey(t)
+	tc, boxer := setupChatTest(t, "unbox")
+	defer tc.Cleanup()
+
+	u, err := kbtest.CreateAndSignupFakeUser("unbox", tc.G)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := textMsgWithSender(t, "hi", gregor1.UID(u.User.GetUID().ToBytes()))
+	msg.ClientHeader.MerkleRoot = &chat1.MerkleRoot{Seqno: 1, Hash: []byte("a merkle root hash")}
+
+	signKP := getSigningKeyPairForTest(t, tc, u)
+
+	boxed, _, err := boxer.boxMessageWithKeys(msg, key, signKP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	boxed.ServerHeader = &chat1.MessageServerHeader{
+		Ctime: gregor1.ToTime(time.Now()),
+	}
+
+	decmsg, ierr := boxer.UnboxMessage(context.TODO(), *boxed, nil /* finalizeInfo */, nil /* asOf */, nil /* expectedOutboxIDs */)
+	if ierr != nil {
+		t.Fatal(ierr)
+	}
+	if !decmsg.IsValid() {
+		t.Fatalf("decmsg is not valid")
+	}
+	require.True(t, decmsg.Valid().HasMerkleRoot, "expected a stamped merkle root to be reported")
+}
+
+func TestChatMessageUnboxHasMerkleRootAbsent(t *testing.T) {
+	key := cryptKey(t)
+	tc, boxer := setupChatTest(t, "unbox")
+	defer tc.Cleanup()
+
+	u, err := kbtest.CreateAndSignupFakeUser("unbox", tc.G)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := textMsgWithSender(t, "hi", gregor1.UID(u.User.GetUID().ToBytes()))
+	msg.ClientHeader.MerkleRoot = nil
+
+	signKP := getSigningKeyPairForTest(t, tc, u)
+
+	boxed, _, err := boxer.boxMessageWithKeys(msg, key, signKP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	boxed.ServerHeader = &chat1.MessageServerHeader{
+		Ctime: gregor1.ToTime(time.Now()),
+	}
+
+	decmsg, ierr := boxer.UnboxMessage(context.TODO(), *boxed, nil /* finalizeInfo */, nil /* asOf */, nil /* expectedOutboxIDs */)
+	if ierr != nil {
+		t.Fatal(ierr)
+	}
+	if !decmsg.IsValid() {
+		t.Fatalf("decmsg is not valid")
+	}
+	require.False(t, decmsg.Valid().HasMerkleRoot, "expected no merkle root to be reported when none was stamped")
+}
+
+func TestChatMessageUnboxOutboxIDReconciliation(t *testing.T) {
+	key := cryptKey(t)
+	tc, boxer := setupChatTest(t, "unbox")
+	defer tc.Cleanup()
+
+	u, err := kbtest.CreateAndSignupFakeUser("unbox", tc.G)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signKP := getSigningKeyPairForTest(t, tc, u)
+
+	outboxID := chat1.OutboxID("deadbeefdeadbeefdeadbeefdeadbeef")
+	msg := textMsgWithSender(t, "hi", gregor1.UID(u.User.GetUID().ToBytes()))
+	msg.ClientHeader.OutboxID = &outboxID
+
+	boxed, _, err := boxer.boxMessageWithKeys(msg, key, signKP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	boxed.ServerHeader = &chat1.MessageServerHeader{
+		Ctime: gregor1.ToTime(time.Now()),
+	}
+
+	t.Run("matches one of the expected outbox IDs", func(t *testing.T) {
+		otherOutboxID := chat1.OutboxID("11111111111111111111111111111111")
+		decmsg, ierr := boxer.UnboxMessage(context.TODO(), *boxed, nil, /* finalizeInfo */
+			nil /* asOf */, []chat1.OutboxID{otherOutboxID, outboxID})
+		if ierr != nil {
+			t.Fatal(ierr)
+		}
+		require.False(t, decmsg.Valid().OutboxIDMismatch, "expected no mismatch when the outbox ID is in the expected set")
+	})
+
+	t.Run("doesn't match any expected outbox ID", func(t *testing.T) {
+		otherOutboxID := chat1.OutboxID("11111111111111111111111111111111")
+		decmsg, ierr := boxer.UnboxMessage(context.TODO(), *boxed, nil, /* finalizeInfo */
+			nil /* asOf */, []chat1.OutboxID{otherOutboxID})
+		if ierr != nil {
+			t.Fatal(ierr)
+		}
+		require.True(t, decmsg.Valid().OutboxIDMismatch, "expected a mismatch when the outbox ID isn't in the expected set")
+	})
+
+	t.Run("no expected outbox IDs given", func(t *testing.T) {
+		decmsg, ierr := boxer.UnboxMessage(context.TODO(), *boxed, nil /* finalizeInfo */, nil /* asOf */, nil)
+		if ierr != nil {
+			t.Fatal(ierr)
+		}
+		require.False(t, decmsg.Valid().OutboxIDMismatch, "expected no mismatch when the caller isn't doing reconciliation")
+	})
+}
+
 func TestChatMessageInvalidBodyHash(t *testing.T) {
 	key := cryptKey(t)
 	text := "hi"
@@ -184,7 +412,7 @@ func TestChatMessageInvalidBodyHash(t *testing.T) {
 		return sum[:]
 	}
 
-	boxed, err := boxer.boxMessageWithKeys(msg, key, signKP)
+	boxed, _, err := boxer.boxMessageWithKeys(msg, key, signKP)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -197,7 +425,7 @@ func TestChatMessageInvalidBodyHash(t *testing.T) {
 	// put original hash fn back
 	boxer.hashV1 = origHashFn
 
-	_, ierr := boxer.unboxMessageWithKey(context.TODO(), *boxed, key)
+	_, ierr := boxer.unboxMessageWithKey(context.TODO(), *boxed, key, nil)
 	if _, ok := ierr.Inner().(BodyHashInvalid); !ok {
 		t.Fatalf("unexpected error for invalid body hash: %s", ierr)
 	}
@@ -234,7 +462,7 @@ func TestChatMessageUnboxInvalidBodyHash(t *testing.T) {
 	}
 
 	ctx := context.Background()
-	boxed, err := boxer.BoxMessage(ctx, msg, signKP)
+	boxed, _, err := boxer.BoxMessage(ctx, msg, signKP, nil, BoxMessageOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -248,7 +476,7 @@ func TestChatMessageUnboxInvalidBodyHash(t *testing.T) {
 	boxer.hashV1 = origHashFn
 
 	// This should produce a permanent error. So err will be nil, but the decmsg will be state=error.
-	decmsg, err := boxer.UnboxMessage(ctx, *boxed, nil /* finalizeInfo */)
+	decmsg, err := boxer.UnboxMessage(ctx, *boxed, nil /* finalizeInfo */, nil /* asOf */, nil /* expectedOutboxIDs */)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -281,7 +509,7 @@ func TestChatMessageUnboxNoCryptKey(t *testing.T) {
 	signKP := getSigningKeyPairForTest(t, tc, u)
 
 	ctx := context.Background()
-	boxed, err := boxer.BoxMessage(ctx, msg, signKP)
+	boxed, _, err := boxer.BoxMessage(ctx, msg, signKP, nil, BoxMessageOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -293,7 +521,7 @@ func TestChatMessageUnboxNoCryptKey(t *testing.T) {
 
 	// This should produce a non-permanent error. So err will be set.
 	bctx := context.WithValue(ctx, kfKey, NewKeyFinderMock())
-	decmsg, ierr := boxer.UnboxMessage(bctx, *boxed, nil /* finalizeInfo */)
+	decmsg, ierr := boxer.UnboxMessage(bctx, *boxed, nil /* finalizeInfo */, nil /* asOf */, nil /* expectedOutboxIDs */)
 	if !strings.Contains(ierr.Error(), "no key found") {
 		t.Fatalf("error should contain 'no key found': %v", ierr)
 	}
@@ -333,7 +561,7 @@ func TestChatMessageInvalidHeaderSig(t *testing.T) {
 		return sigInfo, nil
 	}
 
-	boxed, err := boxer.boxMessageWithKeys(msg, key, signKP)
+	boxed, _, err := boxer.boxMessageWithKeys(msg, key, signKP)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -346,8 +574,8 @@ func TestChatMessageInvalidHeaderSig(t *testing.T) {
 	// put original signing fn back
 	boxer.sign = origSign
 
-	_, ierr := boxer.unboxMessageWithKey(context.TODO(), *boxed, key)
-	if _, ok := ierr.Inner().(libkb.BadSigError); !ok {
+	_, ierr := boxer.unboxMessageWithKey(context.TODO(), *boxed, key, nil)
+	if _, ok := ierr.Inner().(HeaderSignatureError); !ok {
 		t.Fatalf("unexpected error for invalid header signature: %s", ierr)
 	}
 }
@@ -371,7 +599,7 @@ func TestChatMessageInvalidSenderKey(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	boxed, err := boxer.boxMessageWithKeys(msg, key, signKP)
+	boxed, _, err := boxer.boxMessageWithKeys(msg, key, signKP)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -380,7 +608,7 @@ func TestChatMessageInvalidSenderKey(t *testing.T) {
 		Ctime: gregor1.ToTime(time.Now()),
 	}
 
-	_, ierr := boxer.unboxMessageWithKey(context.TODO(), *boxed, key)
+	_, ierr := boxer.unboxMessageWithKey(context.TODO(), *boxed, key, nil)
 	if ierr != nil {
 		if _, ok := ierr.Inner().(libkb.NoKeyError); !ok {
 			t.Fatalf("unexpected error for invalid sender key: %v", ierr)
@@ -432,7 +660,7 @@ func TestChatMessageRevokedKeyThenSent(t *testing.T) {
 	// Sign a message using a key of u's that has been revoked
 	t.Logf("signing message")
 	msg := textMsgWithSender(t, text, gregor1.UID(u.User.GetUID().ToBytes()))
-	boxed, err := boxer.boxMessageWithKeys(msg, key, signKP)
+	boxed, _, err := boxer.boxMessageWithKeys(msg, key, signKP)
 	require.NoError(t, err)
 
 	boxed.ServerHeader = &chat1.MessageServerHeader{
@@ -440,7 +668,7 @@ func TestChatMessageRevokedKeyThenSent(t *testing.T) {
 	}
 
 	// The message should not unbox
-	umwkr, ierr := boxer.unboxMessageWithKey(context.TODO(), *boxed, key)
+	umwkr, ierr := boxer.unboxMessageWithKey(context.TODO(), *boxed, key, nil)
 	require.NotNil(t, ierr, "unboxing must err (%v)", umwkr.senderDeviceRevokedAt)
 	require.IsType(t, libkb.NoKeyError{}, ierr.Inner(), "unexpected error for revoked sender key: %v", ierr)
 
@@ -485,7 +713,7 @@ func TestChatMessageSentThenRevokedSenderKey(t *testing.T) {
 	// Sign a message using a key of u's that has not yet been revoked
 	t.Logf("signing message")
 	msg := textMsgWithSender(t, text, gregor1.UID(u.User.GetUID().ToBytes()))
-	boxed, err := boxer.boxMessageWithKeys(msg, key, signKP)
+	boxed, _, err := boxer.boxMessageWithKeys(msg, key, signKP)
 	require.NoError(t, err)
 
 	boxed.ServerHeader = &chat1.MessageServerHeader{
@@ -501,7 +729,7 @@ func TestChatMessageSentThenRevokedSenderKey(t *testing.T) {
 	require.NoError(t, err, "revoke device")
 
 	// The message should unbox but with senderDeviceRevokedAt set
-	umwkr, ierr := boxer.unboxMessageWithKey(context.TODO(), *boxed, key)
+	umwkr, ierr := boxer.unboxMessageWithKey(context.TODO(), *boxed, key, nil)
 	require.Nil(t, ierr, "unboxing err")
 	require.NotNil(t, umwkr.senderDeviceRevokedAt, "message should be noticed as signed by revoked key")
 
@@ -513,6 +741,72 @@ func TestChatMessageSentThenRevokedSenderKey(t *testing.T) {
 	require.NotNil(t, revoked, "key should be revoked (v:%v r:%v)", validAtCtime, revoked)
 }
 
+// Sent with a key that was valid at ctime but that a caller-supplied asOf
+// time (e.g. from a trusted merkle timeline) shows was already revoked.
+// This covers the divergence case: unboxing against ctime alone accepts the
+// message, but unboxing with asOf set to the (later, authoritative) time
+// flags it, since a colluding server could have backdated ctime to land
+// before the revocation.
+func TestChatMessageAsOfOverridesCtimeForKeyValidity(t *testing.T) {
+	key := cryptKey(t)
+	text := "hi"
+	tc, boxer := setupChatTest(t, "unbox")
+	defer tc.Cleanup()
+
+	// need a real user
+	u, err := kbtest.CreateAndSignupFakeUser("unbox", tc.G)
+	require.NoError(t, err)
+
+	// pick a device
+	devices, _ := getActiveDevicesAndKeys(tc, u)
+	var thisDevice *libkb.Device
+	for _, device := range devices {
+		if device.Type != libkb.DeviceTypePaper {
+			thisDevice = device
+		}
+	}
+	require.NotNil(t, thisDevice, "thisDevice should be non-nil")
+
+	// Find the key
+	f := func() libkb.SecretUI { return u.NewSecretUI() }
+	signingKey, err := engine.GetMySecretKey(tc.G, f, libkb.DeviceSigningKeyType, "some chat or something test")
+	require.NoError(t, err, "get device signing key")
+	signKP, ok := signingKey.(libkb.NaclSigningKeyPair)
+	require.Equal(t, true, ok, "signing key must be nacl")
+
+	// Sign a message using a key of u's that has not yet been revoked
+	msg := textMsgWithSender(t, text, gregor1.UID(u.User.GetUID().ToBytes()))
+	boxed, _, err := boxer.boxMessageWithKeys(msg, key, signKP)
+	require.NoError(t, err)
+
+	boxed.ServerHeader = &chat1.MessageServerHeader{
+		Ctime: gregor1.ToTime(time.Now()),
+	}
+
+	// Sleep for a second because revocation timestamps are only
+	// second-resolution.
+	time.Sleep(1 * time.Second)
+
+	// Revoke the key
+	err = doRevokeDevice(tc, u, thisDevice.ID, true)
+	require.NoError(t, err, "revoke device")
+
+	time.Sleep(1 * time.Second)
+	asOf := gregor1.ToTime(time.Now())
+
+	// Against ctime (the default), the message unboxes cleanly -- it was
+	// signed before the revocation.
+	umwkr, ierr := boxer.unboxMessageWithKey(context.TODO(), *boxed, key, nil)
+	require.Nil(t, ierr, "unboxing err against ctime")
+	require.NotNil(t, umwkr.senderDeviceRevokedAt, "message should be noticed as signed by revoked key")
+
+	// Against the later asOf time, the same key is no longer valid, so the
+	// message is flagged even though ctime claims otherwise.
+	_, ierr = boxer.unboxMessageWithKey(context.TODO(), *boxed, key, &asOf)
+	require.NotNil(t, ierr, "unboxing must err when asOf is after the revocation")
+	require.IsType(t, SenderKeyNotFoundError{}, ierr.Inner(), "unexpected error for key invalid as of the given time: %v", ierr)
+}
+
 func TestChatMessagePublic(t *testing.T) {
 	text := "hi"
 	tc, boxer := setupChatTest(t, "unbox")
@@ -539,7 +833,7 @@ func TestChatMessagePublic(t *testing.T) {
 
 	ctx := context.Background()
 
-	boxed, err := boxer.BoxMessage(ctx, msg, signKP)
+	boxed, _, err := boxer.BoxMessage(ctx, msg, signKP, nil, BoxMessageOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -550,7 +844,7 @@ func TestChatMessagePublic(t *testing.T) {
 		Ctime: gregor1.ToTime(time.Now()),
 	}
 
-	decmsg, err := boxer.UnboxMessage(ctx, *boxed, nil /* finalizeInfo */)
+	decmsg, err := boxer.UnboxMessage(ctx, *boxed, nil /* finalizeInfo */, nil /* asOf */, nil /* expectedOutboxIDs */)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -575,3 +869,3050 @@ func NewKeyFinderMock() KeyFinder {
 func (k *KeyFinderMock) Find(ctx context.Context, tlf keybase1.TlfInterface, tlfName string, tlfPublic bool) (keybase1.GetTLFCryptKeysRes, error) {
 	return keybase1.GetTLFCryptKeysRes{}, nil
 }
+
+type duplicateGenKeyFinderMock struct{}
+
+func (k *duplicateGenKeyFinderMock) Find(ctx context.Context, tlf keybase1.TlfInterface, tlfName string, tlfPublic bool) (keybase1.GetTLFCryptKeysRes, error) {
+	return keybase1.GetTLFCryptKeysRes{
+		CryptKeys: []keybase1.CryptKey{
+			{KeyGeneration: 3},
+			{KeyGeneration: 3},
+		},
+	}, nil
+}
+
+func TestChatMessageUnboxDuplicateKeyGeneration(t *testing.T) {
+	tc, boxer := setupChatTest(t, "unbox")
+	defer tc.Cleanup()
+	boxer.tlf = func() keybase1.TlfInterface { return nil }
+
+	boxed := chat1.MessageBoxed{
+		ServerHeader:  &chat1.MessageServerHeader{},
+		KeyGeneration: 3,
+	}
+
+	ctx := context.WithValue(context.Background(), kfKey, &duplicateGenKeyFinderMock{})
+	_, ierr := boxer.UnboxMessage(ctx, boxed, nil /* finalizeInfo */, nil /* asOf */, nil /* expectedOutboxIDs */)
+	if ierr == nil {
+		t.Fatal("expected an error unboxing with duplicate key generations")
+	}
+	if _, ok := ierr.Inner().(DuplicateKeyGenerationError); !ok {
+		t.Fatalf("expected DuplicateKeyGenerationError, got %T: %s", ierr.Inner(), ierr)
+	}
+}
+
+func TestChatMessageBoxDuplicateKeyGeneration(t *testing.T) {
+	tc, boxer := setupChatTest(t, "box")
+	defer tc.Cleanup()
+	boxer.tlf = func() keybase1.TlfInterface { return nil }
+
+	msg := textMsg(t, "hello")
+	msg.ClientHeader.TlfName = "duplicate_gen"
+
+	signKP, err := libkb.GenerateNaclSigningKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.WithValue(context.Background(), kfKey, &duplicateGenKeyFinderMock{})
+	if _, _, err := boxer.BoxMessage(ctx, msg, signKP, nil, BoxMessageOptions{}); err == nil {
+		t.Fatal("expected an error boxing with duplicate key generations")
+	}
+}
+
+func TestChatMessageBoxExpectedConvIDMatch(t *testing.T) {
+	tc, boxer := setupChatTest(t, "box")
+	defer tc.Cleanup()
+	boxer.tlf = func() keybase1.TlfInterface { return nil }
+
+	trip := chat1.ConversationIDTriple{
+		Tlfid:     []byte("fake-tlf-id"),
+		TopicType: chat1.TopicType_CHAT,
+		TopicID:   []byte{0},
+	}
+	convID := trip.ToConversationID([2]byte{0, 0})
+
+	msg := textMsg(t, "hello")
+	msg.ClientHeader.TlfName = "expected_convid_match"
+	msg.ClientHeader.Conv = trip
+
+	signKP, err := libkb.GenerateNaclSigningKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	finder := &singleKeyFinderMock{key: keybase1.CryptKey{KeyGeneration: 1, Key: keybase1.Bytes32{1, 2, 3}}}
+	ctx := context.WithValue(context.Background(), kfKey, finder)
+
+	if _, _, err := boxer.BoxMessage(ctx, msg, signKP, &convID, BoxMessageOptions{}); err != nil {
+		t.Fatalf("expected BoxMessage to succeed when the triple derives the expected conversation ID: %v", err)
+	}
+}
+
+func TestChatMessageBoxExpectedConvIDMismatch(t *testing.T) {
+	tc, boxer := setupChatTest(t, "box")
+	defer tc.Cleanup()
+	boxer.tlf = func() keybase1.TlfInterface { return nil }
+
+	trip := chat1.ConversationIDTriple{
+		Tlfid:     []byte("fake-tlf-id"),
+		TopicType: chat1.TopicType_CHAT,
+		TopicID:   []byte{0},
+	}
+
+	otherTrip := chat1.ConversationIDTriple{
+		Tlfid:     []byte("some-other-tlf-id"),
+		TopicType: chat1.TopicType_CHAT,
+		TopicID:   []byte{0},
+	}
+	wrongConvID := otherTrip.ToConversationID([2]byte{0, 0})
+
+	msg := textMsg(t, "hello")
+	msg.ClientHeader.TlfName = "expected_convid_mismatch"
+	msg.ClientHeader.Conv = trip
+
+	signKP, err := libkb.GenerateNaclSigningKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// BoxMessage should reject the message before ever consulting the key
+	// finder, so use a context with no KeyFinder installed at all -- if the
+	// mismatch check didn't run first, CtxKeyFinder would panic.
+	if _, _, err := boxer.BoxMessage(context.Background(), msg, signKP, &wrongConvID, BoxMessageOptions{}); err == nil {
+		t.Fatal("expected an error boxing with a conversation triple that doesn't derive the expected conversation ID")
+	}
+}
+
+func TestChatMessageBoxBlankTLFName(t *testing.T) {
+	tc, boxer := setupChatTest(t, "box")
+	defer tc.Cleanup()
+	boxer.tlf = func() keybase1.TlfInterface { return nil }
+
+	msg := textMsg(t, "hello")
+	msg.ClientHeader.TlfName = ""
+
+	signKP, err := libkb.GenerateNaclSigningKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// BoxMessage should reject a blank TLF name before ever consulting the
+	// key finder, so use a context with no KeyFinder installed at all.
+	_, _, err = boxer.BoxMessage(context.Background(), msg, signKP, nil, BoxMessageOptions{})
+	require.Error(t, err)
+	require.IsType(t, BlankTLFNameError{}, err)
+	perm, immediate := err.(BlankTLFNameError).IsImmediateFail()
+	require.True(t, immediate)
+	require.Equal(t, chat1.OutboxErrorType_MISC, perm)
+}
+
+// blankCanonicalNameKeyFinderMock is a KeyFinder that resolves any TLF name
+// to a blank canonical name, simulating a resolver/service hiccup rather
+// than a caller-supplied blank name.
+type blankCanonicalNameKeyFinderMock struct {
+	key keybase1.CryptKey
+}
+
+func (k *blankCanonicalNameKeyFinderMock) Find(ctx context.Context, tlf keybase1.TlfInterface, tlfName string, tlfPublic bool) (keybase1.GetTLFCryptKeysRes, error) {
+	return keybase1.GetTLFCryptKeysRes{
+		NameIDBreaks: keybase1.CanonicalTLFNameAndIDWithBreaks{CanonicalName: ""},
+		CryptKeys:    []keybase1.CryptKey{k.key},
+	}, nil
+}
+
+func TestChatMessageBoxBlankCanonicalTLFName(t *testing.T) {
+	tc, boxer := setupChatTest(t, "box")
+	defer tc.Cleanup()
+	boxer.tlf = func() keybase1.TlfInterface { return nil }
+
+	msg := textMsg(t, "hello")
+	msg.ClientHeader.TlfName = "blank_canonical_name"
+
+	signKP, err := libkb.GenerateNaclSigningKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	finder := &blankCanonicalNameKeyFinderMock{key: keybase1.CryptKey{KeyGeneration: 1, Key: keybase1.Bytes32{1, 2, 3}}}
+	ctx := context.WithValue(context.Background(), kfKey, finder)
+
+	_, _, err = boxer.BoxMessage(ctx, msg, signKP, nil, BoxMessageOptions{})
+	require.Error(t, err)
+	require.IsType(t, BlankCanonicalTLFNameError{}, err)
+	require.Equal(t, "blank_canonical_name", err.(BlankCanonicalTLFNameError).TLFName)
+	_, immediate := err.(BlankCanonicalTLFNameError).IsImmediateFail()
+	require.False(t, immediate)
+}
+
+func TestChatMarshalBodyPlaintextSmallBodyStaysV1(t *testing.T) {
+	tc, boxer := setupChatTest(t, "marshalbody")
+	defer tc.Cleanup()
+
+	msgBody := chat1.NewMessageBodyWithText(chat1.MessageText{Body: "hello"})
+
+	plaintext, err := boxer.marshalBodyPlaintext(msgBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out chat1.BodyPlaintext
+	if err := boxer.unmarshal(plaintext, &out); err != nil {
+		t.Fatal(err)
+	}
+	version, err := out.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != chat1.BodyPlaintextVersion_V1 {
+		t.Fatalf("expected a small body to stay on V1, got version %v", version)
+	}
+	if out.V1().MessageBody.Text().Body != "hello" {
+		t.Fatalf("expected round-tripped body text %q, got %q", "hello", out.V1().MessageBody.Text().Body)
+	}
+}
+
+func TestChatMarshalBodyPlaintextLargeBodyGoesCompact(t *testing.T) {
+	tc, boxer := setupChatTest(t, "marshalbody")
+	defer tc.Cleanup()
+
+	big := strings.Repeat("x", bodyPlaintextCompactThreshold*2)
+	msgBody := chat1.NewMessageBodyWithText(chat1.MessageText{Body: big})
+
+	v1Plaintext, err := boxer.marshal(chat1.NewBodyPlaintextWithV1(chat1.BodyPlaintextV1{MessageBody: msgBody}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext, err := boxer.marshalBodyPlaintext(msgBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out chat1.BodyPlaintext
+	if err := boxer.unmarshal(plaintext, &out); err != nil {
+		t.Fatal(err)
+	}
+	version, err := out.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != chat1.BodyPlaintextVersion_V2 {
+		t.Fatalf("expected a body over the compact threshold to switch to V2, got version %v", version)
+	}
+	if out.V2().MessageBody.Text().Body != big {
+		t.Fatal("expected round-tripped compact body to carry the original text unchanged")
+	}
+	if len(plaintext) >= len(v1Plaintext) {
+		t.Fatalf("expected the compact V2 encoding (%d bytes) to be smaller than V1 (%d bytes) for a large body",
+			len(plaintext), len(v1Plaintext))
+	}
+}
+
+func TestChatMarshalBodyPlaintextHashStablePerVersion(t *testing.T) {
+	tc, boxer := setupChatTest(t, "marshalbody")
+	defer tc.Cleanup()
+
+	msgBody := chat1.NewMessageBodyWithText(chat1.MessageText{Body: strings.Repeat("y", bodyPlaintextCompactThreshold*2)})
+
+	first, err := boxer.marshalBodyPlaintext(msgBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := boxer.marshalBodyPlaintext(msgBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	firstHash := boxer.hashV1(first)
+	secondHash := boxer.hashV1(second)
+	if !firstHash.Eq(secondHash) {
+		t.Fatal("expected marshaling the same body twice to produce a stable hash within a version")
+	}
+}
+
+func TestChatBoxerSealOpenWithKeyRoundTrip(t *testing.T) {
+	tc, boxer := setupChatTest(t, "sealopen")
+	defer tc.Cleanup()
+
+	key := keybase1.CryptKey{
+		KeyGeneration: 1,
+		Key:           keybase1.Bytes32{1, 2, 3, 4, 5},
+	}
+
+	plaintext := chat1.MessagePlaintext{
+		ClientHeader: chat1.MessageClientHeader{TlfName: "round_trip"},
+	}
+
+	enc, err := boxer.SealForKey(plaintext, &key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := boxer.OpenWithKey(*enc, &key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out chat1.MessagePlaintext
+	if err := boxer.unmarshal(decoded, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.ClientHeader.TlfName != plaintext.ClientHeader.TlfName {
+		t.Fatalf("expected TlfName %q, got %q", plaintext.ClientHeader.TlfName, out.ClientHeader.TlfName)
+	}
+
+	wrongKey := keybase1.CryptKey{KeyGeneration: 1, Key: keybase1.Bytes32{9, 9, 9}}
+	if _, err := boxer.OpenWithKey(*enc, &wrongKey); err == nil {
+		t.Fatal("expected an error opening with the wrong key")
+	}
+}
+
+type singleKeyFinderMock struct {
+	key keybase1.CryptKey
+}
+
+func (k *singleKeyFinderMock) Find(ctx context.Context, tlf keybase1.TlfInterface, tlfName string, tlfPublic bool) (keybase1.GetTLFCryptKeysRes, error) {
+	return keybase1.GetTLFCryptKeysRes{
+		NameIDBreaks: keybase1.CanonicalTLFNameAndIDWithBreaks{CanonicalName: keybase1.CanonicalTlfName(tlfName)},
+		CryptKeys:    []keybase1.CryptKey{k.key},
+	}, nil
+}
+
+// multiKeyFinderMock is like singleKeyFinderMock, but answers Find with a
+// whole key-rotation history, for tests that box against a specific
+// BoxMessageOptions.KeyGeneration rather than whatever's most recent.
+type multiKeyFinderMock struct {
+	keys []keybase1.CryptKey
+}
+
+func (k *multiKeyFinderMock) Find(ctx context.Context, tlf keybase1.TlfInterface, tlfName string, tlfPublic bool) (keybase1.GetTLFCryptKeysRes, error) {
+	return keybase1.GetTLFCryptKeysRes{
+		NameIDBreaks: keybase1.CanonicalTLFNameAndIDWithBreaks{CanonicalName: keybase1.CanonicalTlfName(tlfName)},
+		CryptKeys:    k.keys,
+	}, nil
+}
+
+func TestChatBoxMessageReturnsHeaderHash(t *testing.T) {
+	tc, boxer := setupChatTest(t, "box")
+	defer tc.Cleanup()
+	boxer.tlf = func() keybase1.TlfInterface { return nil }
+
+	msg := textMsg(t, "hello")
+	msg.ClientHeader.TlfName = "header_hash"
+
+	signKP, err := libkb.GenerateNaclSigningKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	finder := &singleKeyFinderMock{key: keybase1.CryptKey{KeyGeneration: 5, Key: keybase1.Bytes32{1, 2, 3}}}
+	ctx := context.WithValue(context.Background(), kfKey, finder)
+
+	boxed, headerHash, err := boxer.BoxMessage(ctx, msg, signKP, nil, BoxMessageOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(headerHash) == 0 {
+		t.Fatal("expected a non-empty header hash from BoxMessage")
+	}
+
+	// This is exactly how unboxMessageWithKey computes headerHash when
+	// unboxing (see boxer.go), which is what ends up as
+	// MessageUnboxedValid.HeaderHash. Recomputing it here from the boxed
+	// message's own ciphertext -- rather than going through a full
+	// UnboxMessage, which needs a real user to verify the header
+	// signature -- lets this test run without a signup server.
+	expected := boxer.hashV1(boxed.HeaderCiphertext.E)
+	if !expected.Eq(headerHash) {
+		t.Fatalf("expected BoxMessage's header hash (%s) to match the one unboxing would compute (%s)",
+			headerHash, expected)
+	}
+}
+
+// TestChatBoxMessagePinnedKeyGeneration checks that BoxMessageOptions.KeyGeneration
+// makes BoxMessage seal against an older generation instead of the TLF's
+// most recent one, and that UnboxMessage's existing KeyGeneration match
+// loop picks that same older key back out again.
+func TestChatBoxMessagePinnedKeyGeneration(t *testing.T) {
+	key := cryptKey(t)
+	boxer, cleanup, sender, signKP, ctx := setupConcurrentUnboxTest(t, key)
+	defer cleanup()
+
+	oldKey := keybase1.CryptKey{KeyGeneration: 3, Key: keybase1.Bytes32{1, 2, 3}}
+	newKey := keybase1.CryptKey{KeyGeneration: 5, Key: keybase1.Bytes32{4, 5, 6}}
+	ctx = context.WithValue(ctx, kfKey, &multiKeyFinderMock{keys: []keybase1.CryptKey{oldKey, newKey}})
+
+	msg := textMsgWithSender(t, "hi", sender)
+	msg.ClientHeader.TlfName = "pinned_key_generation"
+
+	boxed, _, err := boxer.BoxMessage(ctx, msg, signKP, nil, BoxMessageOptions{KeyGeneration: oldKey.KeyGeneration})
+	require.NoError(t, err)
+	require.Equal(t, oldKey.KeyGeneration, boxed.KeyGeneration,
+		"BoxMessage should have boxed against the pinned generation, not the most recent one")
+
+	boxed.ServerHeader = &chat1.MessageServerHeader{MessageID: 1, Ctime: gregor1.ToTime(time.Now())}
+
+	unboxed, ierr := boxer.UnboxMessage(ctx, *boxed, nil, nil, nil)
+	require.Nil(t, ierr)
+	require.True(t, unboxed.IsValid(), "UnboxMessage should find the pinned generation's key via its KeyGeneration match loop")
+}
+
+// TestChatBoxMessagePinnedKeyGenerationNotFound checks that BoxMessage
+// rejects a BoxMessageOptions.KeyGeneration that doesn't match any key the
+// TLF's KeyFinder returns, rather than silently falling back to some other
+// generation.
+func TestChatBoxMessagePinnedKeyGenerationNotFound(t *testing.T) {
+	tc, boxer := setupChatTest(t, "box")
+	defer tc.Cleanup()
+	boxer.tlf = func() keybase1.TlfInterface { return nil }
+
+	msg := textMsg(t, "hello")
+	msg.ClientHeader.TlfName = "pinned_key_generation_missing"
+
+	signKP, err := libkb.GenerateNaclSigningKeyPair()
+	require.NoError(t, err)
+
+	finder := &singleKeyFinderMock{key: keybase1.CryptKey{KeyGeneration: 1, Key: keybase1.Bytes32{1, 2, 3}}}
+	ctx := context.WithValue(context.Background(), kfKey, finder)
+
+	_, _, err = boxer.BoxMessage(ctx, msg, signKP, nil, BoxMessageOptions{KeyGeneration: 99})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "key generation 99 not found")
+}
+
+// panicKeyFinderMock is a KeyFinder that fails the test if it's ever asked
+// to find keys. It's used to confirm that messages outside a requested
+// MessageIDRange are never decrypted.
+type panicKeyFinderMock struct{}
+
+func (k *panicKeyFinderMock) Find(ctx context.Context, tlf keybase1.TlfInterface, tlfName string, tlfPublic bool) (keybase1.GetTLFCryptKeysRes, error) {
+	panic("KeyFinder.Find should not be called for a message outside the requested MessageIDRange")
+}
+
+func TestChatUnboxMessagesSkipsOutOfRangeMessages(t *testing.T) {
+	key := cryptKey(t)
+	tc, boxer := setupChatTest(t, "unbox")
+	defer tc.Cleanup()
+
+	msg := textMsg(t, "hi")
+	msg.ClientHeader.TlfName = "out_of_range"
+
+	signKP, err := libkb.GenerateNaclSigningKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	boxed, _, err := boxer.boxMessageWithKeys(msg, key, signKP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	boxed.ServerHeader = &chat1.MessageServerHeader{
+		MessageID: 1,
+		Ctime:     gregor1.ToTime(time.Now()),
+	}
+
+	ctx := context.WithValue(context.Background(), kfKey, &panicKeyFinderMock{})
+	unboxed, err := boxer.UnboxMessages(ctx, []chat1.MessageBoxed{*boxed}, nil, &MessageIDRange{MinID: 5}, UnboxMessagesOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unboxed) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(unboxed))
+	}
+
+	state, err := unboxed[0].State()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != chat1.MessageUnboxedState_ERROR {
+		t.Fatalf("expected an ERROR (placeholder) state, got %v", state)
+	}
+	errMsg := unboxed[0].Error()
+	if errMsg.ErrType != chat1.MessageUnboxedErrorType_ABSENT {
+		t.Fatalf("expected ABSENT error type, got %v", errMsg.ErrType)
+	}
+	if errMsg.MessageID != 1 {
+		t.Fatalf("expected placeholder MessageID 1, got %d", errMsg.MessageID)
+	}
+	if errMsg.MessageType != msg.ClientHeader.MessageType {
+		t.Fatalf("expected placeholder MessageType %v, got %v", msg.ClientHeader.MessageType, errMsg.MessageType)
+	}
+}
+
+func TestChatUnboxMessagesStopOnPermanentError(t *testing.T) {
+	key := cryptKey(t)
+	tc, boxer := setupChatTest(t, "unbox")
+	defer tc.Cleanup()
+
+	u, err := kbtest.CreateAndSignupFakeUser("unbox", tc.G)
+	require.NoError(t, err)
+	signKP := getSigningKeyPairForTest(t, tc, u)
+	uid := gregor1.UID(u.User.GetUID().ToBytes())
+
+	boxMsg := func(text string, messageID chat1.MessageID) *chat1.MessageBoxed {
+		msg := textMsgWithSender(t, text, uid)
+		boxed, _, err := boxer.boxMessageWithKeys(msg, key, signKP)
+		require.NoError(t, err)
+		boxed.ServerHeader = &chat1.MessageServerHeader{MessageID: messageID, Ctime: gregor1.ToTime(time.Now())}
+		return boxed
+	}
+
+	valid1 := boxMsg("first", 1)
+
+	// Flip a bit in the header signature for just this one message, so it
+	// fails unboxing with a permanent HeaderSignatureError rather than
+	// succeeding.
+	origSign := boxer.sign
+	boxer.sign = func(msg []byte, kp libkb.NaclSigningKeyPair, prefix libkb.SignaturePrefix) (chat1.SignatureInfo, error) {
+		sig, err := kp.SignV2(msg, prefix)
+		if err != nil {
+			return chat1.SignatureInfo{}, err
+		}
+		sigInfo := chat1.SignatureInfo{V: sig.Version, S: sig.Sig[:], K: sig.Kid}
+		sigInfo.S[4] ^= 0x10
+		return sigInfo, nil
+	}
+	invalid := boxMsg("second", 2)
+	boxer.sign = origSign
+
+	valid2 := boxMsg("third", 3)
+
+	finder := &singleKeyFinderMock{key: *key}
+	ctx := context.WithValue(context.Background(), kfKey, finder)
+
+	t.Run("default continues past the permanent error", func(t *testing.T) {
+		unboxed, err := boxer.UnboxMessages(ctx, []chat1.MessageBoxed{*valid1, *invalid, *valid2}, nil, nil, UnboxMessagesOptions{})
+		require.NoError(t, err)
+		require.Len(t, unboxed, 3)
+
+		require.True(t, unboxed[0].IsValid())
+		state, err := unboxed[1].State()
+		require.NoError(t, err)
+		require.Equal(t, chat1.MessageUnboxedState_ERROR, state)
+		require.True(t, unboxed[2].IsValid())
+	})
+
+	t.Run("StopOnPermanentError aborts at the permanent error", func(t *testing.T) {
+		unboxed, err := boxer.UnboxMessages(ctx, []chat1.MessageBoxed{*valid1, *invalid, *valid2}, nil, nil,
+			UnboxMessagesOptions{StopOnPermanentError: true})
+		require.Error(t, err)
+		require.Nil(t, unboxed, "an aborted batch returns no partial results -- with concurrent workers, there's no well-defined prefix 'before' the error")
+
+		uerr, ok := err.(UnboxMessagesError)
+		require.True(t, ok, "expected UnboxMessagesError, got %T", err)
+		require.Equal(t, 1, uerr.Index)
+		require.EqualValues(t, 2, uerr.MessageID)
+		require.True(t, uerr.IsPermanent())
+	})
+}
+
+// upakLoaderWithSenderInfo wraps a scriptedUpak to additionally answer
+// LookupUsernameAndDevice, so a test that exercises the full UnboxMessage
+// path (which always looks up sender info, unlike unboxMessageWithKey) can
+// do so without a live signup server.
+type upakLoaderWithSenderInfo struct {
+	*scriptedUpak
+	username   libkb.NormalizedUsername
+	deviceName string
+	deviceType string
+}
+
+func (u *upakLoaderWithSenderInfo) LookupUsernameAndDevice(ctx context.Context, uid keybase1.UID, did keybase1.DeviceID) (libkb.NormalizedUsername, string, string, error) {
+	return u.username, u.deviceName, u.deviceType, nil
+}
+
+func (u *upakLoaderWithSenderInfo) LookupUsername(ctx context.Context, uid keybase1.UID) (libkb.NormalizedUsername, error) {
+	return u.username, nil
+}
+
+// setupConcurrentUnboxTest builds a Boxer, sender, and signing key pair
+// that can unbox messages from that sender through the full UnboxMessage
+// path (sender key validity, sender info lookup, TLF key lookup) without
+// touching the network, for exercising UnboxMessages' worker pool. It takes
+// testing.TB so it can also be used from a benchmark.
+func setupConcurrentUnboxTest(tb testing.TB, key *keybase1.CryptKey) (boxer *Boxer, cleanup func(), sender gregor1.UID, signKP libkb.NaclSigningKeyPair, ctx context.Context) {
+	tc := externals.SetupTest(tb, "unbox-concurrent", 2)
+	b := NewBoxer(tc.G, func() keybase1.TlfInterface { return nil })
+
+	upak := &upakLoaderWithSenderInfo{
+		scriptedUpak: newScriptedUpak(nil),
+		username:     libkb.NewNormalizedUsername("mike"),
+		deviceName:   "mikes-phone",
+		deviceType:   "mobile",
+	}
+	tc.G.OverrideUPAKLoader(upak)
+	b.merkleRoot = func(ctx context.Context) (*chat1.MerkleRoot, error) {
+		return &chat1.MerkleRoot{Seqno: 1}, nil
+	}
+
+	rawUID, err := libkb.RandBytes(16)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	rawUID[15] = keybase1.UID_SUFFIX_2
+	sender = gregor1.UID(rawUID)
+
+	signKP, err = libkb.GenerateNaclSigningKeyPair()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	uid, err := keybase1.UIDFromString(hex.EncodeToString(sender.Bytes()))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	kid := keybase1.KIDFromSlice(signKP.GetKID().ToBytes())
+	upak.setScript(uid, kid, senderKeyCacheEntry{found: true})
+
+	finder := &singleKeyFinderMock{key: *key}
+	ctx = context.WithValue(context.Background(), kfKey, finder)
+
+	return b, tc.Cleanup, sender, signKP, ctx
+}
+
+func boxTestMessages(tb testing.TB, boxer *Boxer, key *keybase1.CryptKey, sender gregor1.UID, signKP libkb.NaclSigningKeyPair, n int) []chat1.MessageBoxed {
+	boxedMsgs := make([]chat1.MessageBoxed, n)
+	for i := 0; i < n; i++ {
+		msg := textMsgWithSender(nil, fmt.Sprintf("msg-%d", i), sender)
+		boxed, _, err := boxer.boxMessageWithKeys(msg, key, signKP)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		boxed.ServerHeader = &chat1.MessageServerHeader{
+			MessageID: chat1.MessageID(i + 1),
+			Ctime:     gregor1.ToTime(time.Now()),
+		}
+		boxedMsgs[i] = *boxed
+	}
+	return boxedMsgs
+}
+
+// TestChatUnboxMessagesPreservesOrderUnderConcurrency checks that fanning
+// UnboxMessages out across a bounded worker pool doesn't scramble its
+// result order, even though workers can finish in any order.
+func TestChatUnboxMessagesPreservesOrderUnderConcurrency(t *testing.T) {
+	key := cryptKey(t)
+	boxer, cleanup, sender, signKP, ctx := setupConcurrentUnboxTest(t, key)
+	defer cleanup()
+
+	const n = 50
+	boxedMsgs := boxTestMessages(t, boxer, key, sender, signKP, n)
+
+	boxer.SetUnboxMessagesConcurrency(8)
+	unboxed, err := boxer.UnboxMessages(ctx, boxedMsgs, nil, nil, UnboxMessagesOptions{})
+	require.NoError(t, err)
+	require.Len(t, unboxed, n)
+	for i, msg := range unboxed {
+		require.True(t, msg.IsValid(), "message %d failed to unbox: %+v", i, msg)
+		require.Equal(t, fmt.Sprintf("msg-%d", i), msg.Valid().MessageBody.Text().Body)
+	}
+}
+
+// TestChatUnboxMessagesConcurrencyZeroMeansDefault checks that a Boxer
+// that was never given an explicit SetUnboxMessagesConcurrency call still
+// unboxes a batch correctly, using DefaultUnboxMessagesConcurrency.
+func TestChatUnboxMessagesConcurrencyZeroMeansDefault(t *testing.T) {
+	key := cryptKey(t)
+	boxer, cleanup, sender, signKP, ctx := setupConcurrentUnboxTest(t, key)
+	defer cleanup()
+
+	boxedMsgs := boxTestMessages(t, boxer, key, sender, signKP, 3)
+
+	unboxed, err := boxer.UnboxMessages(ctx, boxedMsgs, nil, nil, UnboxMessagesOptions{})
+	require.NoError(t, err)
+	require.Len(t, unboxed, 3)
+	for i, msg := range unboxed {
+		require.True(t, msg.IsValid())
+		require.Equal(t, fmt.Sprintf("msg-%d", i), msg.Valid().MessageBody.Text().Body)
+	}
+}
+
+// debugCapturingLogger is a logger.Logger that records every CDebugf call
+// it receives instead of emitting it anywhere, so a test can assert on
+// exactly which debug lines a call produced.
+type debugCapturingLogger struct {
+	*logger.Null
+	mu    sync.Mutex
+	lines []string
+}
+
+func newDebugCapturingLogger() *debugCapturingLogger {
+	return &debugCapturingLogger{Null: logger.NewNull()}
+}
+
+func (l *debugCapturingLogger) CDebugf(ctx context.Context, fmt string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt)
+}
+
+func (l *debugCapturingLogger) allLines() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return strings.Join(l.lines, "\n")
+}
+
+// TestChatUnboxMessageLogsPhaseTimings checks that, with SetLogUnboxTiming
+// turned on, UnboxMessage logs a line naming every phase it timed for a
+// successful unbox, and that UnboxMessages always logs its own aggregate
+// batch line regardless of that setting. It uses a FakeClock so the timed
+// durations are deterministic and a capturing logger in place of the real
+// one so the lines can be inspected without parsing real log output.
+func TestChatUnboxMessageLogsPhaseTimings(t *testing.T) {
+	key := cryptKey(t)
+	boxer, cleanup, sender, signKP, ctx := setupConcurrentUnboxTest(t, key)
+	defer cleanup()
+
+	fakeLogger := newDebugCapturingLogger()
+	boxer.G().Log = fakeLogger
+
+	fakeClock := clockwork.NewFakeClock()
+	boxer.SetClock(fakeClock)
+	boxer.SetLogUnboxTiming(true)
+
+	boxedMsgs := boxTestMessages(t, boxer, key, sender, signKP, 1)
+	unboxed, err := boxer.UnboxMessages(ctx, boxedMsgs, nil, nil, UnboxMessagesOptions{})
+	require.NoError(t, err)
+	require.Len(t, unboxed, 1)
+	require.True(t, unboxed[0].IsValid())
+
+	lines := fakeLogger.allLines()
+	require.Contains(t, lines, "UnboxMessage: timings:")
+	for _, phase := range []string{"keyFind:", "bodyDecrypt:", "headerDecrypt:", "verify:", "senderLookup:"} {
+		require.Contains(t, lines, phase, "missing phase %q in per-message timing line", phase)
+	}
+
+	require.Contains(t, lines, "UnboxMessages: batch timings:")
+	require.Contains(t, lines, "prevPointerCheck:")
+}
+
+// countingTlfMock is a keybase1.TlfInterface that counts how many times
+// CryptKeys is actually called, to verify that UnboxMessages' per-call
+// KeyFinder caches across every message in the same TLF rather than
+// hitting the TLF interface once per message.
+type countingTlfMock struct {
+	mu    sync.Mutex
+	calls int
+	key   keybase1.CryptKey
+}
+
+var _ keybase1.TlfInterface = (*countingTlfMock)(nil)
+
+func (m *countingTlfMock) CryptKeys(ctx context.Context, arg keybase1.TLFQuery) (keybase1.GetTLFCryptKeysRes, error) {
+	m.mu.Lock()
+	m.calls++
+	m.mu.Unlock()
+	return keybase1.GetTLFCryptKeysRes{
+		NameIDBreaks: keybase1.CanonicalTLFNameAndIDWithBreaks{CanonicalName: keybase1.CanonicalTlfName(arg.TlfName)},
+		CryptKeys:    []keybase1.CryptKey{m.key},
+	}, nil
+}
+
+func (m *countingTlfMock) PublicCanonicalTLFNameAndID(ctx context.Context, arg keybase1.TLFQuery) (keybase1.CanonicalTLFNameAndIDWithBreaks, error) {
+	return keybase1.CanonicalTLFNameAndIDWithBreaks{CanonicalName: keybase1.CanonicalTlfName(arg.TlfName)}, nil
+}
+
+func (m *countingTlfMock) CompleteAndCanonicalizePrivateTlfName(ctx context.Context, arg keybase1.TLFQuery) (keybase1.CanonicalTLFNameAndIDWithBreaks, error) {
+	return keybase1.CanonicalTLFNameAndIDWithBreaks{CanonicalName: keybase1.CanonicalTlfName(arg.TlfName)}, nil
+}
+
+// TestChatUnboxMessagesCachesTlfKeysAcrossBatch checks that UnboxMessages
+// only hits the TLF interface once for a whole batch of messages that all
+// belong to the same TLF, rather than once per message, even though the
+// batch is fanned out across a worker pool with no shared KeyFinder of its
+// own provided by the caller.
+func TestChatUnboxMessagesCachesTlfKeysAcrossBatch(t *testing.T) {
+	boxer, cleanup, sender, signKP, _ := setupConcurrentUnboxTest(t, &keybase1.CryptKey{})
+	defer cleanup()
+
+	tlfMock := &countingTlfMock{key: *cryptKey(t)}
+	boxer.tlf = func() keybase1.TlfInterface { return tlfMock }
+
+	const n = 20
+	boxedMsgs := make([]chat1.MessageBoxed, n)
+	for i := 0; i < n; i++ {
+		msg := textMsgWithSender(t, fmt.Sprintf("msg-%d", i), sender)
+		msg.ClientHeader.TlfName = "caching_test"
+		boxed, _, err := boxer.boxMessageWithKeys(msg, &tlfMock.key, signKP)
+		require.NoError(t, err)
+		boxed.ServerHeader = &chat1.MessageServerHeader{
+			MessageID: chat1.MessageID(i + 1),
+			Ctime:     gregor1.ToTime(time.Now()),
+		}
+		boxedMsgs[i] = *boxed
+	}
+
+	boxer.SetUnboxMessagesConcurrency(8)
+	unboxed, err := boxer.UnboxMessages(context.Background(), boxedMsgs, nil, nil, UnboxMessagesOptions{})
+	require.NoError(t, err)
+	require.Len(t, unboxed, n)
+	for i, msg := range unboxed {
+		require.True(t, msg.IsValid(), "message %d failed to unbox: %+v", i, msg)
+	}
+
+	tlfMock.mu.Lock()
+	defer tlfMock.mu.Unlock()
+	require.Equal(t, 1, tlfMock.calls,
+		"expected UnboxMessages to hit the TLF interface exactly once for a batch entirely in one TLF")
+}
+
+// tlfIDKeyFinderMock is a KeyFinder whose resolved TlfID is a deterministic
+// function of tlfName, so a test can compute the TLF ID a given name
+// should resolve to without a real TLF interface.
+type tlfIDKeyFinderMock struct {
+	key keybase1.CryptKey
+}
+
+func tlfIDForName(tlfName string) chat1.TLFID {
+	return chat1.TLFID([]byte(tlfName))
+}
+
+func (k *tlfIDKeyFinderMock) Find(ctx context.Context, tlf keybase1.TlfInterface, tlfName string, tlfPublic bool) (keybase1.GetTLFCryptKeysRes, error) {
+	return keybase1.GetTLFCryptKeysRes{
+		NameIDBreaks: keybase1.CanonicalTLFNameAndIDWithBreaks{
+			CanonicalName: keybase1.CanonicalTlfName(tlfName),
+			TlfID:         keybase1.TLFID(hex.EncodeToString(tlfIDForName(tlfName))),
+		},
+		CryptKeys: []keybase1.CryptKey{k.key},
+	}, nil
+}
+
+// TestChatUnboxMessageDetectsTamperedTlfName checks that UnboxMessage
+// rejects a message whose (unencrypted, server-visible) TlfName was
+// changed after boxing, so it now resolves to a different TLF ID than
+// the one the server's conversation triple claims this message belongs
+// to.
+func TestChatUnboxMessageDetectsTamperedTlfName(t *testing.T) {
+	key := cryptKey(t)
+	boxer, cleanup, sender, signKP, _ := setupConcurrentUnboxTest(t, key)
+	defer cleanup()
+
+	ctx := context.WithValue(context.Background(), kfKey, &tlfIDKeyFinderMock{key: *key})
+
+	msg := textMsgWithSender(t, "hi", sender)
+	msg.ClientHeader.TlfName = "original_tlf"
+	msg.ClientHeader.Conv.Tlfid = tlfIDForName("original_tlf")
+	boxed, _, err := boxer.boxMessageWithKeys(msg, key, signKP)
+	require.NoError(t, err)
+	boxed.ServerHeader = &chat1.MessageServerHeader{
+		MessageID: 1,
+		Ctime:     gregor1.ToTime(time.Now()),
+	}
+
+	// Tamper with the TLF name the server sees, without touching the
+	// conversation triple's TLF ID -- the two now disagree about which
+	// TLF this message belongs to.
+	boxed.ClientHeader.TlfName = "attacker_tlf"
+
+	unboxed, ierr := boxer.UnboxMessage(ctx, *boxed, nil, nil, nil)
+	require.Nil(t, ierr)
+	state, err := unboxed.State()
+	require.NoError(t, err)
+	require.Equal(t, chat1.MessageUnboxedState_ERROR, state)
+	require.Equal(t, chat1.MessageUnboxedErrorType_TLFIDMISMATCH, unboxed.Error().ErrType)
+}
+
+// TestChatUnboxMessageFinalizedTlfNameResolvesCorrectly checks that a
+// message from a finalized (account-reset) TLF, whose conversation triple
+// carries the ID for the post-reset canonical name, still passes the TLF
+// ID check -- the check has to expand the TLF name with finalizeInfo
+// before resolving, not compare against the raw TlfName.
+func TestChatUnboxMessageFinalizedTlfNameResolvesCorrectly(t *testing.T) {
+	key := cryptKey(t)
+	boxer, cleanup, sender, signKP, _ := setupConcurrentUnboxTest(t, key)
+	defer cleanup()
+
+	ctx := context.WithValue(context.Background(), kfKey, &tlfIDKeyFinderMock{key: *key})
+
+	finalizeInfo := &chat1.ConversationFinalizeInfo{
+		ResetUser: "victim",
+		ResetDate: "2020-01-01",
+		ResetFull: "reset_tlf_name",
+	}
+
+	msg := textMsgWithSender(t, "hi", sender)
+	msg.ClientHeader.TlfName = "reset_tlf"
+	msg.ClientHeader.Conv.Tlfid = tlfIDForName(chat1.ExpandTLFName("reset_tlf", finalizeInfo))
+	boxed, _, err := boxer.boxMessageWithKeys(msg, key, signKP)
+	require.NoError(t, err)
+	boxed.ServerHeader = &chat1.MessageServerHeader{
+		MessageID: 1,
+		Ctime:     gregor1.ToTime(time.Now()),
+	}
+
+	unboxed, ierr := boxer.UnboxMessage(ctx, *boxed, finalizeInfo, nil, nil)
+	require.Nil(t, ierr)
+	require.True(t, unboxed.IsValid(), "expected a finalized TLF whose triple matches the expanded name to unbox cleanly: %+v", unboxed)
+}
+
+// TestChatUnboxMessageUsesConfiguredKeyFinder checks that UnboxMessage uses
+// a Boxer-configured KeyFinder (set via SetKeyFinder) instead of falling
+// back to CtxKeyFinder(ctx), so tools can unbox against a fixed,
+// pre-populated key set with no context wiring and no TLF interface at all.
+func TestChatUnboxMessageUsesConfiguredKeyFinder(t *testing.T) {
+	key := cryptKey(t)
+	boxer, cleanup, sender, signKP, _ := setupConcurrentUnboxTest(t, key)
+	defer cleanup()
+
+	boxer.SetKeyFinder(&singleKeyFinderMock{key: *key})
+
+	msg := textMsgWithSender(t, "hi", sender)
+	boxed, _, err := boxer.boxMessageWithKeys(msg, key, signKP)
+	require.NoError(t, err)
+	boxed.ServerHeader = &chat1.MessageServerHeader{
+		MessageID: 1,
+		Ctime:     gregor1.ToTime(time.Now()),
+	}
+
+	// context.Background() carries no KeyFinder at all: without the
+	// configured one, UnboxMessage would fall back to a fresh KeyFinder
+	// that calls boxer.tlf(), which is nil in this test.
+	unboxed, ierr := boxer.UnboxMessage(context.Background(), *boxed, nil, nil, nil)
+	require.Nil(t, ierr)
+	require.True(t, unboxed.IsValid(), "expected the configured KeyFinder to be used: %+v", unboxed)
+}
+
+// cancelingKeyFinderMock is a KeyFinder that cancels its caller's context
+// as a side effect of answering Find, then answers normally -- it's used
+// to check that a context canceled partway through UnboxMessage's pipeline
+// stops the rest of the pipeline from running, rather than actually
+// failing the key lookup itself.
+type cancelingKeyFinderMock struct {
+	key    keybase1.CryptKey
+	cancel context.CancelFunc
+}
+
+func (k *cancelingKeyFinderMock) Find(ctx context.Context, tlf keybase1.TlfInterface, tlfName string, tlfPublic bool) (keybase1.GetTLFCryptKeysRes, error) {
+	k.cancel()
+	return keybase1.GetTLFCryptKeysRes{
+		NameIDBreaks: keybase1.CanonicalTLFNameAndIDWithBreaks{CanonicalName: keybase1.CanonicalTlfName(tlfName)},
+		CryptKeys:    []keybase1.CryptKey{k.key},
+	}, nil
+}
+
+// TestChatUnboxMessageRespectsContextCancellation checks that UnboxMessage
+// stops once its context is canceled, rather than running all the way
+// through to the sender-info UPAK lookup regardless. It cancels the
+// context from inside the KeyFinder -- UnboxMessage's very first network-
+// ish step -- so that every later phase, including the UPAK lookup, would
+// run into an already-canceled context if UnboxMessage didn't check for
+// it. The UPAK loader here is a bare scriptedUpak with no
+// LookupUsername(AndDevice) script configured, so either of those calls
+// fails the test outright (see scriptedUpak), confirming the UPAK lookup
+// itself was never reached.
+func TestChatUnboxMessageRespectsContextCancellation(t *testing.T) {
+	key := cryptKey(t)
+	tc := externals.SetupTest(t, "unbox-cancel", 2)
+	defer tc.Cleanup()
+
+	boxer := NewBoxer(tc.G, func() keybase1.TlfInterface { return nil })
+	boxer.merkleRoot = func(ctx context.Context) (*chat1.MerkleRoot, error) {
+		return &chat1.MerkleRoot{Seqno: 1}, nil
+	}
+
+	upak := newScriptedUpak(t)
+	tc.G.OverrideUPAKLoader(upak)
+
+	rawUID, err := libkb.RandBytes(16)
+	require.NoError(t, err)
+	rawUID[15] = keybase1.UID_SUFFIX_2
+	sender := gregor1.UID(rawUID)
+
+	signKP, err := libkb.GenerateNaclSigningKeyPair()
+	require.NoError(t, err)
+	uid, err := keybase1.UIDFromString(hex.EncodeToString(sender.Bytes()))
+	require.NoError(t, err)
+	kid := keybase1.KIDFromSlice(signKP.GetKID().ToBytes())
+	upak.setScript(uid, kid, senderKeyCacheEntry{found: true})
+
+	msg := textMsgWithSender(t, "hi", sender)
+	boxed, _, err := boxer.boxMessageWithKeys(msg, key, signKP)
+	require.NoError(t, err)
+	boxed.ServerHeader = &chat1.MessageServerHeader{MessageID: 1, Ctime: gregor1.ToTime(time.Now())}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	finder := &cancelingKeyFinderMock{key: *key, cancel: cancel}
+	ctx = context.WithValue(ctx, kfKey, finder)
+
+	_, ierr := boxer.UnboxMessage(ctx, *boxed, nil, nil, nil)
+	require.NotNil(t, ierr, "expected UnboxMessage to report the canceled context rather than unboxing successfully")
+	require.False(t, ierr.IsPermanent(), "a canceled context is a transient condition, not a permanent rejection of the message")
+	require.Equal(t, context.Canceled, ierr.Inner())
+}
+
+// replayBoxedMessage returns a copy of orig with its MessageID changed,
+// leaving the ciphertext (and therefore the body hash) untouched -- a
+// crafted replay of an earlier message under a new message ID, the way a
+// server replaying a captured ciphertext would present it.
+func replayBoxedMessage(orig chat1.MessageBoxed, newMessageID chat1.MessageID) chat1.MessageBoxed {
+	replayed := orig
+	sh := *orig.ServerHeader
+	sh.MessageID = newMessageID
+	replayed.ServerHeader = &sh
+	return replayed
+}
+
+// TestChatUnboxNoopBodyHashCheckerAllowsReplay checks that the default
+// BodyHashChecker -- NoopBodyHashChecker, used when a Boxer's
+// SetBodyHashChecker is never called -- unboxes a replayed message the
+// same as it would any other: a duplicated body hash under a new message
+// ID doesn't fail it any more than it would have before BodyHashChecker
+// existed.
+func TestChatUnboxNoopBodyHashCheckerAllowsReplay(t *testing.T) {
+	key := cryptKey(t)
+	boxer, cleanup, sender, signKP, ctx := setupConcurrentUnboxTest(t, key)
+	defer cleanup()
+
+	msg := textMsgWithSender(t, "hi", sender)
+	boxed, _, err := boxer.boxMessageWithKeys(msg, key, signKP)
+	require.NoError(t, err)
+	boxed.ServerHeader = &chat1.MessageServerHeader{MessageID: 1, Ctime: gregor1.ToTime(time.Now())}
+
+	unboxedFirst, ierr := boxer.UnboxMessage(ctx, *boxed, nil, nil, nil)
+	require.Nil(t, ierr)
+	require.True(t, unboxedFirst.IsValid())
+
+	replayed := replayBoxedMessage(*boxed, 2)
+	unboxedReplay, ierr := boxer.UnboxMessage(ctx, replayed, nil, nil, nil)
+	require.Nil(t, ierr, "expected the replay to unbox cleanly under the default NoopBodyHashChecker")
+	require.True(t, unboxedReplay.IsValid())
+}
+
+// TestChatUnboxDuplicateInvalidatesCheckerRejectsReplay checks that a Boxer
+// configured with a DuplicateInvalidatesChecker rejects a second message
+// that reuses a body hash already claimed by an earlier message in the
+// same conversation. The earlier message's ID is logged as also suspect,
+// but (as of this writing) nothing acts on that beyond the log line, so
+// the earlier message itself is left unboxed.
+func TestChatUnboxDuplicateInvalidatesCheckerRejectsReplay(t *testing.T) {
+	key := cryptKey(t)
+	boxer, cleanup, sender, signKP, ctx := setupConcurrentUnboxTest(t, key)
+	defer cleanup()
+	boxer.SetBodyHashChecker(NewDuplicateInvalidatesChecker())
+
+	msg := textMsgWithSender(t, "hi", sender)
+	boxed, _, err := boxer.boxMessageWithKeys(msg, key, signKP)
+	require.NoError(t, err)
+	boxed.ServerHeader = &chat1.MessageServerHeader{MessageID: 1, Ctime: gregor1.ToTime(time.Now())}
+
+	unboxedFirst, ierr := boxer.UnboxMessage(ctx, *boxed, nil, nil, nil)
+	require.Nil(t, ierr, "the first claimant of a body hash should always unbox successfully")
+	require.True(t, unboxedFirst.IsValid())
+
+	replayed := replayBoxedMessage(*boxed, 2)
+	unboxedReplay, ierr := boxer.UnboxMessage(ctx, replayed, nil, nil, nil)
+	require.Nil(t, ierr, "a permanent rejection comes back as an error-type MessageUnboxed, not as an UnboxingError")
+	state, err := unboxedReplay.State()
+	require.NoError(t, err)
+	require.Equal(t, chat1.MessageUnboxedState_ERROR, state, "expected the replay to be rejected")
+	require.Contains(t, unboxedReplay.Error().ErrMsg, DuplicateBodyHashError{}.Error())
+
+	// a message unboxed twice (e.g. a client retry) isn't a replay of a
+	// different message, so it isn't flagged
+	_, ierr = boxer.UnboxMessage(ctx, *boxed, nil, nil, nil)
+	require.Nil(t, ierr, "expected re-unboxing the same message ID to succeed")
+}
+
+// TestChatUnboxSetsSenderDeviceTypeEnum checks that UnboxMessage populates
+// MessageUnboxedValid.SenderDeviceTypeEnum from the sender's device type
+// string, alongside the pre-existing SenderDeviceType string field.
+func TestChatUnboxSetsSenderDeviceTypeEnum(t *testing.T) {
+	key := cryptKey(t)
+	tc := externals.SetupTest(t, "device-type-enum", 2)
+	defer tc.Cleanup()
+	boxer := NewBoxer(tc.G, func() keybase1.TlfInterface { return nil })
+	boxer.SetKeyFinder(&singleKeyFinderMock{key: *key})
+
+	upak := &upakLoaderWithSenderInfo{
+		scriptedUpak: newScriptedUpak(t),
+		username:     libkb.NewNormalizedUsername("mike"),
+		deviceName:   "mikes-phone",
+		deviceType:   "mobile",
+	}
+	tc.G.OverrideUPAKLoader(upak)
+	boxer.merkleRoot = func(ctx context.Context) (*chat1.MerkleRoot, error) {
+		return &chat1.MerkleRoot{Seqno: 1}, nil
+	}
+
+	rawUID, err := libkb.RandBytes(16)
+	require.NoError(t, err)
+	rawUID[15] = keybase1.UID_SUFFIX_2
+	sender := gregor1.UID(rawUID)
+	senderDevice := randomDeviceID(t)
+
+	signKP, err := libkb.GenerateNaclSigningKeyPair()
+	require.NoError(t, err)
+	uid, err := keybase1.UIDFromString(hex.EncodeToString(sender.Bytes()))
+	require.NoError(t, err)
+	kid := keybase1.KIDFromSlice(signKP.GetKID().ToBytes())
+	upak.setScript(uid, kid, senderKeyCacheEntry{found: true})
+	did, err := keybase1.DeviceIDFromString(hex.EncodeToString(senderDevice.Bytes()))
+	require.NoError(t, err)
+	upak.setDeviceScript(uid, did, keybase1.PublicKey{KID: kid, DeviceID: did})
+
+	header := chat1.MessageClientHeader{Sender: sender, SenderDevice: senderDevice}
+	msg := textMsgWithHeader(t, "hi", header)
+	boxed, _, err := boxer.boxMessageWithKeys(msg, key, signKP)
+	require.NoError(t, err)
+	boxed.ServerHeader = &chat1.MessageServerHeader{MessageID: 1, Ctime: gregor1.ToTime(time.Now())}
+
+	unboxed, ierr := boxer.UnboxMessage(context.Background(), *boxed, nil, nil, nil)
+	require.Nil(t, ierr)
+	require.True(t, unboxed.IsValid())
+	require.Equal(t, "mobile", unboxed.Valid().SenderDeviceType)
+	require.Equal(t, chat1.SenderDeviceType_MOBILE, unboxed.Valid().SenderDeviceTypeEnum)
+}
+
+// TestChatUnboxHeaderPlaintextV2 boxes a message using HeaderPlaintextV2
+// directly, rather than the V1 that boxMessageWithKeys always produces, and
+// checks that UnboxMessage accepts it and recovers an equivalent
+// MessageClientHeader. HeaderPlaintextV2 is field-for-field identical to
+// HeaderPlaintextV1, but unboxing used to reject it outright as an
+// unsupported version; this is the regression test for that.
+func TestChatUnboxHeaderPlaintextV2(t *testing.T) {
+	key := cryptKey(t)
+	boxer, cleanup, sender, signKP, _ := setupConcurrentUnboxTest(t, key)
+	defer cleanup()
+
+	boxer.SetKeyFinder(&singleKeyFinderMock{key: *key})
+
+	msg := textMsgWithSender(t, "hi from v2", sender)
+
+	bodyPlaintext, err := boxer.marshalBodyPlaintext(msg.MessageBody)
+	require.NoError(t, err)
+	sealedBody, err := boxer.sealBytes(bodyPlaintext, key)
+	require.NoError(t, err)
+	bh := boxer.hashV1(sealedBody.E)
+
+	header := chat1.HeaderPlaintextV2{
+		Conv:         msg.ClientHeader.Conv,
+		TlfName:      msg.ClientHeader.TlfName,
+		TlfPublic:    msg.ClientHeader.TlfPublic,
+		MessageType:  msg.ClientHeader.MessageType,
+		Prev:         msg.ClientHeader.Prev,
+		Sender:       msg.ClientHeader.Sender,
+		SenderDevice: msg.ClientHeader.SenderDevice,
+		BodyHash:     bh[:],
+		OutboxInfo:   msg.ClientHeader.OutboxInfo,
+		OutboxID:     msg.ClientHeader.OutboxID,
+	}
+	sig, err := boxer.signMarshal(header, signKP, libkb.SignaturePrefixChat)
+	require.NoError(t, err)
+	header.HeaderSignature = &sig
+
+	sealedHeader, err := boxer.seal(chat1.NewHeaderPlaintextWithV2(header), key)
+	require.NoError(t, err)
+
+	boxed := &chat1.MessageBoxed{
+		ClientHeader:     msg.ClientHeader,
+		BodyCiphertext:   *sealedBody,
+		HeaderCiphertext: *sealedHeader,
+		KeyGeneration:    key.KeyGeneration,
+		ServerHeader: &chat1.MessageServerHeader{
+			MessageID: 1,
+			Ctime:     gregor1.ToTime(time.Now()),
+		},
+	}
+
+	unboxed, ierr := boxer.UnboxMessage(context.Background(), *boxed, nil, nil, nil)
+	require.Nil(t, ierr)
+	require.True(t, unboxed.IsValid(), "expected HeaderPlaintextV2 to unbox cleanly: %+v", unboxed)
+
+	valid := unboxed.Valid()
+	require.Equal(t, msg.ClientHeader.Conv, valid.ClientHeader.Conv)
+	require.Equal(t, msg.ClientHeader.TlfName, valid.ClientHeader.TlfName)
+	require.Equal(t, msg.ClientHeader.Sender, valid.ClientHeader.Sender)
+	require.Equal(t, msg.ClientHeader.SenderDevice, valid.ClientHeader.SenderDevice)
+	require.Equal(t, "hi from v2", valid.MessageBody.Text().Body)
+}
+
+// TestChatBoxEphemeralMessage boxes a message with a non-nil
+// EphemeralLifetime and checks that it round-trips through UnboxMessage.
+// EphemeralLifetime only exists on HeaderPlaintextV3, so a successful
+// round trip also proves boxMessageWithKeys chose V3 for this message
+// rather than its usual V1.
+func TestChatBoxEphemeralMessage(t *testing.T) {
+	key := cryptKey(t)
+	boxer, cleanup, sender, signKP, _ := setupConcurrentUnboxTest(t, key)
+	defer cleanup()
+
+	boxer.SetKeyFinder(&singleKeyFinderMock{key: *key})
+
+	msg := textMsgWithSender(t, "this message will explode", sender)
+	lifetime := gregor1.DurationMsec(time.Hour / time.Millisecond)
+	msg.ClientHeader.EphemeralLifetime = &lifetime
+
+	boxed, _, err := boxer.boxMessageWithKeys(msg, key, signKP)
+	require.NoError(t, err)
+	boxed.ServerHeader = &chat1.MessageServerHeader{
+		MessageID: 1,
+		Ctime:     gregor1.ToTime(time.Now()),
+	}
+
+	unboxed, ierr := boxer.UnboxMessage(context.Background(), *boxed, nil, nil, nil)
+	require.Nil(t, ierr)
+	require.True(t, unboxed.IsValid(), "expected ephemeral message to unbox cleanly: %+v", unboxed)
+
+	valid := unboxed.Valid()
+	require.True(t, valid.IsEphemeral)
+	require.NotNil(t, valid.ClientHeader.EphemeralLifetime)
+	require.Equal(t, lifetime, *valid.ClientHeader.EphemeralLifetime)
+	require.NotNil(t, valid.Etime)
+	require.Equal(t, boxed.ServerHeader.Ctime+gregor1.Time(lifetime), *valid.Etime)
+	require.Equal(t, "this message will explode", valid.MessageBody.Text().Body)
+}
+
+// TestChatUnboxEphemeralMessageBeforeExpiration checks that an ephemeral
+// message whose Etime hasn't arrived yet unboxes with its body intact.
+func TestChatUnboxEphemeralMessageBeforeExpiration(t *testing.T) {
+	key := cryptKey(t)
+	boxer, cleanup, sender, signKP, _ := setupConcurrentUnboxTest(t, key)
+	defer cleanup()
+
+	boxer.SetKeyFinder(&singleKeyFinderMock{key: *key})
+
+	msg := textMsgWithSender(t, "not exploded yet", sender)
+	lifetime := gregor1.DurationMsec(time.Hour / time.Millisecond)
+	msg.ClientHeader.EphemeralLifetime = &lifetime
+
+	boxed, _, err := boxer.boxMessageWithKeys(msg, key, signKP)
+	require.NoError(t, err)
+	boxed.ServerHeader = &chat1.MessageServerHeader{
+		MessageID: 1,
+		Ctime:     gregor1.ToTime(time.Now()),
+	}
+
+	unboxed, ierr := boxer.UnboxMessage(context.Background(), *boxed, nil, nil, nil)
+	require.Nil(t, ierr)
+	require.True(t, unboxed.IsValid(), "expected unexpired ephemeral message to unbox cleanly: %+v", unboxed)
+
+	valid := unboxed.Valid()
+	require.True(t, valid.IsEphemeral)
+	require.NotNil(t, valid.Etime)
+	require.Equal(t, "not exploded yet", valid.MessageBody.Text().Body)
+}
+
+// TestChatUnboxEphemeralMessageAfterExpiration checks that an ephemeral
+// message whose Etime has already passed unboxes with its body
+// suppressed, while every other field -- ClientHeader included -- still
+// comes through.
+func TestChatUnboxEphemeralMessageAfterExpiration(t *testing.T) {
+	key := cryptKey(t)
+	boxer, cleanup, sender, signKP, _ := setupConcurrentUnboxTest(t, key)
+	defer cleanup()
+
+	boxer.SetKeyFinder(&singleKeyFinderMock{key: *key})
+
+	msg := textMsgWithSender(t, "already exploded", sender)
+	lifetime := gregor1.DurationMsec(time.Minute / time.Millisecond)
+	msg.ClientHeader.EphemeralLifetime = &lifetime
+
+	boxed, _, err := boxer.boxMessageWithKeys(msg, key, signKP)
+	require.NoError(t, err)
+	boxed.ServerHeader = &chat1.MessageServerHeader{
+		MessageID: 1,
+		Ctime:     gregor1.ToTime(time.Now().Add(-time.Hour)),
+	}
+
+	unboxed, ierr := boxer.UnboxMessage(context.Background(), *boxed, nil, nil, nil)
+	require.Nil(t, ierr)
+	require.True(t, unboxed.IsValid(), "expected expired ephemeral message to still unbox: %+v", unboxed)
+
+	valid := unboxed.Valid()
+	require.True(t, valid.IsEphemeral)
+	require.NotNil(t, valid.Etime)
+	require.Equal(t, boxed.ServerHeader.Ctime+gregor1.Time(lifetime), *valid.Etime)
+	require.Equal(t, sender, valid.ClientHeader.Sender)
+	require.Equal(t, chat1.MessageBody{}, valid.MessageBody)
+}
+
+// TestChatUnboxEphemeralMessageExpiresByBoxerClock checks that the
+// ephemeral-expiry check reads the current time off the Boxer's
+// injectable clock rather than the real clock, by advancing a FakeClock
+// past Etime instead of backdating Ctime.
+func TestChatUnboxEphemeralMessageExpiresByBoxerClock(t *testing.T) {
+	key := cryptKey(t)
+	boxer, cleanup, sender, signKP, _ := setupConcurrentUnboxTest(t, key)
+	defer cleanup()
+
+	boxer.SetKeyFinder(&singleKeyFinderMock{key: *key})
+
+	fakeClock := clockwork.NewFakeClock()
+	boxer.SetClock(fakeClock)
+
+	msg := textMsgWithSender(t, "watch the clock", sender)
+	lifetime := gregor1.DurationMsec(time.Minute / time.Millisecond)
+	msg.ClientHeader.EphemeralLifetime = &lifetime
+
+	boxed, _, err := boxer.boxMessageWithKeys(msg, key, signKP)
+	require.NoError(t, err)
+	boxed.ServerHeader = &chat1.MessageServerHeader{
+		MessageID: 1,
+		Ctime:     gregor1.ToTime(fakeClock.Now()),
+	}
+
+	fakeClock.Advance(2 * time.Minute)
+
+	unboxed, ierr := boxer.UnboxMessage(context.Background(), *boxed, nil, nil, nil)
+	require.Nil(t, ierr)
+	require.True(t, unboxed.IsValid(), "expected expired ephemeral message to still unbox: %+v", unboxed)
+
+	valid := unboxed.Valid()
+	require.True(t, valid.IsEphemeral)
+	require.Equal(t, chat1.MessageBody{}, valid.MessageBody, "expected the body to be suppressed once the FakeClock passed Etime")
+}
+
+// BenchmarkChatUnboxMessagesConcurrency compares UnboxMessages on a
+// 500-message thread at concurrency 1 (the old sequential behavior)
+// against DefaultUnboxMessagesConcurrency, to demonstrate the speedup from
+// fanning the batch out across a worker pool.
+func BenchmarkChatUnboxMessagesConcurrency(b *testing.B) {
+	key := cryptKey(b)
+	boxer, cleanup, sender, signKP, ctx := setupConcurrentUnboxTest(b, key)
+	defer cleanup()
+
+	const n = 500
+	boxedMsgs := boxTestMessages(b, boxer, key, sender, signKP, n)
+
+	for _, concurrency := range []int{1, DefaultUnboxMessagesConcurrency} {
+		b.Run(fmt.Sprintf("concurrency=%d", concurrency), func(b *testing.B) {
+			boxer.SetUnboxMessagesConcurrency(concurrency)
+			for i := 0; i < b.N; i++ {
+				if _, err := boxer.UnboxMessages(ctx, boxedMsgs, nil, nil, UnboxMessagesOptions{}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestChatEstimateBoxedSize(t *testing.T) {
+	tc, boxer := setupChatTest(t, "box")
+	defer tc.Cleanup()
+	boxer.tlf = func() keybase1.TlfInterface { return nil }
+
+	msg := textMsg(t, "this is a somewhat longer message, to make sure the estimate tracks a non-trivial body size")
+	msg.ClientHeader.TlfName = "estimate_size"
+
+	estimate, err := boxer.EstimateBoxedSize(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signKP, err := libkb.GenerateNaclSigningKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	finder := &singleKeyFinderMock{key: keybase1.CryptKey{KeyGeneration: 5, Key: keybase1.Bytes32{1, 2, 3}}}
+	ctx := context.WithValue(context.Background(), kfKey, finder)
+
+	boxed, _, err := boxer.BoxMessage(ctx, msg, signKP, nil, BoxMessageOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if estimate.BodyCiphertextSize != len(boxed.BodyCiphertext.E) {
+		t.Fatalf("expected body ciphertext size %d, got %d", estimate.BodyCiphertextSize, len(boxed.BodyCiphertext.E))
+	}
+
+	// The header ciphertext size is only an estimate, since the estimate's
+	// signature was made with a throwaway key rather than msg's real
+	// signer, but NaCl signatures (and the KIDs that go with them) are a
+	// fixed size, so it should still land exactly on the real size.
+	actualHeaderSize := len(boxed.HeaderCiphertext.E)
+	if diff := estimate.HeaderCiphertextSize - actualHeaderSize; diff < -8 || diff > 8 {
+		t.Fatalf("expected header ciphertext size %d to be within a small constant of the real size %d",
+			estimate.HeaderCiphertextSize, actualHeaderSize)
+	}
+
+	actualTotal := len(boxed.BodyCiphertext.E) + len(boxed.BodyCiphertext.N) +
+		len(boxed.HeaderCiphertext.E) + len(boxed.HeaderCiphertext.N)
+	if diff := estimate.TotalSize - actualTotal; diff < -8 || diff > 8 {
+		t.Fatalf("expected total size %d to be within a small constant of the real size %d",
+			estimate.TotalSize, actualTotal)
+	}
+}
+
+func TestChatCheckBodyHash(t *testing.T) {
+	key := cryptKey(t)
+	tc, boxer := setupChatTest(t, "checkbodyhash")
+	defer tc.Cleanup()
+
+	signKP, err := libkb.GenerateNaclSigningKeyPair()
+	require.NoError(t, err)
+
+	msg := textMsg(t, "hi")
+	boxed, _, err := boxer.boxMessageWithKeys(msg, key, signKP)
+	require.NoError(t, err)
+
+	res, err := boxer.CheckBodyHash(*boxed, key)
+	require.NoError(t, err)
+	require.True(t, res.Match, "claimed: %x computed: %x", res.Claimed, res.Computed)
+	require.True(t, res.Claimed.Eq(res.Computed))
+
+	// Deliberately corrupt the body ciphertext, leaving the header (and
+	// thus its claimed BodyHash) untouched.
+	corrupted := *boxed
+	corrupted.BodyCiphertext.E = append([]byte{}, corrupted.BodyCiphertext.E...)
+	corrupted.BodyCiphertext.E[0] ^= 0xff
+
+	corruptedRes, err := boxer.CheckBodyHash(corrupted, key)
+	require.NoError(t, err)
+	require.False(t, corruptedRes.Match)
+	require.False(t, corruptedRes.Claimed.Eq(corruptedRes.Computed))
+	require.True(t, res.Claimed.Eq(corruptedRes.Claimed), "corrupting the body should not change the header's claimed hash")
+}
+
+// TestChatVerifyHeaderOnly checks Boxer.VerifyHeader's two outcomes: a
+// known-good decrypted header verifies cleanly, and the same header with
+// its signature tampered is rejected with a HeaderSignatureError --
+// without either case going through UnboxMessage's decrypt path.
+func TestChatVerifyHeaderOnly(t *testing.T) {
+	key := cryptKey(t)
+	boxer, cleanup, sender, signKP, _ := setupConcurrentUnboxTest(t, key)
+	defer cleanup()
+
+	msg := textMsgWithSender(t, "hi", sender)
+	boxed, _, err := boxer.boxMessageWithKeys(msg, key, signKP)
+	require.NoError(t, err)
+	boxed.ServerHeader = &chat1.MessageServerHeader{MessageID: 1, Ctime: gregor1.ToTime(time.Now())}
+
+	packedHeader, err := boxer.open(boxed.HeaderCiphertext, key)
+	require.NoError(t, err)
+	var header chat1.HeaderPlaintext
+	require.NoError(t, boxer.unmarshal(packedHeader, &header))
+
+	res, ierr := boxer.VerifyHeader(context.Background(), header, *boxed, false)
+	require.Nil(t, ierr)
+	require.Nil(t, res.SenderDeviceRevokedAt)
+
+	// Tamper with the signature bytes without touching anything else.
+	tampered := header.V1()
+	tampered.HeaderSignature.S[0] ^= 0xff
+	tamperedHeader := chat1.NewHeaderPlaintextWithV1(tampered)
+
+	_, ierr = boxer.VerifyHeader(context.Background(), tamperedHeader, *boxed, false)
+	require.NotNil(t, ierr)
+	require.True(t, ierr.IsPermanent())
+	require.IsType(t, HeaderSignatureError{}, ierr.Inner())
+}
+
+// erroringKeyFinderMock always fails to find keys, so any message that
+// reaches it fails to unbox with a transient error, without touching the
+// network.
+type erroringKeyFinderMock struct{}
+
+func (k *erroringKeyFinderMock) Find(ctx context.Context, tlf keybase1.TlfInterface, tlfName string, tlfPublic bool) (keybase1.GetTLFCryptKeysRes, error) {
+	return keybase1.GetTLFCryptKeysRes{}, errors.New("no crypt keys available")
+}
+
+func TestChatUnboxMessagesErrorIncludesFailingMessage(t *testing.T) {
+	key := cryptKey(t)
+	tc, boxer := setupChatTest(t, "unbox")
+	defer tc.Cleanup()
+	boxer.tlf = func() keybase1.TlfInterface { return nil }
+
+	signKP, err := libkb.GenerateNaclSigningKeyPair()
+	require.NoError(t, err)
+
+	// The first message is out of idRange, so it never reaches the
+	// KeyFinder and comes back as an absentMessage placeholder.
+	msg1 := textMsg(t, "first")
+	boxed1, _, err := boxer.boxMessageWithKeys(msg1, key, signKP)
+	require.NoError(t, err)
+	boxed1.ServerHeader = &chat1.MessageServerHeader{MessageID: 1, Ctime: gregor1.ToTime(time.Now())}
+
+	// The second message is in range, so UnboxMessage runs for it and
+	// fails at the KeyFinder, without ever needing the network.
+	msg2 := textMsg(t, "second")
+	boxed2, _, err := boxer.boxMessageWithKeys(msg2, key, signKP)
+	require.NoError(t, err)
+	boxed2.ServerHeader = &chat1.MessageServerHeader{MessageID: 11, Ctime: gregor1.ToTime(time.Now())}
+
+	ctx := context.WithValue(context.Background(), kfKey, &erroringKeyFinderMock{})
+
+	_, err = boxer.UnboxMessages(ctx, []chat1.MessageBoxed{*boxed1, *boxed2}, nil, &MessageIDRange{MinID: 5}, UnboxMessagesOptions{})
+	require.Error(t, err)
+
+	uerr, ok := err.(UnboxMessagesError)
+	require.True(t, ok, "expected UnboxMessagesError, got %T", err)
+	require.Equal(t, 1, uerr.Index, "second message in the batch is at index 1")
+	require.EqualValues(t, 11, uerr.MessageID)
+	require.False(t, uerr.IsPermanent(), "a KeyFinder failure is a transient error")
+	require.Contains(t, uerr.Error(), "11")
+
+	// The wrapper forwards to the inner UnboxingError.
+	require.Equal(t, uerr.Err.Inner(), uerr.Inner())
+	require.Equal(t, uerr.Err, uerr.Unwrap())
+}
+
+func TestChatReBoxRoundTrip(t *testing.T) {
+	tc, boxer := setupChatTest(t, "rebox")
+	defer tc.Cleanup()
+	boxer.tlf = func() keybase1.TlfInterface { return nil }
+
+	msg := textMsg(t, "rebox me")
+	msg.ClientHeader.TlfName = "rebox"
+
+	signKP, err := libkb.GenerateNaclSigningKeyPair()
+	require.NoError(t, err)
+
+	oldKey := keybase1.CryptKey{KeyGeneration: 3, Key: keybase1.Bytes32{1, 2, 3}}
+	unboxed := chat1.NewMessageUnboxedWithValid(chat1.MessageUnboxedValid{
+		ClientHeader: msg.ClientHeader,
+		ServerHeader: chat1.MessageServerHeader{
+			MessageID: 7,
+			Ctime:     gregor1.ToTime(time.Now()),
+		},
+		MessageBody: msg.MessageBody,
+	})
+
+	newKey := keybase1.CryptKey{KeyGeneration: 4, Key: keybase1.Bytes32{4, 5, 6}}
+	finder := &singleKeyFinderMock{key: newKey}
+	ctx := context.WithValue(context.Background(), kfKey, finder)
+
+	reboxed, headerHash, err := boxer.ReBox(ctx, unboxed, signKP)
+	require.NoError(t, err)
+	require.NotNil(t, reboxed)
+	require.NotEmpty(t, headerHash)
+
+	require.Equal(t, newKey.KeyGeneration, reboxed.KeyGeneration)
+	require.NotEqual(t, oldKey.KeyGeneration, reboxed.KeyGeneration)
+	require.Equal(t, msg.ClientHeader.Sender, reboxed.ClientHeader.Sender)
+
+	// The re-boxed message decrypts back to the same plaintext body under
+	// the new key, without needing a full UnboxMessage (which would
+	// require a real user to verify the fresh signature against).
+	packedBody, err := boxer.open(reboxed.BodyCiphertext, &newKey)
+	require.NoError(t, err)
+	var body chat1.BodyPlaintext
+	require.NoError(t, boxer.unmarshal(packedBody, &body))
+	require.Equal(t, msg.MessageBody.Text().Body, body.V1().MessageBody.Text().Body)
+}
+
+func TestChatReBoxRefusesNonValidMessage(t *testing.T) {
+	tc, boxer := setupChatTest(t, "rebox")
+	defer tc.Cleanup()
+
+	signKP, err := libkb.GenerateNaclSigningKeyPair()
+	require.NoError(t, err)
+
+	errMsg := chat1.NewMessageUnboxedWithError(chat1.MessageUnboxedError{
+		ErrType:   chat1.MessageUnboxedErrorType_MISC,
+		MessageID: 9,
+	})
+
+	ctx := context.WithValue(context.Background(), kfKey, &panicKeyFinderMock{})
+	_, _, err = boxer.ReBox(ctx, errMsg, signKP)
+	require.Error(t, err, "ReBox should refuse an error-state message")
+}
+
+func TestChatVerifyClassifiesWrongKey(t *testing.T) {
+	tc, boxer := setupChatTest(t, "verify")
+	defer tc.Cleanup()
+
+	kpA, err := libkb.GenerateNaclSigningKeyPair()
+	require.NoError(t, err)
+	kpB, err := libkb.GenerateNaclSigningKeyPair()
+	require.NoError(t, err)
+
+	data := []byte("some header bytes")
+	sig, err := sign(data, kpA, libkb.SignaturePrefixChat)
+	require.NoError(t, err)
+
+	// Claim the signature came from kpB's key instead of kpA's.
+	sig.K = kpB.GetKID().ToBytes()
+
+	err = boxer.verify(data, sig, libkb.SignaturePrefixChat)
+	require.Error(t, err)
+
+	herr := NewHeaderSignatureError(err, sig.K)
+	require.Contains(t, herr.Error(), "wrong key, wrong prefix, or tampered data")
+
+	details := herr.ExportDetails()
+	require.NotNil(t, details)
+	require.Equal(t, chat1.UnboxingErrorCode_BADSIGNATURE, details.Code)
+	require.Equal(t, sig.K, details.RejectedKID)
+}
+
+func TestChatVerifyClassifiesWrongPrefix(t *testing.T) {
+	tc, boxer := setupChatTest(t, "verify")
+	defer tc.Cleanup()
+
+	kp, err := libkb.GenerateNaclSigningKeyPair()
+	require.NoError(t, err)
+
+	data := []byte("some header bytes")
+	sig, err := sign(data, kp, libkb.SignaturePrefixChat)
+	require.NoError(t, err)
+
+	// Verify against a different prefix than the one used to sign.
+	err = boxer.verify(data, sig, libkb.SignaturePrefixChatAttachment)
+	require.Error(t, err)
+
+	herr := NewHeaderSignatureError(err, sig.K)
+	require.Contains(t, herr.Error(), "wrong key, wrong prefix, or tampered data")
+}
+
+func TestChatVerifyClassifiesWrongVersion(t *testing.T) {
+	tc, boxer := setupChatTest(t, "verify")
+	defer tc.Cleanup()
+
+	kp, err := libkb.GenerateNaclSigningKeyPair()
+	require.NoError(t, err)
+
+	data := []byte("some header bytes")
+	sig, err := sign(data, kp, libkb.SignaturePrefixChat)
+	require.NoError(t, err)
+	sig.V = 99
+
+	err = boxer.verify(data, sig, libkb.SignaturePrefixChat)
+	require.Error(t, err)
+
+	herr := NewHeaderSignatureError(err, sig.K)
+	require.Contains(t, herr.Error(), "unhandled signature version")
+}
+
+// TestChatVerifyRejectsMalleatedSignature checks that boxer.verify rejects
+// a header signature whose S component has been re-encoded to a
+// mathematically equivalent but non-canonical form (S+order instead of
+// S), rather than accepting it as a second valid signature over the same
+// header. This is an additional, direct defense against Ed25519
+// malleability in public chats, where anyone holding the shared key could
+// otherwise twiddle a signature this way -- on top of (not instead of)
+// relying on body-hash uniqueness to notice a duplicate.
+func TestChatVerifyRejectsMalleatedSignature(t *testing.T) {
+	tc, boxer := setupChatTest(t, "verify")
+	defer tc.Cleanup()
+
+	kp, err := libkb.GenerateNaclSigningKeyPair()
+	require.NoError(t, err)
+
+	data := []byte("some header bytes")
+	sig, err := sign(data, kp, libkb.SignaturePrefixChat)
+	require.NoError(t, err)
+	require.NoError(t, boxer.verify(data, sig, libkb.SignaturePrefixChat),
+		"the original, canonical signature should verify")
+
+	// The little-endian encoding of the ed25519 base point's order --
+	// adding it to S produces a signature that's mathematically
+	// equivalent to the original but isn't in canonical form.
+	ed25519GroupOrder := [32]byte{
+		0xed, 0xd3, 0xf5, 0x5c, 0x1a, 0x63, 0x12, 0x58,
+		0xd6, 0x9c, 0xf7, 0xa2, 0xde, 0xf9, 0xde, 0x14,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x10,
+	}
+	var carry uint16
+	for i := 32; i < 64; i++ {
+		sum := uint16(sig.S[i]) + uint16(ed25519GroupOrder[i-32]) + carry
+		sig.S[i] = byte(sum)
+		carry = sum >> 8
+	}
+
+	err = boxer.verify(data, sig, libkb.SignaturePrefixChat)
+	require.Error(t, err, "the malleated (non-canonical) signature should be rejected")
+
+	herr := NewHeaderSignatureError(err, sig.K)
+	require.Contains(t, herr.Error(), "not in canonical (reduced) form")
+}
+
+// TestChatVersionErrorExportsSubCode checks that an unsupported header (or
+// body) version surfaces its version number through ExportDetails, not just
+// the coarse BADVERSION/BADVERSION_CRITICAL ExportType.
+func TestChatVersionErrorExportsSubCode(t *testing.T) {
+	verr := NewHeaderVersionError(chat1.HeaderPlaintextVersion_V7, chat1.HeaderPlaintextUnsupported{
+		Mi: chat1.HeaderPlaintextMetaInfo{Crit: true},
+	})
+
+	ierr := NewPermanentUnboxingError(verr)
+	require.Equal(t, chat1.MessageUnboxedErrorType_BADVERSION_CRITICAL, ierr.ExportType())
+
+	details := ierr.ExportDetails()
+	require.NotNil(t, details)
+	require.Equal(t, chat1.UnboxingErrorCode_UNSUPPORTEDVERSION, details.Code)
+	require.NotNil(t, details.UnsupportedVersion)
+	require.Equal(t, int(chat1.HeaderPlaintextVersion_V7), *details.UnsupportedVersion)
+}
+
+// TestChatSenderKeyNotFoundExportsSubCode checks that a missing- or
+// invalid-sender-key failure surfaces the rejected KID through
+// ExportDetails.
+func TestChatSenderKeyNotFoundExportsSubCode(t *testing.T) {
+	kp, err := libkb.GenerateNaclSigningKeyPair()
+	require.NoError(t, err)
+	kid := kp.GetKID().ToBytes()
+
+	ierr := NewPermanentUnboxingError(SenderKeyNotFoundError{
+		Reason: "key does not belong to sender",
+		KID:    kid,
+	})
+	require.Equal(t, chat1.MessageUnboxedErrorType_MISC, ierr.ExportType())
+
+	details := ierr.ExportDetails()
+	require.NotNil(t, details)
+	require.Equal(t, chat1.UnboxingErrorCode_KEYNOTFOUND, details.Code)
+	require.Equal(t, kid, details.RejectedKID)
+}
+
+// capturingTestLogBackend is a logger.TestLogBackend that records every
+// line logged via Logf (which is what logger.TestLogger's Debug/CDebugf
+// funnel through), so a test can assert on the content of a Debug log line
+// instead of just that something was logged.
+type capturingTestLogBackend struct {
+	t     testing.TB
+	mu    sync.Mutex
+	lines []string
+}
+
+func (c *capturingTestLogBackend) Error(args ...interface{}) { c.t.Log(args...) }
+func (c *capturingTestLogBackend) Errorf(format string, args ...interface{}) {
+	c.t.Logf(format, args...)
+}
+func (c *capturingTestLogBackend) Fatal(args ...interface{}) { c.t.Fatal(args...) }
+func (c *capturingTestLogBackend) Fatalf(format string, args ...interface{}) {
+	c.t.Fatalf(format, args...)
+}
+func (c *capturingTestLogBackend) Log(args ...interface{}) { c.t.Log(args...) }
+
+func (c *capturingTestLogBackend) Logf(format string, args ...interface{}) {
+	c.mu.Lock()
+	c.lines = append(c.lines, fmt.Sprintf(format, args...))
+	c.mu.Unlock()
+	c.t.Logf(format, args...)
+}
+
+func TestChatVerifyMessageLogsFailureContext(t *testing.T) {
+	tc, boxer := setupChatTest(t, "verify")
+	defer tc.Cleanup()
+
+	backend := &capturingTestLogBackend{t: t}
+	tc.G.Log = logger.NewTestLogger(backend)
+
+	key := cryptKey(t)
+	signKP, err := libkb.GenerateNaclSigningKeyPair()
+	require.NoError(t, err)
+
+	uid, err := libkb.RandBytes(16)
+	require.NoError(t, err)
+	uid[15] = keybase1.UID_SUFFIX_2
+	msg := textMsgWithSender(t, "hello", gregor1.UID(uid))
+
+	boxed, _, err := boxer.boxMessageWithKeys(msg, key, signKP)
+	require.NoError(t, err)
+	boxed.ServerHeader = &chat1.MessageServerHeader{MessageID: 42, Ctime: gregor1.ToTime(time.Now())}
+
+	// Tamper with the header's claimed body hash (re-encrypting it in
+	// place) so it no longer matches the real body ciphertext, without
+	// touching the body itself. The header signature is left stale, but
+	// that's fine: the body hash check runs, and fails, before the
+	// signature is ever checked.
+	packedHeader, err := boxer.open(boxed.HeaderCiphertext, key)
+	require.NoError(t, err)
+	var header chat1.HeaderPlaintext
+	require.NoError(t, boxer.unmarshal(packedHeader, &header))
+	h1 := header.V1()
+	h1.BodyHash = chat1.Hash("not the real hash!")
+	tamperedHeader := chat1.NewHeaderPlaintextWithV1(h1)
+	encryptedHeader, err := boxer.seal(tamperedHeader, key)
+	require.NoError(t, err)
+	boxed.HeaderCiphertext = *encryptedHeader
+
+	_, ierr := boxer.unboxMessageWithKey(context.Background(), *boxed, key, nil)
+	require.Error(t, ierr)
+	require.IsType(t, BodyHashInvalid{}, ierr.Inner())
+
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	var found string
+	for _, line := range backend.lines {
+		if strings.Contains(line, "verifyMessageHeader failed") {
+			found = line
+			break
+		}
+	}
+	require.NotEmpty(t, found, "expected a verifyMessageHeader failure to be logged")
+	require.Contains(t, found, "reason: body hash mismatch")
+	require.Contains(t, found, "msgID: 42")
+	require.Contains(t, found, fmt.Sprintf("keyGeneration: %d", key.KeyGeneration))
+	require.Contains(t, found, fmt.Sprintf("sender: %s", gregor1.UID(uid)))
+	require.NotContains(t, found, hex.EncodeToString(key.Key[:]), "key material must never be logged")
+}
+
+func TestChatBoxUnboxMessageWithKeysNoTlfInterface(t *testing.T) {
+	tc, boxer := setupChatTest(t, "box")
+	defer tc.Cleanup()
+
+	// setupChatTest gives us a Boxer with a nil tlf. BoxMessageWithKeys and
+	// UnboxMessageWithKeys take the crypt key directly, so they should work
+	// fine without one.
+	key := cryptKey(t)
+	signKP, err := libkb.GenerateNaclSigningKeyPair()
+	require.NoError(t, err)
+
+	msg := textMsg(t, "hi")
+	boxed, headerHash, err := boxer.BoxMessageWithKeys(msg, key, signKP)
+	require.NoError(t, err)
+	require.NotEmpty(t, headerHash)
+
+	boxed.ServerHeader = &chat1.MessageServerHeader{
+		MessageID: 1,
+		Ctime:     gregor1.ToTime(time.Now()),
+	}
+
+	res, ierr := boxer.UnboxMessageWithKeys(context.Background(), *boxed, key, nil)
+	require.NoError(t, ierr)
+	require.Equal(t, "hi", res.MessagePlaintext.MessageBody.Text().Body)
+	require.Equal(t, headerHash, res.HeaderHash)
+}
+
+// TestChatUnboxIncludeSignedHeaderBytes checks that SetIncludeSignedHeaderBytes
+// gates whether unboxing retains the exact bytes it signature-checked, and
+// that those bytes, when exported, verify against HeaderSignature the same
+// way unboxing itself verified them.
+func TestChatUnboxIncludeSignedHeaderBytes(t *testing.T) {
+	key := cryptKey(t)
+	tc, boxer := setupChatTest(t, "unbox-signed-header-bytes")
+	defer tc.Cleanup()
+
+	upak := newScriptedUpak(t)
+	tc.G.OverrideUPAKLoader(upak)
+	boxer.merkleRoot = func(ctx context.Context) (*chat1.MerkleRoot, error) {
+		return &chat1.MerkleRoot{Seqno: 1}, nil
+	}
+
+	rawUID, err := libkb.RandBytes(16)
+	require.NoError(t, err)
+	rawUID[15] = keybase1.UID_SUFFIX_2
+	sender := gregor1.UID(rawUID)
+
+	signKP, err := libkb.GenerateNaclSigningKeyPair()
+	require.NoError(t, err)
+	uid, err := keybase1.UIDFromString(hex.EncodeToString(sender.Bytes()))
+	require.NoError(t, err)
+	kid := keybase1.KIDFromSlice(signKP.GetKID().ToBytes())
+	upak.setScript(uid, kid, senderKeyCacheEntry{found: true})
+
+	msg := textMsgWithSender(t, "hi", sender)
+	boxed, _, err := boxer.boxMessageWithKeys(msg, key, signKP)
+	require.NoError(t, err)
+	boxed.ServerHeader = &chat1.MessageServerHeader{
+		Ctime: gregor1.ToTime(time.Now()),
+	}
+
+	// Off by default: unboxing still checks the signature, but doesn't
+	// retain the bytes it checked it against.
+	res, ierr := boxer.unboxMessageWithKey(context.TODO(), *boxed, key, nil)
+	require.Nil(t, ierr)
+	require.Empty(t, res.headerSignedBytes)
+
+	boxer.SetIncludeSignedHeaderBytes(true)
+	res, ierr = boxer.unboxMessageWithKey(context.TODO(), *boxed, key, nil)
+	require.Nil(t, ierr)
+	require.NotEmpty(t, res.headerSignedBytes)
+	require.NotNil(t, res.headerSignature)
+
+	// The exported bytes should verify against headerSignature using the
+	// same check unboxing itself relies on.
+	require.NoError(t, boxer.verify(res.headerSignedBytes, *res.headerSignature, libkb.SignaturePrefixChat))
+
+	// Tampering with the exported bytes should make verification fail,
+	// so a caller can tell a forged export from a real one.
+	tampered := append([]byte{}, res.headerSignedBytes...)
+	tampered[0] ^= 0xff
+	require.Error(t, boxer.verify(tampered, *res.headerSignature, libkb.SignaturePrefixChat))
+}
+
+func TestChatBoxMessageNoTlfInterfaceConfigured(t *testing.T) {
+	tc, boxer := setupChatTest(t, "box")
+	defer tc.Cleanup()
+
+	// boxer.tlf is nil (see setupChatTest); BoxMessage needs it to resolve
+	// a crypt key, so it should fail clearly instead of panicking.
+	signKP, err := libkb.GenerateNaclSigningKeyPair()
+	require.NoError(t, err)
+
+	header := chat1.MessageClientHeader{
+		Sender:    gregor1.UID(make([]byte, 16)),
+		TlfPublic: true,
+		TlfName:   "hi",
+	}
+	msg := textMsgWithHeader(t, "hi", header)
+
+	_, _, err = boxer.BoxMessage(context.Background(), msg, signKP, nil, BoxMessageOptions{})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), errNoTlfInterfaceConfigured)
+}
+
+func TestChatUnboxMessageNoTlfInterfaceConfigured(t *testing.T) {
+	tc, boxer := setupChatTest(t, "unbox")
+	defer tc.Cleanup()
+
+	// boxer.tlf is nil (see setupChatTest); UnboxMessage needs it to
+	// resolve a crypt key, so it should fail clearly instead of panicking.
+	boxed := chat1.MessageBoxed{
+		ServerHeader: &chat1.MessageServerHeader{
+			MessageID: 1,
+			Ctime:     gregor1.ToTime(time.Now()),
+		},
+	}
+
+	_, ierr := boxer.UnboxMessage(context.Background(), boxed, nil /* finalizeInfo */, nil /* asOf */, nil /* expectedOutboxIDs */)
+	require.Error(t, ierr)
+	require.True(t, ierr.IsPermanent())
+	require.Contains(t, ierr.Error(), errNoTlfInterfaceConfigured)
+}
+
+// randomDeviceID returns a random gregor1.DeviceID that decodes to a valid
+// keybase1.DeviceID (the hex-encoded suffix DeviceIDFromString requires).
+func randomDeviceID(t *testing.T) gregor1.DeviceID {
+	raw, err := libkb.RandBytes(keybase1.DeviceIDLen)
+	require.NoError(t, err)
+	raw[keybase1.DeviceIDLen-1] = 0x18
+	return gregor1.DeviceID(raw)
+}
+
+// TestChatVerifySenderDeviceKeyBinding checks that a message signed with
+// the key actually on file for the claimed sending device unboxes
+// cleanly.
+func TestChatVerifySenderDeviceKeyBinding(t *testing.T) {
+	key := cryptKey(t)
+	tc := externals.SetupTest(t, "verify-device-binding", 2)
+	defer tc.Cleanup()
+	boxer := NewBoxer(tc.G, func() keybase1.TlfInterface { return nil })
+	boxer.SetKeyFinder(&singleKeyFinderMock{key: *key})
+
+	upak := &upakLoaderWithSenderInfo{
+		scriptedUpak: newScriptedUpak(t),
+		username:     libkb.NewNormalizedUsername("mike"),
+		deviceName:   "mikes-phone",
+		deviceType:   "mobile",
+	}
+	tc.G.OverrideUPAKLoader(upak)
+	boxer.merkleRoot = func(ctx context.Context) (*chat1.MerkleRoot, error) {
+		return &chat1.MerkleRoot{Seqno: 1}, nil
+	}
+
+	rawUID, err := libkb.RandBytes(16)
+	require.NoError(t, err)
+	rawUID[15] = keybase1.UID_SUFFIX_2
+	sender := gregor1.UID(rawUID)
+	senderDevice := randomDeviceID(t)
+
+	signKP, err := libkb.GenerateNaclSigningKeyPair()
+	require.NoError(t, err)
+	uid, err := keybase1.UIDFromString(hex.EncodeToString(sender.Bytes()))
+	require.NoError(t, err)
+	kid := keybase1.KIDFromSlice(signKP.GetKID().ToBytes())
+	upak.setScript(uid, kid, senderKeyCacheEntry{found: true})
+
+	did, err := keybase1.DeviceIDFromString(hex.EncodeToString(senderDevice.Bytes()))
+	require.NoError(t, err)
+	upak.setDeviceScript(uid, did, keybase1.PublicKey{KID: kid, DeviceID: did})
+
+	header := chat1.MessageClientHeader{Sender: sender, SenderDevice: senderDevice}
+	msg := textMsgWithHeader(t, "hi from my phone", header)
+	boxed, _, err := boxer.boxMessageWithKeys(msg, key, signKP)
+	require.NoError(t, err)
+	boxed.ServerHeader = &chat1.MessageServerHeader{MessageID: 1, Ctime: gregor1.ToTime(time.Now())}
+
+	unboxed, ierr := boxer.UnboxMessage(context.Background(), *boxed, nil, nil, nil)
+	require.Nil(t, ierr)
+	require.True(t, unboxed.IsValid())
+}
+
+// TestChatVerifySenderDeviceKeyMismatch checks that a message whose
+// signing key belongs to the sender's account, but not to the claimed
+// sending device, is rejected with SenderKeyDeviceMismatchError rather
+// than unboxing as if it genuinely came from that device.
+func TestChatVerifySenderDeviceKeyMismatch(t *testing.T) {
+	key := cryptKey(t)
+	tc := externals.SetupTest(t, "verify-device-mismatch", 2)
+	defer tc.Cleanup()
+	boxer := NewBoxer(tc.G, func() keybase1.TlfInterface { return nil })
+	boxer.SetKeyFinder(&singleKeyFinderMock{key: *key})
+
+	upak := &upakLoaderWithSenderInfo{
+		scriptedUpak: newScriptedUpak(t),
+		username:     libkb.NewNormalizedUsername("mike"),
+		deviceName:   "mikes-phone",
+		deviceType:   "mobile",
+	}
+	tc.G.OverrideUPAKLoader(upak)
+	boxer.merkleRoot = func(ctx context.Context) (*chat1.MerkleRoot, error) {
+		return &chat1.MerkleRoot{Seqno: 1}, nil
+	}
+
+	rawUID, err := libkb.RandBytes(16)
+	require.NoError(t, err)
+	rawUID[15] = keybase1.UID_SUFFIX_2
+	sender := gregor1.UID(rawUID)
+	senderDevice := randomDeviceID(t)
+
+	// signKP is valid for sender's account as a whole (e.g. a key issued to
+	// one of their other devices), but not for senderDevice specifically.
+	signKP, err := libkb.GenerateNaclSigningKeyPair()
+	require.NoError(t, err)
+	otherKP, err := libkb.GenerateNaclSigningKeyPair()
+	require.NoError(t, err)
+	uid, err := keybase1.UIDFromString(hex.EncodeToString(sender.Bytes()))
+	require.NoError(t, err)
+	kid := keybase1.KIDFromSlice(signKP.GetKID().ToBytes())
+	upak.setScript(uid, kid, senderKeyCacheEntry{found: true})
+
+	did, err := keybase1.DeviceIDFromString(hex.EncodeToString(senderDevice.Bytes()))
+	require.NoError(t, err)
+	otherKID := keybase1.KIDFromSlice(otherKP.GetKID().ToBytes())
+	upak.setDeviceScript(uid, did, keybase1.PublicKey{KID: otherKID, DeviceID: did})
+
+	header := chat1.MessageClientHeader{Sender: sender, SenderDevice: senderDevice}
+	msg := textMsgWithHeader(t, "this isn't really from my phone", header)
+	boxed, _, err := boxer.boxMessageWithKeys(msg, key, signKP)
+	require.NoError(t, err)
+	boxed.ServerHeader = &chat1.MessageServerHeader{MessageID: 1, Ctime: gregor1.ToTime(time.Now())}
+
+	unboxed, ierr := boxer.UnboxMessage(context.Background(), *boxed, nil, nil, nil)
+	require.Nil(t, ierr)
+	require.False(t, unboxed.IsValid())
+	errState := unboxed.Error()
+	require.Equal(t, chat1.MessageUnboxedErrorType_SENDERDEVICEMISMATCH, errState.ErrType)
+	require.NotNil(t, errState.Details)
+	require.Equal(t, chat1.UnboxingErrorCode_DEVICEKEYMISMATCH, errState.Details.Code)
+	require.Equal(t, signKP.GetKID().ToBytes(), errState.Details.RejectedKID)
+}
+
+// scriptedUpak is a libkb.UPAKLoader whose CheckKIDForUID is scripted per
+// (uid, kid) and counts how many times each one is actually called, to
+// verify ValidSenderKey's sender-key cache serves repeats from the same
+// merkle era without re-calling CheckKIDForUID.
+type scriptedUpak struct {
+	t require.TestingT
+
+	mu            sync.Mutex
+	scripts       map[senderKeyCacheKey]senderKeyCacheEntry
+	calls         map[senderKeyCacheKey]int
+	deviceScripts map[string]keybase1.PublicKey
+}
+
+func newScriptedUpak(t require.TestingT) *scriptedUpak {
+	return &scriptedUpak{
+		t:             t,
+		scripts:       make(map[senderKeyCacheKey]senderKeyCacheEntry),
+		calls:         make(map[senderKeyCacheKey]int),
+		deviceScripts: make(map[string]keybase1.PublicKey),
+	}
+}
+
+func (f *scriptedUpak) setScript(uid keybase1.UID, kid keybase1.KID, entry senderKeyCacheEntry) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.scripts[senderKeyCacheKey{uid: uid.String(), kid: kid.String()}] = entry
+}
+
+// setDeviceScript scripts the device key that LoadDeviceKey returns for
+// (uid, did), for the sender-device binding tests.
+func (f *scriptedUpak) setDeviceScript(uid keybase1.UID, did keybase1.DeviceID, key keybase1.PublicKey) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deviceScripts[uid.String()+did.String()] = key
+}
+
+func (f *scriptedUpak) callCount(uid keybase1.UID, kid keybase1.KID) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[senderKeyCacheKey{uid: uid.String(), kid: kid.String()}]
+}
+
+func (f *scriptedUpak) ClearMemory() {
+	require.Fail(f.t, "ClearMemory call")
+}
+func (f *scriptedUpak) Load(arg libkb.LoadUserArg) (ret *keybase1.UserPlusAllKeys, user *libkb.User, err error) {
+	require.Fail(f.t, "Load call")
+	return nil, nil, nil
+}
+func (f *scriptedUpak) CheckKIDForUID(ctx context.Context, uid keybase1.UID, kid keybase1.KID) (found bool, revokedAt *keybase1.KeybaseTime, deleted bool, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := senderKeyCacheKey{uid: uid.String(), kid: kid.String()}
+	f.calls[key]++
+	entry := f.scripts[key]
+	return entry.found, entry.revokedAt, entry.deleted, nil
+}
+func (f *scriptedUpak) LoadUserPlusKeys(ctx context.Context, uid keybase1.UID, pollForKID keybase1.KID) (keybase1.UserPlusKeys, error) {
+	require.Fail(f.t, "LoadUserPlusKeys call")
+	return keybase1.UserPlusKeys{}, nil
+}
+func (f *scriptedUpak) Invalidate(ctx context.Context, uid keybase1.UID) {
+	require.Fail(f.t, "Invalidate call")
+}
+func (f *scriptedUpak) LoadDeviceKey(ctx context.Context, uid keybase1.UID, deviceID keybase1.DeviceID) (upk *keybase1.UserPlusAllKeys, deviceKey *keybase1.PublicKey, revoked *keybase1.RevokedKey, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key, ok := f.deviceScripts[uid.String()+deviceID.String()]
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("device not found for %s", deviceID)
+	}
+	return nil, &key, nil, nil
+}
+func (f *scriptedUpak) LookupUsername(ctx context.Context, uid keybase1.UID) (libkb.NormalizedUsername, error) {
+	require.Fail(f.t, "LookupUsername call")
+	return "", nil
+}
+func (f *scriptedUpak) LookupUsernameAndDevice(ctx context.Context, uid keybase1.UID, did keybase1.DeviceID) (username libkb.NormalizedUsername, deviceName string, deviceType string, err error) {
+	require.Fail(f.t, "LookupUsernameAndDevice call")
+	return "", "", "", nil
+}
+func (f *scriptedUpak) ListFollowedUIDs(uid keybase1.UID) ([]keybase1.UID, error) {
+	require.Fail(f.t, "ListFollowedUIDs call")
+	return nil, nil
+}
+func (f *scriptedUpak) PutUserToCache(user *libkb.User) error {
+	require.Fail(f.t, "PutUserToCache call")
+	return nil
+}
+
+// senderKeyCacheTestSender generates a fresh random (sender, key, uid, kid)
+// tuple for the sender-key cache tests below, so each test run starts with
+// its own namespace and can't collide with another test's cache entries.
+func senderKeyCacheTestSender(t *testing.T) (sender gregor1.UID, key []byte, uid keybase1.UID, kid keybase1.KID) {
+	rawUID, err := libkb.RandBytes(16)
+	require.NoError(t, err)
+	rawUID[15] = keybase1.UID_SUFFIX_2
+	sender = gregor1.UID(rawUID)
+
+	signKP, err := libkb.GenerateNaclSigningKeyPair()
+	require.NoError(t, err)
+	key = signKP.GetKID().ToBytes()
+
+	uid, err = keybase1.UIDFromString(hex.EncodeToString(sender.Bytes()))
+	require.NoError(t, err)
+	kid = keybase1.KIDFromSlice(key)
+	return sender, key, uid, kid
+}
+
+func TestChatValidSenderKeyCachesWithinMerkleEra(t *testing.T) {
+	tc, boxer := setupChatTest(t, "senderkeycache")
+	defer tc.Cleanup()
+
+	upak := newScriptedUpak(t)
+	tc.G.OverrideUPAKLoader(upak)
+
+	sender, key, uid, kid := senderKeyCacheTestSender(t)
+	upak.setScript(uid, kid, senderKeyCacheEntry{found: true})
+
+	boxer.merkleRoot = func(ctx context.Context) (*chat1.MerkleRoot, error) {
+		return &chat1.MerkleRoot{Seqno: 5}, nil
+	}
+
+	ctime := gregor1.ToTime(time.Now())
+	for i := 0; i < 5; i++ {
+		found, validAtCtime, revoked, ierr := boxer.ValidSenderKey(context.Background(), sender, key, ctime)
+		require.Nil(t, ierr)
+		require.True(t, found)
+		require.True(t, validAtCtime)
+		require.Nil(t, revoked)
+	}
+
+	require.Equal(t, 1, upak.callCount(uid, kid),
+		"repeated lookups for the same sender within one merkle era should hit the cache after the first")
+}
+
+func TestChatValidSenderKeyInvalidatesAcrossMerkleEraBoundary(t *testing.T) {
+	tc, boxer := setupChatTest(t, "senderkeycache")
+	defer tc.Cleanup()
+
+	upak := newScriptedUpak(t)
+	tc.G.OverrideUPAKLoader(upak)
+
+	sender, key, uid, kid := senderKeyCacheTestSender(t)
+
+	before := time.Now().Add(-time.Hour)
+	after := time.Now().Add(time.Hour)
+
+	// Era 1: the key isn't revoked yet.
+	upak.setScript(uid, kid, senderKeyCacheEntry{found: true})
+	boxer.merkleRoot = func(ctx context.Context) (*chat1.MerkleRoot, error) {
+		return &chat1.MerkleRoot{Seqno: 1}, nil
+	}
+
+	found, validAtCtime, revoked, ierr := boxer.ValidSenderKey(context.Background(), sender, key, gregor1.ToTime(before))
+	require.Nil(t, ierr)
+	require.True(t, found)
+	require.True(t, validAtCtime)
+	require.Nil(t, revoked)
+	require.Equal(t, 1, upak.callCount(uid, kid))
+
+	// The key gets revoked, and the merkle root advances to an era where
+	// that revocation is visible.
+	revokedAt := keybase1.KeybaseTime{Unix: keybase1.ToTime(time.Now())}
+	upak.setScript(uid, kid, senderKeyCacheEntry{found: true, revokedAt: &revokedAt})
+	boxer.merkleRoot = func(ctx context.Context) (*chat1.MerkleRoot, error) {
+		return &chat1.MerkleRoot{Seqno: 2}, nil
+	}
+
+	found, validAtCtime, revoked, ierr = boxer.ValidSenderKey(context.Background(), sender, key, gregor1.ToTime(after))
+	require.Nil(t, ierr)
+	require.True(t, found)
+	require.False(t, validAtCtime, "key should no longer be valid at ctime once the cache has moved to the era where it's revoked")
+	require.NotNil(t, revoked)
+	require.Equal(t, 2, upak.callCount(uid, kid), "crossing the era boundary should force a fresh CheckKIDForUID call")
+
+	// Still within era 2: the revoked answer should come from the cache,
+	// not another CheckKIDForUID call.
+	found, validAtCtime, revoked, ierr = boxer.ValidSenderKey(context.Background(), sender, key, gregor1.ToTime(after))
+	require.Nil(t, ierr)
+	require.True(t, found)
+	require.False(t, validAtCtime)
+	require.NotNil(t, revoked)
+	require.Equal(t, 2, upak.callCount(uid, kid), "repeated lookups within the same era should reuse the cached result")
+}
+
+// recheckRevocationTestValid builds a minimal MessageUnboxedValid carrying
+// just the fields RecheckRevocation reads: the sender, the signing key it
+// was cached as verified against, and the ctime it was valid at.
+func recheckRevocationTestValid(sender gregor1.UID, key []byte, ctime gregor1.Time) chat1.MessageUnboxedValid {
+	return chat1.MessageUnboxedValid{
+		ClientHeader:    chat1.MessageClientHeader{Sender: sender},
+		ServerHeader:    chat1.MessageServerHeader{Ctime: ctime},
+		HeaderSignature: &chat1.SignatureInfo{K: key},
+	}
+}
+
+// TestChatRecheckRevocationStillValid checks that RecheckRevocation reports
+// no revocation for a cached message whose sender key hasn't been revoked
+// since it was unboxed.
+func TestChatRecheckRevocationStillValid(t *testing.T) {
+	tc, boxer := setupChatTest(t, "recheckrevocation")
+	defer tc.Cleanup()
+
+	upak := newScriptedUpak(t)
+	tc.G.OverrideUPAKLoader(upak)
+	boxer.merkleRoot = func(ctx context.Context) (*chat1.MerkleRoot, error) {
+		return &chat1.MerkleRoot{Seqno: 1}, nil
+	}
+
+	sender, key, uid, kid := senderKeyCacheTestSender(t)
+	upak.setScript(uid, kid, senderKeyCacheEntry{found: true})
+
+	valid := recheckRevocationTestValid(sender, key, gregor1.ToTime(time.Now()))
+	revoked, ierr := boxer.RecheckRevocation(context.Background(), valid)
+	require.Nil(t, ierr)
+	require.Nil(t, revoked, "key hasn't been revoked, so RecheckRevocation should report no revocation")
+}
+
+// TestChatRecheckRevocationCatchesRevocationSinceCaching checks that
+// RecheckRevocation notices a sender key that was revoked after a message
+// was cached as valid -- the exact gap ValidSenderKey's unbox-time check
+// can't see, since it only runs once, at unbox time.
+func TestChatRecheckRevocationCatchesRevocationSinceCaching(t *testing.T) {
+	tc, boxer := setupChatTest(t, "recheckrevocation")
+	defer tc.Cleanup()
+
+	upak := newScriptedUpak(t)
+	tc.G.OverrideUPAKLoader(upak)
+
+	sender, key, uid, kid := senderKeyCacheTestSender(t)
+	ctime := time.Now().Add(-time.Hour)
+
+	// At unbox time, the key was still valid.
+	boxer.merkleRoot = func(ctx context.Context) (*chat1.MerkleRoot, error) {
+		return &chat1.MerkleRoot{Seqno: 1}, nil
+	}
+	upak.setScript(uid, kid, senderKeyCacheEntry{found: true})
+	valid := recheckRevocationTestValid(sender, key, gregor1.ToTime(ctime))
+	revoked, ierr := boxer.RecheckRevocation(context.Background(), valid)
+	require.Nil(t, ierr)
+	require.Nil(t, revoked)
+
+	// The key is revoked after caching (and after ctime, so the message was
+	// legitimately signed before the revocation happened -- the "signed by
+	// a since-revoked device" case this API exists for), and the merkle
+	// root advances to an era where that revocation is visible.
+	revokedAt := keybase1.KeybaseTime{Unix: keybase1.ToTime(time.Now())}
+	upak.setScript(uid, kid, senderKeyCacheEntry{found: true, revokedAt: &revokedAt})
+	boxer.merkleRoot = func(ctx context.Context) (*chat1.MerkleRoot, error) {
+		return &chat1.MerkleRoot{Seqno: 2}, nil
+	}
+
+	revoked, ierr = boxer.RecheckRevocation(context.Background(), valid)
+	require.Nil(t, ierr, "the key was still valid at ctime, so a later revocation shouldn't invalidate the message")
+	require.NotNil(t, revoked, "RecheckRevocation should now report the revocation that happened since caching")
+}
+
+// TestChatRecheckRevocationRejectsMissingHeaderSignature checks that
+// RecheckRevocation refuses to guess at a message with no cached
+// HeaderSignature, rather than silently treating it as unrevoked.
+func TestChatRecheckRevocationRejectsMissingHeaderSignature(t *testing.T) {
+	tc, boxer := setupChatTest(t, "recheckrevocation")
+	defer tc.Cleanup()
+
+	valid := chat1.MessageUnboxedValid{
+		ClientHeader: chat1.MessageClientHeader{Sender: gregor1.UID("u")},
+		ServerHeader: chat1.MessageServerHeader{Ctime: gregor1.ToTime(time.Now())},
+	}
+	revoked, ierr := boxer.RecheckRevocation(context.Background(), valid)
+	require.NotNil(t, ierr)
+	require.Nil(t, revoked)
+}
+
+// TestChatUnboxMessagesCachesSenderKeyAcrossBatch checks that UnboxMessages
+// installs a call-scoped sender-key cache (see ctxCallSenderKeyCache) that
+// memoizes CheckKIDForUID per (UID, KID) for the duration of the batch,
+// even when the merkle-era cache (senderKeyCache) can't help at all -- the
+// case a public channel with many senders and no successful merkle root
+// fetch would otherwise pay a full UPAK round trip per message for.
+func TestChatUnboxMessagesCachesSenderKeyAcrossBatch(t *testing.T) {
+	key := cryptKey(t)
+	boxer, cleanup, sender, signKP, ctx := setupConcurrentUnboxTest(t, key)
+	defer cleanup()
+
+	boxer.merkleRoot = func(ctx context.Context) (*chat1.MerkleRoot, error) {
+		return nil, fmt.Errorf("no merkle root in this test")
+	}
+
+	const n = 20
+	boxedMsgs := boxTestMessages(t, boxer, key, sender, signKP, n)
+
+	unboxed, err := boxer.UnboxMessages(ctx, boxedMsgs, nil, nil, UnboxMessagesOptions{})
+	require.NoError(t, err)
+	require.Len(t, unboxed, n)
+	for _, msg := range unboxed {
+		state, err := msg.State()
+		require.NoError(t, err)
+		require.Equal(t, chat1.MessageUnboxedState_VALID, state)
+	}
+
+	upak, ok := boxer.G().GetUPAKLoader().(*upakLoaderWithSenderInfo)
+	require.True(t, ok)
+	uid, err := keybase1.UIDFromString(hex.EncodeToString(sender.Bytes()))
+	require.NoError(t, err)
+	kid := keybase1.KIDFromSlice(signKP.GetKID().ToBytes())
+	require.Equal(t, 1, upak.callCount(uid, kid),
+		"CheckKIDForUID should be called once for the whole batch even with no merkle root to key the era cache off of")
+}
+
+// BenchmarkChatValidSenderKeyCached measures ValidSenderKey when the
+// underlying CheckKIDForUID lookup is always served from the sender-key
+// cache (a fixed merkle seqno), which is the common case for a thread with
+// many messages from the same few senders.
+func BenchmarkChatValidSenderKeyCached(b *testing.B) {
+	tc := externals.SetupTest(b, "senderkeycache", 2)
+	defer tc.Cleanup()
+	boxer := NewBoxer(tc.G, nil)
+
+	upak := newScriptedUpak(nil)
+	tc.G.OverrideUPAKLoader(upak)
+
+	rawUID := make([]byte, 16)
+	rawUID[15] = keybase1.UID_SUFFIX_2
+	sender := gregor1.UID(rawUID)
+	signKP, err := libkb.GenerateNaclSigningKeyPair()
+	if err != nil {
+		b.Fatal(err)
+	}
+	key := signKP.GetKID().ToBytes()
+	uid, err := keybase1.UIDFromString(hex.EncodeToString(sender.Bytes()))
+	if err != nil {
+		b.Fatal(err)
+	}
+	kid := keybase1.KIDFromSlice(key)
+	upak.setScript(uid, kid, senderKeyCacheEntry{found: true})
+
+	boxer.merkleRoot = func(ctx context.Context) (*chat1.MerkleRoot, error) {
+		return &chat1.MerkleRoot{Seqno: 1}, nil
+	}
+
+	ctime := gregor1.ToTime(time.Now())
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, _, ierr := boxer.ValidSenderKey(context.Background(), sender, key, ctime); ierr != nil {
+			b.Fatal(ierr)
+		}
+	}
+}
+
+func TestChatOpenThreadsKeyGenerationThroughFailure(t *testing.T) {
+	tc, boxer := setupChatTest(t, "open")
+	defer tc.Cleanup()
+
+	key := cryptKey(t)
+	key.KeyGeneration = 5
+	enc, err := boxer.SealForKey("hello", key)
+	require.NoError(t, err)
+
+	// Wrong key: a different generation's key can't open this ciphertext.
+	wrongKey := cryptKey(t)
+	wrongKey.KeyGeneration = 6
+	_, err = boxer.OpenWithKey(*enc, wrongKey)
+	require.Error(t, err)
+	wrongKeyErr, ok := err.(DecryptOpenError)
+	require.True(t, ok, "expected a DecryptOpenError, got %T", err)
+	require.Equal(t, 6, wrongKeyErr.Generation)
+
+	// Right key, but a flipped bit: the ciphertext itself is corrupted.
+	corrupted := *enc
+	corrupted.E = append([]byte{}, enc.E...)
+	corrupted.E[0] ^= 0xff
+	_, err = boxer.OpenWithKey(corrupted, key)
+	require.Error(t, err)
+	corruptedErr, ok := err.(DecryptOpenError)
+	require.True(t, ok, "expected a DecryptOpenError, got %T", err)
+	require.Equal(t, 5, corruptedErr.Generation)
+
+	// open can't tell these two failures apart on its own -- a secretbox
+	// MAC failure looks the same either way. What it gives the caller is
+	// the generation it actually tried, which is what lets a multi-key
+	// fallback caller recognize "this failure was for the generation I
+	// meant to use" (corruption) from "this failure was for some other
+	// generation" (wrong key, try the next one).
+	require.NotEqual(t, wrongKeyErr.Generation, corruptedErr.Generation)
+}
+
+func TestOutboxIDMismatch(t *testing.T) {
+	a := chat1.OutboxID("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	b := chat1.OutboxID("bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb")
+
+	require.False(t, outboxIDMismatch(nil, []chat1.OutboxID{a, b}), "no header OutboxID is never a mismatch")
+	require.False(t, outboxIDMismatch(&a, nil), "an empty expected set is never a mismatch")
+	require.False(t, outboxIDMismatch(&a, []chat1.OutboxID{a, b}), "header OutboxID is in the expected set")
+	require.True(t, outboxIDMismatch(&a, []chat1.OutboxID{b}), "header OutboxID is not in the expected set")
+}
+
+func TestDeriveAssuranceLevel(t *testing.T) {
+	revokedAt := gregor1.ToTime(time.Now())
+
+	cases := []struct {
+		name string
+		in   assuranceInputs
+		want chat1.AssuranceLevel
+	}{
+		{
+			name: "everything checks out",
+			in:   assuranceInputs{hasMerkleRoot: true},
+			want: chat1.AssuranceLevel_FULL,
+		},
+		{
+			name: "no merkle root",
+			in:   assuranceInputs{hasMerkleRoot: false},
+			want: chat1.AssuranceLevel_REDUCED,
+		},
+		{
+			name: "outbox ID mismatch",
+			in:   assuranceInputs{hasMerkleRoot: true, outboxIDMismatch: true},
+			want: chat1.AssuranceLevel_REDUCED,
+		},
+		{
+			name: "no merkle root and outbox ID mismatch, still just reduced",
+			in:   assuranceInputs{hasMerkleRoot: false, outboxIDMismatch: true},
+			want: chat1.AssuranceLevel_REDUCED,
+		},
+		{
+			name: "sender device revoked",
+			in:   assuranceInputs{hasMerkleRoot: true, senderDeviceRevokedAt: &revokedAt},
+			want: chat1.AssuranceLevel_SUSPECT,
+		},
+		{
+			name: "sender unresolved",
+			in:   assuranceInputs{hasMerkleRoot: true, senderUnresolved: true},
+			want: chat1.AssuranceLevel_SUSPECT,
+		},
+		{
+			name: "revoked sender wins over a merkle/outbox problem too",
+			in: assuranceInputs{
+				hasMerkleRoot:         false,
+				outboxIDMismatch:      true,
+				senderDeviceRevokedAt: &revokedAt,
+			},
+			want: chat1.AssuranceLevel_SUSPECT,
+		},
+		{
+			name: "unresolved sender wins over a merkle/outbox problem too",
+			in: assuranceInputs{
+				hasMerkleRoot:    false,
+				outboxIDMismatch: true,
+				senderUnresolved: true,
+			},
+			want: chat1.AssuranceLevel_SUSPECT,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			require.Equal(t, c.want, deriveAssuranceLevel(c.in))
+		})
+	}
+}
+
+// TestGetSenderInfoLocalRejectsMalformedHeader checks that
+// getSenderInfoLocal validates the sender UID and device ID before ever
+// reaching the UPAK loader, so a malformed or truncated header produces a
+// clear error instead of an opaque lookup failure. scriptedUpak fails the
+// test outright if LookupUsernameAndDevice is called, which proves
+// validation short-circuits the lookup for bad input.
+func TestGetSenderInfoLocalRejectsMalformedHeader(t *testing.T) {
+	tc, boxer := setupChatTest(t, "sender-info-validation")
+	defer tc.Cleanup()
+
+	upak := newScriptedUpak(t)
+	tc.G.OverrideUPAKLoader(upak)
+
+	validUID, err := libkb.RandBytes(keybase1.UID_LEN)
+	require.NoError(t, err)
+	validUID[keybase1.UID_LEN-1] = keybase1.UID_SUFFIX_2
+	validDeviceID, err := libkb.RandBytes(keybase1.DeviceIDLen)
+	require.NoError(t, err)
+	validDeviceID[keybase1.DeviceIDLen-1] = keybase1.DeviceIDSuffix
+
+	badLengthUID, err := libkb.RandBytes(keybase1.UID_LEN - 1)
+	require.NoError(t, err)
+	badLengthDeviceID, err := libkb.RandBytes(keybase1.DeviceIDLen - 1)
+	require.NoError(t, err)
+
+	cases := []struct {
+		name   string
+		sender gregor1.UID
+		device gregor1.DeviceID
+	}{
+		{name: "bad-length UID", sender: gregor1.UID(badLengthUID), device: gregor1.DeviceID(validDeviceID)},
+		{name: "bad-length device ID", sender: gregor1.UID(validUID), device: gregor1.DeviceID(badLengthDeviceID)},
+		{name: "both bad-length", sender: gregor1.UID(badLengthUID), device: gregor1.DeviceID(badLengthDeviceID)},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			header := chat1.MessageClientHeader{
+				Sender:       c.sender,
+				SenderDevice: c.device,
+			}
+			_, _, _, err := boxer.getSenderInfoLocal(context.TODO(), header)
+			require.Error(t, err)
+		})
+	}
+}
+
+// TestChatCurrentlyActiveSenderKey contrasts CurrentlyActiveSenderKey
+// against ValidSenderKey for the three key states
+// SetRequireCurrentlyActiveSenderKey is meant to distinguish: a key that's
+// still active today, one that was valid when a message was sent but has
+// since been revoked, and one that was already revoked before the message
+// was even sent.
+func TestChatCurrentlyActiveSenderKey(t *testing.T) {
+	tc, boxer := setupChatTest(t, "currently-active-senderkey")
+	defer tc.Cleanup()
+
+	upak := newScriptedUpak(t)
+	tc.G.OverrideUPAKLoader(upak)
+
+	boxer.merkleRoot = func(ctx context.Context) (*chat1.MerkleRoot, error) {
+		return &chat1.MerkleRoot{Seqno: 1}, nil
+	}
+
+	ctime := gregor1.ToTime(time.Now())
+	before := keybase1.KeybaseTime{Unix: keybase1.ToTime(time.Now().Add(-time.Hour))}
+	after := keybase1.KeybaseTime{Unix: keybase1.ToTime(time.Now().Add(time.Hour))}
+
+	cases := []struct {
+		name             string
+		entry            senderKeyCacheEntry
+		wantValidAtCtime bool
+		wantActive       bool
+	}{
+		{
+			name:             "currently active",
+			entry:            senderKeyCacheEntry{found: true},
+			wantValidAtCtime: true,
+			wantActive:       true,
+		},
+		{
+			name:             "revoked after ctime",
+			entry:            senderKeyCacheEntry{found: true, revokedAt: &after},
+			wantValidAtCtime: true,
+			wantActive:       false,
+		},
+		{
+			name:             "revoked before ctime",
+			entry:            senderKeyCacheEntry{found: true, revokedAt: &before},
+			wantValidAtCtime: false,
+			wantActive:       false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			sender, key, uid, kid := senderKeyCacheTestSender(t)
+			upak.setScript(uid, kid, c.entry)
+
+			found, validAtCtime, _, ierr := boxer.ValidSenderKey(context.Background(), sender, key, ctime)
+			require.Nil(t, ierr)
+			require.True(t, found)
+			require.Equal(t, c.wantValidAtCtime, validAtCtime)
+
+			found, active, ierr := boxer.CurrentlyActiveSenderKey(context.Background(), sender, key)
+			require.Nil(t, ierr)
+			require.True(t, found)
+			require.Equal(t, c.wantActive, active)
+		})
+	}
+}
+
+// TestChatRequireCurrentlyActiveSenderKeyRejectsRevokedKey checks that
+// SetRequireCurrentlyActiveSenderKey makes unboxing reject a message whose
+// signing key was valid when sent but has since been revoked -- a message
+// that unboxes fine under the default (ValidSenderKey) check -- and that
+// it does so with a distinct, identifiable error type rather than the
+// generic invalid-key error.
+func TestChatRequireCurrentlyActiveSenderKeyRejectsRevokedKey(t *testing.T) {
+	key := cryptKey(t)
+	text := "hi"
+	tc, boxer := setupChatTest(t, "currently-active-senderkey-strict")
+	defer tc.Cleanup()
+
+	upak := newScriptedUpak(t)
+	tc.G.OverrideUPAKLoader(upak)
+	boxer.merkleRoot = func(ctx context.Context) (*chat1.MerkleRoot, error) {
+		return &chat1.MerkleRoot{Seqno: 1}, nil
+	}
+
+	rawUID, err := libkb.RandBytes(16)
+	require.NoError(t, err)
+	rawUID[15] = keybase1.UID_SUFFIX_2
+	sender := gregor1.UID(rawUID)
+
+	signKP, err := libkb.GenerateNaclSigningKeyPair()
+	require.NoError(t, err)
+	uid, err := keybase1.UIDFromString(hex.EncodeToString(sender.Bytes()))
+	require.NoError(t, err)
+	kid := keybase1.KIDFromSlice(signKP.GetKID().ToBytes())
+
+	msg := textMsgWithSender(t, text, sender)
+	boxed, _, err := boxer.boxMessageWithKeys(msg, key, signKP)
+	require.NoError(t, err)
+	boxed.ServerHeader = &chat1.MessageServerHeader{
+		Ctime: gregor1.ToTime(time.Now()),
+	}
+
+	// Key was valid when the message was sent, but has since been revoked.
+	revokedAt := keybase1.KeybaseTime{Unix: keybase1.ToTime(time.Now().Add(time.Hour))}
+	upak.setScript(uid, kid, senderKeyCacheEntry{found: true, revokedAt: &revokedAt})
+
+	// Without the stricter option, the message unboxes fine.
+	_, ierr := boxer.unboxMessageWithKey(context.TODO(), *boxed, key, nil)
+	require.Nil(t, ierr)
+
+	// With the stricter option enabled, the same message is rejected, with
+	// its own distinct error type.
+	boxer.SetRequireCurrentlyActiveSenderKey(true)
+	_, ierr = boxer.unboxMessageWithKey(context.TODO(), *boxed, key, nil)
+	require.NotNil(t, ierr)
+	require.True(t, ierr.IsPermanent())
+	require.IsType(t, SenderKeyNotCurrentlyActiveError{}, ierr.Inner())
+	require.Equal(t, chat1.MessageUnboxedErrorType_SENDERKEYNOTACTIVE, ierr.ExportType())
+}
+
+// TestChatUnboxHeaderOnlyNeverDecryptsBody checks that UnboxHeaderOnly
+// recovers the header metadata for a properly boxed message without ever
+// touching its body: it corrupts BodyCiphertext.E after boxing (which
+// would make a body decrypt fail with a nacl open error) and confirms
+// unboxHeaderOnlyWithKey still succeeds.
+func TestChatUnboxHeaderOnlyNeverDecryptsBody(t *testing.T) {
+	key := cryptKey(t)
+	text := "hi"
+	tc, boxer := setupChatTest(t, "unbox-header-only")
+	defer tc.Cleanup()
+
+	upak := newScriptedUpak(t)
+	tc.G.OverrideUPAKLoader(upak)
+	boxer.merkleRoot = func(ctx context.Context) (*chat1.MerkleRoot, error) {
+		return &chat1.MerkleRoot{Seqno: 1}, nil
+	}
+
+	rawUID, err := libkb.RandBytes(16)
+	require.NoError(t, err)
+	rawUID[15] = keybase1.UID_SUFFIX_2
+	sender := gregor1.UID(rawUID)
+
+	signKP, err := libkb.GenerateNaclSigningKeyPair()
+	require.NoError(t, err)
+	uid, err := keybase1.UIDFromString(hex.EncodeToString(sender.Bytes()))
+	require.NoError(t, err)
+	kid := keybase1.KIDFromSlice(signKP.GetKID().ToBytes())
+	upak.setScript(uid, kid, senderKeyCacheEntry{found: true})
+
+	msg := textMsgWithSender(t, text, sender)
+	boxed, _, err := boxer.boxMessageWithKeys(msg, key, signKP)
+	require.NoError(t, err)
+	boxed.ServerHeader = &chat1.MessageServerHeader{
+		Ctime: gregor1.ToTime(time.Now()),
+	}
+
+	// Corrupt the body ciphertext. If unboxHeaderOnlyWithKey ever tried to
+	// decrypt it, this would fail with a nacl open error.
+	boxed.BodyCiphertext.E = append([]byte{}, boxed.BodyCiphertext.E...)
+	boxed.BodyCiphertext.E[0] ^= 0xff
+
+	res, ierr := boxer.unboxHeaderOnlyWithKey(context.TODO(), *boxed, key, nil)
+	require.Nil(t, ierr)
+	require.Equal(t, sender, res.ClientHeader.Sender)
+	require.Nil(t, res.SenderDeviceRevokedAt)
+}
+
+// TestChatBoxUnboxChunkedBodyRoundTrip boxes a body large enough to cross
+// bodyChunkThreshold and checks that boxMessageWithKeys sealed it as
+// bodyCiphertextChunks (not a single bodyCiphertext blob), and that
+// unboxMessageWithKey reassembles and verifies the chunks back into the
+// original text.
+func TestChatBoxUnboxChunkedBodyRoundTrip(t *testing.T) {
+	key := cryptKey(t)
+	text := strings.Repeat("a", bodyChunkThreshold+1024)
+	tc, boxer := setupChatTest(t, "unbox-chunked-body")
+	defer tc.Cleanup()
+
+	upak := newScriptedUpak(t)
+	tc.G.OverrideUPAKLoader(upak)
+	boxer.merkleRoot = func(ctx context.Context) (*chat1.MerkleRoot, error) {
+		return &chat1.MerkleRoot{Seqno: 1}, nil
+	}
+
+	rawUID, err := libkb.RandBytes(16)
+	require.NoError(t, err)
+	rawUID[15] = keybase1.UID_SUFFIX_2
+	sender := gregor1.UID(rawUID)
+
+	signKP, err := libkb.GenerateNaclSigningKeyPair()
+	require.NoError(t, err)
+	uid, err := keybase1.UIDFromString(hex.EncodeToString(sender.Bytes()))
+	require.NoError(t, err)
+	kid := keybase1.KIDFromSlice(signKP.GetKID().ToBytes())
+	upak.setScript(uid, kid, senderKeyCacheEntry{found: true})
+
+	msg := textMsgWithSender(t, text, sender)
+	boxed, _, err := boxer.boxMessageWithKeys(msg, key, signKP)
+	require.NoError(t, err)
+	require.Empty(t, boxed.BodyCiphertext.E)
+	require.True(t, len(boxed.BodyCiphertextChunks) > 1, "expected more than one chunk for a body past bodyChunkThreshold")
+
+	boxed.ServerHeader = &chat1.MessageServerHeader{
+		Ctime: gregor1.ToTime(time.Now()),
+	}
+
+	res, ierr := boxer.unboxMessageWithKey(context.TODO(), *boxed, key, nil)
+	require.Nil(t, ierr)
+	require.Equal(t, text, res.messagePlaintext.MessageBody.Text().Body)
+}
+
+// TestChatUnboxChunkedBodyDetectsTamperedChunkHash checks that a chunk
+// whose claimed header hash doesn't match its own ciphertext is rejected
+// before any chunk is decrypted or reassembled.
+func TestChatUnboxChunkedBodyDetectsTamperedChunkHash(t *testing.T) {
+	key := cryptKey(t)
+	text := strings.Repeat("b", bodyChunkThreshold+1024)
+	tc, boxer := setupChatTest(t, "unbox-chunked-body-tampered")
+	defer tc.Cleanup()
+
+	upak := newScriptedUpak(t)
+	tc.G.OverrideUPAKLoader(upak)
+	boxer.merkleRoot = func(ctx context.Context) (*chat1.MerkleRoot, error) {
+		return &chat1.MerkleRoot{Seqno: 1}, nil
+	}
+
+	rawUID, err := libkb.RandBytes(16)
+	require.NoError(t, err)
+	rawUID[15] = keybase1.UID_SUFFIX_2
+	sender := gregor1.UID(rawUID)
+
+	signKP, err := libkb.GenerateNaclSigningKeyPair()
+	require.NoError(t, err)
+	uid, err := keybase1.UIDFromString(hex.EncodeToString(sender.Bytes()))
+	require.NoError(t, err)
+	kid := keybase1.KIDFromSlice(signKP.GetKID().ToBytes())
+	upak.setScript(uid, kid, senderKeyCacheEntry{found: true})
+
+	msg := textMsgWithSender(t, text, sender)
+	boxed, _, err := boxer.boxMessageWithKeys(msg, key, signKP)
+	require.NoError(t, err)
+	require.True(t, len(boxed.BodyCiphertextChunks) > 1)
+
+	boxed.ServerHeader = &chat1.MessageServerHeader{
+		Ctime: gregor1.ToTime(time.Now()),
+	}
+	boxed.BodyCiphertextChunks[0].Header.Hash[0] ^= 0xff
+
+	_, ierr := boxer.unboxMessageWithKey(context.TODO(), *boxed, key, nil)
+	require.NotNil(t, ierr)
+}
+
+// TestChatVerifyBodyCiphertextChunksRejectsReordering checks that
+// verifyBodyCiphertextChunks notices when chunks have been reordered, even
+// though each individual chunk's own claimed hash still matches its
+// ciphertext.
+func TestChatVerifyBodyCiphertextChunksRejectsReordering(t *testing.T) {
+	key := cryptKey(t)
+	tc, boxer := setupChatTest(t, "verify-chunks-reordered")
+	defer tc.Cleanup()
+
+	chunks, _, err := boxer.sealBodyChunked(
+		chat1.NewMessageBodyWithText(chat1.MessageText{Body: strings.Repeat("c", bodyChunkSize*2+1)}),
+		key)
+	require.NoError(t, err)
+	require.True(t, len(chunks) > 1)
+
+	chunks[0], chunks[1] = chunks[1], chunks[0]
+
+	_, err = boxer.verifyBodyCiphertextChunks(chunks)
+	require.Error(t, err)
+}
+
+// TestChatCheckMerkleRootFreshnessAcceptsNearbyRoot checks that
+// SetCheckMerkleRootFreshness lets through a message whose claimed merkle
+// root is at (or near) the latest root this client has cached.
+func TestChatCheckMerkleRootFreshnessAcceptsNearbyRoot(t *testing.T) {
+	key := cryptKey(t)
+	boxer, cleanup, sender, signKP, ctx := setupConcurrentUnboxTest(t, key)
+	defer cleanup()
+	boxer.SetCheckMerkleRootFreshness(true)
+
+	header := chat1.MessageClientHeader{
+		Sender:     sender,
+		MerkleRoot: &chat1.MerkleRoot{Seqno: 1},
+	}
+	msg := textMsgWithHeader(t, "hi", header)
+	boxed, _, err := boxer.boxMessageWithKeys(msg, key, signKP)
+	require.NoError(t, err)
+	boxed.ServerHeader = &chat1.MessageServerHeader{MessageID: 1, Ctime: gregor1.ToTime(time.Now())}
+
+	res, ierr := boxer.UnboxMessage(ctx, *boxed, nil, nil, nil)
+	require.Nil(t, ierr)
+	require.Equal(t, "hi", res.Valid().MessageBody.Text().Body)
+}
+
+// TestChatCheckMerkleRootFreshnessRejectsFutureRoot checks that
+// SetCheckMerkleRootFreshness rejects a message whose claimed merkle root
+// is far beyond the latest root this client has cached, with a
+// MerkleRootFreshnessError carrying both seqnos for the caller to surface.
+func TestChatCheckMerkleRootFreshnessRejectsFutureRoot(t *testing.T) {
+	key := cryptKey(t)
+	boxer, cleanup, sender, signKP, ctx := setupConcurrentUnboxTest(t, key)
+	defer cleanup()
+	boxer.SetCheckMerkleRootFreshness(true)
+
+	header := chat1.MessageClientHeader{
+		Sender:     sender,
+		MerkleRoot: &chat1.MerkleRoot{Seqno: 1 + maxMerkleRootSeqnoSlack + 1},
+	}
+	msg := textMsgWithHeader(t, "hi", header)
+	boxed, _, err := boxer.boxMessageWithKeys(msg, key, signKP)
+	require.NoError(t, err)
+	boxed.ServerHeader = &chat1.MessageServerHeader{MessageID: 1, Ctime: gregor1.ToTime(time.Now())}
+
+	res, ierr := boxer.UnboxMessage(ctx, *boxed, nil, nil, nil)
+	require.Nil(t, ierr)
+	require.Equal(t, chat1.MessageUnboxedErrorType_SUSPICIOUSMERKLEROOT, res.Error().ErrType)
+	require.Equal(t, chat1.UnboxingErrorCode_FUTUREMERKLEROOT, res.Error().Details.Code)
+	require.EqualValues(t, 1, *res.Error().Details.CachedMerkleSeqno)
+	require.EqualValues(t, 1+maxMerkleRootSeqnoSlack+1, *res.Error().Details.ClaimedMerkleSeqno)
+
+	// Without the option, the same message unboxes fine.
+	boxer.SetCheckMerkleRootFreshness(false)
+	res, ierr = boxer.UnboxMessage(ctx, *boxed, nil, nil, nil)
+	require.Nil(t, ierr)
+	require.Equal(t, "hi", res.Valid().MessageBody.Text().Body)
+}
+
+// slowKeyFinderMock is a KeyFinder that sleeps briefly on every call, so a
+// test driving a batch through UnboxMessagesStream has a wide enough
+// window to cancel its context while some messages are still in flight,
+// rather than racing to land the cancellation before the whole batch
+// finishes.
+type slowKeyFinderMock struct {
+	key   keybase1.CryptKey
+	delay time.Duration
+}
+
+func (k *slowKeyFinderMock) Find(ctx context.Context, tlf keybase1.TlfInterface, tlfName string, tlfPublic bool) (keybase1.GetTLFCryptKeysRes, error) {
+	time.Sleep(k.delay)
+	return keybase1.GetTLFCryptKeysRes{
+		NameIDBreaks: keybase1.CanonicalTLFNameAndIDWithBreaks{CanonicalName: keybase1.CanonicalTlfName(tlfName)},
+		CryptKeys:    []keybase1.CryptKey{k.key},
+	}, nil
+}
+
+// TestChatUnboxMessagesStreamCancelation checks that canceling the context
+// passed to UnboxMessagesStream partway through a batch still closes the
+// returned channel promptly, and doesn't leave any of its worker
+// goroutines running afterward.
+func TestChatUnboxMessagesStreamCancelation(t *testing.T) {
+	key := cryptKey(t)
+	boxer, cleanup, sender, signKP, _ := setupConcurrentUnboxTest(t, key)
+	defer cleanup()
+
+	// Give every message its own TlfName so the KeyFinder (which caches
+	// by TLF) is actually asked, and thus actually slept, once per
+	// message, rather than once for the whole batch.
+	const n = 30
+	boxedMsgs := make([]chat1.MessageBoxed, n)
+	for i := 0; i < n; i++ {
+		msg := textMsgWithSender(t, fmt.Sprintf("msg-%d", i), sender)
+		msg.ClientHeader.TlfName = fmt.Sprintf("stream_cancel_test_%d", i)
+		boxed, _, err := boxer.boxMessageWithKeys(msg, key, signKP)
+		require.NoError(t, err)
+		boxed.ServerHeader = &chat1.MessageServerHeader{
+			MessageID: chat1.MessageID(i + 1),
+			Ctime:     gregor1.ToTime(time.Now()),
+		}
+		boxedMsgs[i] = *boxed
+	}
+
+	boxer.SetUnboxMessagesConcurrency(4)
+	finder := &slowKeyFinderMock{key: *key, delay: 20 * time.Millisecond}
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), kfKey, finder))
+
+	before := runtime.NumGoroutine()
+
+	results := boxer.UnboxMessagesStream(ctx, boxedMsgs, chat1.ConversationID("stream-cancel-test"), nil)
+
+	received := 0
+	select {
+	case _, ok := <-results:
+		require.True(t, ok, "expected at least one result before canceling")
+		received++
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the first result")
+	}
+	cancel()
+
+	for range results {
+		received++
+	}
+	require.True(t, received < n, "expected cancellation to cut the stream short of the full batch: got %d of %d", received, n)
+
+	// The channel's close already tells us every worker the stream
+	// started has returned (UnboxMessagesStream waits for them before
+	// closing it), but double check via NumGoroutine with a short, bounded
+	// retry to absorb scheduler noise rather than asserting immediately.
+	var after int
+	leaked := true
+	for i := 0; i < 200; i++ {
+		after = runtime.NumGoroutine()
+		if after <= before+2 {
+			leaked = false
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.False(t, leaked, "expected no leaked goroutines after the stream closed: had %d, now %d", before, after)
+}
+
+// transientTwiceKeyFinderMock answers Find with a transient error for its
+// first two calls, then succeeds as singleKeyFinderMock would -- standing
+// in for a rekey or network hiccup that clears up on retry.
+type transientTwiceKeyFinderMock struct {
+	key keybase1.CryptKey
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (k *transientTwiceKeyFinderMock) Find(ctx context.Context, tlf keybase1.TlfInterface, tlfName string, tlfPublic bool) (keybase1.GetTLFCryptKeysRes, error) {
+	k.mu.Lock()
+	k.calls++
+	call := k.calls
+	k.mu.Unlock()
+
+	if call <= 2 {
+		return keybase1.GetTLFCryptKeysRes{}, fmt.Errorf("transient key lookup failure (attempt %d)", call)
+	}
+	return keybase1.GetTLFCryptKeysRes{
+		NameIDBreaks: keybase1.CanonicalTLFNameAndIDWithBreaks{CanonicalName: keybase1.CanonicalTlfName(tlfName)},
+		CryptKeys:    []keybase1.CryptKey{k.key},
+	}, nil
+}
+
+// TestChatUnboxMessagesRetriesTransientErrors checks that UnboxMessages,
+// configured via SetUnboxRetries, retries a message that fails with a
+// transient error instead of aborting the whole batch on the first
+// failure -- using a KeyFinder that fails twice before succeeding, the
+// message should still come back unboxed once retries give it a third
+// try.
+func TestChatUnboxMessagesRetriesTransientErrors(t *testing.T) {
+	key := cryptKey(t)
+	boxer, cleanup, sender, signKP, _ := setupConcurrentUnboxTest(t, key)
+	defer cleanup()
+
+	boxer.SetUnboxRetries(2, time.Millisecond)
+	boxer.SetKeyFinder(&transientTwiceKeyFinderMock{key: *key})
+
+	msg := textMsgWithSender(t, "hi", sender)
+	boxed, _, err := boxer.boxMessageWithKeys(msg, key, signKP)
+	require.NoError(t, err)
+	boxed.ServerHeader = &chat1.MessageServerHeader{MessageID: 1, Ctime: gregor1.ToTime(time.Now())}
+
+	unboxed, err := boxer.UnboxMessages(context.Background(), []chat1.MessageBoxed{*boxed}, nil, nil, UnboxMessagesOptions{})
+	require.NoError(t, err)
+	require.Len(t, unboxed, 1)
+	require.True(t, unboxed[0].IsValid(), "expected the message to unbox once retries got past the two transient failures: %+v", unboxed[0])
+}
+
+// countingKeyFinderMock is like singleKeyFinderMock, but counts its calls
+// so a test can confirm a message wasn't retried.
+type countingKeyFinderMock struct {
+	key keybase1.CryptKey
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (k *countingKeyFinderMock) Find(ctx context.Context, tlf keybase1.TlfInterface, tlfName string, tlfPublic bool) (keybase1.GetTLFCryptKeysRes, error) {
+	k.mu.Lock()
+	k.calls++
+	k.mu.Unlock()
+	return keybase1.GetTLFCryptKeysRes{
+		NameIDBreaks: keybase1.CanonicalTLFNameAndIDWithBreaks{CanonicalName: keybase1.CanonicalTlfName(tlfName)},
+		CryptKeys:    []keybase1.CryptKey{k.key},
+	}, nil
+}
+
+// TestChatUnboxMessagesDoesNotRetryPermanentErrors checks that a permanent
+// error -- here, a tampered header signature -- still fails a message on
+// the first try even with retries configured, since retrying it would
+// just reproduce the same failure. It confirms this by counting KeyFinder
+// calls: a retried message would look up its key again on each attempt,
+// so more than one call means the permanent error was retried when it
+// shouldn't have been.
+func TestChatUnboxMessagesDoesNotRetryPermanentErrors(t *testing.T) {
+	key := cryptKey(t)
+	boxer, cleanup, sender, signKP, _ := setupConcurrentUnboxTest(t, key)
+	defer cleanup()
+
+	boxer.SetUnboxRetries(5, time.Millisecond)
+	finder := &countingKeyFinderMock{key: *key}
+	boxer.SetKeyFinder(finder)
+
+	msg := textMsgWithSender(t, "hi", sender)
+
+	origSign := boxer.sign
+	boxer.sign = func(msg []byte, kp libkb.NaclSigningKeyPair, prefix libkb.SignaturePrefix) (chat1.SignatureInfo, error) {
+		sig, err := kp.SignV2(msg, prefix)
+		if err != nil {
+			return chat1.SignatureInfo{}, err
+		}
+		sigInfo := chat1.SignatureInfo{V: sig.Version, S: sig.Sig[:], K: sig.Kid}
+		sigInfo.S[4] ^= 0x10
+		return sigInfo, nil
+	}
+	boxed, _, err := boxer.boxMessageWithKeys(msg, key, signKP)
+	boxer.sign = origSign
+	require.NoError(t, err)
+	boxed.ServerHeader = &chat1.MessageServerHeader{MessageID: 1, Ctime: gregor1.ToTime(time.Now())}
+
+	unboxed, err := boxer.UnboxMessages(context.Background(), []chat1.MessageBoxed{*boxed}, nil, nil, UnboxMessagesOptions{})
+	require.NoError(t, err)
+	require.Len(t, unboxed, 1)
+	state, err := unboxed[0].State()
+	require.NoError(t, err)
+	require.Equal(t, chat1.MessageUnboxedState_ERROR, state)
+
+	finder.mu.Lock()
+	calls := finder.calls
+	finder.mu.Unlock()
+	require.Equal(t, 1, calls, "expected a permanent error not to be retried")
+}
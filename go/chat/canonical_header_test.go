@@ -0,0 +1,49 @@
+package chat
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/keybase/client/go/protocol/chat1"
+	"github.com/keybase/client/go/protocol/gregor1"
+	"github.com/stretchr/testify/require"
+)
+
+// canonicalHeaderEncodingGoldenInput is a fixed HeaderPlaintextV1, built
+// from deterministic (non-random) field values so that the bytes it
+// marshals to can be recorded once and compared against forever after.
+func canonicalHeaderEncodingGoldenInput() chat1.HeaderPlaintextV1 {
+	return chat1.HeaderPlaintextV1{
+		Conv: chat1.ConversationIDTriple{
+			Tlfid:     chat1.TLFID("0123456789abcdef0123456789abcdef"),
+			TopicType: chat1.TopicType_CHAT,
+			TopicID:   chat1.TopicID("fedcba9876543210fedcba9876543210"),
+		},
+		TlfName:      "alice,bob",
+		MessageType:  chat1.MessageType_TEXT,
+		Prev:         []chat1.MessagePreviousPointer{{Id: 1, Hash: make(chat1.Hash, 32)}},
+		Sender:       gregor1.UID(make([]byte, 16)),
+		SenderDevice: gregor1.DeviceID(make([]byte, 16)),
+		BodyHash:     make(chat1.Hash, 32),
+	}
+}
+
+// canonicalHeaderEncodingGoldenHex is the recorded output of encoding
+// canonicalHeaderEncodingGoldenInput() through marshalCanonicalHeader --
+// the same encoding boxMessageWithKeys signs a header with and
+// verifyMessageHeaderV1/V2/V3 re-derive to check that signature against.
+// If this test starts failing, a dependency bump or codec config change
+// has altered the wire encoding, and that must be caught here, loudly,
+// rather than surfacing as a signature verification failure in
+// production.
+const canonicalHeaderEncodingGoldenHex = "88a8626f647948617368c4200000000000000000000000000000000000000000000000000000000000000000a4636f6e7683a5746c666964c4203031323334353637383961626364656630313233343536373839616263646566a7746f7069634944c4206665646362613938373635343332313066656463626139383736353433323130a9746f7069635479706501ab6d6573736167655479706501a4707265769182a468617368c4200000000000000000000000000000000000000000000000000000000000000000a2696401a673656e646572c41000000000000000000000000000000000ac73656e646572446576696365c41000000000000000000000000000000000a7746c664e616d65a9616c6963652c626f62a9746c665075626c6963c2"
+
+func TestChatCanonicalHeaderEncodingGolden(t *testing.T) {
+	tc, boxer := setupChatTest(t, "canonical-header-golden")
+	defer tc.Cleanup()
+
+	got, err := boxer.marshalCanonicalHeader(canonicalHeaderEncodingGoldenInput())
+	require.NoError(t, err)
+	require.Equal(t, canonicalHeaderEncodingGoldenHex, hex.EncodeToString(got),
+		"canonical header encoding changed -- see the comment on canonicalHeaderEncodingGoldenHex")
+}
@@ -0,0 +1,72 @@
+package chat
+
+import (
+	"sync"
+
+	"github.com/keybase/go-codec/codec"
+)
+
+// chatMsgpackHandle and chatMsgpackHandleCompact are the codec.Handles
+// Boxer.marshal/marshalCompact/unmarshal encode and decode with. A Handle
+// is read-only, static per-call configuration, not per-message state, so
+// building one per call (as marshal/unmarshal used to) was pure overhead
+// on the hot unboxing path; both are built once here and shared by every
+// pooled Encoder/Decoder below.
+//
+// WriteExt: true is load-bearing, not cosmetic: it's what makes chat1.Hash
+// and other raw-byte fields encode as msgpack bin extensions rather than
+// plain arrays, which is the exact byte layout verifyMessageHeaderV1/V2/V3
+// sign and verify. Changing it would change the encoding of every
+// existing header signature out from under it, so it's preserved
+// unchanged from the handles marshal/unmarshal used to construct inline.
+var chatMsgpackHandle = &codec.MsgpackHandle{WriteExt: true}
+
+var chatMsgpackHandleCompact = func() *codec.MsgpackHandle {
+	mh := &codec.MsgpackHandle{WriteExt: true}
+	mh.StructToArray = true
+	return mh
+}()
+
+// chatEncoderPool, chatEncoderCompactPool, and chatDecoderPool recycle
+// codec.Encoders/Decoders across marshal/unmarshal calls, one pool per
+// Handle, so the hot unboxing path doesn't pay for a fresh Encoder or
+// Decoder (and the reflection-based type caches each one builds up) on
+// every single message. Safe for concurrent use: sync.Pool itself is, and
+// every Get is immediately Reset to the caller's own input/output before
+// anything is encoded or decoded, so two concurrent callers never observe
+// each other's data through a shared Encoder/Decoder.
+var chatEncoderPool = sync.Pool{
+	New: func() interface{} {
+		return codec.NewEncoderBytes(&[]byte{}, chatMsgpackHandle)
+	},
+}
+
+var chatEncoderCompactPool = sync.Pool{
+	New: func() interface{} {
+		return codec.NewEncoderBytes(&[]byte{}, chatMsgpackHandleCompact)
+	},
+}
+
+var chatDecoderPool = sync.Pool{
+	New: func() interface{} {
+		return codec.NewDecoderBytes(nil, chatMsgpackHandle)
+	},
+}
+
+func chatMarshalWithPool(pool *sync.Pool, v interface{}) ([]byte, error) {
+	enc := pool.Get().(*codec.Encoder)
+	defer pool.Put(enc)
+	var data []byte
+	enc.ResetBytes(&data)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func chatUnmarshal(data []byte, v interface{}) error {
+	dec := chatDecoderPool.Get().(*codec.Decoder)
+	defer chatDecoderPool.Put(dec)
+	dec.ResetBytes(data)
+	return dec.Decode(&v)
+}
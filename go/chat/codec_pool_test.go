@@ -0,0 +1,141 @@
+package chat
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/keybase/client/go/externals"
+	"github.com/keybase/client/go/protocol/chat1"
+	"github.com/keybase/client/go/protocol/gregor1"
+	"github.com/keybase/go-codec/codec"
+	"github.com/stretchr/testify/require"
+)
+
+// setupCodecPoolTest builds a bare Boxer for exercising
+// marshal/marshalCompact/unmarshal directly; it takes testing.TB so it can
+// also be used from a benchmark.
+func setupCodecPoolTest(tb testing.TB, name string) (boxer *Boxer, cleanup func()) {
+	tc := externals.SetupTest(tb, name, 2)
+	return NewBoxer(tc.G, nil), tc.Cleanup
+}
+
+// realisticTestHeader returns a chat1.HeaderPlaintextV1 representative of
+// what Boxer actually marshals on the hot unboxing path: a handful of
+// prev pointers, a real-sized body hash and signature, the works.
+func realisticTestHeader() chat1.HeaderPlaintextV1 {
+	return chat1.HeaderPlaintextV1{
+		Conv: chat1.ConversationIDTriple{
+			Tlfid:     chat1.TLFID("0123456789abcdef0123456789abcdef"),
+			TopicType: chat1.TopicType_CHAT,
+			TopicID:   chat1.TopicID("0123456789abcdef0123456789abcdef"),
+		},
+		TlfName:     "alice,bob",
+		MessageType: chat1.MessageType_TEXT,
+		Prev: []chat1.MessagePreviousPointer{
+			{Id: 1, Hash: make(chat1.Hash, 32)},
+			{Id: 2, Hash: make(chat1.Hash, 32)},
+			{Id: 3, Hash: make(chat1.Hash, 32)},
+		},
+		Sender:       gregor1.UID(make([]byte, 16)),
+		SenderDevice: gregor1.DeviceID(make([]byte, 16)),
+		BodyHash:     make(chat1.Hash, 32),
+		HeaderSignature: &chat1.SignatureInfo{
+			V: 1,
+			S: make([]byte, 64),
+			K: make([]byte, 32),
+		},
+	}
+}
+
+// TestChatMarshalUnmarshalPoolRoundTrip checks that a pooled
+// marshal/unmarshal round trip recovers an equal struct, and that two
+// independent marshals of the same value produce byte-for-byte identical
+// output -- the determinism verifyMessageHeaderV1/V2/V3 rely on for
+// signature verification, which pooling the Encoder must not disturb.
+func TestChatMarshalUnmarshalPoolRoundTrip(t *testing.T) {
+	boxer, cleanup := setupCodecPoolTest(t, "codec-pool")
+	defer cleanup()
+
+	header := realisticTestHeader()
+
+	first, err := boxer.marshal(header)
+	require.NoError(t, err)
+	second, err := boxer.marshal(header)
+	require.NoError(t, err)
+	require.Equal(t, first, second, "marshaling the same value twice must produce identical bytes")
+
+	var got chat1.HeaderPlaintextV1
+	require.NoError(t, boxer.unmarshal(first, &got))
+	require.Equal(t, header, got)
+}
+
+// TestChatMarshalUnmarshalPoolConcurrent checks that concurrent
+// marshal/unmarshal calls through the shared pools never see each other's
+// data -- a pooled Encoder/Decoder that leaked state across callers could
+// otherwise pass single-threaded tests while corrupting output under
+// concurrency.
+func TestChatMarshalUnmarshalPoolConcurrent(t *testing.T) {
+	boxer, cleanup := setupCodecPoolTest(t, "codec-pool-concurrent")
+	defer cleanup()
+
+	const n = 50
+	errCh := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			header := realisticTestHeader()
+			header.TlfName = string(rune('a' + i%26))
+			data, err := boxer.marshal(header)
+			if err != nil {
+				errCh <- err
+				return
+			}
+			var got chat1.HeaderPlaintextV1
+			if err := boxer.unmarshal(data, &got); err != nil {
+				errCh <- err
+				return
+			}
+			if got.TlfName != header.TlfName {
+				errCh <- fmt.Errorf("got TlfName %q, want %q", got.TlfName, header.TlfName)
+				return
+			}
+			errCh <- nil
+		}(i)
+	}
+	for i := 0; i < n; i++ {
+		require.NoError(t, <-errCh)
+	}
+}
+
+// BenchmarkChatBoxerMarshal compares the pooled Boxer.marshal against the
+// unpooled construct-a-fresh-Encoder-every-call approach it replaced, on a
+// realistic header. Run with -benchmem to see the allocation counts drop;
+// "unpooled" reconstructs the pre-pooling code path inline rather than
+// keeping a second copy of marshal around in production code just for
+// this comparison.
+func BenchmarkChatBoxerMarshal(b *testing.B) {
+	boxer, cleanup := setupCodecPoolTest(b, "codec-pool-bench")
+	defer cleanup()
+
+	header := realisticTestHeader()
+
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := boxer.marshal(header); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("unpooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			mh := codec.MsgpackHandle{WriteExt: true}
+			var data []byte
+			enc := codec.NewEncoderBytes(&data, &mh)
+			if err := enc.Encode(header); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -12,11 +12,15 @@ type identifyModeKey int
 type keyfinderKey int
 type identifyNotifierKey int
 type chatTrace int
+type callSenderKeyCacheKey int
+type unboxBatchTimingsKey int
 
 var identModeKey identifyModeKey
 var kfKey keyfinderKey
 var inKey identifyNotifierKey
 var chatTraceKey chatTrace
+var skcKey callSenderKeyCacheKey
+var ubtKey unboxBatchTimingsKey
 
 type identModeData struct {
 	mode   keybase1.TLFIdentifyBehavior
@@ -47,6 +51,27 @@ func CtxKeyFinder(ctx context.Context) KeyFinder {
 	return NewKeyFinder()
 }
 
+// ctxCallSenderKeyCache returns the callSenderKeyCache installed on ctx by
+// UnboxMessages/UnboxMessagesStream, or nil if ctx doesn't carry one (e.g.
+// a plain UnboxMessage call, which isn't a batch and has nothing to
+// amortize a lookup across). nil is a valid, meaningful result here,
+// unlike CtxKeyFinder's always-construct-a-fresh-one fallback: a lookup
+// with no call cache available just skips this extra layer and falls
+// straight through to senderKeyCache.
+func ctxCallSenderKeyCache(ctx context.Context) *callSenderKeyCache {
+	cache, _ := ctx.Value(skcKey).(*callSenderKeyCache)
+	return cache
+}
+
+// ctxUnboxBatchTimings returns the unboxBatchTimings installed on ctx by
+// UnboxMessages, or nil if ctx doesn't carry one (e.g. a standalone
+// UnboxMessage call, which isn't part of a batch with totals to
+// accumulate into).
+func ctxUnboxBatchTimings(ctx context.Context) *unboxBatchTimings {
+	timings, _ := ctx.Value(ubtKey).(*unboxBatchTimings)
+	return timings
+}
+
 func CtxIdentifyNotifier(ctx context.Context) *IdentifyNotifier {
 	var in *IdentifyNotifier
 	var ok bool
@@ -153,7 +153,7 @@ func (s *RemoteConversationSource) Pull(ctx context.Context, convID chat1.Conver
 		return chat1.ThreadView{}, rl, err
 	}
 
-	thread, err := s.boxer.UnboxThread(ctx, boxed.Thread, convID, conv.Metadata.FinalizeInfo)
+	thread, err := s.boxer.UnboxThread(ctx, boxed.Thread, convID, conv.Metadata.FinalizeInfo, nil, UnboxMessagesOptions{})
 	if err != nil {
 		return chat1.ThreadView{}, rl, err
 	}
@@ -188,7 +188,7 @@ func (s *RemoteConversationSource) GetMessages(ctx context.Context, convID chat1
 		MessageIDs:     msgIDs,
 	})
 
-	msgs, err := s.boxer.UnboxMessages(ctx, rres.Msgs, finalizeInfo)
+	msgs, err := s.boxer.UnboxMessages(ctx, rres.Msgs, finalizeInfo, nil, UnboxMessagesOptions{})
 	if err != nil {
 		return nil, err
 	}
@@ -202,7 +202,7 @@ func (s *RemoteConversationSource) GetMessagesWithRemotes(ctx context.Context,
 	if s.IsOffline() {
 		return nil, nil
 	}
-	return s.boxer.UnboxMessages(ctx, msgs, finalizeInfo)
+	return s.boxer.UnboxMessages(ctx, msgs, finalizeInfo, nil, UnboxMessagesOptions{})
 }
 
 type HybridConversationSource struct {
@@ -245,7 +245,7 @@ func (s *HybridConversationSource) Push(ctx context.Context, convID chat1.Conver
 	// coincides with an account reset.
 	var emptyFinalizeInfo *chat1.ConversationFinalizeInfo
 
-	decmsg, err := s.boxer.UnboxMessage(ctx, msg, emptyFinalizeInfo)
+	decmsg, err := s.boxer.UnboxMessage(ctx, msg, emptyFinalizeInfo, nil, nil)
 	if err != nil {
 		return decmsg, continuousUpdate, err
 	}
@@ -387,7 +387,7 @@ func (s *HybridConversationSource) Pull(ctx context.Context, convID chat1.Conver
 	}
 
 	// Unbox
-	thread, err = s.boxer.UnboxThread(ctx, boxed.Thread, convID, conv.Metadata.FinalizeInfo)
+	thread, err = s.boxer.UnboxThread(ctx, boxed.Thread, convID, conv.Metadata.FinalizeInfo, nil, UnboxMessagesOptions{})
 	if err != nil {
 		return chat1.ThreadView{}, rl, err
 	}
@@ -426,19 +426,10 @@ func (s *HybridConversationSource) updateMessage(ctx context.Context, message ch
 			return message, nil
 		}
 
-		sender := m.ClientHeader.Sender
-		key := m.HeaderSignature.K
-		ctime := m.ServerHeader.Ctime
-		found, validAtCtime, revoked, err := s.boxer.ValidSenderKey(ctx, sender, key, ctime)
+		revoked, err := s.boxer.RecheckRevocation(ctx, m)
 		if err != nil {
 			return chat1.MessageUnboxed{}, err
 		}
-		if !found {
-			return chat1.MessageUnboxed{}, NewPermanentUnboxingError(libkb.NoKeyError{Msg: "sender key not found"})
-		}
-		if !validAtCtime {
-			return chat1.MessageUnboxed{}, NewPermanentUnboxingError(libkb.NoKeyError{Msg: "key invalid for sender at message ctime"})
-		}
 		m.SenderDeviceRevokedAt = revoked
 		updatedMessage := chat1.NewMessageUnboxedWithValid(m)
 		return updatedMessage, nil
@@ -513,7 +504,7 @@ func (s *HybridConversationSource) GetMessages(ctx context.Context, convID chat1
 		}
 
 		// Unbox all the remote messages
-		rmsgsUnboxed, err := s.boxer.UnboxMessages(ctx, rmsgs.Msgs, finalizeInfo)
+		rmsgsUnboxed, err := s.boxer.UnboxMessages(ctx, rmsgs.Msgs, finalizeInfo, nil, UnboxMessagesOptions{})
 		if err != nil {
 			return nil, err
 		}
@@ -577,7 +568,7 @@ func (s *HybridConversationSource) GetMessagesWithRemotes(ctx context.Context,
 		if lmsg, ok := lmsgsTab[msg.GetMessageID()]; ok {
 			res = append(res, lmsg)
 		} else if !s.IsOffline() {
-			unboxed, err := s.boxer.UnboxMessage(ctx, msg, finalizeInfo)
+			unboxed, err := s.boxer.UnboxMessage(ctx, msg, finalizeInfo, nil, nil)
 			if err != nil {
 				return res, err
 			}
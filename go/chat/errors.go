@@ -12,6 +12,7 @@ type UnboxingError interface {
 	Inner() error
 	IsPermanent() bool
 	ExportType() chat1.MessageUnboxedErrorType
+	ExportDetails() *chat1.UnboxingErrorDetails
 }
 
 var _ error = (UnboxingError)(nil)
@@ -34,11 +35,34 @@ func (e PermanentUnboxingError) ExportType() chat1.MessageUnboxedErrorType {
 	switch err := e.inner.(type) {
 	case VersionError:
 		return err.ExportType()
+	case SenderKeyNotCurrentlyActiveError:
+		return err.ExportType()
+	case SenderKeyDeviceMismatchError:
+		return err.ExportType()
+	case TLFIDMismatchError:
+		return err.ExportType()
+	case MerkleRootFreshnessError:
+		return err.ExportType()
 	default:
 		return chat1.MessageUnboxedErrorType_MISC
 	}
 }
 
+// detailedUnboxingError is implemented by the inner errors that have a
+// finer-grained UnboxingErrorCode to offer beyond ExportType's coarse
+// MessageUnboxedErrorType. Not every inner error needs one, so ExportDetails
+// falls back to nil for anything that doesn't implement it.
+type detailedUnboxingError interface {
+	ExportDetails() *chat1.UnboxingErrorDetails
+}
+
+func (e PermanentUnboxingError) ExportDetails() *chat1.UnboxingErrorDetails {
+	if err, ok := e.inner.(detailedUnboxingError); ok {
+		return err.ExportDetails()
+	}
+	return nil
+}
+
 func NewTransientUnboxingError(inner error) UnboxingError {
 	return &TransientUnboxingError{inner}
 }
@@ -57,6 +81,60 @@ func (e TransientUnboxingError) ExportType() chat1.MessageUnboxedErrorType {
 	return chat1.MessageUnboxedErrorType_MISC
 }
 
+func (e TransientUnboxingError) ExportDetails() *chat1.UnboxingErrorDetails {
+	if err, ok := e.inner.(detailedUnboxingError); ok {
+		return err.ExportDetails()
+	}
+	return nil
+}
+
+//=============================================================================
+
+// UnboxMessagesError wraps the UnboxingError that made UnboxMessages abort a
+// batch with the position of the offending message, so callers don't have
+// to go digging through debug logs to find which message in the batch
+// failed. It implements UnboxingError itself, forwarding IsPermanent,
+// Inner, and ExportType to the wrapped error, so existing callers that
+// switch on chat.UnboxingError keep working unchanged.
+type UnboxMessagesError struct {
+	MessageID chat1.MessageID
+	Index     int
+	Err       UnboxingError
+}
+
+func NewUnboxMessagesError(index int, messageID chat1.MessageID, err UnboxingError) UnboxMessagesError {
+	return UnboxMessagesError{
+		MessageID: messageID,
+		Index:     index,
+		Err:       err,
+	}
+}
+
+func (e UnboxMessagesError) Error() string {
+	return fmt.Sprintf("error unboxing message %d (index %d in batch): %s", e.MessageID, e.Index, e.Err.Error())
+}
+
+// Unwrap returns the UnboxingError that caused the batch to fail.
+func (e UnboxMessagesError) Unwrap() error {
+	return e.Err
+}
+
+func (e UnboxMessagesError) Inner() error {
+	return e.Err.Inner()
+}
+
+func (e UnboxMessagesError) IsPermanent() bool {
+	return e.Err.IsPermanent()
+}
+
+func (e UnboxMessagesError) ExportType() chat1.MessageUnboxedErrorType {
+	return e.Err.ExportType()
+}
+
+func (e UnboxMessagesError) ExportDetails() *chat1.UnboxingErrorDetails {
+	return e.Err.ExportDetails()
+}
+
 //=============================================================================
 
 type ConsistencyErrorCode int
@@ -120,6 +198,70 @@ func (e BoxingError) IsImmediateFail() (chat1.OutboxErrorType, bool) {
 
 //=============================================================================
 
+// BlankTLFNameError is returned when BoxMessage is given a message whose
+// client header already has a blank TLF name, before any resolution has
+// happened. That can only be a caller bug, so it's always permanent.
+type BlankTLFNameError struct{}
+
+func NewBlankTLFNameError() BlankTLFNameError {
+	return BlankTLFNameError{}
+}
+
+func (e BlankTLFNameError) Error() string {
+	return "boxing error: blank TLF name given"
+}
+
+func (e BlankTLFNameError) IsImmediateFail() (chat1.OutboxErrorType, bool) {
+	return chat1.OutboxErrorType_MISC, true
+}
+
+//=============================================================================
+
+// BlankCanonicalTLFNameError is returned when BoxMessage's TLF name
+// resolver returns a blank canonical name for a non-blank name the caller
+// gave it. Unlike BlankTLFNameError, the caller did nothing wrong here --
+// this points at the resolver or the service it talks to, which may well
+// recover on retry, so it's treated as transient.
+type BlankCanonicalTLFNameError struct {
+	TLFName string
+}
+
+func NewBlankCanonicalTLFNameError(tlfName string) BlankCanonicalTLFNameError {
+	return BlankCanonicalTLFNameError{TLFName: tlfName}
+}
+
+func (e BlankCanonicalTLFNameError) Error() string {
+	return fmt.Sprintf("boxing error: blank canonical TLF name received for %q", e.TLFName)
+}
+
+func (e BlankCanonicalTLFNameError) IsImmediateFail() (chat1.OutboxErrorType, bool) {
+	return 0, false
+}
+
+//=============================================================================
+
+// DecryptOpenError is returned by Boxer.open when secretbox.Open fails to
+// authenticate a ciphertext. A secretbox MAC failure by itself can't say
+// whether the caller used the wrong CryptKey generation or the
+// ciphertext/nonce was corrupted -- libkb.DecryptOpenError's message
+// already says as much. What it does carry is the generation open was
+// given, so a caller doing multi-key fallback across several generations
+// can tell which attempt this failure came from, instead of every
+// attempt producing an identical, context-free error.
+type DecryptOpenError struct {
+	Generation int
+}
+
+func NewDecryptOpenError(generation int) DecryptOpenError {
+	return DecryptOpenError{Generation: generation}
+}
+
+func (e DecryptOpenError) Error() string {
+	return fmt.Sprintf("%s (key generation %d)", libkb.DecryptOpenError{}.Error(), e.Generation)
+}
+
+//=============================================================================
+
 type BoxingCryptKeysError struct {
 	Err error
 }
@@ -147,12 +289,203 @@ func (e BoxingCryptKeysError) IsImmediateFail() (chat1.OutboxErrorType, bool) {
 
 //=============================================================================
 
+// HeaderSignatureError reports why a message's header signature failed to
+// verify, so a "header signature invalid" report carries enough detail to
+// diagnose without re-deriving it from debug logs. KID is the signing key
+// the header's signature claimed, so a caller can tell which key to
+// distrust without re-parsing the header itself.
+type HeaderSignatureError struct {
+	Reason string
+	KID    []byte
+}
+
+func (e HeaderSignatureError) Error() string {
+	return fmt.Sprintf("header signature invalid: %s", e.Reason)
+}
+
+func (e HeaderSignatureError) ExportDetails() *chat1.UnboxingErrorDetails {
+	return &chat1.UnboxingErrorDetails{
+		Code:        chat1.UnboxingErrorCode_BADSIGNATURE,
+		RejectedKID: e.KID,
+	}
+}
+
+// NewHeaderSignatureError classifies the error libkb.NaclSigInfo.Verify
+// returned into a HeaderSignatureError with a reason a human (or tooling
+// reading unbox failures) can act on. kid is the signing key the header's
+// signature claimed.
+//
+// A wrong signing key, a tampered header, and a mismatched signature
+// prefix are indistinguishable at this layer: NaCl signature verification
+// fails the same way (libkb.VerificationError) for all three, since each
+// one amounts to "the bytes that were actually signed don't match what
+// we're checking against." Only a malformed KID (libkb.BadKeyError) and an
+// unsupported signature version (libkb.UnhandledSignatureError) are
+// distinguishable from a generic mismatch.
+func NewHeaderSignatureError(err error, kid []byte) HeaderSignatureError {
+	switch err.(type) {
+	case libkb.BadKeyError:
+		return HeaderSignatureError{Reason: "malformed or unrecognized signing key", KID: kid}
+	case libkb.UnhandledSignatureError:
+		return HeaderSignatureError{Reason: err.Error(), KID: kid}
+	case libkb.VerificationError:
+		return HeaderSignatureError{Reason: "signature does not match the signed header (wrong key, wrong prefix, or tampered data)", KID: kid}
+	default:
+		return HeaderSignatureError{Reason: err.Error(), KID: kid}
+	}
+}
+
+//=============================================================================
+
 type BodyHashInvalid struct{}
 
 func (e BodyHashInvalid) Error() string {
 	return "chat body hash invalid"
 }
 
+// EmptyBodyUnsupersededError is returned when a MessageBoxed has no body
+// ciphertext but also no SupersededBy pointer, so there's no message that
+// explains why the body is gone. This legitimately happens for a deleted
+// message whose supersede pointer hasn't propagated yet, so callers get
+// their own error type to distinguish it from other unboxing failures and
+// decide for themselves whether to hide or flag the message, rather than
+// treating it as an opaque unbox error.
+type EmptyBodyUnsupersededError struct{}
+
+func (e EmptyBodyUnsupersededError) Error() string {
+	return "empty body and not superseded in MessageBoxed"
+}
+
+// SenderKeyNotCurrentlyActiveError is returned when a Boxer configured with
+// SetRequireCurrentlyActiveSenderKey unboxes a message whose signing key,
+// while valid for the sender at the message's ctime, is no longer among
+// the sender's currently-active sigchain keys. It gets its own
+// MessageUnboxedErrorType so callers can tell this stricter rejection
+// apart from an ordinary invalid- or not-found-key failure.
+type SenderKeyNotCurrentlyActiveError struct{}
+
+func (e SenderKeyNotCurrentlyActiveError) Error() string {
+	return "sender key is no longer among the sender's currently active keys"
+}
+
+func (e SenderKeyNotCurrentlyActiveError) ExportType() chat1.MessageUnboxedErrorType {
+	return chat1.MessageUnboxedErrorType_SENDERKEYNOTACTIVE
+}
+
+// SenderKeyNotFoundError is returned when a message's claimed signing key
+// either doesn't belong to the claimed sender at all, or wasn't valid for
+// them at the message's ctime. KID is the key that failed the lookup, so a
+// caller can tell the GUI which key it can't find without re-deriving it
+// from debug logs.
+type SenderKeyNotFoundError struct {
+	Reason string
+	KID    []byte
+}
+
+func (e SenderKeyNotFoundError) Error() string {
+	return fmt.Sprintf("sender key not found: %s", e.Reason)
+}
+
+func (e SenderKeyNotFoundError) ExportDetails() *chat1.UnboxingErrorDetails {
+	return &chat1.UnboxingErrorDetails{
+		Code:        chat1.UnboxingErrorCode_KEYNOTFOUND,
+		RejectedKID: e.KID,
+	}
+}
+
+// SenderKeyDeviceMismatchError is returned when a message's signing key
+// belongs to the claimed sender's account, but not to the claimed sending
+// device -- e.g. a key compromised on one of the sender's other devices
+// was used to forge a message attributed to this one. It gets its own
+// MessageUnboxedErrorType so callers can tell this apart from an ordinary
+// SenderKeyNotFoundError, where the key doesn't belong to the sender at
+// all.
+type SenderKeyDeviceMismatchError struct {
+	KID []byte
+}
+
+func (e SenderKeyDeviceMismatchError) Error() string {
+	return "sender key does not belong to the claimed sending device"
+}
+
+func (e SenderKeyDeviceMismatchError) ExportType() chat1.MessageUnboxedErrorType {
+	return chat1.MessageUnboxedErrorType_SENDERDEVICEMISMATCH
+}
+
+func (e SenderKeyDeviceMismatchError) ExportDetails() *chat1.UnboxingErrorDetails {
+	return &chat1.UnboxingErrorDetails{
+		Code:        chat1.UnboxingErrorCode_DEVICEKEYMISMATCH,
+		RejectedKID: e.KID,
+	}
+}
+
+// TLFIDMismatchError is returned when the TLF ID resolved from a message's
+// TLF name, public flag, and finalized info doesn't match the TLF ID the
+// server attached to the message's conversation triple. A server that's
+// lying about which TLF a message belongs to -- or rerouting it into the
+// wrong conversation -- would otherwise unbox successfully, since the TLF
+// name drives the key lookup and the signature only covers the header's
+// own fields, not the triple the server sent alongside it.
+type TLFIDMismatchError struct {
+	TlfName  string
+	Expected chat1.TLFID
+	Actual   chat1.TLFID
+}
+
+func (e TLFIDMismatchError) Error() string {
+	return fmt.Sprintf("TLF ID mismatch for %q: resolved %s, but conversation triple says %s",
+		e.TlfName, e.Expected, e.Actual)
+}
+
+func (e TLFIDMismatchError) ExportType() chat1.MessageUnboxedErrorType {
+	return chat1.MessageUnboxedErrorType_TLFIDMISMATCH
+}
+
+// TlfPublicMismatchError is returned when the decrypted header's TlfPublic
+// flag disagrees with the outer, server-supplied ClientHeader.TlfPublic --
+// the flag UnboxMessage's caller used to pick which key path (public or
+// private) to look up in the first place. The header signature covers the
+// decrypted header's own TlfPublic, but not the outer one, so a server that
+// swapped it wouldn't otherwise be caught until something downstream acted
+// on the wrong flag.
+type TlfPublicMismatchError struct {
+	HeaderTlfPublic bool
+	OuterTlfPublic  bool
+}
+
+func (e TlfPublicMismatchError) Error() string {
+	return fmt.Sprintf("TlfPublic mismatch: decrypted header says %v, but outer header says %v",
+		e.HeaderTlfPublic, e.OuterTlfPublic)
+}
+
+// MerkleRootFreshnessError is returned when SetCheckMerkleRootFreshness is
+// enabled and a message's claimed merkle root has a sequence number far
+// ahead of the latest root this client has cached. A real message can't be
+// anchored to a root this client hasn't heard of yet; a root that far
+// ahead is a much more likely sign that it was fabricated to make the
+// message look more recently anchored than it is.
+type MerkleRootFreshnessError struct {
+	ClaimedSeqno int64
+	CachedSeqno  int64
+}
+
+func (e MerkleRootFreshnessError) Error() string {
+	return fmt.Sprintf("message claims merkle seqno %d, far ahead of the latest cached seqno %d",
+		e.ClaimedSeqno, e.CachedSeqno)
+}
+
+func (e MerkleRootFreshnessError) ExportType() chat1.MessageUnboxedErrorType {
+	return chat1.MessageUnboxedErrorType_SUSPICIOUSMERKLEROOT
+}
+
+func (e MerkleRootFreshnessError) ExportDetails() *chat1.UnboxingErrorDetails {
+	return &chat1.UnboxingErrorDetails{
+		Code:               chat1.UnboxingErrorCode_FUTUREMERKLEROOT,
+		ClaimedMerkleSeqno: &e.ClaimedSeqno,
+		CachedMerkleSeqno:  &e.CachedSeqno,
+	}
+}
+
 type VersionError struct {
 	Kind     string
 	Version  int
@@ -171,6 +504,14 @@ func (e VersionError) ExportType() chat1.MessageUnboxedErrorType {
 	return chat1.MessageUnboxedErrorType_BADVERSION
 }
 
+func (e VersionError) ExportDetails() *chat1.UnboxingErrorDetails {
+	version := e.Version
+	return &chat1.UnboxingErrorDetails{
+		Code:               chat1.UnboxingErrorCode_UNSUPPORTEDVERSION,
+		UnsupportedVersion: &version,
+	}
+}
+
 func NewHeaderVersionError(version chat1.HeaderPlaintextVersion,
 	defaultHeader chat1.HeaderPlaintextUnsupported) VersionError {
 	return VersionError{
@@ -190,6 +531,16 @@ func NewBodyVersionError(version chat1.BodyPlaintextVersion, defaultBody chat1.B
 
 //=============================================================================
 
+type DuplicateKeyGenerationError struct {
+	KeyGeneration int
+}
+
+func (e DuplicateKeyGenerationError) Error() string {
+	return fmt.Sprintf("duplicate crypt key generation found: %d", e.KeyGeneration)
+}
+
+//=============================================================================
+
 type OfflineError struct {
 }
 
@@ -2,6 +2,51 @@ package chat
 
 import "github.com/keybase/client/go/protocol/chat1"
 
+// prevPointerKey identifies a single (referenced message, expected hash)
+// fact asserted by a prev pointer, for deduping validation across a batch
+// of messages that repeat the same prev pointer.
+type prevPointerKey struct {
+	id   chat1.MessageID
+	hash string
+}
+
+// PrevPointerChecker validates a single previous-message pointer, returning
+// a non-nil error if it's inconsistent.
+type PrevPointerChecker func(prev chat1.MessagePreviousPointer) error
+
+// CheckPrevPointersBatch runs checker once per distinct (MessageID, Hash)
+// pair referenced across every message's ClientHeader.Prev list in msgs,
+// then maps each message that referenced an invalid pointer to the error
+// checker returned for it. In a dense thread, many messages point back to
+// the same handful of recent messages with the same hash, so deduping here
+// avoids calling checker once per occurrence instead of once per distinct
+// fact -- checker is a pure function of the pointer, so the per-message
+// result is identical either way.
+func CheckPrevPointersBatch(msgs []chat1.MessageUnboxedValid, checker PrevPointerChecker) map[chat1.MessageID]error {
+	results := make(map[prevPointerKey]error)
+	for _, msg := range msgs {
+		for _, prev := range msg.ClientHeader.Prev {
+			key := prevPointerKey{id: prev.Id, hash: prev.Hash.String()}
+			if _, done := results[key]; done {
+				continue
+			}
+			results[key] = checker(prev)
+		}
+	}
+
+	perMessage := make(map[chat1.MessageID]error)
+	for _, msg := range msgs {
+		for _, prev := range msg.ClientHeader.Prev {
+			key := prevPointerKey{id: prev.Id, hash: prev.Hash.String()}
+			if err := results[key]; err != nil {
+				perMessage[msg.ServerHeader.MessageID] = err
+				break
+			}
+		}
+	}
+	return perMessage
+}
+
 // Ingest a ThreadView, check several invariants, and produce a list of prev
 // pointers to not-yet-pointed-to messages. Check several invariants at the
 // same time:
@@ -144,6 +144,75 @@ func TestPrevOutOfOrder(t *testing.T) {
 	expectCode(t, err, OutOfOrderID)
 }
 
+func validsFromDummies(dummies []dummyMessage) []chat1.MessageUnboxedValid {
+	var valids []chat1.MessageUnboxedValid
+	for _, dummy := range dummies {
+		valids = append(valids, chat1.MessageUnboxedValid{
+			HeaderHash: dummy.hash,
+			ServerHeader: chat1.MessageServerHeader{
+				MessageID: dummy.id,
+			},
+			ClientHeader: chat1.MessageClientHeader{
+				Prev: dummy.prevs,
+			},
+		})
+	}
+	return valids
+}
+
+func TestCheckPrevPointersBatchDedupesRepeatedPointers(t *testing.T) {
+	// Five messages all point back to message 1 with the same hash, so a
+	// checker that counts its calls should only see that pointer once.
+	dummies := []dummyMessage{
+		{id: 1, hash: []byte("hash-1")},
+	}
+	for id := chat1.MessageID(2); id <= 6; id++ {
+		dummies = append(dummies, dummyMessage{
+			id: id,
+			prevs: []chat1.MessagePreviousPointer{
+				{Id: 1, Hash: []byte("hash-1")},
+			},
+		})
+	}
+
+	calls := 0
+	checker := func(prev chat1.MessagePreviousPointer) error {
+		calls++
+		return nil
+	}
+
+	bad := CheckPrevPointersBatch(validsFromDummies(dummies), checker)
+	if len(bad) != 0 {
+		t.Fatalf("expected no bad messages, got %v", bad)
+	}
+	if calls != 1 {
+		t.Fatalf("expected checker to be called once for the distinct prev pointer, got %d calls", calls)
+	}
+}
+
+func TestCheckPrevPointersBatchReportsPerMessageResults(t *testing.T) {
+	dummies := []dummyMessage{
+		{id: 1, hash: []byte("hash-1")},
+		{id: 2, prevs: []chat1.MessagePreviousPointer{{Id: 1, Hash: []byte("hash-1")}}},
+		{id: 3, prevs: []chat1.MessagePreviousPointer{{Id: 1, Hash: []byte("wrong-hash")}}},
+	}
+
+	checker := func(prev chat1.MessagePreviousPointer) error {
+		if !prev.Hash.Eq([]byte("hash-1")) {
+			return NewChatThreadConsistencyError(IncorrectHash, "bad prev pointer to %d", prev.Id)
+		}
+		return nil
+	}
+
+	bad := CheckPrevPointersBatch(validsFromDummies(dummies), checker)
+	if len(bad) != 1 {
+		t.Fatalf("expected exactly one bad message, got %v", bad)
+	}
+	if _, ok := bad[3]; !ok {
+		t.Fatalf("expected message 3 to be reported bad, got %v", bad)
+	}
+}
+
 func TestPrevIncorrectHash(t *testing.T) {
 	thread := threadViewFromDummies([]dummyMessage{
 		dummyMessage{
@@ -300,7 +300,7 @@ func (s *BlockingSender) Prepare(ctx context.Context, plaintext chat1.MessagePla
 
 	// For now, BoxMessage canonicalizes the TLF name. We should try to refactor
 	// it a bit to do it here.
-	boxed, err := s.boxer.BoxMessage(ctx, msg, skp)
+	boxed, _, err := s.boxer.BoxMessage(ctx, msg, skp, convID, BoxMessageOptions{})
 	if err != nil {
 		return nil, nil, err
 	}
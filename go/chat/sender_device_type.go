@@ -0,0 +1,29 @@
+package chat
+
+import (
+	"context"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/chat1"
+)
+
+// parseSenderDeviceType maps the device-type string the UPAK loader hands
+// back (one of libkb.DeviceTypeDesktop/Mobile/Paper) to the typed
+// chat1.SenderDeviceType a caller can switch on, rather than having to
+// compare against libkb's raw string constants itself. An unrecognized
+// string -- e.g. a device type this client predates -- maps to
+// SenderDeviceType_UNKNOWN, logged so it's visible without digging through
+// every caller's own debug output.
+func (b *Boxer) parseSenderDeviceType(ctx context.Context, raw string) chat1.SenderDeviceType {
+	switch raw {
+	case libkb.DeviceTypeDesktop:
+		return chat1.SenderDeviceType_DESKTOP
+	case libkb.DeviceTypeMobile:
+		return chat1.SenderDeviceType_MOBILE
+	case libkb.DeviceTypePaper:
+		return chat1.SenderDeviceType_PAPER
+	default:
+		b.Debug(ctx, "unrecognized sender device type: %q", raw)
+		return chat1.SenderDeviceType_UNKNOWN
+	}
+}
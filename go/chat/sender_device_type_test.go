@@ -0,0 +1,37 @@
+package chat
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/client/go/externals"
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/chat1"
+	"github.com/stretchr/testify/require"
+)
+
+// TestChatParseSenderDeviceType checks that every device-type string the
+// UPAK loader can hand back maps to its corresponding chat1.SenderDeviceType,
+// and that anything else falls back to the UNKNOWN sentinel rather than
+// failing outright.
+func TestChatParseSenderDeviceType(t *testing.T) {
+	tc := externals.SetupTest(t, "parse-sender-device-type", 2)
+	defer tc.Cleanup()
+	b := NewBoxer(tc.G, nil)
+	ctx := context.Background()
+
+	cases := []struct {
+		raw  string
+		want chat1.SenderDeviceType
+	}{
+		{libkb.DeviceTypeDesktop, chat1.SenderDeviceType_DESKTOP},
+		{libkb.DeviceTypeMobile, chat1.SenderDeviceType_MOBILE},
+		{libkb.DeviceTypePaper, chat1.SenderDeviceType_PAPER},
+		{"some-future-device-type", chat1.SenderDeviceType_UNKNOWN},
+		{"", chat1.SenderDeviceType_UNKNOWN},
+	}
+	for _, c := range cases {
+		require.Equal(t, c.want, b.parseSenderDeviceType(ctx, c.raw), "raw device type %q", c.raw)
+	}
+}
@@ -0,0 +1,106 @@
+package chat
+
+import (
+	"sync"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// senderKeyCacheKey identifies a sender's signing key within the merkle
+// era a senderKeyCache entry was populated in.
+type senderKeyCacheKey struct {
+	uid string
+	kid string
+}
+
+// senderKeyCacheEntry mirrors the three return values of
+// CachedUserLoader.CheckKIDForUID: whether the key was ever found for the
+// sender, and if so, when (if ever) it was revoked or deleted.
+type senderKeyCacheEntry struct {
+	found     bool
+	revokedAt *keybase1.KeybaseTime
+	deleted   bool
+}
+
+// senderKeyCache memoizes ValidSenderKey's underlying CheckKIDForUID
+// lookups for the current merkle era. CheckKIDForUID's answer for a given
+// (uid, kid) is stable as of a merkle seqno, so a thread with many
+// messages from the same few senders doesn't need to redo the lookup for
+// every message, only once per sender per era.
+//
+// The whole cache is dropped whenever the observed merkle seqno advances,
+// rather than pruning individual stale entries -- simpler, and correct,
+// since an entry is only ever consulted within the era it was populated
+// in.
+type senderKeyCache struct {
+	sync.Mutex
+	seqno   int64
+	entries map[senderKeyCacheKey]senderKeyCacheEntry
+}
+
+func newSenderKeyCache() *senderKeyCache {
+	return &senderKeyCache{
+		entries: make(map[senderKeyCacheKey]senderKeyCacheEntry),
+	}
+}
+
+// callSenderKeyCache is a simpler companion to senderKeyCache: instead of
+// being keyed to the Boxer's current merkle era, it lives only for the
+// duration of a single UnboxMessages/UnboxMessagesStream call (see
+// ctxCallSenderKeyCache), so it has no era to roll over and can memoize a
+// (UID, KID) lookup unconditionally, even on a call where a merkle root
+// fetch fails or hasn't happened yet and senderKeyCache can't be
+// consulted at all. That matters most for a public channel, where every
+// message might come from a different sender but still needs
+// CheckKIDForUID run at most once per distinct sender within the batch.
+type callSenderKeyCache struct {
+	sync.Mutex
+	entries map[senderKeyCacheKey]senderKeyCacheEntry
+}
+
+func newCallSenderKeyCache() *callSenderKeyCache {
+	return &callSenderKeyCache{
+		entries: make(map[senderKeyCacheKey]senderKeyCacheEntry),
+	}
+}
+
+func (c *callSenderKeyCache) get(key senderKeyCacheKey) (senderKeyCacheEntry, bool) {
+	c.Lock()
+	defer c.Unlock()
+	entry, ok := c.entries[key]
+	return entry, ok
+}
+
+func (c *callSenderKeyCache) put(key senderKeyCacheKey, entry senderKeyCacheEntry) {
+	c.Lock()
+	defer c.Unlock()
+	c.entries[key] = entry
+}
+
+// get looks up key within the era identified by seqno. A seqno that
+// doesn't match the cache's current era rotates it out (clearing every
+// entry from the stale era) before reporting a miss.
+func (c *senderKeyCache) get(seqno int64, key senderKeyCacheKey) (senderKeyCacheEntry, bool) {
+	c.Lock()
+	defer c.Unlock()
+	if seqno != c.seqno {
+		c.seqno = seqno
+		c.entries = make(map[senderKeyCacheKey]senderKeyCacheEntry)
+		return senderKeyCacheEntry{}, false
+	}
+	entry, ok := c.entries[key]
+	return entry, ok
+}
+
+// put records entry for key within the era identified by seqno. If the
+// era has already moved on since the caller's get (e.g. a concurrent
+// lookup rotated the cache first), the entry is dropped instead of being
+// filed under the wrong era.
+func (c *senderKeyCache) put(seqno int64, key senderKeyCacheKey, entry senderKeyCacheEntry) {
+	c.Lock()
+	defer c.Unlock()
+	if seqno != c.seqno {
+		return
+	}
+	c.entries[key] = entry
+}
@@ -33,6 +33,7 @@ func (t *supersedesTransform) transformEdit(msg chat1.MessageUnboxed, superMsg c
 		SenderUsername:        msg.Valid().SenderUsername,
 		SenderDeviceName:      msg.Valid().SenderDeviceName,
 		SenderDeviceType:      msg.Valid().SenderDeviceType,
+		SenderDeviceTypeEnum:  msg.Valid().SenderDeviceTypeEnum,
 		HeaderHash:            msg.Valid().HeaderHash,
 		HeaderSignature:       msg.Valid().HeaderSignature,
 		SenderDeviceRevokedAt: msg.Valid().SenderDeviceRevokedAt,
@@ -60,6 +61,7 @@ func (t *supersedesTransform) transformAttachment(msg chat1.MessageUnboxed, supe
 		SenderUsername:        msg.Valid().SenderUsername,
 		SenderDeviceName:      msg.Valid().SenderDeviceName,
 		SenderDeviceType:      msg.Valid().SenderDeviceType,
+		SenderDeviceTypeEnum:  msg.Valid().SenderDeviceTypeEnum,
 		HeaderHash:            msg.Valid().HeaderHash,
 		HeaderSignature:       msg.Valid().HeaderSignature,
 		SenderDeviceRevokedAt: msg.Valid().SenderDeviceRevokedAt,
@@ -24,6 +24,30 @@ func newCmdFS(cl *libcmdline.CommandLine, g *libkb.GlobalContext) cli.Command {
 		Usage:        "File system",
 		Subcommands: []cli.Command{
 			newCmdFSList(cl, g),
+			newCmdSimpleFSList(cl, g),
+			newCmdSimpleFSWrite(cl, g),
+			newCmdSimpleFSRead(cl, g),
+			newCmdSimpleFSCat(cl, g),
+			newCmdSimpleFSDownload(cl, g),
+			newCmdSimpleFSCopy(cl, g),
+			newCmdSimpleFSMove(cl, g),
+			newCmdSimpleFSMkdir(cl, g),
+			newCmdSimpleFSStat(cl, g),
+			newCmdSimpleFSResolve(cl, g),
+			newCmdSimpleFSRecent(cl, g),
+			newCmdSimpleFSFind(cl, g),
+			newCmdSimpleFSComplete(cl, g),
+			newCmdSimpleFSInfo(cl, g),
+			newCmdSimpleFSRemove(cl, g),
+			newCmdSimpleFSUndo(cl, g),
+			newCmdSimpleFSChmod(cl, g),
+			newCmdSimpleFSWatch(cl, g),
+			newCmdSimpleFSChecksum(cl, g),
+			newCmdSimpleFSDu(cl, g),
+			newCmdSimpleFSSync(cl, g),
+			newCmdSimpleFSOps(cl, g),
+			newCmdSimpleFSPs(cl, g),
+			newCmdSimpleFSShell(cl, g),
 		},
 	}
 }
@@ -44,7 +68,11 @@ func newCmdFSList(cl *libcmdline.CommandLine, g *libkb.GlobalContext) cli.Comman
 	}
 }
 
-func (c *cmdFSList) Run() error {
+func (c *cmdFSList) Run() (err error) {
+	defer func() {
+		err = fsFinishError(c.G(), err)
+	}()
+
 	arg := keybase1.ListArg{
 		Path: c.path,
 	}
@@ -0,0 +1,482 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	isatty "github.com/mattn/go-isatty"
+	"golang.org/x/net/context"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// simpleFSSniffSize is how many leading bytes of a file simpleFSDetectContentType
+// reads to sniff its content type. net/http.DetectContentType only looks at
+// the first 512 bytes, so reading more would just waste a round trip.
+const simpleFSSniffSize = 512
+
+// simpleFSDetectContentType samples the first simpleFSSniffSize bytes of
+// the file at path and returns the MIME type http.DetectContentType infers
+// from them. It returns "" without reading anything for directories and
+// symlinks, since "content type" isn't a meaningful question for either.
+func simpleFSDetectContentType(ctx context.Context, cli keybase1.SimpleFSInterface, path keybase1.Path, dirent keybase1.Dirent) (string, error) {
+	if dirent.DirentType == keybase1.DirentType_DIR || dirent.DirentType == keybase1.DirentType_SYM {
+		return "", nil
+	}
+
+	if !simpleFSIsKbfs(path) {
+		return simpleFSDetectLocalContentType(path.Local())
+	}
+
+	opid, err := cli.SimpleFSMakeOpid(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = cli.SimpleFSClose(ctx, opid) }()
+
+	if err := cli.SimpleFSOpen(ctx, keybase1.SimpleFSOpenArg{
+		OpID:  opid,
+		Dest:  path,
+		Flags: keybase1.OpenFlags_READ | keybase1.OpenFlags_EXISTING,
+	}); err != nil {
+		return "", err
+	}
+
+	content, err := cli.SimpleFSRead(ctx, keybase1.SimpleFSReadArg{
+		OpID: opid,
+		Size: simpleFSSniffSize,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return http.DetectContentType(content.Data), nil
+}
+
+// simpleFSDetectLocalContentType is simpleFSDetectContentType's local
+// filesystem counterpart, sampling the file directly instead of round
+// tripping through SimpleFSOpen/SimpleFSRead.
+func simpleFSDetectLocalContentType(localPath string) (string, error) {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	buf := make([]byte, simpleFSSniffSize)
+	n, err := f.Read(buf)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
+
+// kbfsPathPrefix is the path prefix that identifies a path as living inside
+// KBFS, as opposed to on the local filesystem.
+const kbfsPathPrefix = "/keybase/"
+
+// simpleFSPathFromArg turns a command line argument into a keybase1.Path,
+// routing it to KBFS or the local filesystem based on whether it lives
+// under /keybase/.
+func simpleFSPathFromArg(arg string) keybase1.Path {
+	if strings.HasPrefix(arg, kbfsPathPrefix) {
+		return keybase1.NewPathWithKbfs(path.Clean(arg))
+	}
+	return keybase1.NewPathWithLocal(arg)
+}
+
+// simpleFSIsKbfs returns true if p refers to a path inside KBFS.
+func simpleFSIsKbfs(p keybase1.Path) bool {
+	t, err := p.PathType()
+	return err == nil && t == keybase1.PathType_KBFS
+}
+
+// simpleFSPathString returns p's underlying path string, whether it's a
+// KBFS or a local path.
+func simpleFSPathString(p keybase1.Path) string {
+	if simpleFSIsKbfs(p) {
+		return p.Kbfs()
+	}
+	return p.Local()
+}
+
+// simpleFSDescribePath renders p the way --dry-run output does, tagging it
+// with "local:" or "kbfs:" so a plan that copies or deletes across both
+// kinds of path in the same line doesn't leave which is which to guesswork.
+func simpleFSDescribePath(p keybase1.Path) string {
+	if simpleFSIsKbfs(p) {
+		return "kbfs:" + p.Kbfs()
+	}
+	return "local:" + p.Local()
+}
+
+// simpleFSPathTLFType extracts the TLF type ("private", "public", "team")
+// and TLF name out of a KBFS path, which may point anywhere inside the
+// TLF (e.g. /keybase/private/alice,bob/docs/report.txt), unlike ParseTLF,
+// which only accepts a bare TLF root. ok is false for a path that isn't
+// under kbfsPathPrefix, or doesn't have a TLF name component yet (e.g.
+// /keybase/private on its own).
+func simpleFSPathTLFType(kbfsPath string) (tlfType, tlfName string, ok bool) {
+	if !strings.HasPrefix(kbfsPath, kbfsPathPrefix) {
+		return "", "", false
+	}
+	rel := strings.TrimPrefix(kbfsPath, kbfsPathPrefix)
+	parts := strings.SplitN(rel, "/", 3)
+	if len(parts) < 2 || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// simpleFSMkdirParent ensures that the parent directory of dest exists in
+// KBFS, creating intermediate directories as needed (the equivalent of
+// `mkdir -p` on the destination's parent). It is a no-op for local
+// destinations, since os.OpenFile/os.Create already fail informatively
+// there.
+func simpleFSMkdirParent(ctx context.Context, cli keybase1.SimpleFSInterface, dest keybase1.Path) error {
+	if !simpleFSIsKbfs(dest) {
+		return nil
+	}
+	parent := path.Dir(dest.Kbfs())
+	return simpleFSMkdirAll(ctx, cli, parent)
+}
+
+// simpleFSMkdirAll creates kbfsPath and all of its missing parents, in the
+// style of `mkdir -p`. It tolerates directories that already exist.
+func simpleFSMkdirAll(ctx context.Context, cli keybase1.SimpleFSInterface, kbfsPath string) error {
+	kbfsPath = path.Clean(kbfsPath)
+	if kbfsPath == "/" || kbfsPath == "." || !strings.HasPrefix(kbfsPath, kbfsPathPrefix) {
+		return nil
+	}
+
+	// Walk from the root down, creating each missing path component.
+	// /keybase/private/alice/foo/bar -> [/keybase/private, /keybase/private/alice, ...]
+	rel := strings.TrimPrefix(kbfsPath, kbfsPathPrefix)
+	cur := strings.TrimSuffix(kbfsPathPrefix, "/")
+	for _, part := range strings.Split(rel, "/") {
+		if part == "" {
+			continue
+		}
+		cur = cur + "/" + part
+		if err := simpleFSMkdirOne(ctx, cli, cur); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// simpleFSMkdirOne creates a single KBFS directory, treating "already
+// exists" as success.
+func simpleFSMkdirOne(ctx context.Context, cli keybase1.SimpleFSInterface, kbfsPath string) error {
+	opid, err := cli.SimpleFSMakeOpid(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = cli.SimpleFSClose(ctx, opid) }()
+
+	err = cli.SimpleFSOpen(ctx, keybase1.SimpleFSOpenArg{
+		OpID:  opid,
+		Dest:  keybase1.NewPathWithKbfs(kbfsPath),
+		Flags: keybase1.OpenFlags_DIRECTORY,
+	})
+	if err != nil && !isSimpleFSExistsErr(err) {
+		return err
+	}
+	return nil
+}
+
+// isSimpleFSExistsErr reports whether err indicates that a file or
+// directory SimpleFS tried to create already exists.
+func isSimpleFSExistsErr(err error) bool {
+	return err != nil && strings.Contains(strings.ToLower(err.Error()), "exist")
+}
+
+// isSimpleFSMissingParentErr reports whether err looks like it was caused
+// by a missing parent directory on the KBFS side.
+func isSimpleFSMissingParentErr(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "no such file") || strings.Contains(msg, "not found") ||
+		strings.Contains(msg, "not a directory")
+}
+
+// errDestParentMissing is returned by fs subcommands when the parent of the
+// destination path doesn't exist and --make-parents wasn't given.
+func errDestParentMissing(dest string) error {
+	return fmt.Errorf("parent directory of %q does not exist (use --make-parents to create it)", dest)
+}
+
+// simpleFSLocalMkdirParent creates the local parent directory of dest when
+// makeParents is set, mirroring simpleFSMkdirParent for the KBFS side.
+func simpleFSLocalMkdirParent(dest string, makeParents bool) error {
+	dir := path.Dir(dest)
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+	if !makeParents {
+		return errDestParentMissing(dest)
+	}
+	return os.MkdirAll(dir, 0755)
+}
+
+// existingFilePolicy controls what a copy-like fs subcommand does when a
+// destination path already exists. It is shared by cp (and, as they grow
+// recursive/glob support, by anything else that can clobber many files at
+// once) so the behaviors compose the same way everywhere.
+type existingFilePolicy string
+
+const (
+	existingPolicyPrompt    existingFilePolicy = "prompt"
+	existingPolicySkip      existingFilePolicy = "skip"
+	existingPolicyOverwrite existingFilePolicy = "overwrite"
+	existingPolicyUpdate    existingFilePolicy = "update"
+)
+
+func parseExistingFilePolicy(s string) (existingFilePolicy, error) {
+	switch existingFilePolicy(s) {
+	case "", existingPolicyPrompt:
+		return existingPolicyPrompt, nil
+	case existingPolicySkip:
+		return existingPolicySkip, nil
+	case existingPolicyOverwrite:
+		return existingPolicyOverwrite, nil
+	case existingPolicyUpdate:
+		return existingPolicyUpdate, nil
+	default:
+		return "", fmt.Errorf("invalid --existing value %q (want prompt, skip, overwrite, or update)", s)
+	}
+}
+
+// simpleFSExists reports whether p refers to an existing local or KBFS
+// path.
+func simpleFSExists(ctx context.Context, cli keybase1.SimpleFSInterface, p keybase1.Path) bool {
+	if !simpleFSIsKbfs(p) {
+		_, err := os.Stat(p.Local())
+		return err == nil
+	}
+	_, err := cli.SimpleFSStat(ctx, p)
+	return err == nil
+}
+
+// simpleFSBatchStatConcurrency bounds how many SimpleFSStat calls
+// simpleFSBatchStat has in flight at once, so a listing with thousands of
+// entries doesn't open thousands of simultaneous RPCs.
+const simpleFSBatchStatConcurrency = 8
+
+// simpleFSStatResult is one path's outcome from simpleFSBatchStat. Err is
+// set instead of aborting the batch when that one path's stat fails, so a
+// single bad entry in a long listing doesn't block everyone else's.
+type simpleFSStatResult struct {
+	Path   keybase1.Path
+	Dirent keybase1.Dirent
+	Err    error
+}
+
+// simpleFSBatchStat stats paths concurrently, bounded by
+// simpleFSBatchStatConcurrency, and returns one simpleFSStatResult per
+// path in the same order as paths. This is for callers like `fs ls -l`
+// that need per-entry metadata beyond what SimpleFSList's Dirents carry,
+// and would otherwise pay one serial round trip per entry.
+func simpleFSBatchStat(ctx context.Context, cli keybase1.SimpleFSInterface, paths []keybase1.Path) []simpleFSStatResult {
+	results := make([]simpleFSStatResult, len(paths))
+	sem := make(chan struct{}, simpleFSBatchStatConcurrency)
+	var wg sync.WaitGroup
+	for i, p := range paths {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, p keybase1.Path) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			dirent, err := cli.SimpleFSStat(ctx, p)
+			results[i] = simpleFSStatResult{Path: p, Dirent: dirent, Err: err}
+		}(i, p)
+	}
+	wg.Wait()
+	return results
+}
+
+// simpleFSModTime returns p's modification time, for local or KBFS paths.
+func simpleFSModTime(ctx context.Context, cli keybase1.SimpleFSInterface, p keybase1.Path) (time.Time, error) {
+	if !simpleFSIsKbfs(p) {
+		info, err := os.Stat(p.Local())
+		if err != nil {
+			return time.Time{}, err
+		}
+		return info.ModTime(), nil
+	}
+	dirent, err := cli.SimpleFSStat(ctx, p)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return dirent.Time.Time(), nil
+}
+
+// simpleFSIsDir reports whether p refers to an existing directory, for
+// local or KBFS paths alike.
+func simpleFSIsDir(ctx context.Context, cli keybase1.SimpleFSInterface, p keybase1.Path) (bool, error) {
+	if !simpleFSIsKbfs(p) {
+		info, err := os.Stat(p.Local())
+		if err != nil {
+			return false, err
+		}
+		return info.IsDir(), nil
+	}
+	dirent, err := cli.SimpleFSStat(ctx, p)
+	if err != nil {
+		return false, err
+	}
+	return dirent.DirentType == keybase1.DirentType_DIR, nil
+}
+
+// simpleFSSize returns p's size in bytes, for local or KBFS paths alike.
+func simpleFSSize(ctx context.Context, cli keybase1.SimpleFSInterface, p keybase1.Path) (int64, error) {
+	if !simpleFSIsKbfs(p) {
+		info, err := os.Stat(p.Local())
+		if err != nil {
+			return 0, err
+		}
+		return info.Size(), nil
+	}
+	dirent, err := cli.SimpleFSStat(ctx, p)
+	if err != nil {
+		return 0, err
+	}
+	return int64(dirent.Size), nil
+}
+
+// simpleFSStatDirent stats p and returns a Dirent describing it, for local
+// or KBFS paths alike -- the same local/KBFS split as simpleFSModTime,
+// simpleFSIsDir, and simpleFSSize, but returning the full Dirent `fs stat`
+// needs instead of one field at a time. A local Dirent's Name is the
+// path's base name, since there's no RPC response to source it from the
+// way SimpleFSStat's is.
+func simpleFSStatDirent(ctx context.Context, cli keybase1.SimpleFSInterface, p keybase1.Path) (keybase1.Dirent, error) {
+	if !simpleFSIsKbfs(p) {
+		localPath := p.Local()
+		info, err := os.Stat(localPath)
+		if err != nil {
+			return keybase1.Dirent{}, err
+		}
+		direntType := keybase1.DirentType_FILE
+		switch {
+		case info.IsDir():
+			direntType = keybase1.DirentType_DIR
+		case info.Mode()&os.ModeSymlink != 0:
+			direntType = keybase1.DirentType_SYM
+		case info.Mode()&0111 != 0:
+			direntType = keybase1.DirentType_EXEC
+		}
+		return keybase1.Dirent{
+			Name:       filepath.Base(localPath),
+			Size:       int(info.Size()),
+			Time:       keybase1.ToTime(info.ModTime()),
+			DirentType: direntType,
+		}, nil
+	}
+	return cli.SimpleFSStat(ctx, p)
+}
+
+// simpleFSConfirmOverwrite decides whether a copy-like operation may
+// proceed when dest already exists, applying policy and prompting the user
+// interactively when policy is "prompt". It fails safe: --force (i.e.
+// policy overwrite) is required to clobber files from a non-interactive
+// session, and a bare "fs cp" with an existing destination and no TTY
+// refuses rather than silently overwriting.
+//
+// srcNewer is only consulted for existingPolicyUpdate: it must report
+// whether the source is newer than dest, which decides whether to
+// overwrite (source newer) or skip (dest already as new or newer). It's a
+// func instead of a plain bool so callers that don't use "update" never
+// pay for stat'ing both sides.
+func simpleFSConfirmOverwrite(g *libkb.GlobalContext, policy existingFilePolicy, dest string, srcNewer func() (bool, error)) (proceed bool, err error) {
+	switch policy {
+	case existingPolicyOverwrite:
+		return true, nil
+	case existingPolicySkip:
+		return false, nil
+	case existingPolicyUpdate:
+		return srcNewer()
+	}
+
+	if !isatty.IsTerminal(os.Stdin.Fd()) {
+		return false, fmt.Errorf("%q already exists; use --force to overwrite in a non-interactive session", dest)
+	}
+
+	tui := g.UI.GetTerminalUI()
+	if tui == nil {
+		return false, fmt.Errorf("%q already exists and no terminal is available to confirm overwriting it", dest)
+	}
+	ok, err := tui.PromptYesNo(PromptDescriptorFSOverwrite,
+		fmt.Sprintf("%q already exists. Overwrite?", dest), libkb.PromptDefaultNo)
+	if err != nil {
+		return false, err
+	}
+	return ok, nil
+}
+
+// simpleFSOpSummary is the final report --summary prints for `fs cp`,
+// `fs mv`, and `fs rm`. It reports one outcome per invocation, not a plan
+// checked off item by item: any recursion into a directory happens inside
+// a single SimpleFSCopy/SimpleFSMove/SimpleFSRemove opid on the service
+// side (or, for `fs mv` across the local/KBFS boundary, inside the CLI's
+// own copy-then-verify-then-delete sequence), and a glob <source> given to
+// `fs cp` copies every match but is still reported as a single item. So
+// the counts here are always 0 or 1. cp and rm's --dry-run modes bypass
+// this summary entirely (they print a plan instead of an outcome, and
+// never reach this path). The summary has no effect on the process exit
+// code, which fsFinishError sets from the returned error alone.
+type simpleFSOpSummary struct {
+	Verb string // e.g. "copied", "removed"
+
+	Start time.Time
+	Err   error
+
+	// BytesTransferred and BytesKnown describe how much data moved.
+	// BytesKnown is false when the transfer happened inside a single
+	// opaque SimpleFSCopy RPC (the common case for a KBFS-to-KBFS or
+	// local-to-KBFS copy): SimpleFSCheck only reports a percentage, not a
+	// byte count, so there's nothing honest to put there. It's true for
+	// an http(s) source, where the CLI itself streams the bytes and so
+	// already knows how many there were.
+	BytesTransferred int64
+	BytesKnown       bool
+}
+
+// printSimpleFSOpSummary prints summary as a single line to stderr, unless
+// quiet is set.
+func printSimpleFSOpSummary(summary simpleFSOpSummary, quiet bool) {
+	if quiet {
+		return
+	}
+
+	items := 1
+	failures := 0
+	if summary.Err != nil {
+		items = 0
+		failures = 1
+	}
+
+	bytes := "unknown bytes"
+	if summary.BytesKnown {
+		bytes = fmt.Sprintf("%d bytes", summary.BytesTransferred)
+	}
+
+	fmt.Fprintf(os.Stderr, "%d %s, %s, %d failures, %s\n",
+		items, summary.Verb, bytes, failures, time.Since(summary.Start))
+	if summary.Err != nil {
+		fmt.Fprintf(os.Stderr, "  %s\n", summary.Err)
+	}
+}
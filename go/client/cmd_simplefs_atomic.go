@@ -0,0 +1,82 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// simpleFSAtomicTempDest returns a temporary path in the same KBFS
+// directory as dest, suitable for writing the new content to before
+// renaming it into place. ok is false if dest isn't a KBFS path (there's
+// no rename that would make a non-KBFS write atomic this way) or has no
+// containing directory to put a sibling temp file in.
+func simpleFSAtomicTempDest(dest keybase1.Path, now time.Time) (tmp keybase1.Path, ok bool) {
+	if !simpleFSIsKbfs(dest) {
+		return keybase1.Path{}, false
+	}
+	kbfsPath := path.Clean(dest.Kbfs())
+	dir := path.Dir(kbfsPath)
+	if dir == "." || dir == "/" || !strings.HasPrefix(dir, kbfsPathPrefix) {
+		return keybase1.Path{}, false
+	}
+	name := fmt.Sprintf(".%s.tmp.%d", path.Base(kbfsPath), now.UnixNano())
+	return keybase1.NewPathWithKbfs(path.Join(dir, name)), true
+}
+
+// simpleFSAtomicPublish writes dest atomically: write is called with a
+// temporary path alongside dest to put the new content in, and once write
+// succeeds, that temp path is renamed into dest with a single SimpleFSMove
+// -- so a reader polling dest never sees a partially-written file. If
+// write, or the rename itself, fails, the temp path is removed
+// (best-effort) and the original error is returned; dest is left
+// untouched either way.
+func simpleFSAtomicPublish(ctx context.Context, cli keybase1.SimpleFSInterface, dest keybase1.Path, now time.Time, write func(tmp keybase1.Path) error) error {
+	tmp, ok := simpleFSAtomicTempDest(dest, now)
+	if !ok {
+		return fmt.Errorf("fs: --atomic requires a /keybase/... destination with a containing directory, got %q", simpleFSPathString(dest))
+	}
+
+	if err := write(tmp); err != nil {
+		simpleFSAtomicCleanup(ctx, cli, tmp)
+		return err
+	}
+
+	opid, err := cli.SimpleFSMakeOpid(ctx)
+	if err != nil {
+		simpleFSAtomicCleanup(ctx, cli, tmp)
+		return err
+	}
+	defer func() { _ = cli.SimpleFSClose(ctx, opid) }()
+
+	if err := cli.SimpleFSMove(ctx, keybase1.SimpleFSMoveArg{OpID: opid, Src: tmp, Dest: dest}); err != nil {
+		simpleFSAtomicCleanup(ctx, cli, tmp)
+		return err
+	}
+	return cli.SimpleFSWait(ctx, opid)
+}
+
+// simpleFSAtomicCleanup best-effort removes a temp path left behind by a
+// failed simpleFSAtomicPublish. Its own errors are swallowed: the write or
+// rename failure that triggered the cleanup is what the caller should see,
+// and a temp file this fails to remove is no worse than the ones any
+// ordinary crash mid-write can leave lying around.
+func simpleFSAtomicCleanup(ctx context.Context, cli keybase1.SimpleFSInterface, tmp keybase1.Path) {
+	opid, err := cli.SimpleFSMakeOpid(ctx)
+	if err != nil {
+		return
+	}
+	defer func() { _ = cli.SimpleFSClose(ctx, opid) }()
+	if err := cli.SimpleFSRemove(ctx, keybase1.SimpleFSRemoveArg{OpID: opid, Path: tmp}); err != nil {
+		return
+	}
+	_ = cli.SimpleFSWait(ctx, opid)
+}
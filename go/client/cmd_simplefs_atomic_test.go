@@ -0,0 +1,166 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// fakeSimpleFSAtomicClient is a minimal keybase1.SimpleFSInterface that
+// records the moves and removes made against it, so simpleFSAtomicPublish
+// can be exercised without a real SimpleFS daemon.
+type fakeSimpleFSAtomicClient struct {
+	keybase1.SimpleFSInterface // panics on anything not overridden below
+
+	moveArgs    []keybase1.SimpleFSMoveArg
+	removeArgs  []keybase1.SimpleFSRemoveArg
+	moveErr     error
+	makeOpidErr error
+}
+
+func (f *fakeSimpleFSAtomicClient) SimpleFSMakeOpid(ctx context.Context) (keybase1.OpID, error) {
+	return keybase1.OpID{}, f.makeOpidErr
+}
+
+func (f *fakeSimpleFSAtomicClient) SimpleFSClose(ctx context.Context, opid keybase1.OpID) error {
+	return nil
+}
+
+func (f *fakeSimpleFSAtomicClient) SimpleFSWait(ctx context.Context, opid keybase1.OpID) error {
+	return nil
+}
+
+func (f *fakeSimpleFSAtomicClient) SimpleFSMove(ctx context.Context, arg keybase1.SimpleFSMoveArg) error {
+	f.moveArgs = append(f.moveArgs, arg)
+	return f.moveErr
+}
+
+func (f *fakeSimpleFSAtomicClient) SimpleFSRemove(ctx context.Context, arg keybase1.SimpleFSRemoveArg) error {
+	f.removeArgs = append(f.removeArgs, arg)
+	return nil
+}
+
+func TestSimpleFSAtomicTempDest(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+
+	t.Run("kbfs path", func(t *testing.T) {
+		dest := keybase1.NewPathWithKbfs("/keybase/private/alice/config.json")
+		tmp, ok := simpleFSAtomicTempDest(dest, now)
+		if !ok {
+			t.Fatal("expected ok=true")
+		}
+		want := "/keybase/private/alice/.config.json.tmp.1600000000000000000"
+		if tmp.Kbfs() != want {
+			t.Errorf("expected temp path %q, got %q", want, tmp.Kbfs())
+		}
+	})
+
+	t.Run("local path", func(t *testing.T) {
+		if _, ok := simpleFSAtomicTempDest(keybase1.NewPathWithLocal("/tmp/config.json"), now); ok {
+			t.Error("expected ok=false for a local path")
+		}
+	})
+
+	t.Run("kbfs path with no containing directory", func(t *testing.T) {
+		if _, ok := simpleFSAtomicTempDest(keybase1.NewPathWithKbfs("/keybase"), now); ok {
+			t.Error("expected ok=false for a path with no containing directory")
+		}
+	})
+}
+
+func TestSimpleFSAtomicPublishMovesTempIntoPlaceOnSuccess(t *testing.T) {
+	fake := &fakeSimpleFSAtomicClient{}
+	dest := keybase1.NewPathWithKbfs("/keybase/private/alice/config.json")
+
+	var writtenTo keybase1.Path
+	err := simpleFSAtomicPublish(context.Background(), fake, dest, time.Unix(1, 0), func(tmp keybase1.Path) error {
+		writtenTo = tmp
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fake.moveArgs) != 1 {
+		t.Fatalf("expected exactly one SimpleFSMove call, got %d", len(fake.moveArgs))
+	}
+	if fake.moveArgs[0].Src.Kbfs() != writtenTo.Kbfs() {
+		t.Errorf("expected move src %q (what write() was given), got %q", writtenTo.Kbfs(), fake.moveArgs[0].Src.Kbfs())
+	}
+	if fake.moveArgs[0].Dest.Kbfs() != dest.Kbfs() {
+		t.Errorf("expected move dest %q, got %q", dest.Kbfs(), fake.moveArgs[0].Dest.Kbfs())
+	}
+	if len(fake.removeArgs) != 0 {
+		t.Errorf("expected no cleanup removes on success, got %d", len(fake.removeArgs))
+	}
+}
+
+func TestSimpleFSAtomicPublishCleansUpTempOnWriteFailure(t *testing.T) {
+	fake := &fakeSimpleFSAtomicClient{}
+	dest := keybase1.NewPathWithKbfs("/keybase/private/alice/config.json")
+	writeErr := errors.New("simulated mid-write failure")
+
+	var writtenTo keybase1.Path
+	err := simpleFSAtomicPublish(context.Background(), fake, dest, time.Unix(1, 0), func(tmp keybase1.Path) error {
+		writtenTo = tmp
+		return writeErr
+	})
+	if err != writeErr {
+		t.Fatalf("expected the write error to propagate, got %v", err)
+	}
+
+	if len(fake.moveArgs) != 0 {
+		t.Errorf("expected no SimpleFSMove calls after a failed write, got %d", len(fake.moveArgs))
+	}
+	if len(fake.removeArgs) != 1 {
+		t.Fatalf("expected exactly one cleanup SimpleFSRemove call, got %d", len(fake.removeArgs))
+	}
+	if fake.removeArgs[0].Path.Kbfs() != writtenTo.Kbfs() {
+		t.Errorf("expected cleanup to remove the temp path %q, got %q", writtenTo.Kbfs(), fake.removeArgs[0].Path.Kbfs())
+	}
+}
+
+func TestSimpleFSAtomicPublishCleansUpTempOnRenameFailure(t *testing.T) {
+	moveErr := errors.New("simulated rename failure")
+	fake := &fakeSimpleFSAtomicClient{moveErr: moveErr}
+	dest := keybase1.NewPathWithKbfs("/keybase/private/alice/config.json")
+
+	var writtenTo keybase1.Path
+	err := simpleFSAtomicPublish(context.Background(), fake, dest, time.Unix(1, 0), func(tmp keybase1.Path) error {
+		writtenTo = tmp
+		return nil
+	})
+	if err != moveErr {
+		t.Fatalf("expected the move error to propagate, got %v", err)
+	}
+
+	if len(fake.removeArgs) != 1 {
+		t.Fatalf("expected exactly one cleanup SimpleFSRemove call, got %d", len(fake.removeArgs))
+	}
+	if fake.removeArgs[0].Path.Kbfs() != writtenTo.Kbfs() {
+		t.Errorf("expected cleanup to remove the temp path %q, got %q", writtenTo.Kbfs(), fake.removeArgs[0].Path.Kbfs())
+	}
+}
+
+func TestSimpleFSAtomicPublishRejectsNonKbfsDest(t *testing.T) {
+	fake := &fakeSimpleFSAtomicClient{}
+	dest := keybase1.NewPathWithLocal("/tmp/config.json")
+
+	err := simpleFSAtomicPublish(context.Background(), fake, dest, time.Now(), func(tmp keybase1.Path) error {
+		t.Fatal("write should not be called when dest isn't a KBFS path")
+		return nil
+	})
+	if err == nil {
+		t.Fatal("expected an error for a local destination")
+	}
+	if len(fake.moveArgs) != 0 || len(fake.removeArgs) != 0 {
+		t.Errorf("expected no RPCs for a rejected destination, got moves=%d removes=%d", len(fake.moveArgs), len(fake.removeArgs))
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"unicode/utf8"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/cli"
+	"github.com/keybase/client/go/libcmdline"
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// CmdSimpleFSCat is the 'fs cat' command, which streams a window of a
+// single KBFS file's contents to stdout. Unlike `fs read`, which always
+// reads a file (or several) start to finish, `fs cat` takes --offset and
+// --length to read a ranged slice without downloading the rest of the
+// file -- useful for peeking at part of something large. Output is
+// written through unmodified by default; --text additionally requires
+// the slice be valid UTF-8, for when the caller wants to be told they hit
+// a binary file or split a multi-byte character instead of getting
+// whatever bytes happened to be there.
+type CmdSimpleFSCat struct {
+	libkb.Contextified
+	path   string
+	offset int64
+	length int64
+	text   bool
+}
+
+func newCmdSimpleFSCat(cl *libcmdline.CommandLine, g *libkb.GlobalContext) cli.Command {
+	return cli.Command{
+		Name:         "cat",
+		Usage:        "Print a KBFS file, or a byte range of it, to stdout",
+		ArgumentHelp: "<path>",
+		Action: func(c *cli.Context) {
+			cl.ChooseCommand(&CmdSimpleFSCat{Contextified: libkb.NewContextified(g)}, "cat", c)
+		},
+		Flags: []cli.Flag{
+			cli.IntFlag{
+				Name:  "offset",
+				Usage: "Byte offset to start reading from",
+			},
+			cli.IntFlag{
+				Name:  "length",
+				Usage: "Number of bytes to read (0 means to the end of the file)",
+			},
+			cli.BoolFlag{
+				Name:  "text",
+				Usage: "Require the output to be valid UTF-8, instead of passing binary data through unmodified",
+			},
+		},
+	}
+}
+
+func (c *CmdSimpleFSCat) ParseArgv(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		return errors.New("fs cat takes one argument: <path>")
+	}
+	c.path = ctx.Args()[0]
+	c.offset = int64(ctx.Int("offset"))
+	c.length = int64(ctx.Int("length"))
+	if c.offset < 0 {
+		return errors.New("--offset may not be negative")
+	}
+	if c.length < 0 {
+		return errors.New("--length may not be negative")
+	}
+	c.text = ctx.Bool("text")
+	return nil
+}
+
+func (c *CmdSimpleFSCat) Run() (err error) {
+	defer func() {
+		err = fsFinishError(c.G(), err)
+	}()
+
+	cli, err := GetSimpleFSClient(c.G())
+	if err != nil {
+		return err
+	}
+	ctx := context.TODO()
+
+	return simpleFSCatOne(ctx, cli, c.path, c.offset, c.length, c.text)
+}
+
+// simpleFSCatOne streams a window of a single KBFS path's contents to
+// stdout, starting at offset and reading up to length bytes (0 meaning to
+// the end of the file). If text is set, the collected window is checked
+// for valid UTF-8 before anything is written out, instead of passing
+// whatever bytes were read straight through.
+func simpleFSCatOne(ctx context.Context, cli keybase1.SimpleFSInterface, arg string, offset, length int64, text bool) error {
+	path := simpleFSPathFromArg(arg)
+	if !simpleFSIsKbfs(path) {
+		return fmt.Errorf("fs cat source must be a /keybase/... path: %q", arg)
+	}
+
+	dirent, err := cli.SimpleFSStat(ctx, path)
+	if err != nil {
+		return err
+	}
+	if dirent.DirentType == keybase1.DirentType_DIR {
+		return fmt.Errorf("%q is a directory", arg)
+	}
+
+	opid, err := cli.SimpleFSMakeOpid(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = cli.SimpleFSClose(ctx, opid) }()
+
+	if err := cli.SimpleFSOpen(ctx, keybase1.SimpleFSOpenArg{
+		OpID:  opid,
+		Dest:  path,
+		Flags: keybase1.OpenFlags_READ | keybase1.OpenFlags_EXISTING,
+	}); err != nil {
+		return err
+	}
+
+	var textBuf []byte
+	remaining := length
+	for length == 0 || remaining > 0 {
+		size := int64(simpleFSReadChunkSize)
+		if length != 0 && remaining < size {
+			size = remaining
+		}
+		content, err := cli.SimpleFSRead(ctx, keybase1.SimpleFSReadArg{
+			OpID:   opid,
+			Offset: offset,
+			Size:   int(size),
+		})
+		if err != nil {
+			return err
+		}
+		if len(content.Data) == 0 {
+			break
+		}
+
+		if text {
+			textBuf = append(textBuf, content.Data...)
+		} else if _, err := os.Stdout.Write(content.Data); err != nil {
+			return err
+		}
+
+		offset += int64(len(content.Data))
+		remaining -= int64(len(content.Data))
+	}
+
+	if text {
+		if !utf8.Valid(textBuf) {
+			return fmt.Errorf("%q is not valid UTF-8 (try without --text)", arg)
+		}
+		if _, err := os.Stdout.Write(textBuf); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *CmdSimpleFSCat) GetUsage() libkb.Usage {
+	return libkb.Usage{
+		Config: true,
+		API:    true,
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestSimpleFSCatOneFull(t *testing.T) {
+	fake := newFakeSimpleFSReadClient()
+	fake.files["/keybase/private/alice/a.txt"] = []byte("hello world")
+
+	out := captureStdout(t, func() {
+		if err := simpleFSCatOne(context.Background(), fake, "/keybase/private/alice/a.txt", 0, 0, false); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if out != "hello world" {
+		t.Fatalf("got %q, want %q", out, "hello world")
+	}
+}
+
+func TestSimpleFSCatOneOffsetAndLength(t *testing.T) {
+	fake := newFakeSimpleFSReadClient()
+	fake.files["/keybase/private/alice/a.txt"] = []byte("hello world")
+
+	out := captureStdout(t, func() {
+		if err := simpleFSCatOne(context.Background(), fake, "/keybase/private/alice/a.txt", 6, 5, false); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if out != "world" {
+		t.Fatalf("got %q, want %q", out, "world")
+	}
+}
+
+func TestSimpleFSCatOneOffsetPastEOFReturnsEmpty(t *testing.T) {
+	fake := newFakeSimpleFSReadClient()
+	fake.files["/keybase/private/alice/a.txt"] = []byte("hello world")
+
+	out := captureStdout(t, func() {
+		if err := simpleFSCatOne(context.Background(), fake, "/keybase/private/alice/a.txt", 100, 0, false); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if out != "" {
+		t.Fatalf("got %q, want empty output", out)
+	}
+}
+
+func TestSimpleFSCatOneTextRejectsBinaryData(t *testing.T) {
+	fake := newFakeSimpleFSReadClient()
+	fake.files["/keybase/private/alice/a.bin"] = []byte{0xff, 0xfe, 0x00, 0x01}
+
+	err := simpleFSCatOne(context.Background(), fake, "/keybase/private/alice/a.bin", 0, 0, true)
+	if err == nil {
+		t.Fatal("expected an error catting binary data with --text")
+	}
+}
+
+func TestSimpleFSCatOneRejectsDirectories(t *testing.T) {
+	fake := newFakeSimpleFSReadClient()
+	fake.dirs["/keybase/private/alice"] = true
+
+	err := simpleFSCatOne(context.Background(), fake, "/keybase/private/alice", 0, 0, false)
+	if err == nil {
+		t.Fatal("expected an error catting a directory")
+	}
+}
+
+func TestSimpleFSCatOneRejectsLocalPaths(t *testing.T) {
+	fake := newFakeSimpleFSReadClient()
+	err := simpleFSCatOne(context.Background(), fake, "/tmp/not-kbfs", 0, 0, false)
+	if err == nil {
+		t.Fatal("expected an error catting a non-kbfs path")
+	}
+}
@@ -0,0 +1,514 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/cli"
+	"github.com/keybase/client/go/libcmdline"
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// simpleFSChecksumAlgorithm identifies a hash algorithm usable with
+// --checksum-algorithm, shared by `fs cp --checksum` and `fs checksum`.
+type simpleFSChecksumAlgorithm string
+
+const (
+	simpleFSChecksumSHA256 simpleFSChecksumAlgorithm = "sha256"
+	simpleFSChecksumSHA512 simpleFSChecksumAlgorithm = "sha512"
+	// simpleFSChecksumMD5 is accepted only to match external manifests
+	// that were already computed with it; it is not safe against a
+	// deliberately crafted collision, so parseChecksumAlgorithm warns
+	// whenever it's selected.
+	simpleFSChecksumMD5 simpleFSChecksumAlgorithm = "md5"
+)
+
+func (a simpleFSChecksumAlgorithm) newHash() hash.Hash {
+	switch a {
+	case simpleFSChecksumSHA512:
+		return sha512.New()
+	case simpleFSChecksumMD5:
+		return md5.New()
+	default:
+		return sha256.New()
+	}
+}
+
+// parseChecksumAlgorithm parses --checksum-algorithm's value, defaulting to
+// sha256. warn, if non-nil, is called with a message if the algorithm
+// chosen is md5, which callers should report to the user since md5 isn't
+// a cryptographic guarantee of content equality, just a convenient match
+// against manifests that already used it.
+func parseChecksumAlgorithm(s string, warn func(string)) (simpleFSChecksumAlgorithm, error) {
+	algo := simpleFSChecksumAlgorithm(s)
+	switch algo {
+	case "":
+		return simpleFSChecksumSHA256, nil
+	case simpleFSChecksumSHA256, simpleFSChecksumSHA512:
+		return algo, nil
+	case simpleFSChecksumMD5:
+		if warn != nil {
+			warn("md5 is not cryptographically secure; only use it to match an existing md5 manifest, not as a guarantee of content integrity")
+		}
+		return algo, nil
+	default:
+		return "", fmt.Errorf("invalid --checksum-algorithm value %q (want sha256, sha512, or md5)", s)
+	}
+}
+
+// simpleFSStreamHash streams the full contents of p, local or KBFS,
+// through algo and returns the digest, without holding the whole file in
+// memory.
+func simpleFSStreamHash(ctx context.Context, cli keybase1.SimpleFSInterface, p keybase1.Path, algo simpleFSChecksumAlgorithm) ([]byte, error) {
+	h := algo.newHash()
+
+	if !simpleFSIsKbfs(p) {
+		f, err := os.Open(p.Local())
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if _, err := io.Copy(h, f); err != nil {
+			return nil, err
+		}
+		return h.Sum(nil), nil
+	}
+
+	opid, err := cli.SimpleFSMakeOpid(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = cli.SimpleFSClose(ctx, opid) }()
+
+	if err := cli.SimpleFSOpen(ctx, keybase1.SimpleFSOpenArg{
+		OpID:  opid,
+		Dest:  p,
+		Flags: keybase1.OpenFlags_READ | keybase1.OpenFlags_EXISTING,
+	}); err != nil {
+		return nil, err
+	}
+
+	var offset int64
+	for {
+		content, err := cli.SimpleFSRead(ctx, keybase1.SimpleFSReadArg{
+			OpID:   opid,
+			Offset: offset,
+			Size:   simpleFSReadChunkSize,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(content.Data) == 0 {
+			return h.Sum(nil), nil
+		}
+		h.Write(content.Data)
+		offset += int64(len(content.Data))
+	}
+}
+
+// simpleFSOpenContentReader opens p for reading and returns an io.ReadCloser
+// over its content, local or KBFS, so a caller that needs ordinary
+// io.Reader semantics (e.g. bufio, or more than one pass over the data)
+// doesn't have to hand-roll SimpleFSRead's chunk loop the way
+// simpleFSStreamHash does for its own single pass.
+func simpleFSOpenContentReader(ctx context.Context, cli keybase1.SimpleFSInterface, p keybase1.Path) (io.ReadCloser, error) {
+	if !simpleFSIsKbfs(p) {
+		return os.Open(p.Local())
+	}
+
+	opid, err := cli.SimpleFSMakeOpid(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := cli.SimpleFSOpen(ctx, keybase1.SimpleFSOpenArg{
+		OpID:  opid,
+		Dest:  p,
+		Flags: keybase1.OpenFlags_READ | keybase1.OpenFlags_EXISTING,
+	}); err != nil {
+		_ = cli.SimpleFSClose(ctx, opid)
+		return nil, err
+	}
+	return &simpleFSOpidReader{ctx: ctx, cli: cli, opid: opid}, nil
+}
+
+// simpleFSOpenContentReaderAt is simpleFSOpenContentReader's offset-aware
+// counterpart, for a caller (namely --resume) that needs to pick up
+// reading p partway through instead of from the start.
+func simpleFSOpenContentReaderAt(ctx context.Context, cli keybase1.SimpleFSInterface, p keybase1.Path, offset int64) (io.ReadCloser, error) {
+	if !simpleFSIsKbfs(p) {
+		f, err := os.Open(p.Local())
+		if err != nil {
+			return nil, err
+		}
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+		return f, nil
+	}
+
+	opid, err := cli.SimpleFSMakeOpid(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := cli.SimpleFSOpen(ctx, keybase1.SimpleFSOpenArg{
+		OpID:  opid,
+		Dest:  p,
+		Flags: keybase1.OpenFlags_READ | keybase1.OpenFlags_EXISTING,
+	}); err != nil {
+		_ = cli.SimpleFSClose(ctx, opid)
+		return nil, err
+	}
+	return &simpleFSOpidReader{ctx: ctx, cli: cli, opid: opid, offset: offset}, nil
+}
+
+// simpleFSOpidReader adapts a SimpleFSRead-backed opid into an io.Reader,
+// reading simpleFSReadChunkSize (or less, per the caller's buffer) at a
+// time and reporting io.EOF the same way SimpleFSRead itself signals it:
+// a read that comes back with no data.
+type simpleFSOpidReader struct {
+	ctx    context.Context
+	cli    keybase1.SimpleFSInterface
+	opid   keybase1.OpID
+	offset int64
+}
+
+func (r *simpleFSOpidReader) Read(p []byte) (int, error) {
+	size := len(p)
+	if size > simpleFSReadChunkSize {
+		size = simpleFSReadChunkSize
+	}
+	content, err := r.cli.SimpleFSRead(r.ctx, keybase1.SimpleFSReadArg{OpID: r.opid, Offset: r.offset, Size: size})
+	if err != nil {
+		return 0, err
+	}
+	if len(content.Data) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, content.Data)
+	r.offset += int64(n)
+	return n, nil
+}
+
+func (r *simpleFSOpidReader) Close() error {
+	return r.cli.SimpleFSClose(r.ctx, r.opid)
+}
+
+// simpleFSFindFirstDiffOffset scans a and b byte by byte and returns the
+// offset their content first diverges at, stopping as soon as it finds one
+// rather than reading either stream in full. ok is false if no difference
+// turns up before both reach EOF at the same length; callers only reach for
+// this after a hash comparison already reported a mismatch, so that case
+// isn't expected in practice, but is reported rather than panicked on.
+func simpleFSFindFirstDiffOffset(a, b io.Reader) (offset int64, ok bool, err error) {
+	ra := bufio.NewReader(a)
+	rb := bufio.NewReader(b)
+	for {
+		ba, aErr := ra.ReadByte()
+		bb, bErr := rb.ReadByte()
+		if aErr == io.EOF && bErr == io.EOF {
+			return 0, false, nil
+		}
+		if aErr != nil && aErr != io.EOF {
+			return 0, false, aErr
+		}
+		if bErr != nil && bErr != io.EOF {
+			return 0, false, bErr
+		}
+		if aErr == io.EOF || bErr == io.EOF || ba != bb {
+			return offset, true, nil
+		}
+		offset++
+	}
+}
+
+// simpleFSVerifyCopy re-hashes src and dest after a copy has completed and
+// reports whether their content matches, for `fs cp --verify`. On a
+// mismatch it also tries to report the offset of the first differing byte
+// as a diagnostic: that's a second pass over both files, but a cheap one,
+// since simpleFSFindFirstDiffOffset stops at the first difference instead
+// of reading either one in full, and it's only ever taken after the hashes
+// have already disagreed.
+func simpleFSVerifyCopy(ctx context.Context, cli keybase1.SimpleFSInterface, src, dest keybase1.Path, algo simpleFSChecksumAlgorithm) (match bool, diffOffset int64, diffOffsetKnown bool, err error) {
+	srcHash, err := simpleFSStreamHash(ctx, cli, src, algo)
+	if err != nil {
+		return false, 0, false, err
+	}
+	destHash, err := simpleFSStreamHash(ctx, cli, dest, algo)
+	if err != nil {
+		return false, 0, false, err
+	}
+	if bytes.Equal(srcHash, destHash) {
+		return true, 0, false, nil
+	}
+
+	srcR, err := simpleFSOpenContentReader(ctx, cli, src)
+	if err != nil {
+		return false, 0, false, nil
+	}
+	defer srcR.Close()
+	destR, err := simpleFSOpenContentReader(ctx, cli, dest)
+	if err != nil {
+		return false, 0, false, nil
+	}
+	defer destR.Close()
+
+	offset, ok, diffErr := simpleFSFindFirstDiffOffset(srcR, destR)
+	if diffErr != nil {
+		// The hashes already disagree; losing the offset to a failure in
+		// this secondary diagnostic pass shouldn't change that verdict.
+		return false, 0, false, nil
+	}
+	return false, offset, ok, nil
+}
+
+// CmdSimpleFSChecksum is the 'fs checksum' command, which prints the
+// digest of one or more local or KBFS files' content, in the style of
+// sha256sum. With -r, a directory argument is walked and every file
+// beneath it is hashed; with --manifest, the output is sorted by path and
+// each line is prefixed with the algorithm used, so the manifest stays
+// self-describing even if --checksum-algorithm's default ever changes.
+type CmdSimpleFSChecksum struct {
+	libkb.Contextified
+	paths     []string
+	recursive bool
+	manifest  bool
+	algorithm simpleFSChecksumAlgorithm
+}
+
+func newCmdSimpleFSChecksum(cl *libcmdline.CommandLine, g *libkb.GlobalContext) cli.Command {
+	return cli.Command{
+		Name:         "checksum",
+		Usage:        "Print the checksum of one or more local or KBFS files",
+		ArgumentHelp: "<path>...",
+		Action: func(c *cli.Context) {
+			cl.ChooseCommand(&CmdSimpleFSChecksum{Contextified: libkb.NewContextified(g)}, "checksum", c)
+		},
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "checksum-algorithm",
+				Usage: "Hash algorithm to use: sha256 (default), sha512, or md5 (not cryptographically secure; for matching external manifests only)",
+			},
+			cli.BoolFlag{
+				Name:  "r, recursive",
+				Usage: "Recurse into directory arguments, hashing every file beneath them",
+			},
+			cli.BoolFlag{
+				Name:  "manifest",
+				Usage: "Sort output by path and prefix each line with the algorithm used, for saving as a manifest to check against later",
+			},
+		},
+	}
+}
+
+func (c *CmdSimpleFSChecksum) ParseArgv(ctx *cli.Context) error {
+	if len(ctx.Args()) < 1 {
+		return fmt.Errorf("fs checksum takes at least one argument: <path>...")
+	}
+	c.paths = ctx.Args()
+	c.recursive = ctx.Bool("recursive")
+	c.manifest = ctx.Bool("manifest")
+
+	algo, err := parseChecksumAlgorithm(ctx.String("checksum-algorithm"), func(msg string) {
+		c.G().Log.Warning(msg)
+	})
+	if err != nil {
+		return err
+	}
+	c.algorithm = algo
+	return nil
+}
+
+func (c *CmdSimpleFSChecksum) Run() (err error) {
+	defer func() {
+		err = fsFinishError(c.G(), err)
+	}()
+
+	fsClient, err := GetSimpleFSClient(c.G())
+	if err != nil {
+		return err
+	}
+
+	ctx := context.TODO()
+	var lines []simpleFSChecksumLine
+	for _, arg := range c.paths {
+		p := simpleFSPathFromArg(arg)
+		more, err := c.hashPath(ctx, fsClient, arg, p)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, more...)
+	}
+
+	if c.manifest {
+		sort.Slice(lines, func(i, j int) bool { return lines[i].path < lines[j].path })
+	}
+	for _, line := range lines {
+		if c.manifest {
+			fmt.Fprintf(os.Stdout, "%s:%s  %s\n", c.algorithm, hex.EncodeToString(line.sum), line.path)
+		} else {
+			fmt.Fprintf(os.Stdout, "%s  %s\n", hex.EncodeToString(line.sum), line.path)
+		}
+	}
+	return nil
+}
+
+// simpleFSChecksumLine is one file's result from CmdSimpleFSChecksum.Run,
+// kept around uncomputed-output so --manifest can sort the whole batch by
+// path before printing any of it.
+type simpleFSChecksumLine struct {
+	path string
+	sum  []byte
+}
+
+// hashPath hashes arg, which names either a single file or, with
+// c.recursive set, a directory to walk. argStr is arg's original command
+// line spelling, which is what a single file's line is labeled with (and
+// what error messages quote); entries found while walking a directory are
+// labeled with simpleFSPathString of their own path instead.
+func (c *CmdSimpleFSChecksum) hashPath(ctx context.Context, cli keybase1.SimpleFSInterface, argStr string, p keybase1.Path) ([]simpleFSChecksumLine, error) {
+	isDir, err := c.isDir(ctx, cli, p)
+	if err != nil {
+		return nil, err
+	}
+	if !isDir {
+		sum, err := simpleFSStreamHash(ctx, cli, p, c.algorithm)
+		if err != nil {
+			return nil, err
+		}
+		return []simpleFSChecksumLine{{path: argStr, sum: sum}}, nil
+	}
+
+	if !c.recursive {
+		return nil, fmt.Errorf("%q is a directory (use -r to recurse into it)", argStr)
+	}
+
+	var lines []simpleFSChecksumLine
+	warn := func(format string, args ...interface{}) {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+	}
+	fn := func(dir keybase1.Path, entry keybase1.Dirent) error {
+		if entry.DirentType == keybase1.DirentType_DIR {
+			return nil
+		}
+		child := simpleFSChildPath(dir, entry.Name)
+		sum, err := simpleFSStreamHash(ctx, cli, child, c.algorithm)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, simpleFSChecksumLine{path: simpleFSPathString(child), sum: sum})
+		return nil
+	}
+
+	lister := c.lister(ctx, cli)
+	if err := simpleFSWalk(ctx, lister, p, simpleFSWalkOptions{}, warn, fn); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+// isDir reports whether p names a directory.
+func (c *CmdSimpleFSChecksum) isDir(ctx context.Context, cli keybase1.SimpleFSInterface, p keybase1.Path) (bool, error) {
+	if !simpleFSIsKbfs(p) {
+		info, err := os.Stat(p.Local())
+		if err != nil {
+			return false, err
+		}
+		return info.IsDir(), nil
+	}
+	dirent, err := cli.SimpleFSStat(ctx, p)
+	if err != nil {
+		return false, err
+	}
+	return dirent.DirentType == keybase1.DirentType_DIR, nil
+}
+
+// lister returns the simpleFSLister to drive simpleFSWalk with for
+// hashing a directory, choosing between the real KBFS listing RPCs and a
+// local directory lister based on the root's path type.
+func (c *CmdSimpleFSChecksum) lister(ctx context.Context, cli keybase1.SimpleFSInterface) simpleFSLister {
+	return &simpleFSChecksumLister{ctx: ctx, cli: cli}
+}
+
+// simpleFSChecksumLister is a simpleFSLister that dispatches each List
+// call to either the real SimpleFS RPCs or the local filesystem,
+// depending on the path type of the directory being listed, so that a
+// single simpleFSWalk can drive a tree that might be local or might be
+// KBFS. CmdSimpleFSChecksum uses it to walk one tree with -r;
+// CmdSimpleFSSync uses it to walk both the source and destination trees,
+// which may each independently be local or KBFS.
+type simpleFSChecksumLister struct {
+	ctx context.Context
+	cli keybase1.SimpleFSInterface
+}
+
+func (l *simpleFSChecksumLister) List(ctx context.Context, dir keybase1.Path) ([]keybase1.Dirent, error) {
+	if !simpleFSIsKbfs(dir) {
+		infos, err := ioutil.ReadDir(dir.Local())
+		if err != nil {
+			return nil, err
+		}
+		entries := make([]keybase1.Dirent, len(infos))
+		for i, info := range infos {
+			entries[i] = localDirent(info)
+		}
+		return entries, nil
+	}
+	return (&simpleFSRPCLister{ctx: ctx, cli: l.cli}).List(ctx, dir)
+}
+
+func (l *simpleFSChecksumLister) Canonicalize(ctx context.Context, p keybase1.Path) (string, error) {
+	if !simpleFSIsKbfs(p) {
+		resolved, err := filepath.EvalSymlinks(p.Local())
+		if err != nil {
+			return "", err
+		}
+		return resolved, nil
+	}
+	return (&simpleFSRPCLister{ctx: ctx, cli: l.cli}).Canonicalize(ctx, p)
+}
+
+// localDirent converts a local os.FileInfo into the keybase1.Dirent shape
+// simpleFSWalk expects, the same fields SimpleFSList reports for a KBFS
+// entry.
+func localDirent(info os.FileInfo) keybase1.Dirent {
+	direntType := keybase1.DirentType_FILE
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		direntType = keybase1.DirentType_SYM
+	case info.IsDir():
+		direntType = keybase1.DirentType_DIR
+	case info.Mode()&0111 != 0:
+		direntType = keybase1.DirentType_EXEC
+	}
+	return keybase1.Dirent{
+		Name:       info.Name(),
+		Size:       int(info.Size()),
+		Time:       keybase1.ToTime(info.ModTime()),
+		DirentType: direntType,
+	}
+}
+
+func (c *CmdSimpleFSChecksum) GetUsage() libkb.Usage {
+	return libkb.Usage{
+		Config: true,
+		API:    true,
+	}
+}
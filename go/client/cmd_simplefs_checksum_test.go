@@ -0,0 +1,208 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+func TestParseChecksumAlgorithm(t *testing.T) {
+	algo, err := parseChecksumAlgorithm("", nil)
+	if err != nil || algo != simpleFSChecksumSHA256 {
+		t.Errorf("empty string: got (%q, %v), want (%q, nil)", algo, err, simpleFSChecksumSHA256)
+	}
+
+	algo, err = parseChecksumAlgorithm("sha512", nil)
+	if err != nil || algo != simpleFSChecksumSHA512 {
+		t.Errorf("sha512: got (%q, %v), want (%q, nil)", algo, err, simpleFSChecksumSHA512)
+	}
+
+	var warned string
+	algo, err = parseChecksumAlgorithm("md5", func(msg string) { warned = msg })
+	if err != nil || algo != simpleFSChecksumMD5 {
+		t.Errorf("md5: got (%q, %v), want (%q, nil)", algo, err, simpleFSChecksumMD5)
+	}
+	if warned == "" {
+		t.Error("md5: expected warn callback to be invoked")
+	}
+
+	warned = ""
+	if _, err := parseChecksumAlgorithm("sha256", func(msg string) { warned = msg }); err != nil {
+		t.Errorf("sha256: unexpected error %v", err)
+	}
+	if warned != "" {
+		t.Errorf("sha256: expected no warning, got %q", warned)
+	}
+
+	if _, err := parseChecksumAlgorithm("crc32", nil); err == nil {
+		t.Error("crc32: expected an error for an unsupported algorithm")
+	}
+}
+
+func TestSimpleFSStreamHashKnownVectors(t *testing.T) {
+	// Standard published digests of the empty string and "abc".
+	vectors := []struct {
+		algo    simpleFSChecksumAlgorithm
+		content string
+		want    string
+	}{
+		{simpleFSChecksumSHA256, "", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{simpleFSChecksumSHA256, "abc", "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{simpleFSChecksumSHA512, "", "cf83e1357eefb8bdf1542850d66d8007d620e4050b5715dc83f4a921d36ce9ce47d0d13c5d85f2b0ff8318d2877eec2f63b931bd47417a81a538327af927da3e"},
+		{simpleFSChecksumSHA512, "abc", "ddaf35a193617abacc417349ae20413112e6fa4e89a97ea20a9eeee64b55d39a2192992a274fc1a836ba3c23a3feebbd454d4423643ce80e2a9ac94fa54ca49f"},
+		{simpleFSChecksumMD5, "", "d41d8cd98f00b204e9800998ecf8427e"},
+		{simpleFSChecksumMD5, "abc", "900150983cd24fb0d6963f7d28e17f72"},
+	}
+
+	for _, v := range vectors {
+		f, err := ioutil.TempFile("", "simplefs-checksum-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := f.Name()
+		defer os.Remove(name)
+		if _, err := f.WriteString(v.content); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+
+		sum, err := simpleFSStreamHash(context.Background(), nil, keybase1.NewPathWithLocal(name), v.algo)
+		if err != nil {
+			t.Fatalf("%s(%q): %v", v.algo, v.content, err)
+		}
+		got := hex.EncodeToString(sum)
+		if got != v.want {
+			t.Errorf("%s(%q): got %s, want %s", v.algo, v.content, got, v.want)
+		}
+	}
+}
+
+// sumOf hashes content with algo, for comparing against CmdSimpleFSChecksum's
+// output in the tests below.
+func sumOf(t *testing.T, algo simpleFSChecksumAlgorithm, content string) []byte {
+	sum, err := simpleFSStreamHash(context.Background(), nil, keybase1.NewPathWithLocal(writeChecksumTestFile(t, content)), algo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sum
+}
+
+// writeChecksumTestFile writes content to a new temp file and returns its path.
+func writeChecksumTestFile(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "simplefs-checksum-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestCmdSimpleFSChecksumSingleFile(t *testing.T) {
+	path := writeChecksumTestFile(t, "hello")
+	defer os.Remove(path)
+
+	c := &CmdSimpleFSChecksum{algorithm: simpleFSChecksumSHA256}
+	lines, err := c.hashPath(context.Background(), nil, path, keybase1.NewPathWithLocal(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 1 {
+		t.Fatalf("got %d lines, want 1", len(lines))
+	}
+	if lines[0].path != path {
+		t.Errorf("got path %q, want %q", lines[0].path, path)
+	}
+	want := sumOf(t, simpleFSChecksumSHA256, "hello")
+	if hex.EncodeToString(lines[0].sum) != hex.EncodeToString(want) {
+		t.Errorf("got sum %x, want %x", lines[0].sum, want)
+	}
+}
+
+func TestCmdSimpleFSChecksumMultipleFiles(t *testing.T) {
+	path1 := writeChecksumTestFile(t, "one")
+	defer os.Remove(path1)
+	path2 := writeChecksumTestFile(t, "two")
+	defer os.Remove(path2)
+
+	c := &CmdSimpleFSChecksum{algorithm: simpleFSChecksumSHA256}
+	var lines []simpleFSChecksumLine
+	for _, p := range []string{path1, path2} {
+		more, err := c.hashPath(context.Background(), nil, p, keybase1.NewPathWithLocal(p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, more...)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2", len(lines))
+	}
+	if hex.EncodeToString(lines[0].sum) != hex.EncodeToString(sumOf(t, simpleFSChecksumSHA256, "one")) {
+		t.Errorf("path1: got sum %x", lines[0].sum)
+	}
+	if hex.EncodeToString(lines[1].sum) != hex.EncodeToString(sumOf(t, simpleFSChecksumSHA256, "two")) {
+		t.Errorf("path2: got sum %x", lines[1].sum)
+	}
+}
+
+func TestCmdSimpleFSChecksumRecursesLocalDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "simplefs-checksum-recurse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "a"), []byte("aaa"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "sub", "b"), []byte("bbb"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &CmdSimpleFSChecksum{algorithm: simpleFSChecksumSHA256, recursive: true}
+	lines, err := c.hashPath(context.Background(), nil, dir, keybase1.NewPathWithLocal(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2: %+v", len(lines), lines)
+	}
+
+	got := map[string][]byte{}
+	for _, l := range lines {
+		got[l.path] = l.sum
+	}
+	if a, ok := got[filepath.Join(dir, "a")]; !ok || hex.EncodeToString(a) != hex.EncodeToString(sumOf(t, simpleFSChecksumSHA256, "aaa")) {
+		t.Errorf("missing or wrong sum for %q: %+v", filepath.Join(dir, "a"), got)
+	}
+	if b, ok := got[filepath.Join(dir, "sub", "b")]; !ok || hex.EncodeToString(b) != hex.EncodeToString(sumOf(t, simpleFSChecksumSHA256, "bbb")) {
+		t.Errorf("missing or wrong sum for %q: %+v", filepath.Join(dir, "sub", "b"), got)
+	}
+}
+
+func TestCmdSimpleFSChecksumDirectoryWithoutRecursiveFails(t *testing.T) {
+	dir, err := ioutil.TempDir("", "simplefs-checksum-norecurse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := &CmdSimpleFSChecksum{algorithm: simpleFSChecksumSHA256}
+	if _, err := c.hashPath(context.Background(), nil, dir, keybase1.NewPathWithLocal(dir)); err == nil {
+		t.Error("expected an error hashing a directory without -r")
+	}
+}
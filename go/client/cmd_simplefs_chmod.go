@@ -0,0 +1,112 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/cli"
+	"github.com/keybase/client/go/libcmdline"
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// CmdSimpleFSChmod is the 'fs chmod' command. KBFS only tracks one
+// permission bit -- whether a file is executable -- so this only accepts
+// +x/-x, unlike the local `chmod` which takes arbitrary octal modes. For
+// local destinations it maps onto os.Chmod, toggling the owner/group/other
+// execute bits to match; every other local permission bit is left alone.
+type CmdSimpleFSChmod struct {
+	libkb.Contextified
+	setExec bool
+	paths   []string
+}
+
+func newCmdSimpleFSChmod(cl *libcmdline.CommandLine, g *libkb.GlobalContext) cli.Command {
+	return cli.Command{
+		Name:         "chmod",
+		Usage:        "Set or clear the executable bit on a KBFS or local path",
+		ArgumentHelp: "<+x|-x> <path> [path...]",
+		Action: func(c *cli.Context) {
+			cl.ChooseCommand(&CmdSimpleFSChmod{Contextified: libkb.NewContextified(g)}, "chmod", c)
+		},
+	}
+}
+
+func (c *CmdSimpleFSChmod) ParseArgv(ctx *cli.Context) error {
+	if len(ctx.Args()) < 2 {
+		return errors.New("fs chmod takes a mode and at least one path: <+x|-x> <path> [path...]")
+	}
+	switch mode := ctx.Args()[0]; mode {
+	case "+x":
+		c.setExec = true
+	case "-x":
+		c.setExec = false
+	default:
+		return fmt.Errorf("fs chmod only supports +x and -x (got %q)", mode)
+	}
+	c.paths = ctx.Args()[1:]
+	return nil
+}
+
+func (c *CmdSimpleFSChmod) Run() (err error) {
+	defer func() {
+		err = fsFinishError(c.G(), err)
+	}()
+
+	cli, err := GetSimpleFSClient(c.G())
+	if err != nil {
+		return err
+	}
+	ctx := context.TODO()
+
+	for _, p := range c.paths {
+		if err := simpleFSChmodOne(ctx, cli, p, c.setExec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// simpleFSChmodOne sets or clears the executable bit of a single path,
+// routing to SimpleFSSetStat for KBFS paths and os.Chmod for local ones.
+func simpleFSChmodOne(ctx context.Context, cli keybase1.SimpleFSInterface, arg string, setExec bool) error {
+	path := simpleFSPathFromArg(arg)
+	if !simpleFSIsKbfs(path) {
+		return simpleFSChmodLocal(arg, setExec)
+	}
+
+	flag := keybase1.DirentType_FILE
+	if setExec {
+		flag = keybase1.DirentType_EXEC
+	}
+	return cli.SimpleFSSetStat(ctx, keybase1.SimpleFSSetStatArg{Dest: path, Flag: flag})
+}
+
+// simpleFSChmodLocal toggles the owner/group/other execute bits of a local
+// file to match setExec, leaving every other permission bit as it was.
+func simpleFSChmodLocal(path string, setExec bool) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	mode := info.Mode()
+	if setExec {
+		mode |= 0111
+	} else {
+		mode &^= 0111
+	}
+	return os.Chmod(path, mode)
+}
+
+func (c *CmdSimpleFSChmod) GetUsage() libkb.Usage {
+	return libkb.Usage{
+		Config: true,
+		API:    true,
+	}
+}
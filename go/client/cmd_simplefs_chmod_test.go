@@ -0,0 +1,93 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// fakeSimpleFSChmodClient is a minimal keybase1.SimpleFSInterface that just
+// records the flag each SimpleFSSetStat call was given.
+type fakeSimpleFSChmodClient struct {
+	keybase1.SimpleFSInterface // panics on anything not overridden below
+
+	setStatCalls []keybase1.SimpleFSSetStatArg
+}
+
+func (f *fakeSimpleFSChmodClient) SimpleFSSetStat(ctx context.Context, arg keybase1.SimpleFSSetStatArg) error {
+	f.setStatCalls = append(f.setStatCalls, arg)
+	return nil
+}
+
+func TestSimpleFSChmodOneKBFSSetsExecFlag(t *testing.T) {
+	fake := &fakeSimpleFSChmodClient{}
+
+	if err := simpleFSChmodOne(context.Background(), fake, "/keybase/private/alice/script", true); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.setStatCalls) != 1 {
+		t.Fatalf("expected 1 SimpleFSSetStat call, got %d", len(fake.setStatCalls))
+	}
+	if got := fake.setStatCalls[0].Flag; got != keybase1.DirentType_EXEC {
+		t.Fatalf("expected EXEC flag, got %v", got)
+	}
+
+	if err := simpleFSChmodOne(context.Background(), fake, "/keybase/private/alice/script", false); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.setStatCalls[1].Flag; got != keybase1.DirentType_FILE {
+		t.Fatalf("expected FILE flag, got %v", got)
+	}
+}
+
+func TestSimpleFSChmodOneLocalTogglesExecuteBits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "simplefschmod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "script")
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &fakeSimpleFSChmodClient{}
+
+	if err := simpleFSChmodOne(context.Background(), fake, path, true); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&0111 != 0111 {
+		t.Fatalf("expected all execute bits set, got mode %v", info.Mode())
+	}
+
+	if err := simpleFSChmodOne(context.Background(), fake, path, false); err != nil {
+		t.Fatal(err)
+	}
+	info, err = os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&0111 != 0 {
+		t.Fatalf("expected all execute bits cleared, got mode %v", info.Mode())
+	}
+	if info.Mode()&0600 != 0600 {
+		t.Fatalf("expected read/write bits left alone, got mode %v", info.Mode())
+	}
+
+	if len(fake.setStatCalls) != 0 {
+		t.Fatalf("expected no SimpleFSSetStat calls for a local path, got %d", len(fake.setStatCalls))
+	}
+}
@@ -0,0 +1,246 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// simpleFSChunkRetries is how many additional times a single chunk's
+// SimpleFSRead/SimpleFSWrite is retried after a failure, inside the
+// parallel transfer path, before the whole transfer gives up. A
+// transient failure on one chunk shouldn't force every other chunk's
+// work to be redone, so retries are scoped to just the chunk that failed.
+const simpleFSChunkRetries = 2
+
+// parseChunkSize parses --chunk-size's value into a byte count, falling
+// back to defaultSize for an empty string (the flag wasn't given).
+func parseChunkSize(s string, defaultSize int64) (int64, error) {
+	if s == "" {
+		return defaultSize, nil
+	}
+	var size int64
+	if _, err := fmt.Sscanf(s, "%d", &size); err != nil || size <= 0 {
+		return 0, fmt.Errorf("invalid --chunk-size value %q (want a positive number of bytes)", s)
+	}
+	return size, nil
+}
+
+// parseParallelism parses --parallel's value into a worker count, falling
+// back to 1 (no parallelism) for an empty string.
+func parseParallelism(s string) (int, error) {
+	if s == "" {
+		return 1, nil
+	}
+	var n int
+	if _, err := fmt.Sscanf(s, "%d", &n); err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid --parallel value %q (want a positive number of workers)", s)
+	}
+	return n, nil
+}
+
+// simpleFSChunkCount returns how many chunkSize-sized chunks totalBytes
+// splits into (the last one possibly shorter).
+func simpleFSChunkCount(totalBytes, chunkSize int64) int {
+	if totalBytes <= 0 {
+		return 0
+	}
+	return int((totalBytes + chunkSize - 1) / chunkSize)
+}
+
+// simpleFSChunkRange returns the offset and size of the i'th chunkSize-sized
+// chunk of a totalBytes-byte transfer.
+func simpleFSChunkRange(i int, totalBytes, chunkSize int64) (offset, size int64) {
+	offset = int64(i) * chunkSize
+	size = chunkSize
+	if remaining := totalBytes - offset; size > remaining {
+		size = remaining
+	}
+	return offset, size
+}
+
+// simpleFSReadChunkWithRetry reads one chunk of opid at offset/size, retrying
+// up to simpleFSChunkRetries times if SimpleFSRead fails, so a transient
+// error on one chunk doesn't force the whole parallel read to restart.
+func simpleFSReadChunkWithRetry(ctx context.Context, cli keybase1.SimpleFSInterface, opid keybase1.OpID, offset, size int64) ([]byte, error) {
+	var lastErr error
+	for attempt := 0; attempt <= simpleFSChunkRetries; attempt++ {
+		content, err := cli.SimpleFSRead(ctx, keybase1.SimpleFSReadArg{OpID: opid, Offset: offset, Size: int(size)})
+		if err == nil {
+			return content.Data, nil
+		}
+		lastErr = err
+	}
+	return nil, fmt.Errorf("chunk at offset %d failed after %d attempts: %s", offset, simpleFSChunkRetries+1, lastErr)
+}
+
+// simpleFSWriteChunkWithRetry writes one chunk of data to opid at offset,
+// retrying up to simpleFSChunkRetries times if SimpleFSWrite fails, so a
+// transient error on one chunk doesn't force the whole parallel write to
+// restart.
+func simpleFSWriteChunkWithRetry(ctx context.Context, cli keybase1.SimpleFSInterface, opid keybase1.OpID, offset int64, data []byte) error {
+	var lastErr error
+	for attempt := 0; attempt <= simpleFSChunkRetries; attempt++ {
+		err := cli.SimpleFSWrite(ctx, keybase1.SimpleFSWriteArg{OpID: opid, Offset: offset, Content: data})
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+	}
+	return fmt.Errorf("chunk at offset %d failed after %d attempts: %s", offset, simpleFSChunkRetries+1, lastErr)
+}
+
+// simpleFSReadAllParallel reads opid's totalBytes bytes as chunkSize-sized
+// chunks, up to parallel of them in flight at once, and writes them to w in
+// order as each one completes -- a chunk that finishes early just waits in
+// pending until the chunks before it have been written. This keeps output
+// ordering correct without requiring chunks to complete in order. Workers
+// don't wait for the writer, so pending is not bounded by parallel: every
+// chunk that finishes ahead of a slow earlier one is held there until that
+// one arrives.
+//
+// A chunk that exhausts its retries fails the whole read: every remaining
+// chunk is still dispatched and read (so no goroutine leaks), but nothing
+// past the first failure is written to w.
+func simpleFSReadAllParallel(ctx context.Context, cli keybase1.SimpleFSInterface, opid keybase1.OpID, w io.Writer, totalBytes, chunkSize int64, parallel int, progress func(written int64)) error {
+	numChunks := simpleFSChunkCount(totalBytes, chunkSize)
+	if numChunks == 0 {
+		return nil
+	}
+	if parallel > numChunks {
+		parallel = numChunks
+	}
+
+	type chunkResult struct {
+		index int
+		data  []byte
+		err   error
+	}
+
+	jobs := make(chan int)
+	results := make(chan chunkResult)
+
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				offset, size := simpleFSChunkRange(idx, totalBytes, chunkSize)
+				data, err := simpleFSReadChunkWithRetry(ctx, cli, opid, offset, size)
+				results <- chunkResult{index: idx, data: data, err: err}
+			}
+		}()
+	}
+	go func() {
+		for i := 0; i < numChunks; i++ {
+			jobs <- i
+		}
+		close(jobs)
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	pending := make(map[int][]byte)
+	next := 0
+	var written int64
+	var firstErr error
+	for res := range results {
+		if res.err != nil {
+			if firstErr == nil {
+				firstErr = res.err
+			}
+			continue
+		}
+		pending[res.index] = res.data
+		for firstErr == nil {
+			data, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			if _, err := w.Write(data); err != nil {
+				firstErr = err
+				break
+			}
+			written += int64(len(data))
+			if progress != nil {
+				progress(written)
+			}
+		}
+	}
+	return firstErr
+}
+
+// simpleFSWriteAllParallel writes totalBytes of r (read via ReadAt, so
+// chunks can be pulled out concurrently without racing on a shared cursor)
+// to opid as chunkSize-sized chunks, up to parallel of them in flight at
+// once. Unlike the read side, writes target independent offsets in the
+// destination, so there's nothing to reassemble in order -- each chunk can
+// be written as soon as it's read.
+func simpleFSWriteAllParallel(ctx context.Context, cli keybase1.SimpleFSInterface, opid keybase1.OpID, r io.ReaderAt, totalBytes, chunkSize int64, parallel int, progress func(written int64)) error {
+	numChunks := simpleFSChunkCount(totalBytes, chunkSize)
+	if numChunks == 0 {
+		return nil
+	}
+	if parallel > numChunks {
+		parallel = numChunks
+	}
+
+	jobs := make(chan int)
+	errs := make(chan error, parallel)
+	var mu sync.Mutex
+	var written int64
+
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				offset, size := simpleFSChunkRange(idx, totalBytes, chunkSize)
+				buf := make([]byte, size)
+				if _, err := r.ReadAt(buf, offset); err != nil {
+					errs <- fmt.Errorf("reading source at offset %d: %s", offset, err)
+					continue
+				}
+				if err := simpleFSWriteChunkWithRetry(ctx, cli, opid, offset, buf); err != nil {
+					errs <- err
+					continue
+				}
+				mu.Lock()
+				written += size
+				if progress != nil {
+					progress(written)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	go func() {
+		for i := 0; i < numChunks; i++ {
+			jobs <- i
+		}
+		close(jobs)
+	}()
+	wg.Wait()
+	close(errs)
+
+	var firstErr error
+	for err := range errs {
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,183 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// fakeSimpleFSChunkedClient is a minimal keybase1.SimpleFSInterface backed
+// by an in-memory byte slice for reads and an offset-keyed map for writes,
+// safe for concurrent use by simpleFSReadAllParallel/simpleFSWriteAllParallel.
+// readFails/writeFails let a test make a specific offset fail a fixed
+// number of times before succeeding, to exercise the per-chunk retry path.
+type fakeSimpleFSChunkedClient struct {
+	keybase1.SimpleFSInterface // panics on anything not overridden below
+
+	data []byte
+
+	mu         sync.Mutex
+	readFails  map[int64]int
+	writeFails map[int64]int
+	written    map[int64][]byte
+}
+
+func newFakeSimpleFSChunkedClient(data []byte) *fakeSimpleFSChunkedClient {
+	return &fakeSimpleFSChunkedClient{
+		data:       data,
+		readFails:  map[int64]int{},
+		writeFails: map[int64]int{},
+		written:    map[int64][]byte{},
+	}
+}
+
+func (f *fakeSimpleFSChunkedClient) SimpleFSRead(ctx context.Context, arg keybase1.SimpleFSReadArg) (keybase1.FileContent, error) {
+	f.mu.Lock()
+	if f.readFails[arg.Offset] > 0 {
+		f.readFails[arg.Offset]--
+		f.mu.Unlock()
+		return keybase1.FileContent{}, fmt.Errorf("simulated read failure at offset %d", arg.Offset)
+	}
+	f.mu.Unlock()
+
+	if arg.Offset >= int64(len(f.data)) {
+		return keybase1.FileContent{}, nil
+	}
+	end := arg.Offset + int64(arg.Size)
+	if end > int64(len(f.data)) {
+		end = int64(len(f.data))
+	}
+	return keybase1.FileContent{Data: f.data[arg.Offset:end]}, nil
+}
+
+func (f *fakeSimpleFSChunkedClient) SimpleFSWrite(ctx context.Context, arg keybase1.SimpleFSWriteArg) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.writeFails[arg.Offset] > 0 {
+		f.writeFails[arg.Offset]--
+		return fmt.Errorf("simulated write failure at offset %d", arg.Offset)
+	}
+	content := make([]byte, len(arg.Content))
+	copy(content, arg.Content)
+	f.written[arg.Offset] = content
+	return nil
+}
+
+// reassemble concatenates f.written in offset order, for comparing against
+// the original source content.
+func (f *fakeSimpleFSChunkedClient) reassemble() []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	offsets := make([]int64, 0, len(f.written))
+	for offset := range f.written {
+		offsets = append(offsets, offset)
+	}
+	sortInt64s(offsets)
+	var buf bytes.Buffer
+	for _, offset := range offsets {
+		buf.Write(f.written[offset])
+	}
+	return buf.Bytes()
+}
+
+func sortInt64s(s []int64) {
+	for i := 1; i < len(s); i++ {
+		for j := i; j > 0 && s[j-1] > s[j]; j-- {
+			s[j-1], s[j] = s[j], s[j-1]
+		}
+	}
+}
+
+func randomBytes(n int, seed int64) []byte {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(b)
+	return b
+}
+
+func TestSimpleFSReadAllParallelMatchesSequentialOutput(t *testing.T) {
+	const chunkSize = 4096
+	content := randomBytes(5*chunkSize+999, 1)
+	fake := newFakeSimpleFSChunkedClient(content)
+
+	var got bytes.Buffer
+	if err := simpleFSReadAllParallel(context.Background(), fake, keybase1.OpID{}, &got, int64(len(content)), chunkSize, 4, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), content) {
+		t.Fatalf("parallel read output does not match source: got %d bytes, want %d bytes", got.Len(), len(content))
+	}
+}
+
+func TestSimpleFSReadAllParallelRetriesTransientFailures(t *testing.T) {
+	const chunkSize = 4096
+	content := randomBytes(3*chunkSize, 2)
+	fake := newFakeSimpleFSChunkedClient(content)
+	fake.readFails[chunkSize] = simpleFSChunkRetries // fails every attempt but the last
+
+	var got bytes.Buffer
+	if err := simpleFSReadAllParallel(context.Background(), fake, keybase1.OpID{}, &got, int64(len(content)), chunkSize, 2, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), content) {
+		t.Fatal("parallel read output does not match source after a retried chunk")
+	}
+}
+
+func TestSimpleFSReadAllParallelGivesUpAfterExhaustingRetries(t *testing.T) {
+	const chunkSize = 4096
+	content := randomBytes(3*chunkSize, 3)
+	fake := newFakeSimpleFSChunkedClient(content)
+	fake.readFails[chunkSize] = simpleFSChunkRetries + 1 // fails every attempt
+
+	var got bytes.Buffer
+	if err := simpleFSReadAllParallel(context.Background(), fake, keybase1.OpID{}, &got, int64(len(content)), chunkSize, 2, nil); err == nil {
+		t.Fatal("expected an error once a chunk's retries are exhausted")
+	}
+}
+
+func TestSimpleFSWriteAllParallelMatchesSequentialOutput(t *testing.T) {
+	const chunkSize = 4096
+	content := randomBytes(5*chunkSize+999, 4)
+	fake := newFakeSimpleFSChunkedClient(nil)
+
+	if err := simpleFSWriteAllParallel(context.Background(), fake, keybase1.OpID{}, bytes.NewReader(content), int64(len(content)), chunkSize, 4, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.reassemble(); !bytes.Equal(got, content) {
+		t.Fatalf("parallel write output does not match source: got %d bytes, want %d bytes", len(got), len(content))
+	}
+}
+
+func TestSimpleFSWriteAllParallelRetriesTransientFailures(t *testing.T) {
+	const chunkSize = 4096
+	content := randomBytes(3*chunkSize, 5)
+	fake := newFakeSimpleFSChunkedClient(nil)
+	fake.writeFails[chunkSize] = simpleFSChunkRetries // fails every attempt but the last
+
+	if err := simpleFSWriteAllParallel(context.Background(), fake, keybase1.OpID{}, bytes.NewReader(content), int64(len(content)), chunkSize, 2, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.reassemble(); !bytes.Equal(got, content) {
+		t.Fatal("parallel write output does not match source after a retried chunk")
+	}
+}
+
+func TestSimpleFSWriteAllParallelGivesUpAfterExhaustingRetries(t *testing.T) {
+	const chunkSize = 4096
+	content := randomBytes(3*chunkSize, 6)
+	fake := newFakeSimpleFSChunkedClient(nil)
+	fake.writeFails[chunkSize] = simpleFSChunkRetries + 1 // fails every attempt
+
+	if err := simpleFSWriteAllParallel(context.Background(), fake, keybase1.OpID{}, bytes.NewReader(content), int64(len(content)), chunkSize, 2, nil); err == nil {
+		t.Fatal("expected an error once a chunk's retries are exhausted")
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/cli"
+	"github.com/keybase/client/go/libcmdline"
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// CmdSimpleFSComplete is the 'fs complete' command: given a partial KBFS
+// path, it lists the immediate children of the longest existing prefix
+// that could complete it, one candidate per line. It's meant to be called
+// from a bash/zsh completion script, not typed directly, so its output is
+// kept to exactly the candidate paths and nothing else.
+//
+// It resolves the /keybase mount prefix the same way simpleFSPathFromArg
+// (and so makeSimpleFSPath) does elsewhere in this package, and returns no
+// candidates rather than an error for a prefix that doesn't exist -- a
+// completion script re-running on every keystroke shouldn't have to worry
+// about a typo mid-path looking like a failure.
+type CmdSimpleFSComplete struct {
+	libkb.Contextified
+	partial string
+}
+
+func newCmdSimpleFSComplete(cl *libcmdline.CommandLine, g *libkb.GlobalContext) cli.Command {
+	return cli.Command{
+		Name:         "complete",
+		Usage:        "List candidate completions for a partial KBFS path, for shell completion scripts",
+		ArgumentHelp: "<partial-path>",
+		Action: func(c *cli.Context) {
+			cl.ChooseCommand(&CmdSimpleFSComplete{Contextified: libkb.NewContextified(g)}, "complete", c)
+		},
+	}
+}
+
+func (c *CmdSimpleFSComplete) ParseArgv(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		return fmt.Errorf("fs complete takes one argument: <partial-path>")
+	}
+	c.partial = ctx.Args()[0]
+	return nil
+}
+
+func (c *CmdSimpleFSComplete) Run() (err error) {
+	defer func() {
+		err = fsFinishError(c.G(), err)
+	}()
+
+	fsClient, err := GetSimpleFSClient(c.G())
+	if err != nil {
+		return err
+	}
+
+	ctx := context.TODO()
+	lister := &simpleFSRPCLister{ctx: ctx, cli: fsClient}
+
+	for _, candidate := range simpleFSCompletions(ctx, lister, c.partial) {
+		fmt.Fprintln(os.Stdout, candidate)
+	}
+	return nil
+}
+
+// simpleFSCompletions lists the candidate completions of partial: the
+// names of dir's children (dir being the longest existing prefix of
+// partial that names a directory) that start with whatever partial's
+// final, not-yet-complete path component is, each rejoined onto dir and
+// suffixed with "/" if it's itself a directory. It returns nil, without
+// an error, if dir can't be listed (most commonly because it doesn't
+// exist).
+func simpleFSCompletions(ctx context.Context, lister simpleFSLister, partial string) []string {
+	dirArg, prefix := simpleFSCompletePrefixSplit(partial)
+	dir := simpleFSPathFromArg(dirArg)
+
+	entries, err := lister.List(ctx, dir)
+	if err != nil {
+		return nil
+	}
+
+	var candidates []string
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name, prefix) {
+			continue
+		}
+		candidates = append(candidates, simpleFSCompleteJoin(dirArg, e.Name, e.DirentType == keybase1.DirentType_DIR))
+	}
+	return candidates
+}
+
+// simpleFSCompletePrefixSplit splits partial into the directory to list
+// and the prefix its children's names must start with, the way shell
+// completion splits a partially-typed path: "/keybase/private/al" splits
+// into ("/keybase/private", "al"). A partial path already ending in "/"
+// lists that directory's children outright, with an empty prefix.
+func simpleFSCompletePrefixSplit(partial string) (dir, prefix string) {
+	if strings.HasSuffix(partial, "/") {
+		return strings.TrimSuffix(partial, "/"), ""
+	}
+	return path.Dir(partial), path.Base(partial)
+}
+
+// simpleFSCompleteJoin rejoins name onto dir (path.Dir's output, which is
+// "." for a bare name with no directory component of its own) to build a
+// full completion candidate, appending "/" when isDir so the result is
+// ready to be completed again one level deeper.
+func simpleFSCompleteJoin(dir, name string, isDir bool) string {
+	candidate := name
+	if dir != "." && dir != "" {
+		candidate = strings.TrimSuffix(dir, "/") + "/" + name
+	}
+	if isDir {
+		candidate += "/"
+	}
+	return candidate
+}
+
+func (c *CmdSimpleFSComplete) GetUsage() libkb.Usage {
+	return libkb.Usage{
+		Config: true,
+		API:    true,
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+func simpleFSCompleteTestLister() *fakeLister {
+	return &fakeLister{
+		dirs: map[string][]keybase1.Dirent{
+			"/keybase/private/alice": {
+				{Name: "alpha.txt", DirentType: keybase1.DirentType_FILE},
+				{Name: "album", DirentType: keybase1.DirentType_DIR},
+				{Name: "beta.txt", DirentType: keybase1.DirentType_FILE},
+			},
+		},
+	}
+}
+
+func TestSimpleFSCompletionsMatchingDirectoryPrefix(t *testing.T) {
+	lister := simpleFSCompleteTestLister()
+	got := simpleFSCompletions(context.Background(), lister, "/keybase/private/alice/al")
+
+	want := []string{"/keybase/private/alice/alpha.txt", "/keybase/private/alice/album/"}
+	if !stringSlicesEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSimpleFSCompletionsMatchingFilePrefix(t *testing.T) {
+	lister := simpleFSCompleteTestLister()
+	got := simpleFSCompletions(context.Background(), lister, "/keybase/private/alice/bet")
+
+	want := []string{"/keybase/private/alice/beta.txt"}
+	if !stringSlicesEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSimpleFSCompletionsListsWholeDirectoryWithTrailingSlash(t *testing.T) {
+	lister := simpleFSCompleteTestLister()
+	got := simpleFSCompletions(context.Background(), lister, "/keybase/private/alice/")
+
+	want := []string{
+		"/keybase/private/alice/alpha.txt",
+		"/keybase/private/alice/album/",
+		"/keybase/private/alice/beta.txt",
+	}
+	if !stringSlicesEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSimpleFSCompletionsReturnsNoneForNonexistentPrefix(t *testing.T) {
+	lister := simpleFSCompleteTestLister()
+	got := simpleFSCompletions(context.Background(), lister, "/keybase/private/bob/anything")
+
+	if len(got) != 0 {
+		t.Errorf("expected no completions for a nonexistent prefix, got %v", got)
+	}
+}
@@ -0,0 +1,847 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/cli"
+	"github.com/keybase/client/go/libcmdline"
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// CmdSimpleFSCopy is the 'fs cp' command, which copies a file to or from
+// KBFS (or within KBFS), or streams one in from an http(s) URL, using the
+// SimpleFS RPC API.
+type CmdSimpleFSCopy struct {
+	libkb.Contextified
+	src           string
+	dest          string
+	makeParents   bool
+	force         bool
+	recursive     bool
+	existing      existingFilePolicy
+	checksum      bool
+	verify        bool
+	hashAlgo      simpleFSChecksumAlgorithm
+	hashCache     map[string][]byte
+	headers       http.Header
+	atomic        bool
+	resume        bool
+	noClobber     bool
+	preserveTimes bool
+	summary       bool
+	quiet         bool
+	dryRun        bool
+
+	// bytesTransferred and bytesKnown back --summary's byte count; see
+	// copyFromURL, the only path that currently sets them.
+	bytesTransferred int64
+	bytesKnown       bool
+}
+
+func newCmdSimpleFSCopy(cl *libcmdline.CommandLine, g *libkb.GlobalContext) cli.Command {
+	return cli.Command{
+		Name:         "cp",
+		Usage:        "Copy a file into, out of, or within KBFS",
+		ArgumentHelp: "<source> <destination>",
+		Action: func(c *cli.Context) {
+			cl.ChooseCommand(&CmdSimpleFSCopy{Contextified: libkb.NewContextified(g)}, "cp", c)
+		},
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "make-parents",
+				Usage: "Create missing destination parent directories",
+			},
+			cli.BoolFlag{
+				Name:  "f, force",
+				Usage: "Overwrite an existing destination without prompting",
+			},
+			cli.BoolFlag{
+				Name:  "r, recursive",
+				Usage: "Copy a directory and everything under it",
+			},
+			cli.StringFlag{
+				Name:  "existing",
+				Usage: "What to do when the destination exists: prompt, skip, overwrite, or update (overwrite only if source is newer)",
+			},
+			cli.BoolFlag{
+				Name:  "checksum",
+				Usage: "Skip the copy if source and destination already have identical content, comparing a checksum instead of mtime (slower, but correct across filesystems)",
+			},
+			cli.StringFlag{
+				Name:  "checksum-algorithm",
+				Usage: "Hash algorithm to use with --checksum and --verify: sha256 (default), sha512, or md5 (not cryptographically secure; for matching external manifests only)",
+			},
+			cli.BoolFlag{
+				Name:  "verify",
+				Usage: "After copying, read back the destination and compare its checksum to the source; fail if they don't match",
+			},
+			cli.StringSliceFlag{
+				Name:  "header",
+				Usage: "Extra \"Key: Value\" header to send when <source> is an http(s) URL (e.g. for auth); repeatable",
+			},
+			cli.BoolFlag{
+				Name:  "atomic",
+				Usage: "Copy to a temporary file in the destination's directory and rename into place, so readers never observe a partially-written destination; requires a /keybase/... destination",
+			},
+			cli.BoolFlag{
+				Name:  "resume",
+				Usage: "Continue an interrupted copy by appending to an existing partial destination instead of starting over; requires a /keybase/... destination. With --verify, the already-written prefix is hash-compared against source before appending, instead of the usual post-copy full-file verify",
+			},
+			cli.BoolFlag{
+				Name:  "no-clobber",
+				Usage: "Skip a destination that already exists without prompting or overwriting; shorthand for --existing=skip",
+			},
+			cli.BoolFlag{
+				Name:  "preserve-times",
+				Usage: "Set the destination's mtime to match the source's after copying; local destinations only, since SimpleFS has no RPC to set a KBFS file's mtime",
+			},
+			cli.BoolFlag{
+				Name:  "summary",
+				Usage: "Print a one-line summary (items copied, bytes transferred, failures, elapsed time) to stderr when done",
+			},
+			cli.BoolFlag{
+				Name:  "quiet",
+				Usage: "Suppress the --summary report and the progress display",
+			},
+			cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Resolve sources and destinations and print what would be copied, without copying anything",
+			},
+		},
+	}
+}
+
+func (c *CmdSimpleFSCopy) ParseArgv(ctx *cli.Context) error {
+	if len(ctx.Args()) != 2 {
+		return errors.New("fs cp takes two arguments: <source> <destination>")
+	}
+	c.src = ctx.Args()[0]
+	c.dest = ctx.Args()[1]
+	c.makeParents = ctx.Bool("make-parents")
+	c.force = ctx.Bool("force")
+	c.recursive = ctx.Bool("recursive")
+	c.checksum = ctx.Bool("checksum")
+	c.verify = ctx.Bool("verify")
+	c.atomic = ctx.Bool("atomic")
+	c.resume = ctx.Bool("resume")
+	c.noClobber = ctx.Bool("no-clobber")
+	c.preserveTimes = ctx.Bool("preserve-times")
+	c.summary = ctx.Bool("summary")
+	c.quiet = ctx.Bool("quiet")
+	c.dryRun = ctx.Bool("dry-run")
+
+	algo, err := parseChecksumAlgorithm(ctx.String("checksum-algorithm"), func(msg string) {
+		c.G().Log.Warning(msg)
+	})
+	if err != nil {
+		return err
+	}
+	c.hashAlgo = algo
+
+	if err := c.checkClobberFlags(); err != nil {
+		return err
+	}
+
+	existing, err := parseExistingFilePolicy(ctx.String("existing"))
+	if err != nil {
+		return err
+	}
+	if c.force {
+		existing = existingPolicyOverwrite
+	}
+	if c.noClobber {
+		existing = existingPolicySkip
+	}
+	c.existing = existing
+
+	if isHTTPURL(c.src) {
+		if c.checksum {
+			return errors.New("fs cp --checksum is not supported when the source is a URL")
+		}
+		if c.verify {
+			return errors.New("fs cp --verify is not supported when the source is a URL")
+		}
+		if c.existing == existingPolicyUpdate {
+			return errors.New("fs cp --existing=update is not supported when the source is a URL")
+		}
+		headers, err := parseHTTPHeaderFlags(ctx.StringSlice("header"))
+		if err != nil {
+			return err
+		}
+		c.headers = headers
+	} else if len(ctx.StringSlice("header")) > 0 {
+		return errors.New("fs cp --header only applies when the source is a URL")
+	}
+
+	if err := c.checkResumeFlags(); err != nil {
+		return err
+	}
+
+	return c.checkRecursiveFlags()
+}
+
+// checkResumeFlags rejects --resume combinations that don't make sense: an
+// http(s) source (copyFromURL always streams straight through from offset
+// 0, so there's no partial destination from a prior run to pick back up
+// from), -r (SimpleFSCopyRecursive walks the tree server-side, so the CLI
+// never sees a single partial file's offset to resume), and --atomic
+// (which only reveals its result at the real destination path once the
+// whole copy is done, so that path never holds a partial file to resume
+// from either).
+func (c *CmdSimpleFSCopy) checkResumeFlags() error {
+	if !c.resume {
+		return nil
+	}
+	if isHTTPURL(c.src) {
+		return errors.New("fs cp --resume is not supported when the source is a URL")
+	}
+	if c.recursive {
+		return errors.New("fs cp --resume does not support -r")
+	}
+	if c.atomic {
+		return errors.New("fs cp --resume does not support --atomic")
+	}
+	return nil
+}
+
+// checkRecursiveFlags rejects -r combinations that don't make sense: an
+// http(s) source (there's no directory to walk), --checksum (which hashes
+// a single file's content), --verify (same reason -- SimpleFSCopyRecursive
+// walks the tree server-side, so the CLI never sees individual files to
+// verify one at a time), and --atomic (which stages a single file under a
+// temporary name).
+func (c *CmdSimpleFSCopy) checkRecursiveFlags() error {
+	if !c.recursive {
+		return nil
+	}
+	if isHTTPURL(c.src) {
+		return errors.New("fs cp -r is not supported when the source is a URL")
+	}
+	if c.checksum {
+		return errors.New("fs cp -r does not support --checksum")
+	}
+	if c.verify {
+		return errors.New("fs cp -r does not support --verify")
+	}
+	if c.atomic {
+		return errors.New("fs cp -r does not support --atomic")
+	}
+	return nil
+}
+
+// checkClobberFlags rejects --force combined with --no-clobber, since
+// they ask for opposite behavior when the destination already exists.
+func (c *CmdSimpleFSCopy) checkClobberFlags() error {
+	if c.force && c.noClobber {
+		return errors.New("fs cp --force and --no-clobber are mutually exclusive")
+	}
+	return nil
+}
+
+// isHTTPURL reports whether src names an http(s) URL rather than a local
+// or KBFS path.
+func isHTTPURL(src string) bool {
+	return strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://")
+}
+
+// parseHTTPHeaderFlags parses a set of "Key: Value" --header flags into an
+// http.Header, the way curl's -H does.
+func parseHTTPHeaderFlags(headers []string) (http.Header, error) {
+	h := make(http.Header, len(headers))
+	for _, header := range headers {
+		parts := strings.SplitN(header, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid --header %q (want \"Key: Value\")", header)
+		}
+		h.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+	return h, nil
+}
+
+func (c *CmdSimpleFSCopy) Run() (err error) {
+	start := time.Now()
+	defer func() {
+		if c.dryRun {
+			err = fsFinishError(c.G(), err)
+			return
+		}
+		simpleFSRecordOp(simpleFSOpsLogPath(c.G()), fmt.Sprintf("cp %s -> %s", c.src, c.dest), start, err)
+		if c.summary {
+			printSimpleFSOpSummary(simpleFSOpSummary{
+				Verb:             "copied",
+				Start:            start,
+				Err:              err,
+				BytesTransferred: c.bytesTransferred,
+				BytesKnown:       c.bytesKnown,
+			}, c.quiet)
+		}
+		err = fsFinishError(c.G(), err)
+	}()
+
+	fsClient, err := GetSimpleFSClient(c.G())
+	if err != nil {
+		return err
+	}
+
+	dest := simpleFSPathFromArg(c.dest)
+	if c.atomic && !simpleFSIsKbfs(dest) {
+		return errors.New("fs cp --atomic requires a /keybase/... destination")
+	}
+	if c.resume && !simpleFSIsKbfs(dest) {
+		return errors.New("fs cp --resume requires a /keybase/... destination")
+	}
+
+	ctx := context.TODO()
+
+	if isHTTPURL(c.src) {
+		if !simpleFSIsKbfs(dest) {
+			return errors.New("fs cp from a URL requires a /keybase/... destination")
+		}
+		if c.dryRun {
+			fmt.Fprintf(os.Stdout, "would copy %s -> %s\n", c.src, simpleFSDescribePath(dest))
+			return nil
+		}
+		if c.makeParents {
+			if err := simpleFSMkdirParent(ctx, fsClient, dest); err != nil {
+				return err
+			}
+		}
+		if simpleFSExists(ctx, fsClient, dest) {
+			proceed, err := simpleFSConfirmOverwrite(c.G(), c.existing, c.dest, nil)
+			if err != nil {
+				return err
+			}
+			if !proceed {
+				return nil
+			}
+		}
+		if c.atomic {
+			return simpleFSAtomicPublish(ctx, fsClient, dest, time.Now(), func(tmp keybase1.Path) error {
+				return c.copyFromURL(ctx, fsClient, tmp)
+			})
+		}
+		return c.copyFromURL(ctx, fsClient, dest)
+	}
+
+	matches, err := simpleFSExpandGlob(ctx, &simpleFSRPCLister{ctx: ctx, cli: fsClient}, c.src)
+	if err != nil {
+		return err
+	}
+	if len(matches) > 1 {
+		return c.copyGlobMatches(ctx, fsClient, matches, dest)
+	}
+
+	return c.copyOne(ctx, fsClient, matches[0], dest)
+}
+
+// copyGlobMatches copies each of matches (the expansion of a glob source
+// pattern) into dest, which must already be an existing directory --
+// there's no single file name to copy multiple sources onto otherwise.
+// It stops at the first failure rather than copying the remaining matches,
+// the same as the single-source path above.
+func (c *CmdSimpleFSCopy) copyGlobMatches(ctx context.Context, fsClient keybase1.SimpleFSInterface, matches []string, dest keybase1.Path) error {
+	isDir, err := simpleFSIsDir(ctx, fsClient, dest)
+	if err != nil || !isDir {
+		return fmt.Errorf("fs cp: %q matches %d paths; %q must already be an existing directory", c.src, len(matches), c.dest)
+	}
+
+	for _, m := range matches {
+		if err := c.copyOne(ctx, fsClient, m, simpleFSChildPath(dest, path.Base(m))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyOne copies the single source path srcArg to dest, applying all of
+// the flags (--make-parents, -r, --force/--existing, --checksum,
+// --atomic, --resume) the way the single-source case at the top of Run
+// always has.
+// With --dry-run, it resolves src and dest and stops right after the
+// directory/recursive validation above, before making or touching
+// anything.
+func (c *CmdSimpleFSCopy) copyOne(ctx context.Context, fsClient keybase1.SimpleFSInterface, srcArg string, dest keybase1.Path) (err error) {
+	src := simpleFSPathFromArg(srcArg)
+
+	srcIsDir, err := simpleFSIsDir(ctx, fsClient, src)
+	if err != nil {
+		return err
+	}
+	if srcIsDir && !c.recursive {
+		return fmt.Errorf("%q is a directory (use -r to copy it recursively)", srcArg)
+	}
+	if !srcIsDir && c.recursive {
+		return fmt.Errorf("fs cp -r: %q is not a directory", srcArg)
+	}
+
+	if c.dryRun {
+		fmt.Fprintf(os.Stdout, "would copy %s -> %s\n", simpleFSDescribePath(src), simpleFSDescribePath(dest))
+		return nil
+	}
+
+	if c.makeParents {
+		if err := simpleFSMkdirParent(ctx, fsClient, dest); err != nil {
+			return err
+		}
+		if !simpleFSIsKbfs(dest) {
+			if err := simpleFSLocalMkdirParent(dest.Local(), true); err != nil {
+				return err
+			}
+		}
+	}
+
+	// totalBytes is left at 0 (an unknown total, which the progress printer
+	// renders without a percentage or ETA) for a recursive copy, since a
+	// directory's aggregate size isn't available up front; stat failures on
+	// the non-recursive path are likewise non-fatal, since progress display
+	// is a nice-to-have, not something worth failing the copy over.
+	var totalBytes int64
+	if !c.recursive {
+		if size, err := simpleFSSize(ctx, fsClient, src); err == nil {
+			totalBytes = size
+		}
+	}
+	printer := newSimpleFSProgressPrinter(c.quiet)
+
+	if c.resume {
+		if err := simpleFSResumeCopy(ctx, fsClient, src, dest, c.verify, c.hashAlgo, totalBytes, printer); err != nil {
+			if !c.makeParents && isSimpleFSMissingParentErr(err) {
+				return errDestParentMissing(simpleFSPathString(dest))
+			}
+			return err
+		}
+		if c.preserveTimes {
+			return simpleFSPreserveTimes(ctx, fsClient, src, dest, false)
+		}
+		return nil
+	}
+
+	if simpleFSExists(ctx, fsClient, dest) {
+		if c.checksum {
+			same, err := c.contentsEqual(ctx, fsClient, src, dest)
+			if err != nil {
+				return err
+			}
+			if same {
+				return nil
+			}
+		}
+
+		proceed, err := simpleFSConfirmOverwrite(c.G(), c.existing, simpleFSPathString(dest), func() (bool, error) {
+			return c.srcNewerThanDest(ctx, fsClient, src, dest)
+		})
+		if err != nil {
+			return err
+		}
+		if !proceed {
+			return nil
+		}
+	}
+
+	if c.recursive {
+		err = simpleFSCopyRecursiveOnce(ctx, fsClient, src, dest, totalBytes, printer)
+	} else if c.atomic {
+		err = simpleFSAtomicPublish(ctx, fsClient, dest, time.Now(), func(tmp keybase1.Path) error {
+			return simpleFSCopyOnce(ctx, fsClient, src, tmp, totalBytes, printer)
+		})
+	} else {
+		err = simpleFSCopyOnce(ctx, fsClient, src, dest, totalBytes, printer)
+	}
+	if err != nil {
+		if !c.makeParents && isSimpleFSMissingParentErr(err) {
+			return errDestParentMissing(simpleFSPathString(dest))
+		}
+		return err
+	}
+
+	if c.preserveTimes {
+		if err := simpleFSPreserveTimes(ctx, fsClient, src, dest, c.recursive); err != nil {
+			return err
+		}
+	}
+
+	if c.verify {
+		return c.verifyCopy(ctx, fsClient, src, dest)
+	}
+	return nil
+}
+
+// verifyCopy re-reads dest after copyOne's copy has completed and compares
+// it against src, for --verify. dest is always the final destination by
+// this point even under --atomic, since simpleFSAtomicPublish has already
+// renamed the temporary file into place by the time copyOne's dispatch
+// above returns.
+func (c *CmdSimpleFSCopy) verifyCopy(ctx context.Context, cli keybase1.SimpleFSInterface, src, dest keybase1.Path) error {
+	match, diffOffset, diffOffsetKnown, err := simpleFSVerifyCopy(ctx, cli, src, dest, c.hashAlgo)
+	if err != nil {
+		return err
+	}
+	if match {
+		return nil
+	}
+	if diffOffsetKnown {
+		return fmt.Errorf("fs cp --verify: %s and %s differ, first mismatch at byte offset %d", simpleFSPathString(src), simpleFSPathString(dest), diffOffset)
+	}
+	return fmt.Errorf("fs cp --verify: %s and %s differ", simpleFSPathString(src), simpleFSPathString(dest))
+}
+
+// simpleFSPreserveTimes sets dest's mtime to match src's after a copy has
+// completed, for --preserve-times. It only supports a local destination:
+// SimpleFSSetStat only toggles the executable bit, and SimpleFS has no
+// other RPC that sets an arbitrary mtime on a KBFS file, so a KBFS
+// destination is refused outright rather than silently doing nothing.
+// For a recursive copy, dest is walked and each file's mtime is set to
+// match the correspondingly-named file under src, since
+// SimpleFSCopyRecursive's walk happens entirely server-side and never
+// hands the individual files it copied back to the client.
+func simpleFSPreserveTimes(ctx context.Context, cli keybase1.SimpleFSInterface, src, dest keybase1.Path, recursive bool) error {
+	if simpleFSIsKbfs(dest) {
+		return errors.New("fs cp --preserve-times does not support a KBFS destination: SimpleFS has no RPC to set a file's mtime")
+	}
+
+	if !recursive {
+		mtime, err := simpleFSModTime(ctx, cli, src)
+		if err != nil {
+			return err
+		}
+		return os.Chtimes(dest.Local(), mtime, mtime)
+	}
+
+	return filepath.Walk(dest.Local(), func(destPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dest.Local(), destPath)
+		if err != nil {
+			return err
+		}
+		mtime, err := simpleFSModTime(ctx, cli, simpleFSChildPath(src, rel))
+		if err != nil {
+			return err
+		}
+		return os.Chtimes(destPath, mtime, mtime)
+	})
+}
+
+// simpleFSResumeCopy copies src to dest for --resume, continuing a
+// previous copy that was interrupted partway through instead of starting
+// over. It compares dest's current size to src's: a dest already as large
+// as src means the copy already finished, and a dest larger than src is
+// refused outright rather than guessed about. With verify set, the prefix
+// of src up to dest's current size is hash-compared against dest's full
+// content before anything more is written, so resuming never appends past
+// a corrupt or unrelated partial file.
+func simpleFSResumeCopy(ctx context.Context, cli keybase1.SimpleFSInterface, src, dest keybase1.Path, verify bool, algo simpleFSChecksumAlgorithm, totalBytes int64, printer simpleFSProgressUpdater) error {
+	srcSize, err := simpleFSSize(ctx, cli, src)
+	if err != nil {
+		return err
+	}
+
+	var destSize int64
+	if simpleFSExists(ctx, cli, dest) {
+		destSize, err = simpleFSSize(ctx, cli, dest)
+		if err != nil {
+			return err
+		}
+	}
+
+	if destSize > srcSize {
+		return fmt.Errorf("fs cp --resume: %s (%d bytes) is larger than %s (%d bytes); refusing to resume", simpleFSPathString(dest), destSize, simpleFSPathString(src), srcSize)
+	}
+
+	if destSize > 0 && verify {
+		srcPrefix, err := simpleFSHashPrefix(ctx, cli, src, algo, destSize)
+		if err != nil {
+			return err
+		}
+		destPrefix, err := simpleFSHashPrefix(ctx, cli, dest, algo, destSize)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(srcPrefix, destPrefix) {
+			return fmt.Errorf("fs cp --resume: the first %d bytes of %s do not match %s; refusing to resume", destSize, simpleFSPathString(dest), simpleFSPathString(src))
+		}
+	}
+
+	if destSize == srcSize {
+		printer.Update(destSize, totalBytes)
+		printer.Finish()
+		return nil
+	}
+
+	return simpleFSCopyRange(ctx, cli, src, dest, destSize, totalBytes, printer)
+}
+
+// simpleFSHashPrefix hashes the first length bytes of p's content under
+// algo, for comparing dest's already-written prefix against the
+// corresponding prefix of src before --resume --verify appends to it.
+func simpleFSHashPrefix(ctx context.Context, cli keybase1.SimpleFSInterface, p keybase1.Path, algo simpleFSChecksumAlgorithm, length int64) ([]byte, error) {
+	r, err := simpleFSOpenContentReader(ctx, cli, p)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	h := algo.newHash()
+	if _, err := io.CopyN(h, r, length); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// simpleFSCopyRange appends src's content starting at startOffset onto
+// dest for --resume, streaming through SimpleFSWrite the same way
+// copyFromURL does rather than via the opaque SimpleFSCopy RPC, since
+// SimpleFSCopy has no notion of a starting offset. startOffset of 0 opens
+// dest fresh (OpenFlags_REPLACE), matching a plain copy; a nonzero
+// startOffset opens dest without OpenFlags_REPLACE, leaving the bytes
+// simpleFSResumeCopy already verified untouched.
+func simpleFSCopyRange(ctx context.Context, cli keybase1.SimpleFSInterface, src, dest keybase1.Path, startOffset, totalBytes int64, printer simpleFSProgressUpdater) error {
+	srcReader, err := simpleFSOpenContentReaderAt(ctx, cli, src, startOffset)
+	if err != nil {
+		return err
+	}
+	defer srcReader.Close()
+
+	opid, err := cli.SimpleFSMakeOpid(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = cli.SimpleFSClose(ctx, opid) }()
+
+	flags := keybase1.OpenFlags_WRITE | keybase1.OpenFlags_REPLACE
+	if startOffset > 0 {
+		flags = keybase1.OpenFlags_WRITE | keybase1.OpenFlags_EXISTING
+	}
+	if err := cli.SimpleFSOpen(ctx, keybase1.SimpleFSOpenArg{
+		OpID:  opid,
+		Dest:  dest,
+		Flags: flags,
+	}); err != nil {
+		return err
+	}
+
+	printer.Update(startOffset, totalBytes)
+	_, err = simpleFSStreamWrite(ctx, cli, opid, startOffset, srcReader, simpleFSCopyWriteChunkSize, func(written int64) {
+		printer.Update(written, totalBytes)
+	})
+	printer.Finish()
+	return err
+}
+
+// simpleFSCopyOnce copies src to dest via a single SimpleFSCopy call,
+// driving printer with totalBytes (0 if unknown) while waiting for it to
+// finish.
+func simpleFSCopyOnce(ctx context.Context, cli keybase1.SimpleFSInterface, src, dest keybase1.Path, totalBytes int64, printer simpleFSProgressUpdater) error {
+	opid, err := cli.SimpleFSMakeOpid(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = cli.SimpleFSClose(ctx, opid) }()
+
+	if err := cli.SimpleFSCopy(ctx, keybase1.SimpleFSCopyArg{
+		OpID: opid,
+		Src:  src,
+		Dest: dest,
+	}); err != nil {
+		return err
+	}
+	return simpleFSWaitWithProgress(ctx, cli, opid, totalBytes, printer)
+}
+
+// simpleFSCopyRecursiveOnce copies the directory tree rooted at src to dest
+// via a single SimpleFSCopyRecursive call. Unlike simpleFSCopyOnce, the
+// walk of src's contents happens inside that one opid on the service side,
+// not in the CLI; the client only starts the operation and waits for it to
+// finish, driving printer with totalBytes (0 if unknown) in the meantime.
+func simpleFSCopyRecursiveOnce(ctx context.Context, cli keybase1.SimpleFSInterface, src, dest keybase1.Path, totalBytes int64, printer simpleFSProgressUpdater) error {
+	opid, err := cli.SimpleFSMakeOpid(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = cli.SimpleFSClose(ctx, opid) }()
+
+	if err := cli.SimpleFSCopyRecursive(ctx, keybase1.SimpleFSCopyRecursiveArg{
+		OpID: opid,
+		Src:  src,
+		Dest: dest,
+	}); err != nil {
+		return err
+	}
+	return simpleFSWaitWithProgress(ctx, cli, opid, totalBytes, printer)
+}
+
+// srcNewerThanDest reports whether src's mtime is strictly after dest's,
+// for --existing=update.
+func (c *CmdSimpleFSCopy) srcNewerThanDest(ctx context.Context, cli keybase1.SimpleFSInterface, src, dest keybase1.Path) (bool, error) {
+	srcTime, err := simpleFSModTime(ctx, cli, src)
+	if err != nil {
+		return false, err
+	}
+	destTime, err := simpleFSModTime(ctx, cli, dest)
+	if err != nil {
+		return false, err
+	}
+	return srcTime.After(destTime), nil
+}
+
+// contentsEqual reports whether src and dest have identical content, for
+// --checksum.
+func (c *CmdSimpleFSCopy) contentsEqual(ctx context.Context, cli keybase1.SimpleFSInterface, src, dest keybase1.Path) (bool, error) {
+	srcHash, err := c.hashOf(ctx, cli, src)
+	if err != nil {
+		return false, err
+	}
+	destHash, err := c.hashOf(ctx, cli, dest)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(srcHash, destHash), nil
+}
+
+// hashOf returns the digest of p's content under c.hashAlgo, computing it
+// at most once per run: a path already hashed this run (e.g. the same
+// source checked with --checksum against more than one destination) is
+// served from c.hashCache instead of being re-read.
+func (c *CmdSimpleFSCopy) hashOf(ctx context.Context, cli keybase1.SimpleFSInterface, p keybase1.Path) ([]byte, error) {
+	key := simpleFSPathCacheKey(p)
+	if h, ok := c.hashCache[key]; ok {
+		return h, nil
+	}
+
+	h, err := simpleFSStreamHash(ctx, cli, p, c.hashAlgo)
+	if err != nil {
+		return nil, err
+	}
+	if c.hashCache == nil {
+		c.hashCache = make(map[string][]byte)
+	}
+	c.hashCache[key] = h
+	return h, nil
+}
+
+// simpleFSPathCacheKey returns a string that uniquely identifies p for use
+// as a map key, disambiguating a local path from a KBFS path of the same
+// name.
+func simpleFSPathCacheKey(p keybase1.Path) string {
+	if !simpleFSIsKbfs(p) {
+		return "local:" + p.Local()
+	}
+	return "kbfs:" + p.Kbfs()
+}
+
+// simpleFSCopyWriteChunkSize is the chunk size used when streaming an
+// http(s) source into KBFS, mirroring simpleFSReadChunkSize's role on the
+// read side.
+const simpleFSCopyWriteChunkSize = 128 * 1024
+
+// copyFromURL streams src (already known to be an http(s) URL) into dest
+// via SimpleFSWrite, without staging the response body on local disk.
+// Redirects are followed and headers are sent the way http.DefaultClient
+// always does; a non-200 response is reported as an error rather than
+// written out as if it were the file.
+func (c *CmdSimpleFSCopy) copyFromURL(ctx context.Context, fsClient keybase1.SimpleFSInterface, dest keybase1.Path) error {
+	req, err := http.NewRequest("GET", c.src, nil)
+	if err != nil {
+		return err
+	}
+	for key, values := range c.headers {
+		for _, v := range values {
+			req.Header.Add(key, v)
+		}
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fs cp: %s: unexpected status %s", c.src, resp.Status)
+	}
+
+	opid, err := fsClient.SimpleFSMakeOpid(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = fsClient.SimpleFSClose(ctx, opid) }()
+
+	err = fsClient.SimpleFSOpen(ctx, keybase1.SimpleFSOpenArg{
+		OpID:  opid,
+		Dest:  dest,
+		Flags: keybase1.OpenFlags_WRITE | keybase1.OpenFlags_REPLACE,
+	})
+	if err != nil {
+		if !c.makeParents && isSimpleFSMissingParentErr(err) {
+			return errDestParentMissing(c.dest)
+		}
+		return err
+	}
+
+	printer := newSimpleFSProgressPrinter(c.quiet)
+	written, err := simpleFSStreamWrite(ctx, fsClient, opid, 0, resp.Body, simpleFSCopyWriteChunkSize, func(written int64) {
+		printer.Update(written, resp.ContentLength)
+	})
+	printer.Finish()
+	c.bytesTransferred = written
+	c.bytesKnown = true
+	return err
+}
+
+// simpleFSStreamWrite reads r in chunkSize chunks and writes each one to
+// opid at increasing offsets via SimpleFSWrite, starting at startOffset, so
+// the caller never has to hold the whole of r in memory at once. progress,
+// if non-nil, is called after each chunk is written with the absolute
+// offset written up to so far.
+func simpleFSStreamWrite(ctx context.Context, cli keybase1.SimpleFSInterface, opid keybase1.OpID, startOffset int64, r io.Reader, chunkSize int, progress func(written int64)) (int64, error) {
+	buf := make([]byte, chunkSize)
+	offset := startOffset
+	for {
+		n, readErr := r.Read(buf)
+		if n > 0 {
+			if err := cli.SimpleFSWrite(ctx, keybase1.SimpleFSWriteArg{
+				OpID:    opid,
+				Offset:  offset,
+				Content: buf[:n],
+			}); err != nil {
+				return offset, err
+			}
+			offset += int64(n)
+			if progress != nil {
+				progress(offset)
+			}
+		}
+		if readErr == io.EOF {
+			return offset, nil
+		}
+		if readErr != nil {
+			return offset, readErr
+		}
+	}
+}
+
+func (c *CmdSimpleFSCopy) GetUsage() libkb.Usage {
+	return libkb.Usage{
+		Config: true,
+		API:    true,
+	}
+}
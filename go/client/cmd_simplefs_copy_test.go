@@ -0,0 +1,793 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+func writeTempFile(t *testing.T, dir, name string, content []byte, mtime time.Time) string {
+	p := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(p, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(p, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestSimpleFSCopyChecksumSameContentDifferentMtime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "simplefscopy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	src := writeTempFile(t, dir, "src", []byte("identical content"), now)
+	dest := writeTempFile(t, dir, "dest", []byte("identical content"), now.Add(-24*time.Hour))
+
+	c := &CmdSimpleFSCopy{checksum: true}
+	same, err := c.contentsEqual(context.Background(), nil, simpleFSPathFromArg(src), simpleFSPathFromArg(dest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !same {
+		t.Error("expected identical-content files to compare equal despite differing mtimes")
+	}
+}
+
+func TestSimpleFSCopyChecksumDifferentContentSameMtime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "simplefscopy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mtime := time.Now()
+	src := writeTempFile(t, dir, "src", []byte("source content"), mtime)
+	dest := writeTempFile(t, dir, "dest", []byte("different content"), mtime)
+
+	c := &CmdSimpleFSCopy{checksum: true}
+	same, err := c.contentsEqual(context.Background(), nil, simpleFSPathFromArg(src), simpleFSPathFromArg(dest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if same {
+		t.Error("expected different-content files to compare unequal despite matching mtimes")
+	}
+}
+
+func TestSimpleFSCopyHashOfCachesPerPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "simplefscopy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := writeTempFile(t, dir, "src", []byte("hash me once"), time.Now())
+	path := simpleFSPathFromArg(src)
+
+	c := &CmdSimpleFSCopy{checksum: true}
+	h1, err := c.hashOf(context.Background(), nil, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Truncate the file on disk; if hashOf reads from cache instead of
+	// re-reading, it should still return the originally computed hash.
+	if err := ioutil.WriteFile(src, []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	h2, err := c.hashOf(context.Background(), nil, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(h1) != string(h2) {
+		t.Error("expected hashOf to serve a cached digest for a previously-hashed path instead of re-reading it")
+	}
+}
+
+func TestSimpleFSCopySrcNewerThanDest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "simplefscopy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	older := writeTempFile(t, dir, "older", []byte("a"), now.Add(-time.Hour))
+	newer := writeTempFile(t, dir, "newer", []byte("b"), now)
+
+	c := &CmdSimpleFSCopy{}
+	newerThanOlder, err := c.srcNewerThanDest(context.Background(), nil, simpleFSPathFromArg(newer), simpleFSPathFromArg(older))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !newerThanOlder {
+		t.Error("expected the newer file to be reported newer than the older one")
+	}
+
+	olderThanNewer, err := c.srcNewerThanDest(context.Background(), nil, simpleFSPathFromArg(older), simpleFSPathFromArg(newer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if olderThanNewer {
+		t.Error("expected the older file not to be reported newer than the newer one")
+	}
+}
+
+func TestSimpleFSConfirmOverwriteUpdatePolicy(t *testing.T) {
+	proceed, err := simpleFSConfirmOverwrite(nil, existingPolicyUpdate, "dest", func() (bool, error) {
+		return true, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proceed {
+		t.Error("expected existingPolicyUpdate to proceed when srcNewer reports true")
+	}
+
+	proceed, err = simpleFSConfirmOverwrite(nil, existingPolicyUpdate, "dest", func() (bool, error) {
+		return false, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proceed {
+		t.Error("expected existingPolicyUpdate to skip when srcNewer reports false")
+	}
+}
+
+func TestParseExistingFilePolicyUpdate(t *testing.T) {
+	policy, err := parseExistingFilePolicy("update")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy != existingPolicyUpdate {
+		t.Errorf("expected existingPolicyUpdate, got %v", policy)
+	}
+}
+
+// fakeSimpleFSCopyWriteClient is a minimal keybase1.SimpleFSInterface that
+// records the SimpleFSOpen and SimpleFSWrite calls made against it and
+// reassembles the written content, so copyFromURL can be exercised without
+// a real SimpleFS daemon.
+type fakeSimpleFSCopyWriteClient struct {
+	keybase1.SimpleFSInterface // panics on anything not overridden below
+
+	openArg   keybase1.SimpleFSOpenArg
+	openCalls int
+	written   bytes.Buffer
+	openErr   error
+}
+
+func (f *fakeSimpleFSCopyWriteClient) SimpleFSMakeOpid(ctx context.Context) (keybase1.OpID, error) {
+	return keybase1.OpID{}, nil
+}
+
+func (f *fakeSimpleFSCopyWriteClient) SimpleFSClose(ctx context.Context, opid keybase1.OpID) error {
+	return nil
+}
+
+func (f *fakeSimpleFSCopyWriteClient) SimpleFSOpen(ctx context.Context, arg keybase1.SimpleFSOpenArg) error {
+	f.openArg = arg
+	f.openCalls++
+	return f.openErr
+}
+
+func (f *fakeSimpleFSCopyWriteClient) SimpleFSWrite(ctx context.Context, arg keybase1.SimpleFSWriteArg) error {
+	if int64(f.written.Len()) != arg.Offset {
+		return fmt.Errorf("unexpected write offset %d, written so far is %d bytes", arg.Offset, f.written.Len())
+	}
+	f.written.Write(arg.Content)
+	return nil
+}
+
+func TestSimpleFSCopyFromURLStreamsBody(t *testing.T) {
+	const body = "the quick brown fox jumps over the lazy dog"
+	var gotAuth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		_, _ = w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	fake := &fakeSimpleFSCopyWriteClient{}
+	c := &CmdSimpleFSCopy{
+		src:     srv.URL,
+		dest:    "/keybase/private/alice/fox.txt",
+		headers: http.Header{"Authorization": []string{"Bearer sometoken"}},
+	}
+
+	dest := simpleFSPathFromArg(c.dest)
+	if err := c.copyFromURL(context.Background(), fake, dest); err != nil {
+		t.Fatal(err)
+	}
+
+	if fake.openCalls != 1 {
+		t.Fatalf("expected exactly one SimpleFSOpen call, got %d", fake.openCalls)
+	}
+	if fake.openArg.Flags != keybase1.OpenFlags_WRITE|keybase1.OpenFlags_REPLACE {
+		t.Errorf("expected WRITE|REPLACE flags, got %v", fake.openArg.Flags)
+	}
+	if fake.written.String() != body {
+		t.Errorf("expected written content %q, got %q", body, fake.written.String())
+	}
+	if gotAuth != "Bearer sometoken" {
+		t.Errorf("expected --header value to reach the server, got Authorization %q", gotAuth)
+	}
+}
+
+func TestSimpleFSCopyFromURLStreamsBodyLargerThanChunkSize(t *testing.T) {
+	body := strings.Repeat("x", simpleFSCopyWriteChunkSize*2+17)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	fake := &fakeSimpleFSCopyWriteClient{}
+	c := &CmdSimpleFSCopy{src: srv.URL, dest: "/keybase/private/alice/big.bin"}
+
+	dest := simpleFSPathFromArg(c.dest)
+	if err := c.copyFromURL(context.Background(), fake, dest); err != nil {
+		t.Fatal(err)
+	}
+	if fake.written.Len() != len(body) {
+		t.Fatalf("expected %d bytes written across chunks, got %d", len(body), fake.written.Len())
+	}
+}
+
+func TestSimpleFSCopyFromURLFailsOnNon200(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not found", http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	fake := &fakeSimpleFSCopyWriteClient{}
+	c := &CmdSimpleFSCopy{src: srv.URL, dest: "/keybase/private/alice/missing.txt"}
+
+	dest := simpleFSPathFromArg(c.dest)
+	err := c.copyFromURL(context.Background(), fake, dest)
+	if err == nil {
+		t.Fatal("expected an error for a non-200 response")
+	}
+	if fake.openCalls != 0 {
+		t.Errorf("expected SimpleFSOpen not to be called after a non-200 response, got %d calls", fake.openCalls)
+	}
+}
+
+func TestSimpleFSCopyFromURLFollowsRedirects(t *testing.T) {
+	const body = "redirected content"
+	final := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(body))
+	}))
+	defer final.Close()
+
+	redirector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, final.URL, http.StatusFound)
+	}))
+	defer redirector.Close()
+
+	fake := &fakeSimpleFSCopyWriteClient{}
+	c := &CmdSimpleFSCopy{src: redirector.URL, dest: "/keybase/private/alice/redirected.txt"}
+
+	dest := simpleFSPathFromArg(c.dest)
+	if err := c.copyFromURL(context.Background(), fake, dest); err != nil {
+		t.Fatal(err)
+	}
+	if fake.written.String() != body {
+		t.Errorf("expected redirect to be followed and final body written, got %q", fake.written.String())
+	}
+}
+
+// fakeSimpleFSCopyRecursiveClient is a minimal keybase1.SimpleFSInterface
+// that records the arguments passed to SimpleFSCopy and
+// SimpleFSCopyRecursive, and can be made to fail SimpleFSWait the way a
+// recursive copy that hit an unreadable file partway through would.
+type fakeSimpleFSCopyRecursiveClient struct {
+	keybase1.SimpleFSInterface // panics on anything not overridden below
+
+	stat keybase1.Dirent
+
+	copyCalls          int
+	copyRecursiveCalls int
+	copyRecursiveArg   keybase1.SimpleFSCopyRecursiveArg
+
+	waitErr error
+}
+
+func (f *fakeSimpleFSCopyRecursiveClient) SimpleFSMakeOpid(ctx context.Context) (keybase1.OpID, error) {
+	return keybase1.OpID{}, nil
+}
+
+func (f *fakeSimpleFSCopyRecursiveClient) SimpleFSClose(ctx context.Context, opid keybase1.OpID) error {
+	return nil
+}
+
+func (f *fakeSimpleFSCopyRecursiveClient) SimpleFSStat(ctx context.Context, p keybase1.Path) (keybase1.Dirent, error) {
+	return f.stat, nil
+}
+
+func (f *fakeSimpleFSCopyRecursiveClient) SimpleFSCopy(ctx context.Context, arg keybase1.SimpleFSCopyArg) error {
+	f.copyCalls++
+	return nil
+}
+
+func (f *fakeSimpleFSCopyRecursiveClient) SimpleFSCopyRecursive(ctx context.Context, arg keybase1.SimpleFSCopyRecursiveArg) error {
+	f.copyRecursiveCalls++
+	f.copyRecursiveArg = arg
+	return nil
+}
+
+func (f *fakeSimpleFSCopyRecursiveClient) SimpleFSWait(ctx context.Context, opid keybase1.OpID) error {
+	return f.waitErr
+}
+
+func TestSimpleFSCopyRecursiveOnceCallsCopyRecursive(t *testing.T) {
+	fake := &fakeSimpleFSCopyRecursiveClient{}
+	src := simpleFSPathFromArg("/keybase/private/alice/dir")
+	dest := simpleFSPathFromArg("/tmp/dest")
+
+	if err := simpleFSCopyRecursiveOnce(context.Background(), fake, src, dest, 0, newSimpleFSProgressPrinter(true)); err != nil {
+		t.Fatal(err)
+	}
+	if fake.copyCalls != 0 {
+		t.Errorf("expected SimpleFSCopy not to be called by the recursive path, got %d calls", fake.copyCalls)
+	}
+	if fake.copyRecursiveCalls != 1 {
+		t.Fatalf("expected exactly one SimpleFSCopyRecursive call, got %d", fake.copyRecursiveCalls)
+	}
+	if fake.copyRecursiveArg.Src != src || fake.copyRecursiveArg.Dest != dest {
+		t.Errorf("expected src/dest %v/%v, got %v/%v", src, dest, fake.copyRecursiveArg.Src, fake.copyRecursiveArg.Dest)
+	}
+}
+
+// TestSimpleFSCopyRecursiveOncePropagatesWaitError covers the partial-failure
+// case: the service reports an error partway through the walk (e.g. one
+// file under src couldn't be read) by failing SimpleFSWait, and that error
+// must reach the caller rather than being swallowed.
+func TestSimpleFSCopyRecursiveOncePropagatesWaitError(t *testing.T) {
+	wantErr := errors.New("permission denied reading dir/secret.txt")
+	fake := &fakeSimpleFSCopyRecursiveClient{waitErr: wantErr}
+
+	err := simpleFSCopyRecursiveOnce(context.Background(), fake,
+		simpleFSPathFromArg("/keybase/private/alice/dir"), simpleFSPathFromArg("/tmp/dest"), 0, newSimpleFSProgressPrinter(true))
+	if err != wantErr {
+		t.Fatalf("expected %v, got %v", wantErr, err)
+	}
+}
+
+func TestSimpleFSCopyCheckRecursiveFlagsRejectsIncompatibleFlags(t *testing.T) {
+	c := &CmdSimpleFSCopy{recursive: true, src: "src", checksum: true}
+	if err := c.checkRecursiveFlags(); err == nil {
+		t.Error("expected -r --checksum to be rejected")
+	}
+
+	c = &CmdSimpleFSCopy{recursive: true, src: "src", atomic: true}
+	if err := c.checkRecursiveFlags(); err == nil {
+		t.Error("expected -r --atomic to be rejected")
+	}
+
+	c = &CmdSimpleFSCopy{recursive: true, src: "http://example.com/src"}
+	if err := c.checkRecursiveFlags(); err == nil {
+		t.Error("expected -r with a URL source to be rejected")
+	}
+
+	c = &CmdSimpleFSCopy{recursive: true, src: "src", verify: true}
+	if err := c.checkRecursiveFlags(); err == nil {
+		t.Error("expected -r --verify to be rejected")
+	}
+
+	c = &CmdSimpleFSCopy{recursive: true, src: "src"}
+	if err := c.checkRecursiveFlags(); err != nil {
+		t.Errorf("expected a plain -r copy to be accepted, got %v", err)
+	}
+
+	c = &CmdSimpleFSCopy{src: "src", checksum: true, atomic: true}
+	if err := c.checkRecursiveFlags(); err != nil {
+		t.Errorf("expected non-recursive copies to skip these checks entirely, got %v", err)
+	}
+}
+
+// captureStdout runs f with os.Stdout redirected to a pipe and returns
+// everything f wrote to it.
+func captureStdout(t *testing.T, f func()) string {
+	orig := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = w
+
+	f()
+
+	w.Close()
+	os.Stdout = orig
+
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestSimpleFSCopyOneDryRunDoesNotCallMutatingRPCs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "simplefscopy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := writeTempFile(t, dir, "src.txt", []byte("hello"), time.Now())
+
+	fake := &fakeSimpleFSCopyRecursiveClient{}
+	c := &CmdSimpleFSCopy{dryRun: true}
+	dest := simpleFSPathFromArg("/keybase/private/alice/dest.txt")
+
+	var runErr error
+	out := captureStdout(t, func() {
+		runErr = c.copyOne(context.Background(), fake, src, dest)
+	})
+	if runErr != nil {
+		t.Fatal(runErr)
+	}
+
+	if fake.copyCalls != 0 || fake.copyRecursiveCalls != 0 {
+		t.Errorf("expected no mutating RPCs, got copyCalls=%d copyRecursiveCalls=%d", fake.copyCalls, fake.copyRecursiveCalls)
+	}
+	want := fmt.Sprintf("would copy local:%s -> kbfs:%s\n", src, dest.Kbfs())
+	if out != want {
+		t.Errorf("got output %q, want %q", out, want)
+	}
+}
+
+func TestSimpleFSCopyOneDryRunRecursiveDoesNotCallCopyRecursive(t *testing.T) {
+	fake := &fakeSimpleFSCopyRecursiveClient{stat: keybase1.Dirent{DirentType: keybase1.DirentType_DIR}}
+	c := &CmdSimpleFSCopy{dryRun: true, recursive: true}
+	src := simpleFSPathFromArg("/keybase/private/alice/dir")
+	dest := simpleFSPathFromArg("/tmp/dest")
+
+	var runErr error
+	out := captureStdout(t, func() {
+		runErr = c.copyOne(context.Background(), fake, "/keybase/private/alice/dir", dest)
+	})
+	if runErr != nil {
+		t.Fatal(runErr)
+	}
+	if fake.copyRecursiveCalls != 0 {
+		t.Errorf("expected no SimpleFSCopyRecursive calls in dry-run mode, got %d", fake.copyRecursiveCalls)
+	}
+	want := fmt.Sprintf("would copy kbfs:%s -> local:%s\n", src.Kbfs(), dest.Local())
+	if out != want {
+		t.Errorf("got output %q, want %q", out, want)
+	}
+}
+
+func TestSimpleFSCopyFromURLMissingParent(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("x"))
+	}))
+	defer srv.Close()
+
+	fake := &fakeSimpleFSCopyWriteClient{openErr: errors.New("no such file or directory")}
+	c := &CmdSimpleFSCopy{src: srv.URL, dest: "/keybase/private/alice/missing/parent/file.txt"}
+
+	dest := simpleFSPathFromArg(c.dest)
+	err := c.copyFromURL(context.Background(), fake, dest)
+	if err == nil {
+		t.Fatal("expected an error when the destination parent is missing")
+	}
+}
+
+func TestSimpleFSCopyVerifyCopyPassesOnAMatchingDestination(t *testing.T) {
+	dir, err := ioutil.TempDir("", "simplefscopy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := []byte("the quick brown fox jumps over the lazy dog")
+	src := writeTempFile(t, dir, "src", content, time.Now())
+	dest := writeTempFile(t, dir, "dest", content, time.Now())
+
+	c := &CmdSimpleFSCopy{hashAlgo: simpleFSChecksumSHA256}
+	if err := c.verifyCopy(context.Background(), nil, simpleFSPathFromArg(src), simpleFSPathFromArg(dest)); err != nil {
+		t.Errorf("expected a byte-identical copy to verify, got %v", err)
+	}
+}
+
+func TestSimpleFSCopyVerifyCopyFailsAndReportsOffsetOnACorruptedDestination(t *testing.T) {
+	dir, err := ioutil.TempDir("", "simplefscopy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	srcContent := []byte("the quick brown fox jumps over the lazy dog")
+	destContent := []byte("the quick brown fox jumps over the LAZY dog")
+	src := writeTempFile(t, dir, "src", srcContent, time.Now())
+	dest := writeTempFile(t, dir, "dest", destContent, time.Now())
+
+	c := &CmdSimpleFSCopy{hashAlgo: simpleFSChecksumSHA256}
+	err = c.verifyCopy(context.Background(), nil, simpleFSPathFromArg(src), simpleFSPathFromArg(dest))
+	if err == nil {
+		t.Fatal("expected verification to fail on a corrupted destination")
+	}
+
+	var wantOffset int
+	for wantOffset < len(srcContent) && srcContent[wantOffset] == destContent[wantOffset] {
+		wantOffset++
+	}
+	if !strings.Contains(err.Error(), fmt.Sprintf("byte offset %d", wantOffset)) {
+		t.Errorf("expected error to report offset %d, got %q", wantOffset, err.Error())
+	}
+}
+
+// fakeSimpleFSResumeClient is a minimal keybase1.SimpleFSInterface backed
+// by an in-memory file table, supporting both reads and offset-based
+// writes, just enough to drive simpleFSResumeCopy: stat (with a real
+// size), open/read/write/close, honoring OpenFlags_REPLACE (truncate) vs.
+// leaving an existing file's content alone otherwise.
+type fakeSimpleFSResumeClient struct {
+	keybase1.SimpleFSInterface // panics on anything not overridden below
+
+	files map[string][]byte // kbfs path -> contents
+
+	nextOpID int
+	open     map[keybase1.OpID]string // opid -> kbfs path
+}
+
+func newFakeSimpleFSResumeClient() *fakeSimpleFSResumeClient {
+	return &fakeSimpleFSResumeClient{
+		files: map[string][]byte{},
+		open:  map[keybase1.OpID]string{},
+	}
+}
+
+func (f *fakeSimpleFSResumeClient) SimpleFSStat(ctx context.Context, path keybase1.Path) (keybase1.Dirent, error) {
+	p := path.Kbfs()
+	data, ok := f.files[p]
+	if !ok {
+		return keybase1.Dirent{}, errors.New("no such file or directory")
+	}
+	return keybase1.Dirent{Name: p, Size: len(data), DirentType: keybase1.DirentType_FILE}, nil
+}
+
+func (f *fakeSimpleFSResumeClient) SimpleFSMakeOpid(ctx context.Context) (keybase1.OpID, error) {
+	f.nextOpID++
+	var opid keybase1.OpID
+	opid[0] = byte(f.nextOpID)
+	return opid, nil
+}
+
+func (f *fakeSimpleFSResumeClient) SimpleFSClose(ctx context.Context, opid keybase1.OpID) error {
+	delete(f.open, opid)
+	return nil
+}
+
+func (f *fakeSimpleFSResumeClient) SimpleFSOpen(ctx context.Context, arg keybase1.SimpleFSOpenArg) error {
+	p := arg.Dest.Kbfs()
+	if arg.Flags&keybase1.OpenFlags_REPLACE != 0 {
+		f.files[p] = nil
+	} else if _, ok := f.files[p]; !ok {
+		return errors.New("no such file or directory")
+	}
+	f.open[arg.OpID] = p
+	return nil
+}
+
+func (f *fakeSimpleFSResumeClient) SimpleFSRead(ctx context.Context, arg keybase1.SimpleFSReadArg) (keybase1.FileContent, error) {
+	data := f.files[f.open[arg.OpID]]
+	if arg.Offset >= int64(len(data)) {
+		return keybase1.FileContent{}, nil
+	}
+	end := arg.Offset + int64(arg.Size)
+	if end > int64(len(data)) {
+		end = int64(len(data))
+	}
+	return keybase1.FileContent{Data: data[arg.Offset:end]}, nil
+}
+
+func (f *fakeSimpleFSResumeClient) SimpleFSWrite(ctx context.Context, arg keybase1.SimpleFSWriteArg) error {
+	p := f.open[arg.OpID]
+	data := f.files[p]
+	if int64(len(data)) != arg.Offset {
+		return fmt.Errorf("unexpected write offset %d, dest has %d bytes", arg.Offset, len(data))
+	}
+	f.files[p] = append(data, arg.Content...)
+	return nil
+}
+
+func TestSimpleFSResumeCopyCompletesAnInterruptedTransfer(t *testing.T) {
+	fake := newFakeSimpleFSResumeClient()
+	full := []byte("the quick brown fox jumps over the lazy dog")
+	fake.files["/keybase/private/alice/src.txt"] = full
+	fake.files["/keybase/private/alice/dest.txt"] = full[:10] // partial, as if interrupted
+
+	src := simpleFSPathFromArg("/keybase/private/alice/src.txt")
+	dest := simpleFSPathFromArg("/keybase/private/alice/dest.txt")
+	printer := &fakeSimpleFSProgressUpdater{}
+
+	err := simpleFSResumeCopy(context.Background(), fake, src, dest, false, simpleFSChecksumSHA256, int64(len(full)), printer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(fake.files["/keybase/private/alice/dest.txt"], full) {
+		t.Errorf("got dest content %q, want %q", fake.files["/keybase/private/alice/dest.txt"], full)
+	}
+}
+
+func TestSimpleFSResumeCopyNoopsWhenAlreadyComplete(t *testing.T) {
+	fake := newFakeSimpleFSResumeClient()
+	full := []byte("already done")
+	fake.files["/keybase/private/alice/src.txt"] = full
+	fake.files["/keybase/private/alice/dest.txt"] = full
+
+	src := simpleFSPathFromArg("/keybase/private/alice/src.txt")
+	dest := simpleFSPathFromArg("/keybase/private/alice/dest.txt")
+
+	err := simpleFSResumeCopy(context.Background(), fake, src, dest, false, simpleFSChecksumSHA256, int64(len(full)), &fakeSimpleFSProgressUpdater{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(fake.files["/keybase/private/alice/dest.txt"], full) {
+		t.Errorf("expected dest to be left untouched, got %q", fake.files["/keybase/private/alice/dest.txt"])
+	}
+}
+
+func TestSimpleFSResumeCopyRefusesToResumeOnPrefixMismatch(t *testing.T) {
+	fake := newFakeSimpleFSResumeClient()
+	fake.files["/keybase/private/alice/src.txt"] = []byte("the quick brown fox jumps over the lazy dog")
+	fake.files["/keybase/private/alice/dest.txt"] = []byte("the QUICK brown")
+
+	src := simpleFSPathFromArg("/keybase/private/alice/src.txt")
+	dest := simpleFSPathFromArg("/keybase/private/alice/dest.txt")
+
+	err := simpleFSResumeCopy(context.Background(), fake, src, dest, true, simpleFSChecksumSHA256, 44, &fakeSimpleFSProgressUpdater{})
+	if err == nil {
+		t.Fatal("expected --resume --verify to refuse a mismatched prefix")
+	}
+	if got, want := string(fake.files["/keybase/private/alice/dest.txt"]), "the QUICK brown"; got != want {
+		t.Errorf("expected dest to be left untouched, got %q, want %q", got, want)
+	}
+}
+
+func TestSimpleFSResumeCopyRefusesWhenDestIsLargerThanSrc(t *testing.T) {
+	fake := newFakeSimpleFSResumeClient()
+	fake.files["/keybase/private/alice/src.txt"] = []byte("short")
+	fake.files["/keybase/private/alice/dest.txt"] = []byte("much longer than src")
+
+	src := simpleFSPathFromArg("/keybase/private/alice/src.txt")
+	dest := simpleFSPathFromArg("/keybase/private/alice/dest.txt")
+
+	err := simpleFSResumeCopy(context.Background(), fake, src, dest, false, simpleFSChecksumSHA256, 5, &fakeSimpleFSProgressUpdater{})
+	if err == nil {
+		t.Fatal("expected an error when dest is larger than src")
+	}
+}
+
+// fakeSimpleFSProgressUpdater is a no-op simpleFSProgressUpdater for tests
+// that don't care about the rendered progress output, only that the
+// underlying copy itself behaves correctly.
+type fakeSimpleFSProgressUpdater struct{}
+
+func (f *fakeSimpleFSProgressUpdater) Update(written, total int64) {}
+func (f *fakeSimpleFSProgressUpdater) Finish()                     {}
+
+func TestSimpleFSCopyOneNoClobberSkipsAnExistingDestination(t *testing.T) {
+	dir, err := ioutil.TempDir("", "simplefscopy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := writeTempFile(t, dir, "src.txt", []byte("hello"), time.Now())
+
+	fake := &fakeSimpleFSCopyRecursiveClient{stat: keybase1.Dirent{DirentType: keybase1.DirentType_FILE}}
+	c := &CmdSimpleFSCopy{existing: existingPolicySkip}
+	dest := simpleFSPathFromArg("/keybase/private/alice/dest.txt")
+
+	if err := c.copyOne(context.Background(), fake, src, dest); err != nil {
+		t.Fatal(err)
+	}
+	if fake.copyCalls != 0 || fake.copyRecursiveCalls != 0 {
+		t.Errorf("expected --no-clobber to skip the copy entirely, got copyCalls=%d copyRecursiveCalls=%d", fake.copyCalls, fake.copyRecursiveCalls)
+	}
+}
+
+func TestSimpleFSCopyCheckClobberFlagsRejectsForceAndNoClobber(t *testing.T) {
+	c := &CmdSimpleFSCopy{force: true, noClobber: true}
+	if err := c.checkClobberFlags(); err == nil {
+		t.Error("expected --force --no-clobber to be rejected")
+	}
+
+	c = &CmdSimpleFSCopy{noClobber: true}
+	if err := c.checkClobberFlags(); err != nil {
+		t.Errorf("expected --no-clobber alone to be accepted, got %v", err)
+	}
+}
+
+func TestSimpleFSPreserveTimesSetsDestMtimeToMatchSrc(t *testing.T) {
+	dir, err := ioutil.TempDir("", "simplefscopy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	srcMtime := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+	src := writeTempFile(t, dir, "src.txt", []byte("hello"), srcMtime)
+	dest := writeTempFile(t, dir, "dest.txt", []byte("hello"), time.Now())
+
+	if err := simpleFSPreserveTimes(context.Background(), nil, simpleFSPathFromArg(src), simpleFSPathFromArg(dest), false); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(srcMtime) {
+		t.Errorf("expected dest mtime %v, got %v", srcMtime, info.ModTime())
+	}
+}
+
+func TestSimpleFSPreserveTimesRefusesAKbfsDestination(t *testing.T) {
+	src := simpleFSPathFromArg("/tmp/src.txt")
+	dest := simpleFSPathFromArg("/keybase/private/alice/dest.txt")
+	if err := simpleFSPreserveTimes(context.Background(), nil, src, dest, false); err == nil {
+		t.Error("expected --preserve-times to refuse a KBFS destination")
+	}
+}
+
+func TestSimpleFSPreserveTimesRecursiveWalksDestAndMatchesSrcFiles(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "simplefscopysrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(srcDir)
+	destDir, err := ioutil.TempDir("", "simplefscopydest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(destDir)
+
+	mtime := time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+	if err := os.Mkdir(filepath.Join(srcDir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(destDir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTempFile(t, srcDir, "top.txt", []byte("a"), mtime)
+	writeTempFile(t, destDir, "top.txt", []byte("a"), time.Now())
+	writeTempFile(t, filepath.Join(srcDir, "sub"), "nested.txt", []byte("b"), mtime)
+	nestedDest := writeTempFile(t, filepath.Join(destDir, "sub"), "nested.txt", []byte("b"), time.Now())
+
+	if err := simpleFSPreserveTimes(context.Background(), nil, simpleFSPathFromArg(srcDir), simpleFSPathFromArg(destDir), true); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(nestedDest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Errorf("expected nested dest mtime %v, got %v", mtime, info.ModTime())
+	}
+}
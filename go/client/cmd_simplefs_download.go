@@ -0,0 +1,177 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/cli"
+	"github.com/keybase/client/go/libcmdline"
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// CmdSimpleFSDownload is the 'fs download' command, which streams a single
+// KBFS file to a local destination path, verifying the downloaded size
+// against SimpleFSStat once it's done.
+type CmdSimpleFSDownload struct {
+	libkb.Contextified
+	src         string
+	dest        string
+	force       bool
+	makeParents bool
+}
+
+func newCmdSimpleFSDownload(cl *libcmdline.CommandLine, g *libkb.GlobalContext) cli.Command {
+	return cli.Command{
+		Name:         "download",
+		Usage:        "Download a KBFS file to a local destination",
+		ArgumentHelp: "<kbfs source> <local destination>",
+		Action: func(c *cli.Context) {
+			cl.ChooseCommand(&CmdSimpleFSDownload{Contextified: libkb.NewContextified(g)}, "download", c)
+		},
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "f, force",
+				Usage: "Overwrite the local destination if it already exists",
+			},
+			cli.BoolFlag{
+				Name:  "mkdir",
+				Usage: "Create missing local destination parent directories",
+			},
+		},
+	}
+}
+
+func (c *CmdSimpleFSDownload) ParseArgv(ctx *cli.Context) error {
+	if len(ctx.Args()) != 2 {
+		return errors.New("fs download takes two arguments: <kbfs source> <local destination>")
+	}
+	c.src = ctx.Args()[0]
+	c.dest = ctx.Args()[1]
+	c.force = ctx.Bool("force")
+	c.makeParents = ctx.Bool("mkdir")
+	return nil
+}
+
+func (c *CmdSimpleFSDownload) Run() (err error) {
+	defer func() {
+		err = fsFinishError(c.G(), err)
+	}()
+
+	cli, err := GetSimpleFSClient(c.G())
+	if err != nil {
+		return err
+	}
+
+	src := simpleFSPathFromArg(c.src)
+	if !simpleFSIsKbfs(src) {
+		return fmt.Errorf("fs download source must be a /keybase/... path: %q", c.src)
+	}
+
+	if err := c.checkForce(); err != nil {
+		return err
+	}
+
+	if err := simpleFSLocalMkdirParent(c.dest, c.makeParents); err != nil {
+		return err
+	}
+
+	ctx := context.TODO()
+	dirent, err := cli.SimpleFSStat(ctx, src)
+	if err != nil {
+		return err
+	}
+	if dirent.DirentType == keybase1.DirentType_DIR {
+		return fmt.Errorf("%q is a directory", c.src)
+	}
+
+	n, err := simpleFSDownloadOne(ctx, cli, src, c.dest)
+	if err != nil {
+		return err
+	}
+	return checkDownloadedSize(c.src, n, dirent.Size)
+}
+
+// checkForce enforces --force: if it's not set and c.dest already exists
+// locally, the download is refused rather than silently overwriting it.
+func (c *CmdSimpleFSDownload) checkForce() error {
+	if c.force {
+		return nil
+	}
+	if _, err := os.Stat(c.dest); err == nil {
+		return fmt.Errorf("fs download: %s already exists (use --force to overwrite)", c.dest)
+	}
+	return nil
+}
+
+// checkDownloadedSize compares n, the number of bytes simpleFSDownloadOne
+// actually wrote for src, against wantSize, the size SimpleFSStat reported
+// for it before the download started. A mismatch means the file changed
+// mid-download or the transfer was truncated, either of which the caller
+// should know about rather than silently keep a short local copy.
+func checkDownloadedSize(src string, n int64, wantSize int) error {
+	if n != int64(wantSize) {
+		return fmt.Errorf("fs download: %s: downloaded %d bytes, but SimpleFSStat reported %d", src, n, wantSize)
+	}
+	return nil
+}
+
+// simpleFSDownloadOne streams src's contents from KBFS to the local file at
+// dest, in chunks of simpleFSReadChunkSize, and returns the number of bytes
+// written. dest is created if missing and truncated if it already exists;
+// any existing-file and parent-directory checks are the caller's
+// responsibility.
+func simpleFSDownloadOne(ctx context.Context, cli keybase1.SimpleFSInterface, src keybase1.Path, dest string) (int64, error) {
+	opid, err := cli.SimpleFSMakeOpid(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = cli.SimpleFSClose(ctx, opid) }()
+
+	if err := cli.SimpleFSOpen(ctx, keybase1.SimpleFSOpenArg{
+		OpID:  opid,
+		Dest:  src,
+		Flags: keybase1.OpenFlags_READ | keybase1.OpenFlags_EXISTING,
+	}); err != nil {
+		return 0, err
+	}
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return 0, err
+	}
+	defer out.Close()
+
+	var n int64
+	for {
+		content, err := cli.SimpleFSRead(ctx, keybase1.SimpleFSReadArg{
+			OpID:   opid,
+			Offset: n,
+			Size:   simpleFSReadChunkSize,
+		})
+		if err != nil {
+			return n, err
+		}
+		if len(content.Data) == 0 {
+			return n, nil
+		}
+		written, err := out.Write(content.Data)
+		if err != nil {
+			return n, err
+		}
+		n += int64(written)
+	}
+}
+
+func (c *CmdSimpleFSDownload) GetUsage() libkb.Usage {
+	return libkb.Usage{
+		Config: true,
+		API:    true,
+	}
+}
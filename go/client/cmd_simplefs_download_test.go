@@ -0,0 +1,137 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// fakeSimpleFSDownloadClient is a minimal keybase1.SimpleFSInterface backed
+// by an in-memory file table, just enough to drive simpleFSDownloadOne:
+// open, repeated read in chunks, and close.
+type fakeSimpleFSDownloadClient struct {
+	keybase1.SimpleFSInterface // panics on anything not overridden below
+
+	files map[string][]byte // kbfs path -> contents
+
+	nextOpID int
+	open     map[keybase1.OpID]string // opid -> kbfs path
+}
+
+func newFakeSimpleFSDownloadClient() *fakeSimpleFSDownloadClient {
+	return &fakeSimpleFSDownloadClient{
+		files: map[string][]byte{},
+		open:  map[keybase1.OpID]string{},
+	}
+}
+
+func (f *fakeSimpleFSDownloadClient) SimpleFSMakeOpid(ctx context.Context) (keybase1.OpID, error) {
+	f.nextOpID++
+	var opid keybase1.OpID
+	opid[0] = byte(f.nextOpID)
+	return opid, nil
+}
+
+func (f *fakeSimpleFSDownloadClient) SimpleFSClose(ctx context.Context, opid keybase1.OpID) error {
+	delete(f.open, opid)
+	return nil
+}
+
+func (f *fakeSimpleFSDownloadClient) SimpleFSOpen(ctx context.Context, arg keybase1.SimpleFSOpenArg) error {
+	f.open[arg.OpID] = arg.Dest.Kbfs()
+	return nil
+}
+
+func (f *fakeSimpleFSDownloadClient) SimpleFSRead(ctx context.Context, arg keybase1.SimpleFSReadArg) (keybase1.FileContent, error) {
+	p := f.open[arg.OpID]
+	data := f.files[p]
+	if arg.Offset >= int64(len(data)) {
+		return keybase1.FileContent{}, nil
+	}
+	end := arg.Offset + int64(arg.Size)
+	if end > int64(len(data)) {
+		end = int64(len(data))
+	}
+	return keybase1.FileContent{Data: data[arg.Offset:end]}, nil
+}
+
+func TestSimpleFSDownloadOneWritesLocalFile(t *testing.T) {
+	fake := newFakeSimpleFSDownloadClient()
+	fake.files["/keybase/private/alice/a.txt"] = []byte("hello world")
+
+	dir, err := ioutil.TempDir("", "simplefs-download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dest := filepath.Join(dir, "a.txt")
+
+	n, err := simpleFSDownloadOne(context.Background(), fake, simpleFSPathFromArg("/keybase/private/alice/a.txt"), dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 11 {
+		t.Fatalf("expected 11 bytes written, got %d", n)
+	}
+
+	got, err := ioutil.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, []byte("hello world")) {
+		t.Fatalf("expected %q, got %q", "hello world", got)
+	}
+}
+
+func TestSimpleFSDownloadCheckForceRefusesExistingDestination(t *testing.T) {
+	dir, err := ioutil.TempDir("", "simplefs-download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dest := filepath.Join(dir, "a.txt")
+	if err := ioutil.WriteFile(dest, []byte("existing"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &CmdSimpleFSDownload{dest: dest}
+	if err := c.checkForce(); err == nil {
+		t.Fatal("expected checkForce to refuse an existing destination without --force")
+	}
+
+	c.force = true
+	if err := c.checkForce(); err != nil {
+		t.Fatalf("expected --force to allow overwriting an existing destination, got %v", err)
+	}
+}
+
+func TestSimpleFSDownloadCheckForceAllowsNewDestination(t *testing.T) {
+	dir, err := ioutil.TempDir("", "simplefs-download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := &CmdSimpleFSDownload{dest: filepath.Join(dir, "new.txt")}
+	if err := c.checkForce(); err != nil {
+		t.Fatalf("expected checkForce to allow a non-existing destination, got %v", err)
+	}
+}
+
+func TestCheckDownloadedSizeDetectsMismatch(t *testing.T) {
+	if err := checkDownloadedSize("/keybase/private/alice/a.txt", 5, 11); err == nil {
+		t.Fatal("expected a size mismatch to be detected")
+	}
+	if err := checkDownloadedSize("/keybase/private/alice/a.txt", 11, 11); err != nil {
+		t.Fatalf("expected a matching size to pass, got %v", err)
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/cli"
+	"github.com/keybase/client/go/libcmdline"
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// simpleFSDuUnlimitedDepth is --max-depth's default: print every
+// subdirectory's size, not just the ones down to some fixed depth.
+const simpleFSDuUnlimitedDepth = -1
+
+// CmdSimpleFSDu is the 'fs du' command. It recursively sums Dirent sizes
+// under a path using the same simpleFSLister SimpleFSList/SimpleFSReadList
+// abstraction simpleFSWalk is built on, and prints a size for each
+// subdirectory as it unwinds, followed by the total for the path itself.
+//
+// Unlike simpleFSWalk's flat, fire-and-forget per-entry callback, du needs
+// each directory's size fed back to its parent so it can report subtotals,
+// so it walks with its own recursive helper (simpleFSDuSize) rather than
+// simpleFSWalk. That helper still visits one directory at a time and never
+// holds more than the current path's ancestor chain in memory, so memory
+// use stays bounded on a very large tree.
+type CmdSimpleFSDu struct {
+	libkb.Contextified
+	path     string
+	maxDepth int
+	human    bool
+}
+
+func newCmdSimpleFSDu(cl *libcmdline.CommandLine, g *libkb.GlobalContext) cli.Command {
+	return cli.Command{
+		Name:         "du",
+		Usage:        "Show how much space a KBFS directory occupies",
+		ArgumentHelp: "<path>",
+		Action: func(c *cli.Context) {
+			cl.ChooseCommand(&CmdSimpleFSDu{Contextified: libkb.NewContextified(g)}, "du", c)
+		},
+		Flags: []cli.Flag{
+			cli.IntFlag{
+				Name:  "max-depth",
+				Value: simpleFSDuUnlimitedDepth,
+				Usage: "Only print subdirectory sizes down to this many levels below <path> (default: no limit)",
+			},
+			cli.BoolFlag{
+				Name:  "human",
+				Usage: "Print sizes in KB/MB/GB instead of raw bytes",
+			},
+		},
+	}
+}
+
+func (c *CmdSimpleFSDu) ParseArgv(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		return fmt.Errorf("fs du takes one argument: <path>")
+	}
+	c.path = ctx.Args()[0]
+	c.maxDepth = ctx.Int("max-depth")
+	c.human = ctx.Bool("human")
+	return nil
+}
+
+func (c *CmdSimpleFSDu) Run() (err error) {
+	defer func() {
+		err = fsFinishError(c.G(), err)
+	}()
+
+	cli, err := GetSimpleFSClient(c.G())
+	if err != nil {
+		return err
+	}
+
+	ctx := context.TODO()
+	lister := &simpleFSRPCLister{ctx: ctx, cli: cli}
+	root := simpleFSPathFromArg(c.path)
+
+	print := func(p keybase1.Path, size int64) {
+		fmt.Fprintf(os.Stdout, "%s\t%s\n", c.formatSize(size), simpleFSPathString(p))
+	}
+
+	_, err = simpleFSDuSize(ctx, lister, root, 0, c.maxDepth, print)
+	return err
+}
+
+// formatSize renders size as raw bytes, or KB/MB/GB-style if --human was
+// given.
+func (c *CmdSimpleFSDu) formatSize(size int64) string {
+	if c.human {
+		return simpleFSFormatBytes(size)
+	}
+	return fmt.Sprintf("%d", size)
+}
+
+// simpleFSDuSize returns dir's total size (the sum of every file size
+// under it, recursively), and along the way calls print once for every
+// directory no deeper than maxDepth below the original root (depth 0 is
+// dir itself), including dir. Pass simpleFSDuUnlimitedDepth for maxDepth
+// to print every level.
+//
+// It walks depth-first, computing each subdirectory's total on the way
+// back up rather than collecting the whole tree's entries up front, so
+// memory use stays proportional to the current path's depth, not the
+// size of the tree.
+func simpleFSDuSize(ctx context.Context, lister simpleFSLister, dir keybase1.Path, depth int, maxDepth int, print func(p keybase1.Path, size int64)) (int64, error) {
+	entries, err := lister.List(ctx, dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, entry := range entries {
+		if entry.DirentType != keybase1.DirentType_DIR {
+			total += int64(entry.Size)
+			continue
+		}
+
+		child := simpleFSChildPath(dir, entry.Name)
+		size, err := simpleFSDuSize(ctx, lister, child, depth+1, maxDepth, print)
+		if err != nil {
+			return 0, err
+		}
+		total += size
+	}
+
+	if maxDepth < 0 || depth <= maxDepth {
+		print(dir, total)
+	}
+
+	return total, nil
+}
+
+func (c *CmdSimpleFSDu) GetUsage() libkb.Usage {
+	return libkb.Usage{
+		Config: true,
+		API:    true,
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// fakeSimpleFSDuLister is an in-memory simpleFSLister over a fixed tree,
+// keyed by each directory's KBFS path string. It's just enough of
+// simpleFSLister to exercise simpleFSDuSize without a real SimpleFS
+// daemon.
+type fakeSimpleFSDuLister struct {
+	entries map[string][]keybase1.Dirent
+}
+
+func (l *fakeSimpleFSDuLister) List(ctx context.Context, dir keybase1.Path) ([]keybase1.Dirent, error) {
+	return l.entries[simpleFSPathString(dir)], nil
+}
+
+func (l *fakeSimpleFSDuLister) Canonicalize(ctx context.Context, p keybase1.Path) (string, error) {
+	return simpleFSPathString(p), nil
+}
+
+// duTestTree builds a small fixed tree:
+//
+//	/keybase/private/alice          (root)
+//	  a.txt       10 bytes
+//	  sub/
+//	    b.txt     20 bytes
+//	    deeper/
+//	      c.txt   30 bytes
+//
+// so root's total is 60, sub's total is 50, and deeper's total is 30.
+func duTestTree() *fakeSimpleFSDuLister {
+	return &fakeSimpleFSDuLister{
+		entries: map[string][]keybase1.Dirent{
+			"/keybase/private/alice": {
+				{Name: "a.txt", Size: 10, DirentType: keybase1.DirentType_FILE},
+				{Name: "sub", DirentType: keybase1.DirentType_DIR},
+			},
+			"/keybase/private/alice/sub": {
+				{Name: "b.txt", Size: 20, DirentType: keybase1.DirentType_FILE},
+				{Name: "deeper", DirentType: keybase1.DirentType_DIR},
+			},
+			"/keybase/private/alice/sub/deeper": {
+				{Name: "c.txt", Size: 30, DirentType: keybase1.DirentType_FILE},
+			},
+		},
+	}
+}
+
+func TestSimpleFSDuSizeTotals(t *testing.T) {
+	lister := duTestTree()
+	root := keybase1.NewPathWithKbfs("/keybase/private/alice")
+
+	seen := map[string]int64{}
+	print := func(p keybase1.Path, size int64) {
+		seen[simpleFSPathString(p)] = size
+	}
+
+	total, err := simpleFSDuSize(context.Background(), lister, root, 0, simpleFSDuUnlimitedDepth, print)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if total != 60 {
+		t.Errorf("got total %d, want 60", total)
+	}
+	want := map[string]int64{
+		"/keybase/private/alice":            60,
+		"/keybase/private/alice/sub":        50,
+		"/keybase/private/alice/sub/deeper": 30,
+	}
+	if len(seen) != len(want) {
+		t.Fatalf("got %d printed directories, want %d: %+v", len(seen), len(want), seen)
+	}
+	for p, wantSize := range want {
+		if seen[p] != wantSize {
+			t.Errorf("size for %s = %d, want %d", p, seen[p], wantSize)
+		}
+	}
+}
+
+func TestSimpleFSDuSizeMaxDepthLimitsWhatsPrinted(t *testing.T) {
+	lister := duTestTree()
+	root := keybase1.NewPathWithKbfs("/keybase/private/alice")
+
+	seen := map[string]int64{}
+	print := func(p keybase1.Path, size int64) {
+		seen[simpleFSPathString(p)] = size
+	}
+
+	// --max-depth 1 should print the root and its direct child "sub", but
+	// not "sub/deeper".
+	total, err := simpleFSDuSize(context.Background(), lister, root, 0, 1, print)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if total != 60 {
+		t.Errorf("got total %d, want 60 (max-depth shouldn't change the computed total)", total)
+	}
+	want := map[string]int64{
+		"/keybase/private/alice":     60,
+		"/keybase/private/alice/sub": 50,
+	}
+	if len(seen) != len(want) {
+		t.Fatalf("got %d printed directories, want %d: %+v", len(seen), len(want), seen)
+	}
+	for p, wantSize := range want {
+		if seen[p] != wantSize {
+			t.Errorf("size for %s = %d, want %d", p, seen[p], wantSize)
+		}
+	}
+}
+
+func TestSimpleFSDuSizeMaxDepthZeroOnlyPrintsTotal(t *testing.T) {
+	lister := duTestTree()
+	root := keybase1.NewPathWithKbfs("/keybase/private/alice")
+
+	var printed []keybase1.Path
+	print := func(p keybase1.Path, size int64) {
+		printed = append(printed, p)
+	}
+
+	if _, err := simpleFSDuSize(context.Background(), lister, root, 0, 0, print); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(printed) != 1 || simpleFSPathString(printed[0]) != "/keybase/private/alice" {
+		t.Fatalf("got %+v, want only the root printed", printed)
+	}
+}
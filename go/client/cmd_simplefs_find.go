@@ -0,0 +1,213 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/cli"
+	"github.com/keybase/client/go/libcmdline"
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// CmdSimpleFSFind is the 'fs find' command, which recursively walks a KBFS
+// path with simpleFSWalk and prints the full path of every entry matching
+// all of --name, --type, --newer-than, and --size (any predicate left
+// unset always matches). Like the other simpleFSWalk-based commands, it
+// prints matches as the walk finds them rather than collecting the whole
+// tree first, so memory use doesn't grow with the size of the tree.
+type CmdSimpleFSFind struct {
+	libkb.Contextified
+	path        string
+	namePattern string
+	typeFilter  keybase1.DirentType
+	hasType     bool
+	newerThan   time.Time
+	size        simpleFSSizePredicate
+	hasSize     bool
+}
+
+func newCmdSimpleFSFind(cl *libcmdline.CommandLine, g *libkb.GlobalContext) cli.Command {
+	return cli.Command{
+		Name:         "find",
+		Usage:        "Recursively search a KBFS directory by name, type, mtime, or size",
+		ArgumentHelp: "<path>",
+		Action: func(c *cli.Context) {
+			cl.ChooseCommand(&CmdSimpleFSFind{Contextified: libkb.NewContextified(g)}, "find", c)
+		},
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "name",
+				Usage: "Only print entries whose name matches this glob pattern (e.g. \"*.jpg\")",
+			},
+			cli.StringFlag{
+				Name:  "type",
+				Usage: "Only print entries of this type: f (file) or d (directory)",
+			},
+			cli.StringFlag{
+				Name:  "newer-than",
+				Usage: "Only print entries last modified within this long (e.g. 30d, 12h) or after this absolute date",
+			},
+			cli.StringFlag{
+				Name:  "size",
+				Usage: "Only print entries matching this size: +1M (larger than), -1M (smaller than), or 1M (exactly)",
+			},
+		},
+	}
+}
+
+func (c *CmdSimpleFSFind) ParseArgv(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		return fmt.Errorf("fs find takes one argument: <path>")
+	}
+	c.path = ctx.Args()[0]
+	c.namePattern = ctx.String("name")
+
+	if t := ctx.String("type"); t != "" {
+		switch t {
+		case "f":
+			c.typeFilter = keybase1.DirentType_FILE
+		case "d":
+			c.typeFilter = keybase1.DirentType_DIR
+		default:
+			return fmt.Errorf("--type must be f or d, got %q", t)
+		}
+		c.hasType = true
+	}
+
+	if s := ctx.String("newer-than"); s != "" {
+		cutoff, err := simpleFSParseTimeFilterCutoff(s)
+		if err != nil {
+			return fmt.Errorf("--newer-than: %s", err)
+		}
+		c.newerThan = cutoff
+	}
+
+	if s := ctx.String("size"); s != "" {
+		pred, err := simpleFSParseSizePredicate(s)
+		if err != nil {
+			return fmt.Errorf("--size: %s", err)
+		}
+		c.size = pred
+		c.hasSize = true
+	}
+
+	return nil
+}
+
+func (c *CmdSimpleFSFind) Run() (err error) {
+	defer func() {
+		err = fsFinishError(c.G(), err)
+	}()
+
+	fsClient, err := GetSimpleFSClient(c.G())
+	if err != nil {
+		return err
+	}
+
+	ctx := context.TODO()
+	lister := &simpleFSRPCLister{ctx: ctx, cli: fsClient}
+	root := simpleFSPathFromArg(c.path)
+	warn := func(format string, args ...interface{}) {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+	}
+
+	fn := func(dir keybase1.Path, entry keybase1.Dirent) error {
+		if !c.matches(entry) {
+			return nil
+		}
+		fmt.Fprintln(os.Stdout, simpleFSPathString(simpleFSChildPath(dir, entry.Name)))
+		return nil
+	}
+
+	return simpleFSWalk(ctx, lister, root, simpleFSWalkOptions{}, warn, fn)
+}
+
+// matches reports whether entry satisfies every predicate given on the
+// command line. --name, --type, --newer-than, and --size all have to
+// agree; a predicate that wasn't given on the command line always
+// matches.
+func (c *CmdSimpleFSFind) matches(entry keybase1.Dirent) bool {
+	if c.namePattern != "" {
+		ok, err := path.Match(c.namePattern, entry.Name)
+		if err != nil || !ok {
+			return false
+		}
+	}
+	if c.hasType && entry.DirentType != c.typeFilter {
+		return false
+	}
+	if !c.newerThan.IsZero() && !entry.Time.Time().After(c.newerThan) {
+		return false
+	}
+	if c.hasSize && !c.size.matches(int64(entry.Size)) {
+		return false
+	}
+	return true
+}
+
+// simpleFSSizePredicate is a parsed --size value: "+1M" (larger than),
+// "-1M" (smaller than), or a bare "1M" (exactly), with K/M/G suffixes in
+// base 1024, matching the units simpleFSFormatBytes prints.
+type simpleFSSizePredicate struct {
+	cmp   byte // '+', '-', or 0 for exact
+	bytes int64
+}
+
+func (p simpleFSSizePredicate) matches(size int64) bool {
+	switch p.cmp {
+	case '+':
+		return size > p.bytes
+	case '-':
+		return size < p.bytes
+	default:
+		return size == p.bytes
+	}
+}
+
+// simpleFSSizePattern matches a --size value: an optional leading +/-,
+// a number of bytes, and an optional K/M/G suffix.
+var simpleFSSizePattern = regexp.MustCompile(`^([+-]?)(\d+)([kKmMgG]?)$`)
+
+func simpleFSParseSizePredicate(s string) (simpleFSSizePredicate, error) {
+	m := simpleFSSizePattern.FindStringSubmatch(s)
+	if m == nil {
+		return simpleFSSizePredicate{}, fmt.Errorf("could not parse %q as a size (e.g. +1M, -500k, 1024)", s)
+	}
+
+	n, err := strconv.ParseInt(m[2], 10, 64)
+	if err != nil {
+		return simpleFSSizePredicate{}, err
+	}
+	switch strings.ToLower(m[3]) {
+	case "k":
+		n *= 1024
+	case "m":
+		n *= 1024 * 1024
+	case "g":
+		n *= 1024 * 1024 * 1024
+	}
+
+	var cmp byte
+	if m[1] == "+" || m[1] == "-" {
+		cmp = m[1][0]
+	}
+	return simpleFSSizePredicate{cmp: cmp, bytes: n}, nil
+}
+
+func (c *CmdSimpleFSFind) GetUsage() libkb.Usage {
+	return libkb.Usage{
+		Config: true,
+		API:    true,
+	}
+}
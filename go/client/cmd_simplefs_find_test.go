@@ -0,0 +1,139 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+func simpleFSFindTestTree() *fakeLister {
+	now := time.Now()
+	return &fakeLister{
+		dirs: map[string][]keybase1.Dirent{
+			"/keybase/private/alice": {
+				{Name: "photo.jpg", DirentType: keybase1.DirentType_FILE, Size: 2 * 1024 * 1024, Time: keybase1.ToTime(now)},
+				{Name: "notes.txt", DirentType: keybase1.DirentType_FILE, Size: 100, Time: keybase1.ToTime(now.Add(-48 * time.Hour))},
+				{Name: "sub", DirentType: keybase1.DirentType_DIR, Time: keybase1.ToTime(now)},
+			},
+			"/keybase/private/alice/sub": {
+				{Name: "sub-photo.jpg", DirentType: keybase1.DirentType_FILE, Size: 10, Time: keybase1.ToTime(now)},
+			},
+		},
+	}
+}
+
+func findRun(t *testing.T, c *CmdSimpleFSFind, lister simpleFSLister) []string {
+	root := keybase1.NewPathWithKbfs("/keybase/private/alice")
+	var got []string
+	fn := func(dir keybase1.Path, entry keybase1.Dirent) error {
+		if c.matches(entry) {
+			got = append(got, simpleFSPathString(simpleFSChildPath(dir, entry.Name)))
+		}
+		return nil
+	}
+	if err := simpleFSWalk(context.Background(), lister, root, simpleFSWalkOptions{}, func(string, ...interface{}) {}, fn); err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestSimpleFSFindNamePredicate(t *testing.T) {
+	lister := simpleFSFindTestTree()
+	c := &CmdSimpleFSFind{namePattern: "*.jpg"}
+	got := findRun(t, c, lister)
+
+	want := []string{"/keybase/private/alice/photo.jpg", "/keybase/private/alice/sub/sub-photo.jpg"}
+	if !stringSlicesEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSimpleFSFindTypePredicate(t *testing.T) {
+	lister := simpleFSFindTestTree()
+	c := &CmdSimpleFSFind{typeFilter: keybase1.DirentType_DIR, hasType: true}
+	got := findRun(t, c, lister)
+
+	want := []string{"/keybase/private/alice/sub"}
+	if !stringSlicesEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSimpleFSFindNewerThanPredicate(t *testing.T) {
+	lister := simpleFSFindTestTree()
+	c := &CmdSimpleFSFind{newerThan: time.Now().Add(-24 * time.Hour)}
+	got := findRun(t, c, lister)
+
+	want := []string{"/keybase/private/alice/photo.jpg", "/keybase/private/alice/sub", "/keybase/private/alice/sub/sub-photo.jpg"}
+	if !stringSlicesEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSimpleFSFindSizePredicate(t *testing.T) {
+	lister := simpleFSFindTestTree()
+
+	pred, err := simpleFSParseSizePredicate("+1M")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &CmdSimpleFSFind{size: pred, hasSize: true}
+	got := findRun(t, c, lister)
+
+	want := []string{"/keybase/private/alice/photo.jpg"}
+	if !stringSlicesEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSimpleFSFindCombinedPredicates(t *testing.T) {
+	lister := simpleFSFindTestTree()
+
+	pred, err := simpleFSParseSizePredicate("-1M")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &CmdSimpleFSFind{namePattern: "*.jpg", size: pred, hasSize: true}
+	got := findRun(t, c, lister)
+
+	want := []string{"/keybase/private/alice/sub/sub-photo.jpg"}
+	if !stringSlicesEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSimpleFSParseSizePredicate(t *testing.T) {
+	cases := []struct {
+		input string
+		size  int64
+		want  bool
+	}{
+		{"+1M", 2 * 1024 * 1024, true},
+		{"+1M", 1024, false},
+		{"-1M", 1024, true},
+		{"-1M", 2 * 1024 * 1024, false},
+		{"1024", 1024, true},
+		{"1024", 1023, false},
+		{"2k", 2048, true},
+		{"1g", 1024 * 1024 * 1024, true},
+	}
+	for _, tc := range cases {
+		pred, err := simpleFSParseSizePredicate(tc.input)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.input, err)
+		}
+		if got := pred.matches(tc.size); got != tc.want {
+			t.Errorf("%q.matches(%d) = %v, want %v", tc.input, tc.size, got, tc.want)
+		}
+	}
+
+	if _, err := simpleFSParseSizePredicate("not-a-size"); err == nil {
+		t.Error("expected an error parsing an invalid --size value")
+	}
+}
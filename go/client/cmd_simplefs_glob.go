@@ -0,0 +1,82 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// simpleFSHasGlobMeta reports whether s contains any of the glob
+// metacharacters path.Match and filepath.Glob understand. A path with none
+// of these is passed through unchanged by simpleFSExpandGlob, so plain
+// paths containing no wildcards never pay for a listing round trip.
+func simpleFSHasGlobMeta(s string) bool {
+	return strings.ContainsAny(s, "*?[")
+}
+
+// simpleFSExpandGlob expands arg if it contains glob metacharacters,
+// returning every path string (in the same /keybase/... or local form
+// simpleFSPathFromArg accepts) it matches, sorted for deterministic
+// output. A pattern that matches nothing is an error rather than being
+// passed through literally -- a bare `*.txt` that silently means "a file
+// literally named *.txt" is rarely what anyone wants.
+//
+// arg without glob metacharacters is returned as the single-element
+// []string{arg}, so callers that only ever see non-glob arguments in
+// practice can still always call this instead of branching themselves.
+func simpleFSExpandGlob(ctx context.Context, lister simpleFSLister, arg string) ([]string, error) {
+	if !simpleFSHasGlobMeta(arg) {
+		return []string{arg}, nil
+	}
+
+	if !strings.HasPrefix(arg, kbfsPathPrefix) {
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("fs: %q: no matches", arg)
+		}
+		sort.Strings(matches)
+		return matches, nil
+	}
+
+	dir, pattern := path.Split(arg)
+	dir = strings.TrimSuffix(dir, "/")
+	if dir == "" {
+		dir = kbfsPathPrefix
+	}
+	if simpleFSHasGlobMeta(dir) {
+		return nil, fmt.Errorf("fs: %q: only the last path component may contain glob characters", arg)
+	}
+
+	entries, err := lister.List(ctx, keybase1.NewPathWithKbfs(dir))
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []string
+	for _, e := range entries {
+		ok, err := path.Match(pattern, e.Name)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matches = append(matches, path.Join(dir, e.Name))
+		}
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("fs: %q: no matches", arg)
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
@@ -0,0 +1,173 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// fakeSimpleFSGlobLister is a simpleFSLister backed by a fixed, in-memory
+// directory listing, for exercising simpleFSExpandGlob's KBFS path without
+// a real SimpleFS daemon. Canonicalize is never called by
+// simpleFSExpandGlob, so it just panics, which is exactly what we want if
+// that assumption ever stops holding.
+type fakeSimpleFSGlobLister struct {
+	dir     string
+	entries []keybase1.Dirent
+}
+
+func (f *fakeSimpleFSGlobLister) List(ctx context.Context, dir keybase1.Path) ([]keybase1.Dirent, error) {
+	if simpleFSPathString(dir) != f.dir {
+		return nil, nil
+	}
+	return f.entries, nil
+}
+
+func (f *fakeSimpleFSGlobLister) Canonicalize(ctx context.Context, p keybase1.Path) (string, error) {
+	panic("not implemented")
+}
+
+func direntsNamed(names ...string) []keybase1.Dirent {
+	entries := make([]keybase1.Dirent, len(names))
+	for i, n := range names {
+		entries[i] = keybase1.Dirent{Name: n}
+	}
+	return entries
+}
+
+func TestSimpleFSExpandGlobKbfsStar(t *testing.T) {
+	lister := &fakeSimpleFSGlobLister{
+		dir:     "/keybase/private/alice",
+		entries: direntsNamed("a.txt", "b.txt", "c.png", "dir"),
+	}
+
+	matches, err := simpleFSExpandGlob(context.Background(), lister, "/keybase/private/alice/*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(matches)
+	want := []string{"/keybase/private/alice/a.txt", "/keybase/private/alice/b.txt"}
+	if !stringSlicesEqual(matches, want) {
+		t.Errorf("expected %v, got %v", want, matches)
+	}
+}
+
+func TestSimpleFSExpandGlobKbfsQuestionMark(t *testing.T) {
+	lister := &fakeSimpleFSGlobLister{
+		dir:     "/keybase/private/alice",
+		entries: direntsNamed("a.txt", "ab.txt", "abc.txt"),
+	}
+
+	matches, err := simpleFSExpandGlob(context.Background(), lister, "/keybase/private/alice/a?.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/keybase/private/alice/ab.txt"}
+	if !stringSlicesEqual(matches, want) {
+		t.Errorf("expected %v, got %v", want, matches)
+	}
+}
+
+func TestSimpleFSExpandGlobKbfsBracket(t *testing.T) {
+	lister := &fakeSimpleFSGlobLister{
+		dir:     "/keybase/private/alice",
+		entries: direntsNamed("report1.txt", "report2.txt", "report3.txt", "reportX.txt"),
+	}
+
+	matches, err := simpleFSExpandGlob(context.Background(), lister, "/keybase/private/alice/report[12].txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/keybase/private/alice/report1.txt", "/keybase/private/alice/report2.txt"}
+	if !stringSlicesEqual(matches, want) {
+		t.Errorf("expected %v, got %v", want, matches)
+	}
+}
+
+func TestSimpleFSExpandGlobKbfsNoMatchesIsError(t *testing.T) {
+	lister := &fakeSimpleFSGlobLister{
+		dir:     "/keybase/private/alice",
+		entries: direntsNamed("a.txt"),
+	}
+
+	if _, err := simpleFSExpandGlob(context.Background(), lister, "/keybase/private/alice/*.png"); err == nil {
+		t.Error("expected an unmatched glob to be an error")
+	}
+}
+
+func TestSimpleFSExpandGlobKbfsNonGlobPassesThrough(t *testing.T) {
+	lister := &fakeSimpleFSGlobLister{dir: "/keybase/private/alice"}
+
+	matches, err := simpleFSExpandGlob(context.Background(), lister, "/keybase/private/alice/plain.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/keybase/private/alice/plain.txt"}
+	if !stringSlicesEqual(matches, want) {
+		t.Errorf("expected the non-glob path to pass through unchanged, got %v", matches)
+	}
+}
+
+func TestSimpleFSExpandGlobKbfsRejectsGlobInParentComponent(t *testing.T) {
+	lister := &fakeSimpleFSGlobLister{}
+	if _, err := simpleFSExpandGlob(context.Background(), lister, "/keybase/private/*/file.txt"); err == nil {
+		t.Error("expected a glob in a parent path component to be rejected")
+	}
+}
+
+func TestSimpleFSExpandGlobLocal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "simplefsglob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"a.txt", "b.txt", "c.png"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	matches, err := simpleFSExpandGlob(context.Background(), nil, filepath.Join(dir, "*.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(matches)
+	want := []string{filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt")}
+	if !stringSlicesEqual(matches, want) {
+		t.Errorf("expected %v, got %v", want, matches)
+	}
+}
+
+func TestSimpleFSExpandGlobLocalNoMatchesIsError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "simplefsglob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := simpleFSExpandGlob(context.Background(), nil, filepath.Join(dir, "*.missing")); err == nil {
+		t.Error("expected an unmatched local glob to be an error")
+	}
+}
+
+func stringSlicesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,245 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/cli"
+	"github.com/keybase/client/go/libcmdline"
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// CmdSimpleFSInfo is the 'fs info' command. It prints a one-shot summary of
+// a TLF: total size and file count (from a streamed recursive walk, the
+// same walker `fs find`/`fs recent` use), writer/reader counts (from
+// resolving the TLF name), and whether this device currently has crypt
+// keys for it. Nothing beyond the run is cached -- a long-lived daemon
+// would want to cache the walk, but this is a point-in-time snapshot.
+type CmdSimpleFSInfo struct {
+	libkb.Contextified
+	tlfName string
+	private bool
+	json    bool
+}
+
+func newCmdSimpleFSInfo(cl *libcmdline.CommandLine, g *libkb.GlobalContext) cli.Command {
+	return cli.Command{
+		Name:         "info",
+		Usage:        "Summarize a TLF's size, file count, and membership",
+		ArgumentHelp: "<path>",
+		Action: func(c *cli.Context) {
+			cl.ChooseCommand(&CmdSimpleFSInfo{Contextified: libkb.NewContextified(g)}, "info", c)
+		},
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "json",
+				Usage: "Emit the summary as JSON",
+			},
+		},
+	}
+}
+
+func (c *CmdSimpleFSInfo) ParseArgv(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		return fmt.Errorf("fs info takes one argument: <path>")
+	}
+
+	folder, err := ParseTLF(ctx.Args()[0])
+	if err != nil {
+		return err
+	}
+	c.tlfName = folder.Name
+	c.private = folder.Private
+	c.json = ctx.Bool("json")
+	return nil
+}
+
+// simpleFSInfoSummary is CmdSimpleFSInfo's --json output, and also backs
+// its plain-text rendering.
+type simpleFSInfoSummary struct {
+	TlfName      string `json:"tlfName"`
+	TotalSize    int64  `json:"totalSize"`
+	FileCount    int    `json:"fileCount"`
+	NumWriters   int    `json:"numWriters"`
+	NumReaders   int    `json:"numReaders"`
+	RekeyPending bool   `json:"rekeyPending"`
+}
+
+func (c *CmdSimpleFSInfo) Run() (err error) {
+	defer func() {
+		err = fsFinishError(c.G(), err)
+	}()
+
+	tlfClient, err := GetTlfClient(c.G())
+	if err != nil {
+		return err
+	}
+	simpleFSClient, err := GetSimpleFSClient(c.G())
+	if err != nil {
+		return err
+	}
+
+	ctx := context.TODO()
+	resolver := tlfInterfaceInfoResolver{cli: tlfClient}
+	lister := &simpleFSRPCLister{ctx: ctx, cli: simpleFSClient}
+
+	summary, err := simpleFSInfo(ctx, resolver, lister, c.tlfName, c.private)
+	if err != nil {
+		return err
+	}
+
+	if c.json {
+		out, err := json.MarshalIndent(summary, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "%s\n", out)
+		return nil
+	}
+
+	fmt.Fprintf(os.Stdout, "%s\n", summary.TlfName)
+	fmt.Fprintf(os.Stdout, "size:    %d bytes across %d file(s)\n", summary.TotalSize, summary.FileCount)
+	fmt.Fprintf(os.Stdout, "members: %d writer(s), %d reader(s)\n", summary.NumWriters, summary.NumReaders)
+	if summary.RekeyPending {
+		fmt.Fprintln(os.Stdout, "rekey:   pending")
+	} else {
+		fmt.Fprintln(os.Stdout, "rekey:   up to date")
+	}
+	return nil
+}
+
+// simpleFSInfo resolves tlfName via resolver and walks it via lister to
+// assemble CmdSimpleFSInfo's summary. It is split out from Run so tests
+// can drive it with a fake lister and a fake resolver instead of real
+// RPCs.
+func simpleFSInfo(ctx context.Context, resolver simpleFSInfoResolver, lister simpleFSLister, tlfName string, private bool) (simpleFSInfoSummary, error) {
+	info, err := resolver.Resolve(ctx, tlfName, private)
+	if err != nil {
+		return simpleFSInfoSummary{}, err
+	}
+
+	var totalSize int64
+	fileCount := 0
+	warn := func(format string, args ...interface{}) {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+	}
+	fn := func(dir keybase1.Path, entry keybase1.Dirent) error {
+		if entry.DirentType == keybase1.DirentType_DIR {
+			return nil
+		}
+		totalSize += int64(entry.Size)
+		fileCount++
+		return nil
+	}
+
+	root := simpleFSTlfRootPath(private, info.CanonicalName)
+	if err := simpleFSWalk(ctx, lister, root, simpleFSWalkOptions{}, warn, fn); err != nil {
+		return simpleFSInfoSummary{}, err
+	}
+
+	return simpleFSInfoSummary{
+		TlfName:      info.CanonicalName,
+		TotalSize:    totalSize,
+		FileCount:    fileCount,
+		NumWriters:   info.NumWriters,
+		NumReaders:   info.NumReaders,
+		RekeyPending: info.RekeyPending,
+	}, nil
+}
+
+// simpleFSTlfRootPath builds the KBFS path for a TLF's root directory from
+// its visibility and (canonical) name.
+func simpleFSTlfRootPath(private bool, tlfName string) keybase1.Path {
+	visibility := "public"
+	if private {
+		visibility = "private"
+	}
+	return keybase1.NewPathWithKbfs(path.Join(kbfsPathPrefix, visibility, tlfName))
+}
+
+// simpleFSTlfInfo is what simpleFSInfoResolver resolves a TLF name to:
+// its canonical name, writer/reader counts derived from that name, and
+// whether this device currently has crypt keys for it.
+type simpleFSTlfInfo struct {
+	CanonicalName string
+	NumWriters    int
+	NumReaders    int
+	RekeyPending  bool
+}
+
+// simpleFSInfoResolver abstracts resolving a TLF name to a simpleFSTlfInfo,
+// so CmdSimpleFSInfo can be tested against a fake instead of a real
+// keybase1.TlfInterface.
+type simpleFSInfoResolver interface {
+	Resolve(ctx context.Context, tlfName string, private bool) (simpleFSTlfInfo, error)
+}
+
+// tlfInterfaceInfoResolver is the real simpleFSInfoResolver backing
+// CmdSimpleFSInfo, built on the same keybase1.TlfInterface RPCs as `fs
+// resolve`.
+type tlfInterfaceInfoResolver struct {
+	cli keybase1.TlfInterface
+}
+
+func (r tlfInterfaceInfoResolver) Resolve(ctx context.Context, tlfName string, private bool) (simpleFSTlfInfo, error) {
+	cname, err := simpleFSResolveTLF(ctx, r.cli, tlfName, private)
+	if err != nil {
+		return simpleFSTlfInfo{}, err
+	}
+
+	writers, readers := simpleFSCountTlfMembers(string(cname.CanonicalName))
+	info := simpleFSTlfInfo{
+		CanonicalName: string(cname.CanonicalName),
+		NumWriters:    writers,
+		NumReaders:    readers,
+	}
+
+	// Public TLFs don't need per-device crypt keys, so there's nothing to
+	// rekey. For private TLFs, CryptKeys is the same RPC chat's Boxer
+	// relies on to box/unbox messages -- this device failing to get keys
+	// through it is the simplest available signal that a rekey is
+	// pending.
+	if private {
+		query := keybase1.TLFQuery{TlfName: tlfName, IdentifyBehavior: keybase1.TLFIdentifyBehavior_CHAT_CLI}
+		if _, err := r.cli.CryptKeys(ctx, query); err != nil {
+			info.RekeyPending = true
+		}
+	}
+
+	return info, nil
+}
+
+// simpleFSCountTlfMembers counts writers and readers out of a canonical
+// TLF name like "alice,bob#charlie" (writers alice and bob, reader
+// charlie) or "alice,bob" (writers only, no '#').
+func simpleFSCountTlfMembers(canonicalName string) (writers, readers int) {
+	parts := strings.SplitN(canonicalName, "#", 2)
+	writers = simpleFSCountCommaList(parts[0])
+	if len(parts) == 2 {
+		readers = simpleFSCountCommaList(parts[1])
+	}
+	return writers, readers
+}
+
+func simpleFSCountCommaList(s string) int {
+	if s == "" {
+		return 0
+	}
+	return len(strings.Split(s, ","))
+}
+
+func (c *CmdSimpleFSInfo) GetUsage() libkb.Usage {
+	return libkb.Usage{
+		Config: true,
+		API:    true,
+	}
+}
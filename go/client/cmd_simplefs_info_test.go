@@ -0,0 +1,113 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// fakeInfoResolver is an in-memory simpleFSInfoResolver for testing
+// simpleFSInfo without a real keybase1.TlfInterface.
+type fakeInfoResolver struct {
+	info simpleFSTlfInfo
+	err  error
+}
+
+func (f *fakeInfoResolver) Resolve(ctx context.Context, tlfName string, private bool) (simpleFSTlfInfo, error) {
+	return f.info, f.err
+}
+
+func TestSimpleFSInfoSummarizesSizeCountAndMembership(t *testing.T) {
+	// /keybase/private/alice,bob#charlie/
+	//   a.txt (3 bytes)
+	//   sub/
+	//     b.txt (5 bytes)
+	lister := &fakeLister{
+		dirs: map[string][]keybase1.Dirent{
+			"/keybase/private/alice,bob#charlie": {
+				{Name: "a.txt", DirentType: keybase1.DirentType_FILE, Size: 3},
+				{Name: "sub", DirentType: keybase1.DirentType_DIR},
+			},
+			"/keybase/private/alice,bob#charlie/sub": {
+				{Name: "b.txt", DirentType: keybase1.DirentType_FILE, Size: 5},
+			},
+		},
+	}
+	resolver := &fakeInfoResolver{
+		info: simpleFSTlfInfo{
+			CanonicalName: "alice,bob#charlie",
+			NumWriters:    2,
+			NumReaders:    1,
+		},
+	}
+
+	summary, err := simpleFSInfo(context.Background(), resolver, lister, "alice,bob#charlie", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.TotalSize != 8 {
+		t.Errorf("got TotalSize %d, want 8", summary.TotalSize)
+	}
+	if summary.FileCount != 2 {
+		t.Errorf("got FileCount %d, want 2", summary.FileCount)
+	}
+	if summary.NumWriters != 2 || summary.NumReaders != 1 {
+		t.Errorf("got %d writer(s)/%d reader(s), want 2/1", summary.NumWriters, summary.NumReaders)
+	}
+	if summary.RekeyPending {
+		t.Errorf("expected RekeyPending to be false")
+	}
+}
+
+func TestSimpleFSInfoPropagatesRekeyPending(t *testing.T) {
+	lister := &fakeLister{dirs: map[string][]keybase1.Dirent{
+		"/keybase/private/alice": {},
+	}}
+	resolver := &fakeInfoResolver{
+		info: simpleFSTlfInfo{CanonicalName: "alice", NumWriters: 1, RekeyPending: true},
+	}
+
+	summary, err := simpleFSInfo(context.Background(), resolver, lister, "alice", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !summary.RekeyPending {
+		t.Errorf("expected RekeyPending to be true")
+	}
+}
+
+func TestSimpleFSInfoPropagatesResolveError(t *testing.T) {
+	lister := &fakeLister{}
+	resolver := &fakeInfoResolver{err: errors.New("resolve failed")}
+
+	_, err := simpleFSInfo(context.Background(), resolver, lister, "alice", true)
+	if err == nil {
+		t.Fatal("expected an error from a failing resolver")
+	}
+}
+
+func TestSimpleFSCountTlfMembers(t *testing.T) {
+	cases := []struct {
+		name        string
+		wantWriters int
+		wantReaders int
+	}{
+		{"alice", 1, 0},
+		{"alice,bob", 2, 0},
+		{"alice,bob#charlie", 2, 1},
+		{"alice,bob#charlie,dave", 2, 2},
+	}
+	for _, c := range cases {
+		writers, readers := simpleFSCountTlfMembers(c.name)
+		if writers != c.wantWriters || readers != c.wantReaders {
+			t.Errorf("simpleFSCountTlfMembers(%q) = (%d, %d), want (%d, %d)", c.name, writers, readers, c.wantWriters, c.wantReaders)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// simpleFSJSONDirent is the JSON rendering of a keybase1.Dirent used by
+// `fs ls --json` and `fs stat --json`. It exists instead of marshaling the
+// Dirent directly so Time can be RFC3339 rather than keybase1.Time's raw
+// milliseconds-since-epoch, and DirentType can be the same human-readable
+// string the non-JSON output already uses, for scripts that don't want to
+// know KBFS's internal enum/time representations.
+type simpleFSJSONDirent struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Size int    `json:"size"`
+	Time string `json:"time"`
+}
+
+// newSimpleFSJSONDirent converts e to its JSON rendering.
+func newSimpleFSJSONDirent(e keybase1.Dirent) simpleFSJSONDirent {
+	return simpleFSJSONDirent{
+		Name: e.Name,
+		Type: simpleFSDirentTypeString(e.DirentType),
+		Size: e.Size,
+		Time: e.Time.Time().Format(time.RFC3339),
+	}
+}
+
+// simpleFSJSONStat is the JSON rendering of `fs stat`'s result: the same
+// fields simpleFSJSONDirent carries, plus the optional --no-sniff content
+// type and --show-crypto status.
+type simpleFSJSONStat struct {
+	simpleFSJSONDirent
+	ContentType string              `json:"content_type,omitempty"`
+	Crypto      *simpleFSJSONCrypto `json:"crypto,omitempty"`
+}
+
+// simpleFSJSONCrypto is the JSON rendering of a simpleFSCryptoInfo.
+type simpleFSJSONCrypto struct {
+	Encrypted     bool   `json:"encrypted"`
+	KeyGeneration int    `json:"key_generation,omitempty"`
+	Unavailable   string `json:"unavailable,omitempty"`
+}
+
+// newSimpleFSJSONStat builds `fs stat --json`'s result. contentType and
+// crypto are the empty string/nil when --no-sniff/--show-crypto weren't
+// given, which newSimpleFSJSONStat renders by omitting those fields
+// entirely rather than printing them as null or empty.
+func newSimpleFSJSONStat(dirent keybase1.Dirent, contentType string, crypto *simpleFSCryptoInfo) simpleFSJSONStat {
+	stat := simpleFSJSONStat{
+		simpleFSJSONDirent: newSimpleFSJSONDirent(dirent),
+		ContentType:        contentType,
+	}
+	if crypto != nil {
+		stat.Crypto = &simpleFSJSONCrypto{
+			Encrypted:     crypto.Encrypted,
+			KeyGeneration: crypto.KeyGeneration,
+			Unavailable:   crypto.Unavailable,
+		}
+	}
+	return stat
+}
+
+// printSimpleFSJSON marshals v as indented JSON to stdout. It's the common
+// tail end of every fs subcommand's --json path.
+func printSimpleFSJSON(v interface{}) error {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "%s\n", out)
+	return nil
+}
@@ -0,0 +1,432 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/cli"
+	"github.com/keybase/client/go/libcmdline"
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// simpleFSListPollInterval is how long CmdSimpleFSList sleeps between
+// SimpleFSReadList polls while an fs ls operation is still in progress.
+const simpleFSListPollInterval = 200 * time.Millisecond
+
+// CmdSimpleFSList is the 'fs ls' command, which lists a KBFS or local
+// directory via the SimpleFS RPC API. Unlike the older `fs list` command,
+// it streams entries to stdout as SimpleFSReadList reports them, rather
+// than waiting for the whole listing and buffering it in memory -- this
+// matters for directories with tens of thousands of entries.
+type CmdSimpleFSList struct {
+	libkb.Contextified
+	path      string
+	limit     int
+	pageSize  int
+	mimeType  bool
+	long      bool
+	olderThan time.Time
+	newerThan time.Time
+	json      bool
+}
+
+func newCmdSimpleFSList(cl *libcmdline.CommandLine, g *libkb.GlobalContext) cli.Command {
+	return cli.Command{
+		Name:         "ls",
+		Usage:        "List a KBFS directory, streaming entries as they arrive",
+		ArgumentHelp: "<path>",
+		Action: func(c *cli.Context) {
+			cl.ChooseCommand(&CmdSimpleFSList{Contextified: libkb.NewContextified(g)}, "ls", c)
+		},
+		Flags: []cli.Flag{
+			cli.IntFlag{
+				Name:  "limit",
+				Usage: "Stop after printing this many entries (0 for no limit)",
+			},
+			cli.IntFlag{
+				Name:  "page-size",
+				Value: 100,
+				Usage: "How many newly-arrived entries to print per flush to stdout",
+			},
+			cli.BoolFlag{
+				Name:  "mime-type",
+				Usage: "Sample each file to show a detected content type (slower: one extra read per file)",
+			},
+			cli.BoolFlag{
+				Name:  "l, long",
+				Usage: "Re-stat each entry for fresher size/mtime info, instead of trusting SimpleFSList's snapshot (batched, not one round trip per entry)",
+			},
+			cli.StringFlag{
+				Name:  "older-than",
+				Usage: "Only show entries last modified before this long ago (e.g. 30d, 12h) or before this absolute date",
+			},
+			cli.StringFlag{
+				Name:  "newer-than",
+				Usage: "Only show entries last modified within this long (e.g. 30d, 12h) or after this absolute date",
+			},
+			cli.BoolFlag{
+				Name:  "json",
+				Usage: "Print entries as a JSON array instead of formatted text",
+			},
+		},
+	}
+}
+
+func (c *CmdSimpleFSList) ParseArgv(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		return fmt.Errorf("fs ls takes one argument: <path>")
+	}
+	c.path = ctx.Args()[0]
+	c.limit = ctx.Int("limit")
+	c.pageSize = ctx.Int("page-size")
+	if c.pageSize <= 0 {
+		c.pageSize = 100
+	}
+	c.mimeType = ctx.Bool("mime-type")
+	c.long = ctx.Bool("long")
+	c.json = ctx.Bool("json")
+
+	if s := ctx.String("older-than"); s != "" {
+		cutoff, err := simpleFSParseTimeFilterCutoff(s)
+		if err != nil {
+			return fmt.Errorf("--older-than: %s", err)
+		}
+		c.olderThan = cutoff
+	}
+	if s := ctx.String("newer-than"); s != "" {
+		cutoff, err := simpleFSParseTimeFilterCutoff(s)
+		if err != nil {
+			return fmt.Errorf("--newer-than: %s", err)
+		}
+		c.newerThan = cutoff
+	}
+	return nil
+}
+
+// Run lists c.path, expanding it first as a glob pattern if it contains
+// one. A pattern that expands to more than one match lists each in turn,
+// printing the matched path as a header line before its listing, the way
+// a shell's own `ls` does for multiple directory arguments.
+func (c *CmdSimpleFSList) Run() (err error) {
+	defer func() {
+		err = fsFinishError(c.G(), err)
+	}()
+
+	cli, err := GetSimpleFSClient(c.G())
+	if err != nil {
+		return err
+	}
+
+	ctx := context.TODO()
+	matches, err := simpleFSExpandGlob(ctx, &simpleFSRPCLister{ctx: ctx, cli: cli}, c.path)
+	if err != nil {
+		return err
+	}
+
+	if c.json {
+		return c.runJSON(ctx, cli, matches)
+	}
+
+	for i, m := range matches {
+		if len(matches) > 1 {
+			if i > 0 {
+				fmt.Fprintln(os.Stdout)
+			}
+			fmt.Fprintf(os.Stdout, "%s:\n", m)
+		}
+		if err := c.listOne(ctx, cli, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runJSON is Run's --json path: it collects every match's entries (in the
+// same order and subject to the same --limit/--long/time-filter options as
+// the plain-text path) into a single flat array and prints it once, rather
+// than streaming formatted lines as they arrive -- a script parsing JSON
+// wants one well-formed document, not a page-by-page stream.
+func (c *CmdSimpleFSList) runJSON(ctx context.Context, cli keybase1.SimpleFSInterface, matches []string) error {
+	var entries []simpleFSJSONDirent
+	for _, m := range matches {
+		collected, err := c.collectOne(ctx, cli, m)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, collected...)
+	}
+	return printSimpleFSJSON(entries)
+}
+
+// collectOne is listOne's non-printing counterpart: it polls
+// SimpleFSReadList the same way, but appends matching entries to a slice
+// instead of writing formatted lines to stdout. --mime-type is ignored
+// here since simpleFSJSONDirent has no content-type field.
+func (c *CmdSimpleFSList) collectOne(ctx context.Context, cli keybase1.SimpleFSInterface, pathArg string) ([]simpleFSJSONDirent, error) {
+	opid, err := cli.SimpleFSMakeOpid(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = cli.SimpleFSClose(ctx, opid) }()
+
+	path := simpleFSPathFromArg(pathArg)
+	if err := cli.SimpleFSList(ctx, keybase1.SimpleFSListArg{OpID: opid, Path: path}); err != nil {
+		return nil, err
+	}
+
+	var result []simpleFSJSONDirent
+	seen := 0
+	for {
+		res, err := cli.SimpleFSReadList(ctx, opid)
+		if err != nil {
+			return nil, err
+		}
+
+		for seen < len(res.Entries) {
+			if c.limit > 0 && len(result) >= c.limit {
+				return result, nil
+			}
+			batchEnd := seen + c.pageSize
+			if batchEnd > len(res.Entries) {
+				batchEnd = len(res.Entries)
+			}
+			batch := res.Entries[seen:batchEnd]
+			if c.long {
+				batch, _ = c.refreshWithBatchStat(ctx, cli, path, batch)
+			}
+			for _, e := range batch {
+				if !c.matchesTimeFilter(e) {
+					continue
+				}
+				if c.limit > 0 && len(result) >= c.limit {
+					return result, nil
+				}
+				result = append(result, newSimpleFSJSONDirent(e))
+			}
+			seen = batchEnd
+		}
+		if c.limit > 0 && len(result) >= c.limit {
+			return result, nil
+		}
+
+		if res.Progress >= 100 {
+			return result, nil
+		}
+		time.Sleep(simpleFSListPollInterval)
+	}
+}
+
+// listOne lists pathArg, polling SimpleFSReadList until the operation is
+// no longer pending. It is Run's entire body for the common case of a
+// single, non-glob path; when c.path expands to more than one match, Run
+// calls this once per match instead.
+//
+// SimpleFSReadList has no notion of a page token: each poll returns the
+// full set of entries KBFS has discovered so far for the opid, and
+// Progress (0-100) is the only signal of completion. So --page-size here
+// doesn't tune the RPC -- it only controls how many newly-seen entries
+// this command buffers locally before flushing them to stdout, to avoid
+// a write() syscall per entry on large directories.
+//
+// Because each poll reflects KBFS's current view, a directory that
+// changes mid-listing can produce a listing that is neither a clean
+// snapshot nor fully live: entries already printed are never retracted
+// even if removed afterward, entries that are renamed before this command
+// reaches their new name may be printed twice (under old and new name) or
+// not at all, and newly created entries show up if they land after the
+// point we've already printed up to. Treat `fs ls` output on a changing
+// directory as a best-effort approximation, not a consistent snapshot.
+func (c *CmdSimpleFSList) listOne(ctx context.Context, cli keybase1.SimpleFSInterface, pathArg string) (err error) {
+	opid, err := cli.SimpleFSMakeOpid(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = cli.SimpleFSClose(ctx, opid) }()
+
+	path := simpleFSPathFromArg(pathArg)
+	if err := cli.SimpleFSList(ctx, keybase1.SimpleFSListArg{OpID: opid, Path: path}); err != nil {
+		return err
+	}
+
+	printed, shown := 0, 0
+	for {
+		res, err := cli.SimpleFSReadList(ctx, opid)
+		if err != nil {
+			return err
+		}
+
+		for printed < len(res.Entries) {
+			if c.limit > 0 && shown >= c.limit {
+				return nil
+			}
+			batchEnd := printed + c.pageSize
+			if batchEnd > len(res.Entries) {
+				batchEnd = len(res.Entries)
+			}
+			batch := res.Entries[printed:batchEnd]
+			statErrs := make([]error, len(batch))
+			if c.long {
+				batch, statErrs = c.refreshWithBatchStat(ctx, cli, path, batch)
+			}
+			for i, e := range batch {
+				if !c.matchesTimeFilter(e) {
+					continue
+				}
+				if c.limit > 0 && shown >= c.limit {
+					return nil
+				}
+				line, err := c.formatEntry(ctx, cli, path, e)
+				if err != nil {
+					return err
+				}
+				if statErrs[i] != nil {
+					line = fmt.Sprintf("%s\t<stat error: %s>", line, statErrs[i])
+				}
+				fmt.Fprintln(os.Stdout, line)
+				shown++
+			}
+			printed = batchEnd
+		}
+		if c.limit > 0 && shown >= c.limit {
+			return nil
+		}
+
+		if res.Progress >= 100 {
+			return nil
+		}
+		time.Sleep(simpleFSListPollInterval)
+	}
+}
+
+// simpleFSFormatDirent renders a single listing entry as `ls`-style output:
+// directories get a trailing slash, everything else is printed with its
+// size.
+func simpleFSFormatDirent(e keybase1.Dirent) string {
+	if e.DirentType == keybase1.DirentType_DIR {
+		return e.Name + "/"
+	}
+	return fmt.Sprintf("%s\t%d", e.Name, e.Size)
+}
+
+// matchesTimeFilter reports whether e's mtime satisfies --older-than and
+// --newer-than, whichever of the two were given.
+func (c *CmdSimpleFSList) matchesTimeFilter(e keybase1.Dirent) bool {
+	t := e.Time.Time()
+	if !c.olderThan.IsZero() && !t.Before(c.olderThan) {
+		return false
+	}
+	if !c.newerThan.IsZero() && !t.After(c.newerThan) {
+		return false
+	}
+	return true
+}
+
+// simpleFSTimeFilterDayPattern matches a bare day count like "30d", the
+// one duration unit time.ParseDuration doesn't understand.
+var simpleFSTimeFilterDayPattern = regexp.MustCompile(`^(\d+)d$`)
+
+// simpleFSParseDuration parses a duration the way time.ParseDuration does,
+// plus a bare "<N>d" form for days, since --older-than/--newer-than are
+// typically given as something like "30d" or "12h".
+func simpleFSParseDuration(s string) (time.Duration, error) {
+	if m := simpleFSTimeFilterDayPattern.FindStringSubmatch(s); m != nil {
+		days, err := strconv.Atoi(m[1])
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// simpleFSTimeFilterLayouts are the absolute date formats
+// simpleFSParseTimeFilterCutoff accepts, tried in order, when its input
+// isn't a duration.
+var simpleFSTimeFilterLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// simpleFSParseTimeFilterCutoff resolves an --older-than/--newer-than flag
+// value to an absolute cutoff time: a duration (e.g. "30d", "12h") is
+// measured back from now, and anything else is parsed as an absolute
+// date.
+func simpleFSParseTimeFilterCutoff(s string) (time.Time, error) {
+	if d, err := simpleFSParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	for _, layout := range simpleFSTimeFilterLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("could not parse %q as a duration (e.g. 30d, 12h) or an absolute date", s)
+}
+
+// refreshWithBatchStat re-stats entries concurrently via simpleFSBatchStat
+// to get fresher Size/Time/DirentType than SimpleFSList's snapshot,
+// avoiding one serial round trip per entry. It returns a same-length
+// slice of refreshed entries, plus one error per entry (nil on success);
+// an entry whose stat failed is returned unchanged from what
+// SimpleFSList originally reported, so one bad entry doesn't blank out
+// the rest of the listing.
+func (c *CmdSimpleFSList) refreshWithBatchStat(ctx context.Context, cli keybase1.SimpleFSInterface, dir keybase1.Path, entries []keybase1.Dirent) ([]keybase1.Dirent, []error) {
+	paths := make([]keybase1.Path, len(entries))
+	for i, e := range entries {
+		paths[i] = simpleFSChildPath(dir, e.Name)
+	}
+	results := simpleFSBatchStat(ctx, cli, paths)
+
+	refreshed := make([]keybase1.Dirent, len(entries))
+	errs := make([]error, len(entries))
+	for i, res := range results {
+		// Keep Name and DirentType from the original listing (SimpleFSStat
+		// doesn't necessarily echo them back for a single path) and only
+		// take the fresher Size/Time from the stat.
+		refreshed[i] = entries[i]
+		if res.Err != nil {
+			errs[i] = res.Err
+			continue
+		}
+		refreshed[i].Size = res.Dirent.Size
+		refreshed[i].Time = res.Dirent.Time
+	}
+	return refreshed, errs
+}
+
+// formatEntry renders e the way simpleFSFormatDirent does, appending a
+// sniffed content type when --mime-type was given. dir is e's containing
+// directory, needed to build e's full path for the content sample.
+func (c *CmdSimpleFSList) formatEntry(ctx context.Context, cli keybase1.SimpleFSInterface, dir keybase1.Path, e keybase1.Dirent) (string, error) {
+	line := simpleFSFormatDirent(e)
+	if !c.mimeType {
+		return line, nil
+	}
+
+	contentType, err := simpleFSDetectContentType(ctx, cli, simpleFSChildPath(dir, e.Name), e)
+	if err != nil {
+		return "", err
+	}
+	if contentType == "" {
+		return line, nil
+	}
+	return fmt.Sprintf("%s\t%s", line, contentType), nil
+}
+
+func (c *CmdSimpleFSList) GetUsage() libkb.Usage {
+	return libkb.Usage{
+		Config: true,
+		API:    true,
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+func TestSimpleFSFormatDirent(t *testing.T) {
+	cases := []struct {
+		name string
+		e    keybase1.Dirent
+		want string
+	}{
+		{"dir", keybase1.Dirent{Name: "foo", DirentType: keybase1.DirentType_DIR}, "foo/"},
+		{"file", keybase1.Dirent{Name: "bar", Size: 42, DirentType: keybase1.DirentType_FILE}, "bar\t42"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := simpleFSFormatDirent(c.e); got != c.want {
+				t.Errorf("simpleFSFormatDirent(%+v) = %q, want %q", c.e, got, c.want)
+			}
+		})
+	}
+}
+
+func TestSimpleFSParseDuration(t *testing.T) {
+	cases := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"30d", 30 * 24 * time.Hour},
+		{"0d", 0},
+		{"12h", 12 * time.Hour},
+		{"90m", 90 * time.Minute},
+	}
+	for _, c := range cases {
+		got, err := simpleFSParseDuration(c.in)
+		if err != nil {
+			t.Errorf("simpleFSParseDuration(%q) returned error: %v", c.in, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("simpleFSParseDuration(%q) = %v, want %v", c.in, got, c.want)
+		}
+	}
+
+	if _, err := simpleFSParseDuration("not a duration"); err == nil {
+		t.Error("expected an error for a string that isn't a duration")
+	}
+}
+
+func TestSimpleFSParseTimeFilterCutoffAbsolute(t *testing.T) {
+	got, err := simpleFSParseTimeFilterCutoff("2020-01-02")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	if !got.Equal(want) {
+		t.Errorf("simpleFSParseTimeFilterCutoff(%q) = %v, want %v", "2020-01-02", got, want)
+	}
+}
+
+func TestSimpleFSParseTimeFilterCutoffRelative(t *testing.T) {
+	before := time.Now().Add(-30 * 24 * time.Hour)
+	got, err := simpleFSParseTimeFilterCutoff("30d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := time.Now().Add(-30 * 24 * time.Hour)
+	if got.Before(before) || got.After(after) {
+		t.Errorf("simpleFSParseTimeFilterCutoff(%q) = %v, want something between %v and %v", "30d", got, before, after)
+	}
+}
+
+func TestSimpleFSListMatchesTimeFilter(t *testing.T) {
+	now := time.Now()
+	mkEntry := func(age time.Duration) keybase1.Dirent {
+		return keybase1.Dirent{Time: keybase1.ToTime(now.Add(-age))}
+	}
+
+	c := &CmdSimpleFSList{olderThan: now.Add(-7 * 24 * time.Hour)}
+	if c.matchesTimeFilter(mkEntry(1 * time.Hour)) {
+		t.Error("expected a 1-hour-old entry not to match --older-than 7d")
+	}
+	if !c.matchesTimeFilter(mkEntry(8 * 24 * time.Hour)) {
+		t.Error("expected an 8-day-old entry to match --older-than 7d")
+	}
+
+	c = &CmdSimpleFSList{newerThan: now.Add(-7 * 24 * time.Hour)}
+	if !c.matchesTimeFilter(mkEntry(1 * time.Hour)) {
+		t.Error("expected a 1-hour-old entry to match --newer-than 7d")
+	}
+	if c.matchesTimeFilter(mkEntry(8 * 24 * time.Hour)) {
+		t.Error("expected an 8-day-old entry not to match --newer-than 7d")
+	}
+
+	// With neither flag set, everything matches.
+	c = &CmdSimpleFSList{}
+	if !c.matchesTimeFilter(mkEntry(365 * 24 * time.Hour)) {
+		t.Error("expected every entry to match when no time filter is set")
+	}
+}
+
+// fakeSimpleFSListJSONClient is a minimal keybase1.SimpleFSInterface
+// serving a single fixed listing, for exercising `fs ls --json` without a
+// real SimpleFS daemon.
+type fakeSimpleFSListJSONClient struct {
+	keybase1.SimpleFSInterface // panics on anything not overridden below
+
+	entries []keybase1.Dirent
+}
+
+func (f *fakeSimpleFSListJSONClient) SimpleFSMakeOpid(ctx context.Context) (keybase1.OpID, error) {
+	return keybase1.OpID{}, nil
+}
+
+func (f *fakeSimpleFSListJSONClient) SimpleFSClose(ctx context.Context, opid keybase1.OpID) error {
+	return nil
+}
+
+func (f *fakeSimpleFSListJSONClient) SimpleFSList(ctx context.Context, arg keybase1.SimpleFSListArg) error {
+	return nil
+}
+
+func (f *fakeSimpleFSListJSONClient) SimpleFSReadList(ctx context.Context, opid keybase1.OpID) (keybase1.SimpleFSListResult, error) {
+	return keybase1.SimpleFSListResult{Entries: f.entries, Progress: 100}, nil
+}
+
+// TestSimpleFSListJSONGolden drives Run's --json path end to end against a
+// fixed listing and compares stdout to a fixed expected document, per the
+// request that added --json: "golden tests comparing the JSON output to a
+// fixed expected document".
+func TestSimpleFSListJSONGolden(t *testing.T) {
+	fake := &fakeSimpleFSListJSONClient{
+		entries: []keybase1.Dirent{
+			{Name: "a.txt", Size: 3, DirentType: keybase1.DirentType_FILE, Time: keybase1.ToTime(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))},
+			{Name: "sub", DirentType: keybase1.DirentType_DIR, Time: keybase1.ToTime(time.Date(2020, 1, 2, 3, 4, 6, 0, time.UTC))},
+		},
+	}
+	c := &CmdSimpleFSList{json: true, pageSize: 100}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	origStdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = origStdout }()
+
+	if err := c.runJSON(context.Background(), fake, []string{"/keybase/private/alice"}); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(r); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `[
+  {
+    "name": "a.txt",
+    "type": "file",
+    "size": 3,
+    "time": "2020-01-02T03:04:05Z"
+  },
+  {
+    "name": "sub",
+    "type": "directory",
+    "size": 0,
+    "time": "2020-01-02T03:04:06Z"
+  }
+]
+`
+	if buf.String() != want {
+		t.Errorf("fs ls --json output mismatch:\ngot:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
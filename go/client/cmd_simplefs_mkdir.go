@@ -0,0 +1,97 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/cli"
+	"github.com/keybase/client/go/libcmdline"
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// CmdSimpleFSMkdir is the 'fs mkdir' command, which creates a single KBFS
+// directory. --parents additionally creates any missing ancestors, the way
+// `mkdir -p` does on POSIX, and succeeds as a no-op if the full path
+// already exists.
+type CmdSimpleFSMkdir struct {
+	libkb.Contextified
+	path    string
+	parents bool
+}
+
+func newCmdSimpleFSMkdir(cl *libcmdline.CommandLine, g *libkb.GlobalContext) cli.Command {
+	return cli.Command{
+		Name:         "mkdir",
+		Usage:        "Create a KBFS directory",
+		ArgumentHelp: "<path>",
+		Action: func(c *cli.Context) {
+			cl.ChooseCommand(&CmdSimpleFSMkdir{Contextified: libkb.NewContextified(g)}, "mkdir", c)
+		},
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "p, parents",
+				Usage: "Create missing intermediate directories as needed, and don't fail if the full path already exists",
+			},
+		},
+	}
+}
+
+func (c *CmdSimpleFSMkdir) ParseArgv(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		return fmt.Errorf("fs mkdir takes one argument: <path>")
+	}
+	c.path = ctx.Args()[0]
+	c.parents = ctx.Bool("parents")
+	return nil
+}
+
+func (c *CmdSimpleFSMkdir) Run() (err error) {
+	defer func() { err = fsFinishError(c.G(), err) }()
+
+	fsClient, err := GetSimpleFSClient(c.G())
+	if err != nil {
+		return err
+	}
+
+	target := simpleFSPathFromArg(c.path)
+	if !simpleFSIsKbfs(target) {
+		return errors.New("fs mkdir target must be a /keybase/... path")
+	}
+
+	ctx := context.TODO()
+	if c.parents {
+		return simpleFSMkdirAll(ctx, fsClient, target.Kbfs())
+	}
+	return simpleFSMkdirOneStrict(ctx, fsClient, target.Kbfs())
+}
+
+// simpleFSMkdirOneStrict creates a single KBFS directory, the way plain
+// `mkdir` (without -p) does on POSIX. Unlike simpleFSMkdirOne, which
+// tolerates "already exists" so that simpleFSMkdirAll can walk over
+// ancestors that are already there, this fails if kbfsPath already exists.
+func simpleFSMkdirOneStrict(ctx context.Context, cli keybase1.SimpleFSInterface, kbfsPath string) error {
+	opid, err := cli.SimpleFSMakeOpid(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = cli.SimpleFSClose(ctx, opid) }()
+
+	return cli.SimpleFSOpen(ctx, keybase1.SimpleFSOpenArg{
+		OpID:  opid,
+		Dest:  keybase1.NewPathWithKbfs(kbfsPath),
+		Flags: keybase1.OpenFlags_DIRECTORY,
+	})
+}
+
+func (c *CmdSimpleFSMkdir) GetUsage() libkb.Usage {
+	return libkb.Usage{
+		Config: true,
+		API:    true,
+	}
+}
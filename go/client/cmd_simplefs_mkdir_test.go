@@ -0,0 +1,126 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// fakeSimpleFSMkdirClient is a minimal keybase1.SimpleFSInterface that
+// records each SimpleFSOpen(..., OpenFlags_DIRECTORY) call's path, and can
+// be made to fail for one specific path, the way a real backend would for
+// a directory the caller lacks permission to create.
+type fakeSimpleFSMkdirClient struct {
+	keybase1.SimpleFSInterface // panics on anything not overridden below
+
+	opened  []string
+	failAt  string
+	failErr error
+}
+
+func (f *fakeSimpleFSMkdirClient) SimpleFSMakeOpid(ctx context.Context) (keybase1.OpID, error) {
+	return keybase1.OpID{}, nil
+}
+
+func (f *fakeSimpleFSMkdirClient) SimpleFSClose(ctx context.Context, opid keybase1.OpID) error {
+	return nil
+}
+
+func (f *fakeSimpleFSMkdirClient) SimpleFSOpen(ctx context.Context, arg keybase1.SimpleFSOpenArg) error {
+	f.opened = append(f.opened, arg.Dest.Kbfs())
+	if f.failAt != "" && arg.Dest.Kbfs() == f.failAt {
+		return f.failErr
+	}
+	return nil
+}
+
+func TestSimpleFSMkdirAllCreatesEachMissingAncestorInOrder(t *testing.T) {
+	fake := &fakeSimpleFSMkdirClient{}
+
+	if err := simpleFSMkdirAll(context.Background(), fake, "/keybase/private/alice/a/b/c"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"/keybase/private",
+		"/keybase/private/alice",
+		"/keybase/private/alice/a",
+		"/keybase/private/alice/a/b",
+		"/keybase/private/alice/a/b/c",
+	}
+	if len(fake.opened) != len(want) {
+		t.Fatalf("got %d SimpleFSOpen calls, want %d: %v", len(fake.opened), len(want), fake.opened)
+	}
+	for i, p := range want {
+		if fake.opened[i] != p {
+			t.Errorf("call %d: got %q, want %q", i, fake.opened[i], p)
+		}
+	}
+}
+
+func TestSimpleFSMkdirAllOnAnAlreadyExistingPathIsANoOpSuccess(t *testing.T) {
+	// Simulate every ancestor already existing by having SimpleFSOpen
+	// report "already exists" for all of them.
+	fake := &mkdirAlreadyExistsClient{fakeSimpleFSMkdirClient: &fakeSimpleFSMkdirClient{}}
+
+	if err := simpleFSMkdirAll(context.Background(), fake, "/keybase/private/alice/a/b"); err != nil {
+		t.Fatalf("expected mkdir -p on an already-existing path to succeed, got %v", err)
+	}
+}
+
+// mkdirAlreadyExistsClient wraps fakeSimpleFSMkdirClient to make every
+// SimpleFSOpen call fail with an "already exists" error, the way the real
+// backend does when mkdir -p walks over a directory that's already there.
+type mkdirAlreadyExistsClient struct {
+	*fakeSimpleFSMkdirClient
+}
+
+func (f *mkdirAlreadyExistsClient) SimpleFSOpen(ctx context.Context, arg keybase1.SimpleFSOpenArg) error {
+	_ = f.fakeSimpleFSMkdirClient.SimpleFSOpen(ctx, arg)
+	return errors.New("file already exists")
+}
+
+func TestSimpleFSMkdirAllPropagatesAMidwayPermissionError(t *testing.T) {
+	wantErr := errors.New("permission denied")
+	fake := &fakeSimpleFSMkdirClient{
+		failAt:  "/keybase/private/alice/a",
+		failErr: wantErr,
+	}
+
+	err := simpleFSMkdirAll(context.Background(), fake, "/keybase/private/alice/a/b/c")
+	if err != wantErr {
+		t.Fatalf("expected %v, got %v", wantErr, err)
+	}
+
+	// The walk should have stopped at the failing component rather than
+	// continuing on to create its children.
+	want := []string{"/keybase/private", "/keybase/private/alice", "/keybase/private/alice/a"}
+	if len(fake.opened) != len(want) {
+		t.Fatalf("got %d SimpleFSOpen calls, want %d: %v", len(fake.opened), len(want), fake.opened)
+	}
+}
+
+func TestSimpleFSMkdirOneStrictFailsIfAlreadyExists(t *testing.T) {
+	fake := &mkdirAlreadyExistsClient{fakeSimpleFSMkdirClient: &fakeSimpleFSMkdirClient{}}
+
+	if err := simpleFSMkdirOneStrict(context.Background(), fake, "/keybase/private/alice/a"); err == nil {
+		t.Fatal("expected mkdir without --parents to fail on an already-existing directory")
+	}
+}
+
+func TestSimpleFSMkdirOneStrictSucceedsOnANewDirectory(t *testing.T) {
+	fake := &fakeSimpleFSMkdirClient{}
+
+	if err := simpleFSMkdirOneStrict(context.Background(), fake, "/keybase/private/alice/a"); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.opened) != 1 || fake.opened[0] != "/keybase/private/alice/a" {
+		t.Errorf("got %v", fake.opened)
+	}
+}
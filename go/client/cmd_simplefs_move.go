@@ -0,0 +1,254 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/cli"
+	"github.com/keybase/client/go/libcmdline"
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// CmdSimpleFSMove is the 'fs mv' command. SimpleFSMove renames in place,
+// which is only meaningful when source and destination are the same kind
+// of path (both local, or both KBFS) -- there's no filesystem underneath
+// that can atomically rename across that boundary. So Run detects a
+// cross-boundary move itself by inspecting both paths and falls back to
+// copying to the destination and only removing the source once that copy
+// has been verified to match, never the other way around: a copy that
+// fails or doesn't verify leaves the source exactly as it was.
+type CmdSimpleFSMove struct {
+	libkb.Contextified
+	src       string
+	dest      string
+	recursive bool
+	force     bool
+	hashAlgo  simpleFSChecksumAlgorithm
+	summary   bool
+	quiet     bool
+}
+
+func newCmdSimpleFSMove(cl *libcmdline.CommandLine, g *libkb.GlobalContext) cli.Command {
+	return cli.Command{
+		Name:         "mv",
+		Usage:        "Move a file into, out of, or within KBFS",
+		ArgumentHelp: "<source> <destination>",
+		Action: func(c *cli.Context) {
+			cl.ChooseCommand(&CmdSimpleFSMove{Contextified: libkb.NewContextified(g)}, "mv", c)
+		},
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "r, recursive",
+				Usage: "Move a directory and everything under it",
+			},
+			cli.BoolFlag{
+				Name:  "f, force",
+				Usage: "Overwrite an existing destination without prompting",
+			},
+			cli.StringFlag{
+				Name:  "checksum-algorithm",
+				Usage: "Hash algorithm used to verify a copy-then-delete move across the local/KBFS boundary: sha256 (default), sha512, or md5 (not cryptographically secure; for matching external manifests only)",
+			},
+			cli.BoolFlag{
+				Name:  "summary",
+				Usage: "Print a one-line summary (items moved, bytes transferred, failures, elapsed time) to stderr when done",
+			},
+			cli.BoolFlag{
+				Name:  "quiet",
+				Usage: "Suppress the --summary report and the progress display",
+			},
+		},
+	}
+}
+
+func (c *CmdSimpleFSMove) ParseArgv(ctx *cli.Context) error {
+	if len(ctx.Args()) != 2 {
+		return errors.New("fs mv takes two arguments: <source> <destination>")
+	}
+	c.src = ctx.Args()[0]
+	c.dest = ctx.Args()[1]
+	c.recursive = ctx.Bool("recursive")
+	c.force = ctx.Bool("force")
+	c.summary = ctx.Bool("summary")
+	c.quiet = ctx.Bool("quiet")
+
+	algo, err := parseChecksumAlgorithm(ctx.String("checksum-algorithm"), func(msg string) {
+		c.G().Log.Warning(msg)
+	})
+	if err != nil {
+		return err
+	}
+	c.hashAlgo = algo
+	return nil
+}
+
+func (c *CmdSimpleFSMove) Run() (err error) {
+	start := time.Now()
+	defer func() {
+		simpleFSRecordOp(simpleFSOpsLogPath(c.G()), fmt.Sprintf("mv %s -> %s", c.src, c.dest), start, err)
+		if c.summary {
+			printSimpleFSOpSummary(simpleFSOpSummary{
+				Verb:  "moved",
+				Start: start,
+				Err:   err,
+			}, c.quiet)
+		}
+		err = fsFinishError(c.G(), err)
+	}()
+
+	fsClient, err := GetSimpleFSClient(c.G())
+	if err != nil {
+		return err
+	}
+
+	ctx := context.TODO()
+	src := simpleFSPathFromArg(c.src)
+	dest := simpleFSPathFromArg(c.dest)
+
+	srcIsDir, err := simpleFSIsDir(ctx, fsClient, src)
+	if err != nil {
+		return err
+	}
+	if srcIsDir && !c.recursive {
+		return fmt.Errorf("%q is a directory (use -r to move it recursively)", c.src)
+	}
+	if !srcIsDir && c.recursive {
+		return fmt.Errorf("fs mv -r: %q is not a directory", c.src)
+	}
+
+	if simpleFSExists(ctx, fsClient, dest) {
+		policy := existingPolicyPrompt
+		if c.force {
+			policy = existingPolicyOverwrite
+		}
+		proceed, err := simpleFSConfirmOverwrite(c.G(), policy, simpleFSPathString(dest), nil)
+		if err != nil {
+			return err
+		}
+		if !proceed {
+			return nil
+		}
+	}
+
+	if simpleFSIsKbfs(src) == simpleFSIsKbfs(dest) {
+		return simpleFSMoveOnce(ctx, fsClient, src, dest)
+	}
+
+	return c.crossBoundaryMove(ctx, fsClient, src, dest)
+}
+
+// simpleFSMoveOnce moves src to dest via a single SimpleFSMove call, for
+// the case where src and dest are the same kind of path (both local or
+// both KBFS) and a real rename applies.
+func simpleFSMoveOnce(ctx context.Context, cli keybase1.SimpleFSInterface, src, dest keybase1.Path) error {
+	opid, err := cli.SimpleFSMakeOpid(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = cli.SimpleFSClose(ctx, opid) }()
+
+	if err := cli.SimpleFSMove(ctx, keybase1.SimpleFSMoveArg{OpID: opid, Src: src, Dest: dest}); err != nil {
+		return err
+	}
+	return cli.SimpleFSWait(ctx, opid)
+}
+
+// crossBoundaryMove moves src to dest when they're on opposite sides of
+// the local/KBFS boundary, by copying and then removing src -- but only
+// once the copy has been verified to match src exactly. A copy that fails
+// outright, or that succeeds but doesn't verify, leaves src untouched
+// either way, so a failed fs mv never loses data.
+func (c *CmdSimpleFSMove) crossBoundaryMove(ctx context.Context, cli keybase1.SimpleFSInterface, src, dest keybase1.Path) error {
+	printer := newSimpleFSProgressPrinter(c.quiet)
+
+	var copyErr error
+	if c.recursive {
+		copyErr = simpleFSCopyRecursiveOnce(ctx, cli, src, dest, 0, printer)
+	} else {
+		copyErr = simpleFSCopyOnce(ctx, cli, src, dest, 0, printer)
+	}
+	if copyErr != nil {
+		return fmt.Errorf("fs mv: copy to %s failed, leaving %s in place: %s", simpleFSPathString(dest), simpleFSPathString(src), copyErr)
+	}
+
+	if err := c.verifyCrossBoundaryCopy(ctx, cli, src, dest); err != nil {
+		return fmt.Errorf("fs mv: copy to %s could not be verified, leaving %s in place: %s", simpleFSPathString(dest), simpleFSPathString(src), err)
+	}
+
+	return simpleFSRemovePermanently(ctx, cli, src)
+}
+
+// verifyCrossBoundaryCopy compares dest's just-copied content against src,
+// for crossBoundaryMove. A single file is compared directly via
+// simpleFSVerifyCopy; a directory is walked (since dest may be a KBFS
+// path, an os.FileInfo-based walk like simpleFSPreserveTimes' can't be
+// used) and every file under it is compared against its counterpart under
+// src the same way.
+func (c *CmdSimpleFSMove) verifyCrossBoundaryCopy(ctx context.Context, cli keybase1.SimpleFSInterface, src, dest keybase1.Path) error {
+	if !c.recursive {
+		return simpleFSVerifyCopyMatches(ctx, cli, src, dest, c.hashAlgo)
+	}
+
+	lister := &simpleFSRPCLister{ctx: ctx, cli: cli}
+	return simpleFSWalk(ctx, lister, dest, simpleFSWalkOptions{}, func(format string, args ...interface{}) {
+		c.G().Log.Warning(format, args...)
+	}, func(dir keybase1.Path, entry keybase1.Dirent) error {
+		if entry.DirentType == keybase1.DirentType_DIR {
+			return nil
+		}
+		destChild := simpleFSChildPath(dir, entry.Name)
+		rel, err := simpleFSRelativeChildPath(dest, destChild)
+		if err != nil {
+			return err
+		}
+		return simpleFSVerifyCopyMatches(ctx, cli, simpleFSChildPath(src, rel), destChild, c.hashAlgo)
+	})
+}
+
+// simpleFSRelativeChildPath returns child's path relative to root, both of
+// which must be the same kind of path (local or KBFS): root's own path
+// string, trimmed off the front of child's. It's simpleFSChildPath's
+// inverse, for turning a path simpleFSWalk hands back during a recursive
+// verify into the relative name needed to find the corresponding file
+// under a different root (src, rather than the dest simpleFSWalk is
+// walking).
+func simpleFSRelativeChildPath(root, child keybase1.Path) (string, error) {
+	rootStr, childStr := simpleFSPathString(root), simpleFSPathString(child)
+	rel := strings.TrimPrefix(childStr, rootStr)
+	if rel == childStr {
+		return "", fmt.Errorf("fs mv: %q is not under %q", childStr, rootStr)
+	}
+	return strings.TrimPrefix(rel, "/"), nil
+}
+
+// simpleFSVerifyCopyMatches is simpleFSVerifyCopy, collapsed to a single
+// error: nil on a match, or an error describing the mismatch (including
+// the first differing byte offset, when known) otherwise.
+func simpleFSVerifyCopyMatches(ctx context.Context, cli keybase1.SimpleFSInterface, src, dest keybase1.Path, algo simpleFSChecksumAlgorithm) error {
+	match, diffOffset, diffOffsetKnown, err := simpleFSVerifyCopy(ctx, cli, src, dest, algo)
+	if err != nil {
+		return err
+	}
+	if match {
+		return nil
+	}
+	if diffOffsetKnown {
+		return fmt.Errorf("%s and %s differ, first mismatch at byte offset %d", simpleFSPathString(src), simpleFSPathString(dest), diffOffset)
+	}
+	return fmt.Errorf("%s and %s differ", simpleFSPathString(src), simpleFSPathString(dest))
+}
+
+func (c *CmdSimpleFSMove) GetUsage() libkb.Usage {
+	return libkb.Usage{
+		Config: true,
+		API:    true,
+	}
+}
@@ -0,0 +1,235 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// fakeSimpleFSMoveClient is a minimal keybase1.SimpleFSInterface for
+// exercising fs mv's native-move and copy-then-delete paths without a real
+// SimpleFS daemon. It keeps KBFS file content in kbfsContent, keyed by
+// path, and resolves local paths straight through to disk, so a copy
+// between the two kinds of path actually moves bytes the way
+// simpleFSVerifyCopy's re-hash expects.
+type fakeSimpleFSMoveClient struct {
+	keybase1.SimpleFSInterface // panics on anything not overridden below
+
+	kbfsContent map[string][]byte
+	opens       map[keybase1.OpID]string
+
+	copyArgs   []keybase1.SimpleFSCopyArg
+	moveArgs   []keybase1.SimpleFSMoveArg
+	removeArgs []keybase1.SimpleFSRemoveArg
+
+	copyErr     error
+	corruptCopy bool
+
+	nextOpid byte
+}
+
+func newFakeSimpleFSMoveClient() *fakeSimpleFSMoveClient {
+	return &fakeSimpleFSMoveClient{
+		kbfsContent: map[string][]byte{},
+		opens:       map[keybase1.OpID]string{},
+	}
+}
+
+func (f *fakeSimpleFSMoveClient) SimpleFSMakeOpid(ctx context.Context) (keybase1.OpID, error) {
+	f.nextOpid++
+	var opid keybase1.OpID
+	opid[0] = f.nextOpid
+	return opid, nil
+}
+
+func (f *fakeSimpleFSMoveClient) SimpleFSClose(ctx context.Context, opid keybase1.OpID) error {
+	delete(f.opens, opid)
+	return nil
+}
+
+func (f *fakeSimpleFSMoveClient) SimpleFSWait(ctx context.Context, opid keybase1.OpID) error {
+	return nil
+}
+
+func (f *fakeSimpleFSMoveClient) readPath(p keybase1.Path) ([]byte, error) {
+	if simpleFSIsKbfs(p) {
+		content, ok := f.kbfsContent[p.Kbfs()]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return content, nil
+	}
+	return ioutil.ReadFile(p.Local())
+}
+
+func (f *fakeSimpleFSMoveClient) writePath(p keybase1.Path, content []byte) error {
+	if simpleFSIsKbfs(p) {
+		f.kbfsContent[p.Kbfs()] = content
+		return nil
+	}
+	return ioutil.WriteFile(p.Local(), content, 0644)
+}
+
+func (f *fakeSimpleFSMoveClient) SimpleFSCopy(ctx context.Context, arg keybase1.SimpleFSCopyArg) error {
+	f.copyArgs = append(f.copyArgs, arg)
+	if f.copyErr != nil {
+		return f.copyErr
+	}
+	content, err := f.readPath(arg.Src)
+	if err != nil {
+		return err
+	}
+	if f.corruptCopy {
+		content = append([]byte("corrupted: "), content...)
+	}
+	return f.writePath(arg.Dest, content)
+}
+
+func (f *fakeSimpleFSMoveClient) SimpleFSMove(ctx context.Context, arg keybase1.SimpleFSMoveArg) error {
+	f.moveArgs = append(f.moveArgs, arg)
+	return nil
+}
+
+func (f *fakeSimpleFSMoveClient) SimpleFSRemove(ctx context.Context, arg keybase1.SimpleFSRemoveArg) error {
+	f.removeArgs = append(f.removeArgs, arg)
+	return nil
+}
+
+func (f *fakeSimpleFSMoveClient) SimpleFSOpen(ctx context.Context, arg keybase1.SimpleFSOpenArg) error {
+	f.opens[arg.OpID] = arg.Dest.Kbfs()
+	return nil
+}
+
+func (f *fakeSimpleFSMoveClient) SimpleFSRead(ctx context.Context, arg keybase1.SimpleFSReadArg) (keybase1.FileContent, error) {
+	content := f.kbfsContent[f.opens[arg.OpID]]
+	if arg.Offset >= int64(len(content)) {
+		return keybase1.FileContent{}, nil
+	}
+	end := arg.Offset + int64(arg.Size)
+	if end > int64(len(content)) {
+		end = int64(len(content))
+	}
+	return keybase1.FileContent{Data: content[arg.Offset:end]}, nil
+}
+
+func tempLocalFile(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "simplefs-mv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	path := filepath.Join(dir, "src.txt")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSimpleFSMoveSameTypeUsesNativeMove(t *testing.T) {
+	fake := newFakeSimpleFSMoveClient()
+	src := keybase1.NewPathWithKbfs("/keybase/private/alice/one.txt")
+	dest := keybase1.NewPathWithKbfs("/keybase/private/alice/two.txt")
+
+	if err := simpleFSMoveOnce(context.Background(), fake, src, dest); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fake.moveArgs) != 1 {
+		t.Fatalf("expected exactly one SimpleFSMove call, got %d", len(fake.moveArgs))
+	}
+	if fake.moveArgs[0].Src.Kbfs() != src.Kbfs() || fake.moveArgs[0].Dest.Kbfs() != dest.Kbfs() {
+		t.Errorf("unexpected move args: %+v", fake.moveArgs[0])
+	}
+	if len(fake.copyArgs) != 0 || len(fake.removeArgs) != 0 {
+		t.Errorf("expected no copy or remove calls for a same-type move, got copy=%d remove=%d", len(fake.copyArgs), len(fake.removeArgs))
+	}
+}
+
+func TestSimpleFSMoveCrossTypeCopiesThenDeletes(t *testing.T) {
+	fake := newFakeSimpleFSMoveClient()
+	srcPath := tempLocalFile(t, "hello world")
+	src := keybase1.NewPathWithLocal(srcPath)
+	dest := keybase1.NewPathWithKbfs("/keybase/private/alice/hello.txt")
+
+	c := &CmdSimpleFSMove{}
+	if err := c.crossBoundaryMove(context.Background(), fake, src, dest); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fake.copyArgs) != 1 {
+		t.Fatalf("expected exactly one SimpleFSCopy call, got %d", len(fake.copyArgs))
+	}
+	if len(fake.moveArgs) != 0 {
+		t.Errorf("expected no SimpleFSMove calls for a cross-type move, got %d", len(fake.moveArgs))
+	}
+	if len(fake.removeArgs) != 1 {
+		t.Fatalf("expected exactly one SimpleFSRemove call, got %d", len(fake.removeArgs))
+	}
+	if fake.removeArgs[0].Path.Local() != srcPath {
+		t.Errorf("expected remove to target the source %q, got %q", srcPath, fake.removeArgs[0].Path.Local())
+	}
+	if got := string(fake.kbfsContent[dest.Kbfs()]); got != "hello world" {
+		t.Errorf("expected dest content %q, got %q", "hello world", got)
+	}
+}
+
+func TestSimpleFSMoveFailedCopyLeavesSourceIntact(t *testing.T) {
+	fake := newFakeSimpleFSMoveClient()
+	fake.copyErr = errors.New("simulated copy failure")
+	srcPath := tempLocalFile(t, "hello world")
+	src := keybase1.NewPathWithLocal(srcPath)
+	dest := keybase1.NewPathWithKbfs("/keybase/private/alice/hello.txt")
+
+	c := &CmdSimpleFSMove{}
+	err := c.crossBoundaryMove(context.Background(), fake, src, dest)
+	if err == nil {
+		t.Fatal("expected an error from a failed copy")
+	}
+
+	if len(fake.removeArgs) != 0 {
+		t.Errorf("expected no SimpleFSRemove calls when the copy fails, got %d", len(fake.removeArgs))
+	}
+	if _, ok := fake.kbfsContent[dest.Kbfs()]; ok {
+		t.Error("expected no content to have reached the destination")
+	}
+	if content, err := ioutil.ReadFile(srcPath); err != nil || string(content) != "hello world" {
+		t.Errorf("expected source to be untouched on disk, got content=%q err=%v", content, err)
+	}
+}
+
+// TestSimpleFSMoveUnverifiedCopyLeavesSourceIntact covers a copy that
+// reports success but silently corrupts the content on the way -- the
+// case simpleFSVerifyCopy's post-copy re-hash exists to catch. crossBoundaryMove
+// must treat that the same as an outright copy failure: no delete.
+func TestSimpleFSMoveUnverifiedCopyLeavesSourceIntact(t *testing.T) {
+	fake := newFakeSimpleFSMoveClient()
+	fake.corruptCopy = true
+	srcPath := tempLocalFile(t, "hello world")
+	src := keybase1.NewPathWithLocal(srcPath)
+	dest := keybase1.NewPathWithKbfs("/keybase/private/alice/hello.txt")
+
+	c := &CmdSimpleFSMove{}
+	err := c.crossBoundaryMove(context.Background(), fake, src, dest)
+	if err == nil {
+		t.Fatal("expected an error from an unverified copy")
+	}
+
+	if len(fake.copyArgs) != 1 {
+		t.Errorf("expected the copy to have been attempted, got %d calls", len(fake.copyArgs))
+	}
+	if len(fake.removeArgs) != 0 {
+		t.Errorf("expected no SimpleFSRemove calls when the copy doesn't verify, got %d", len(fake.removeArgs))
+	}
+	if content, err := ioutil.ReadFile(srcPath); err != nil || string(content) != "hello world" {
+		t.Errorf("expected source to be untouched on disk, got content=%q err=%v", content, err)
+	}
+}
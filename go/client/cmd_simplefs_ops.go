@@ -0,0 +1,221 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/keybase/cli"
+	"github.com/keybase/client/go/libcmdline"
+	"github.com/keybase/client/go/libkb"
+)
+
+// simpleFSOpsHistoryLimit caps how many entries simpleFSAppendOpsLogEntry
+// keeps, so the history file doesn't grow without bound on a machine that
+// runs a lot of fs commands.
+const simpleFSOpsHistoryLimit = 200
+
+// simpleFSOpHistoryEntry is one completed operation recorded in the ops
+// history log.
+type simpleFSOpHistoryEntry struct {
+	Description string        `json:"description"`
+	StartTime   time.Time     `json:"start_time"`
+	Duration    time.Duration `json:"duration_ns"`
+	// Outcome is "ok", or the error's message if the operation failed.
+	Outcome string `json:"outcome"`
+}
+
+// simpleFSOpsLogPath is where fs commands that opt into history tracking
+// (currently `fs cp`, `fs mv`, and `fs write`; see simpleFSRecordOp) record their
+// outcome, and where `fs ops --history`/`--clear` read and purge from.
+// Like the undo log (simpleFSUndoLogPath), it's local state specific to
+// this machine: it isn't the service's in-progress op list (SimpleFSGetOps)
+// and it isn't synced through KBFS, so it only ever reflects commands run
+// from here.
+func simpleFSOpsLogPath(g *libkb.GlobalContext) string {
+	return filepath.Join(g.Env.GetDataDir(), "fs-ops-history.json")
+}
+
+// simpleFSReadOpsLog reads the ops history log at logPath, oldest entry
+// first. A log that doesn't exist yet reads as empty rather than an
+// error.
+func simpleFSReadOpsLog(logPath string) ([]simpleFSOpHistoryEntry, error) {
+	data, err := ioutil.ReadFile(logPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []simpleFSOpHistoryEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// simpleFSWriteOpsLog overwrites the ops history log at logPath with
+// entries.
+func simpleFSWriteOpsLog(logPath string, entries []simpleFSOpHistoryEntry) error {
+	if err := libkb.MakeParentDirs(logPath); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(logPath, data, libkb.PermFile)
+}
+
+// simpleFSAppendOpsLogEntry appends entry to the ops history log at
+// logPath, trimming the oldest entries first if that would leave more
+// than simpleFSOpsHistoryLimit.
+func simpleFSAppendOpsLogEntry(logPath string, entry simpleFSOpHistoryEntry) error {
+	entries, err := simpleFSReadOpsLog(logPath)
+	if err != nil {
+		return err
+	}
+	entries = append(entries, entry)
+	if len(entries) > simpleFSOpsHistoryLimit {
+		entries = entries[len(entries)-simpleFSOpsHistoryLimit:]
+	}
+	return simpleFSWriteOpsLog(logPath, entries)
+}
+
+// simpleFSClearOpsLog purges the ops history log at logPath.
+func simpleFSClearOpsLog(logPath string) error {
+	return simpleFSWriteOpsLog(logPath, nil)
+}
+
+// simpleFSRecordOp records description's outcome and duration (elapsed
+// since start) into the ops history log at logPath. runErr is the error,
+// if any, the operation finished with; it's recorded as the outcome but
+// not otherwise touched, so callers should use this via defer without
+// changing what they return:
+//
+//	func (c *CmdSimpleFSWhatever) Run() (err error) {
+//		start := time.Now()
+//		defer func() { simpleFSRecordOp(simpleFSOpsLogPath(c.G()), "...", start, err) }()
+//		...
+//	}
+//
+// Failing to write the history entry is logged but doesn't change err --
+// history tracking is a convenience, not something a real operation
+// should fail over.
+func simpleFSRecordOp(logPath string, description string, start time.Time, runErr error) {
+	outcome := "ok"
+	if runErr != nil {
+		outcome = runErr.Error()
+	}
+	entry := simpleFSOpHistoryEntry{
+		Description: description,
+		StartTime:   start,
+		Duration:    time.Since(start),
+		Outcome:     outcome,
+	}
+	if err := simpleFSAppendOpsLogEntry(logPath, entry); err != nil {
+		fmt.Fprintf(os.Stderr, "fs: failed to record operation history: %s\n", err)
+	}
+}
+
+// CmdSimpleFSOps is the 'fs ops' command. It shows and clears the local
+// history of completed fs operations recorded by simpleFSRecordOp.
+//
+// This is distinct from the service's live, in-progress operation list,
+// which `fs ps` exposes instead (the SimpleFSGetOps RPC) -- `fs ops` only
+// ever looks at completed operations recorded locally, in
+// simpleFSOpsLogPath. And its coverage is partial: currently only `fs cp`,
+// `fs mv`, and `fs write` record to it, not every fs subcommand.
+type CmdSimpleFSOps struct {
+	libkb.Contextified
+	history bool
+	clear   bool
+	json    bool
+}
+
+func newCmdSimpleFSOps(cl *libcmdline.CommandLine, g *libkb.GlobalContext) cli.Command {
+	return cli.Command{
+		Name:  "ops",
+		Usage: "Show or clear the local history of completed fs operations",
+		Action: func(c *cli.Context) {
+			cl.ChooseCommand(&CmdSimpleFSOps{Contextified: libkb.NewContextified(g)}, "ops", c)
+		},
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "history",
+				Usage: "Print recently completed operations, newest first",
+			},
+			cli.BoolFlag{
+				Name:  "clear",
+				Usage: "Purge the local operation history",
+			},
+			cli.BoolFlag{
+				Name:  "json",
+				Usage: "With --history, print the op list as JSON instead of formatted text",
+			},
+		},
+	}
+}
+
+func (c *CmdSimpleFSOps) ParseArgv(ctx *cli.Context) error {
+	c.history = ctx.Bool("history")
+	c.clear = ctx.Bool("clear")
+	c.json = ctx.Bool("json")
+	if !c.history && !c.clear {
+		return fmt.Errorf("fs ops requires --history or --clear")
+	}
+	return nil
+}
+
+func (c *CmdSimpleFSOps) Run() (err error) {
+	defer func() {
+		err = fsFinishError(c.G(), err)
+	}()
+
+	logPath := simpleFSOpsLogPath(c.G())
+
+	if c.clear {
+		if err := simpleFSClearOpsLog(logPath); err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stdout, "cleared fs operation history")
+	}
+
+	if !c.history {
+		return nil
+	}
+
+	entries, err := simpleFSReadOpsLog(logPath)
+	if err != nil {
+		return err
+	}
+
+	// Newest first, matching a process list's usual ordering.
+	ordered := make([]simpleFSOpHistoryEntry, len(entries))
+	for i, e := range entries {
+		ordered[len(entries)-1-i] = e
+	}
+
+	if c.json {
+		return printSimpleFSJSON(ordered)
+	}
+
+	for _, e := range ordered {
+		fmt.Fprintf(os.Stdout, "%s\t%s\t%s\t%s\n",
+			e.StartTime.Format(time.RFC3339), e.Duration, e.Outcome, e.Description)
+	}
+	return nil
+}
+
+func (c *CmdSimpleFSOps) GetUsage() libkb.Usage {
+	return libkb.Usage{
+		Config: true,
+		API:    true,
+	}
+}
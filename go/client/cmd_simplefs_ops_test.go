@@ -0,0 +1,148 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func tempOpsLogPath(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "simplefs-ops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	return filepath.Join(dir, "fs-ops-history.json")
+}
+
+func TestSimpleFSOpsLogRoundTrip(t *testing.T) {
+	logPath := tempOpsLogPath(t)
+
+	entries, err := simpleFSReadOpsLog(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected a missing log to read as empty, got %d entries", len(entries))
+	}
+
+	entry := simpleFSOpHistoryEntry{
+		Description: "cp a -> b",
+		StartTime:   time.Unix(42, 0),
+		Duration:    5 * time.Second,
+		Outcome:     "ok",
+	}
+	if err := simpleFSAppendOpsLogEntry(logPath, entry); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := simpleFSReadOpsLog(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Description != entry.Description || got[0].Outcome != entry.Outcome {
+		t.Fatalf("unexpected entries after append: %+v", got)
+	}
+
+	if err := simpleFSClearOpsLog(logPath); err != nil {
+		t.Fatal(err)
+	}
+	got, err = simpleFSReadOpsLog(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Fatalf("expected log to be empty after clearing, got %d entries", len(got))
+	}
+}
+
+func TestSimpleFSAppendOpsLogEntryTrimsToLimit(t *testing.T) {
+	logPath := tempOpsLogPath(t)
+
+	for i := 0; i < simpleFSOpsHistoryLimit+10; i++ {
+		entry := simpleFSOpHistoryEntry{Description: "op", StartTime: time.Unix(int64(i), 0)}
+		if err := simpleFSAppendOpsLogEntry(logPath, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := simpleFSReadOpsLog(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != simpleFSOpsHistoryLimit {
+		t.Fatalf("got %d entries, want %d", len(got), simpleFSOpsHistoryLimit)
+	}
+	// The oldest entries should have been trimmed off, so the first
+	// surviving entry is the 11th one appended (index 10).
+	if got[0].StartTime.Unix() != 10 {
+		t.Errorf("got oldest surviving entry at %d, want 10", got[0].StartTime.Unix())
+	}
+}
+
+func TestSimpleFSRecordOpRecordsOutcome(t *testing.T) {
+	logPath := tempOpsLogPath(t)
+
+	start := time.Now()
+	simpleFSRecordOp(logPath, "write a -> b", start, nil)
+	simpleFSRecordOp(logPath, "cp a -> c", start, errors.New("boom"))
+
+	entries, err := simpleFSReadOpsLog(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	if entries[0].Outcome != "ok" {
+		t.Errorf("got outcome %q, want ok", entries[0].Outcome)
+	}
+	if entries[1].Outcome != "boom" {
+		t.Errorf("got outcome %q, want boom", entries[1].Outcome)
+	}
+}
+
+// TestSimpleFSOpsJSONGolden compares the history op list's marshaled form
+// (newest first, the same ordering `fs ops --history` prints in) to a
+// fixed expected document, per the request that added --json: "golden
+// tests comparing the JSON output to a fixed expected document".
+func TestSimpleFSOpsJSONGolden(t *testing.T) {
+	entries := []simpleFSOpHistoryEntry{
+		{Description: "write a to /keybase/private/alice/a.txt", StartTime: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), Duration: time.Second, Outcome: "ok"},
+		{Description: "cp b to /keybase/private/alice/b.txt", StartTime: time.Date(2020, 1, 2, 3, 4, 6, 0, time.UTC), Duration: 2 * time.Second, Outcome: "boom"},
+	}
+	ordered := make([]simpleFSOpHistoryEntry, len(entries))
+	for i, e := range entries {
+		ordered[len(entries)-1-i] = e
+	}
+
+	out, err := json.MarshalIndent(ordered, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `[
+  {
+    "description": "cp b to /keybase/private/alice/b.txt",
+    "start_time": "2020-01-02T03:04:06Z",
+    "duration_ns": 2000000000,
+    "outcome": "boom"
+  },
+  {
+    "description": "write a to /keybase/private/alice/a.txt",
+    "start_time": "2020-01-02T03:04:05Z",
+    "duration_ns": 1000000000,
+    "outcome": "ok"
+  }
+]`
+	if string(out) != want {
+		t.Errorf("fs ops --history --json output mismatch:\ngot:\n%s\nwant:\n%s", out, want)
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	isatty "github.com/mattn/go-isatty"
+	"golang.org/x/net/context"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// simpleFSProgressInterval is how often simpleFSWaitWithProgress polls
+// SimpleFSCheck while an opid-based transfer is in flight. A var, not a
+// const, so tests can shrink it rather than waiting out a real 500ms tick.
+var simpleFSProgressInterval = 500 * time.Millisecond
+
+// simpleFSProgressUpdater is the interface simpleFSWaitWithProgress (and
+// the copy/write paths that call it) drive as a transfer proceeds.
+// simpleFSProgressPrinter is the real implementation; tests substitute a
+// fake to record the update sequence without a terminal.
+type simpleFSProgressUpdater interface {
+	Update(written, total int64)
+	Finish()
+}
+
+// simpleFSProgressPrinter renders a bytes-transferred/rate/ETA line to
+// stderr for a long-running transfer, the way curl's progress meter does.
+// It prints nothing when --quiet was given or stdout isn't a terminal, so
+// a progress line never lands in piped or redirected output.
+type simpleFSProgressPrinter struct {
+	enabled bool
+	start   time.Time
+	printed bool
+}
+
+// newSimpleFSProgressPrinter constructs a printer, gating on stdout being
+// a terminal the way simpleFSConfirmOverwrite gates its interactive
+// prompt.
+func newSimpleFSProgressPrinter(quiet bool) *simpleFSProgressPrinter {
+	return &simpleFSProgressPrinter{
+		enabled: !quiet && isatty.IsTerminal(os.Stdout.Fd()),
+	}
+}
+
+// Update renders the current progress to stderr, overwriting the previous
+// line. total of 0 means the total size isn't known (e.g. a recursive
+// directory copy, where SimpleFS never reports a byte count), in which
+// case the line omits the percentage and ETA it can't compute.
+func (p *simpleFSProgressPrinter) Update(written, total int64) {
+	if !p.enabled {
+		return
+	}
+	if p.start.IsZero() {
+		p.start = time.Now()
+	}
+	fmt.Fprintf(os.Stderr, "\r%s", simpleFSFormatProgress(written, total, time.Since(p.start)))
+	p.printed = true
+}
+
+// Finish ends the progress display by moving to a fresh line, so whatever
+// the command prints next (an error, a --summary line) doesn't land on
+// the same line as the last progress update.
+func (p *simpleFSProgressPrinter) Finish() {
+	if !p.enabled || !p.printed {
+		return
+	}
+	fmt.Fprintln(os.Stderr)
+}
+
+// simpleFSFormatProgress renders one progress line: bytes transferred (and
+// out of how many, if total is known), a transfer rate, and an ETA. It's a
+// pure function of its inputs so it can be tested without a real clock or
+// terminal.
+func simpleFSFormatProgress(written, total int64, elapsed time.Duration) string {
+	rate := float64(written) / elapsedSeconds(elapsed)
+
+	if total <= 0 {
+		return fmt.Sprintf("%s copied, %s/s", simpleFSFormatBytes(written), simpleFSFormatBytes(int64(rate)))
+	}
+
+	pct := int(written * 100 / total)
+	line := fmt.Sprintf("%3d%%  %s/%s  %s/s", pct, simpleFSFormatBytes(written), simpleFSFormatBytes(total), simpleFSFormatBytes(int64(rate)))
+	if rate > 0 && written < total {
+		eta := time.Duration(float64(total-written) / rate * float64(time.Second))
+		line += fmt.Sprintf("  ETA %s", eta.Round(time.Second))
+	}
+	return line
+}
+
+// elapsedSeconds returns d in seconds, floored at 1 so the very first
+// progress update (elapsed ~0) doesn't divide by zero or report a
+// meaninglessly huge rate.
+func elapsedSeconds(d time.Duration) float64 {
+	s := d.Seconds()
+	if s < 1 {
+		return 1
+	}
+	return s
+}
+
+// simpleFSFormatBytes renders n bytes using the same base-1024 units
+// coreutils' -h flags use.
+func simpleFSFormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// simpleFSWaitWithProgress waits for opid to finish the way a plain
+// SimpleFSWait call does, polling SimpleFSCheck every
+// simpleFSProgressInterval in the meantime to drive printer. totalBytes
+// scales SimpleFSCheck's 0-100 Progress into an approximate byte count;
+// pass 0 when it isn't known (e.g. a recursive directory copy, whose
+// final size isn't known up front), and the printer falls back to a
+// percentage-less display.
+func simpleFSWaitWithProgress(ctx context.Context, cli keybase1.SimpleFSInterface, opid keybase1.OpID, totalBytes int64, printer simpleFSProgressUpdater) error {
+	done := make(chan error, 1)
+	go func() { done <- cli.SimpleFSWait(ctx, opid) }()
+
+	ticker := time.NewTicker(simpleFSProgressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-done:
+			printer.Finish()
+			return err
+		case <-ticker.C:
+			progress, err := cli.SimpleFSCheck(ctx, opid)
+			if err != nil {
+				continue
+			}
+			printer.Update(totalBytes*int64(progress)/100, totalBytes)
+		}
+	}
+}
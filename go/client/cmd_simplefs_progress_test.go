@@ -0,0 +1,119 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+func TestSimpleFSFormatProgressPercentIsMonotonic(t *testing.T) {
+	const total = 1000
+	written := []int64{0, 100, 250, 250, 600, 1000}
+
+	prevPct := -1
+	for _, w := range written {
+		line := simpleFSFormatProgress(w, total, time.Second)
+		var pct int
+		if _, err := fmt.Sscanf(line, "%d%%", &pct); err != nil {
+			t.Fatalf("couldn't parse a percentage out of %q: %s", line, err)
+		}
+		if pct < prevPct {
+			t.Errorf("progress went backwards: %d%% after %d%%, for written=%d", pct, prevPct, w)
+		}
+		prevPct = pct
+	}
+	if prevPct != 100 {
+		t.Errorf("expected the final update to report 100%%, got %d%%", prevPct)
+	}
+}
+
+func TestSimpleFSFormatProgressUnknownTotalOmitsPercent(t *testing.T) {
+	line := simpleFSFormatProgress(512, 0, time.Second)
+	if strings.Contains(line, "%") {
+		t.Errorf("expected no percentage in an unknown-total line, got %q", line)
+	}
+}
+
+// fakeSimpleFSCheckClient is a keybase1.SimpleFSInterface whose SimpleFSCheck
+// returns an increasing sequence of Progress values on successive calls,
+// and whose SimpleFSWait blocks until the last of them has been observed --
+// enough to drive simpleFSWaitWithProgress through a realistic
+// poll-while-waiting sequence.
+type fakeSimpleFSCheckClient struct {
+	keybase1.SimpleFSInterface // panics on anything not overridden below
+
+	progress []keybase1.Progress
+	next     int
+	done     chan struct{}
+}
+
+func (f *fakeSimpleFSCheckClient) SimpleFSCheck(ctx context.Context, opid keybase1.OpID) (keybase1.Progress, error) {
+	p := f.progress[f.next]
+	if f.next < len(f.progress)-1 {
+		f.next++
+	}
+	if p >= 100 {
+		select {
+		case <-f.done:
+		default:
+			close(f.done)
+		}
+	}
+	return p, nil
+}
+
+func (f *fakeSimpleFSCheckClient) SimpleFSWait(ctx context.Context, opid keybase1.OpID) error {
+	<-f.done
+	return nil
+}
+
+// recordingProgressUpdater is a simpleFSProgressUpdater that records every
+// written value passed to Update, so a test can assert on the sequence
+// directly instead of scraping rendered terminal output.
+type recordingProgressUpdater struct {
+	updates []int64
+}
+
+func (u *recordingProgressUpdater) Update(written, total int64) {
+	u.updates = append(u.updates, written)
+}
+
+func (u *recordingProgressUpdater) Finish() {}
+
+func TestSimpleFSWaitWithProgressRendersMonotonicUpdates(t *testing.T) {
+	fake := &fakeSimpleFSCheckClient{
+		progress: []keybase1.Progress{10, 40, 75, 100},
+		done:     make(chan struct{}),
+	}
+
+	origInterval := simpleFSProgressInterval
+	simpleFSProgressInterval = time.Millisecond
+	defer func() { simpleFSProgressInterval = origInterval }()
+
+	updater := &recordingProgressUpdater{}
+	if err := simpleFSWaitWithProgress(context.Background(), fake, keybase1.OpID{}, 1000, updater); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(updater.updates) == 0 {
+		t.Fatal("expected at least one progress update")
+	}
+	prev := int64(-1)
+	for _, u := range updater.updates {
+		if u < prev {
+			t.Errorf("progress went backwards: %d after %d", u, prev)
+		}
+		prev = u
+	}
+	if last := updater.updates[len(updater.updates)-1]; last != 1000 {
+		t.Errorf("expected the final update to reach the full total, got %d", last)
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/cli"
+	"github.com/keybase/client/go/libcmdline"
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// CmdSimpleFSPs is the 'fs ps' command. It lists the service's in-progress
+// SimpleFS operations (SimpleFSGetOps), with --path to narrow the list down
+// to operations touching a given path prefix and --sort to order it, which
+// makes it practical to spot a stuck transfer among many.
+//
+// --older-than and --sort=start are rejected rather than silently ignored:
+// OpDescription, what SimpleFSGetOps actually returns, carries no start
+// time for an operation, so there's no honest way to filter or sort by age
+// without one. --sort=bytes sorts by bytes transferred so far instead,
+// using the Offset a read or write op reports; list/copy/move/remove don't
+// track partial progress this way and sort as zero.
+type CmdSimpleFSPs struct {
+	libkb.Contextified
+	path      string
+	sortBytes bool
+	jsonOut   bool
+}
+
+func newCmdSimpleFSPs(cl *libcmdline.CommandLine, g *libkb.GlobalContext) cli.Command {
+	return cli.Command{
+		Name:  "ps",
+		Usage: "List in-progress file system operations",
+		Action: func(c *cli.Context) {
+			cl.ChooseCommand(&CmdSimpleFSPs{Contextified: libkb.NewContextified(g)}, "ps", c)
+		},
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "path",
+				Usage: "Only show operations touching a path with this prefix",
+			},
+			cli.StringFlag{
+				Name:  "sort",
+				Usage: "Sort by this field; only \"bytes\" (bytes transferred so far) is supported",
+			},
+			cli.DurationFlag{
+				Name:  "older-than",
+				Usage: "Only show operations running longer than this (not currently supported; see --help)",
+			},
+			cli.BoolFlag{
+				Name:  "json",
+				Usage: "Print the operation list as JSON instead of formatted text",
+			},
+		},
+	}
+}
+
+func (c *CmdSimpleFSPs) ParseArgv(ctx *cli.Context) error {
+	if len(ctx.Args()) > 0 {
+		return errors.New("fs ps takes no arguments")
+	}
+	c.path = ctx.String("path")
+	c.jsonOut = ctx.Bool("json")
+
+	if ctx.Duration("older-than") > 0 {
+		return errors.New("fs ps --older-than is not supported: the service's in-progress operation list doesn't report when an operation started")
+	}
+
+	switch sortBy := ctx.String("sort"); sortBy {
+	case "":
+		c.sortBytes = false
+	case "bytes":
+		c.sortBytes = true
+	case "start":
+		return errors.New("fs ps --sort=start is not supported: the service's in-progress operation list doesn't report when an operation started")
+	default:
+		return fmt.Errorf("fs ps --sort must be %q, got %q", "bytes", sortBy)
+	}
+	return nil
+}
+
+func (c *CmdSimpleFSPs) Run() (err error) {
+	defer func() {
+		err = fsFinishError(c.G(), err)
+	}()
+
+	fsClient, err := GetSimpleFSClient(c.G())
+	if err != nil {
+		return err
+	}
+
+	ops, err := fsClient.SimpleFSGetOps(context.TODO())
+	if err != nil {
+		return err
+	}
+
+	ops = simpleFSFilterOpsByPath(ops, c.path)
+	if c.sortBytes {
+		simpleFSSortOpsByBytesTransferred(ops)
+	}
+
+	if c.jsonOut {
+		return printSimpleFSJSON(ops)
+	}
+
+	for _, op := range ops {
+		fmt.Fprintln(os.Stdout, simpleFSDescribeOp(op))
+	}
+	return nil
+}
+
+// simpleFSOpPath returns the path most relevant to op for --path filtering
+// and display: the single path for list/read/write/remove ops, and the
+// source path for copy/move, since that's what a caller watching for a
+// stuck transfer usually means by "touching this path".
+func simpleFSOpPath(op keybase1.OpDescription) (keybase1.Path, error) {
+	asyncOp, err := op.AsyncOp()
+	if err != nil {
+		return keybase1.Path{}, err
+	}
+	switch asyncOp {
+	case keybase1.AsyncOps_LIST:
+		return op.List().Path, nil
+	case keybase1.AsyncOps_LIST_RECURSIVE:
+		return op.ListRecursive().Path, nil
+	case keybase1.AsyncOps_READ:
+		return op.Read().Path, nil
+	case keybase1.AsyncOps_WRITE:
+		return op.Write().Path, nil
+	case keybase1.AsyncOps_COPY:
+		return op.Copy().Src, nil
+	case keybase1.AsyncOps_MOVE:
+		return op.Move().Src, nil
+	case keybase1.AsyncOps_REMOVE:
+		return op.Remove().Path, nil
+	default:
+		return keybase1.Path{}, fmt.Errorf("fs ps: unknown async op type %v", asyncOp)
+	}
+}
+
+// simpleFSOpBytesTransferred returns the bytes op has transferred so far,
+// for --sort=bytes: a read or write op's Offset, or 0 for list/copy/move/
+// remove, none of which report partial progress this way.
+func simpleFSOpBytesTransferred(op keybase1.OpDescription) int64 {
+	asyncOp, err := op.AsyncOp()
+	if err != nil {
+		return 0
+	}
+	switch asyncOp {
+	case keybase1.AsyncOps_READ:
+		return op.Read().Offset
+	case keybase1.AsyncOps_WRITE:
+		return op.Write().Offset
+	default:
+		return 0
+	}
+}
+
+// simpleFSFilterOpsByPath returns the ops in ops whose relevant path (see
+// simpleFSOpPath) has pathPrefix as a prefix. An op whose path can't be
+// determined is left out rather than failing the whole list.
+func simpleFSFilterOpsByPath(ops []keybase1.OpDescription, pathPrefix string) []keybase1.OpDescription {
+	if pathPrefix == "" {
+		return ops
+	}
+	var filtered []keybase1.OpDescription
+	for _, op := range ops {
+		p, err := simpleFSOpPath(op)
+		if err != nil {
+			continue
+		}
+		if strings.HasPrefix(simpleFSPathString(p), pathPrefix) {
+			filtered = append(filtered, op)
+		}
+	}
+	return filtered
+}
+
+// simpleFSSortOpsByBytesTransferred sorts ops by bytes transferred so far
+// (see simpleFSOpBytesTransferred), most first, so the op that's made the
+// most progress -- or, scanning from the bottom, the one that's made the
+// least -- is easy to spot. Ties keep their original relative order.
+func simpleFSSortOpsByBytesTransferred(ops []keybase1.OpDescription) {
+	sort.SliceStable(ops, func(i, j int) bool {
+		return simpleFSOpBytesTransferred(ops[i]) > simpleFSOpBytesTransferred(ops[j])
+	})
+}
+
+// simpleFSDescribeOp renders op as a single line of text: its op type, the
+// path it's most relevant to, and, for a copy or move, the destination it's
+// headed to.
+func simpleFSDescribeOp(op keybase1.OpDescription) string {
+	asyncOp, err := op.AsyncOp()
+	if err != nil {
+		return fmt.Sprintf("<%s>", err)
+	}
+
+	p, pathErr := simpleFSOpPath(op)
+	switch asyncOp {
+	case keybase1.AsyncOps_LIST:
+		return fmt.Sprintf("list\t%s", simpleFSDescribePath(p))
+	case keybase1.AsyncOps_LIST_RECURSIVE:
+		return fmt.Sprintf("list -r\t%s", simpleFSDescribePath(p))
+	case keybase1.AsyncOps_READ:
+		return fmt.Sprintf("read\t%s\toffset %d", simpleFSDescribePath(p), op.Read().Offset)
+	case keybase1.AsyncOps_WRITE:
+		return fmt.Sprintf("write\t%s\toffset %d", simpleFSDescribePath(p), op.Write().Offset)
+	case keybase1.AsyncOps_COPY:
+		return fmt.Sprintf("copy\t%s -> %s", simpleFSDescribePath(op.Copy().Src), simpleFSDescribePath(op.Copy().Dest))
+	case keybase1.AsyncOps_MOVE:
+		return fmt.Sprintf("move\t%s -> %s", simpleFSDescribePath(op.Move().Src), simpleFSDescribePath(op.Move().Dest))
+	case keybase1.AsyncOps_REMOVE:
+		return fmt.Sprintf("remove\t%s", simpleFSDescribePath(p))
+	default:
+		if pathErr != nil {
+			return fmt.Sprintf("<%s>", pathErr)
+		}
+		return fmt.Sprintf("%v\t%s", asyncOp, simpleFSDescribePath(p))
+	}
+}
+
+func (c *CmdSimpleFSPs) GetUsage() libkb.Usage {
+	return libkb.Usage{
+		Config: true,
+		API:    true,
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"testing"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+func opPath(p string) keybase1.Path {
+	return keybase1.NewPathWithKbfs(p)
+}
+
+func TestSimpleFSFilterOpsByPath(t *testing.T) {
+	ops := []keybase1.OpDescription{
+		keybase1.NewOpDescriptionWithRead(keybase1.ReadArgs{Path: opPath("/keybase/private/alice/a.txt")}),
+		keybase1.NewOpDescriptionWithWrite(keybase1.WriteArgs{Path: opPath("/keybase/private/bob/b.txt")}),
+		keybase1.NewOpDescriptionWithCopy(keybase1.CopyArgs{
+			Src:  opPath("/keybase/private/alice/docs/c.txt"),
+			Dest: opPath("/keybase/public/alice/c.txt"),
+		}),
+	}
+
+	filtered := simpleFSFilterOpsByPath(ops, "/keybase/private/alice")
+	if len(filtered) != 2 {
+		t.Fatalf("expected 2 ops under /keybase/private/alice, got %d", len(filtered))
+	}
+	if got, _ := simpleFSOpPath(filtered[0]); got != ops[0].Read().Path {
+		t.Errorf("expected the read op to survive filtering, got %v", got)
+	}
+	if got, _ := simpleFSOpPath(filtered[1]); got != ops[2].Copy().Src {
+		t.Errorf("expected the copy op to survive filtering (matched on its Src), got %v", got)
+	}
+}
+
+func TestSimpleFSFilterOpsByPathEmptyPrefixReturnsEverything(t *testing.T) {
+	ops := []keybase1.OpDescription{
+		keybase1.NewOpDescriptionWithRead(keybase1.ReadArgs{Path: opPath("/keybase/private/alice/a.txt")}),
+		keybase1.NewOpDescriptionWithRemove(keybase1.RemoveArgs{Path: opPath("/keybase/private/bob/b.txt")}),
+	}
+	if filtered := simpleFSFilterOpsByPath(ops, ""); len(filtered) != len(ops) {
+		t.Fatalf("expected an empty --path to return every op, got %d of %d", len(filtered), len(ops))
+	}
+}
+
+func TestSimpleFSSortOpsByBytesTransferred(t *testing.T) {
+	slow := keybase1.NewOpDescriptionWithRead(keybase1.ReadArgs{Path: opPath("/keybase/private/alice/slow.bin"), Offset: 10})
+	fast := keybase1.NewOpDescriptionWithWrite(keybase1.WriteArgs{Path: opPath("/keybase/private/alice/fast.bin"), Offset: 9000})
+	noProgress := keybase1.NewOpDescriptionWithRemove(keybase1.RemoveArgs{Path: opPath("/keybase/private/alice/gone.txt")})
+
+	ops := []keybase1.OpDescription{slow, noProgress, fast}
+	simpleFSSortOpsByBytesTransferred(ops)
+
+	wantOrder := []int64{9000, 10, 0}
+	for i, want := range wantOrder {
+		if got := simpleFSOpBytesTransferred(ops[i]); got != want {
+			t.Errorf("position %d: got %d bytes transferred, want %d (order: %+v)", i, got, want, ops)
+		}
+	}
+}
+
+func TestSimpleFSDescribeOp(t *testing.T) {
+	cp := keybase1.NewOpDescriptionWithCopy(keybase1.CopyArgs{
+		Src:  opPath("/keybase/private/alice/src.txt"),
+		Dest: opPath("/keybase/private/alice/dest.txt"),
+	})
+	got := simpleFSDescribeOp(cp)
+	want := "copy\tkbfs:/keybase/private/alice/src.txt -> kbfs:/keybase/private/alice/dest.txt"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
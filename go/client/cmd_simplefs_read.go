@@ -0,0 +1,170 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/cli"
+	"github.com/keybase/client/go/libcmdline"
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// simpleFSReadChunkSize is how much of a file CmdSimpleFSRead asks
+// SimpleFSRead for at a time.
+const simpleFSReadChunkSize = 128 * 1024
+
+// CmdSimpleFSRead is the 'fs read' command, which streams the contents of
+// one or more KBFS files to stdout, concatenating them in argument order
+// like unix cat. It streams straight to stdout in --chunk-size chunks
+// (simpleFSReadChunkSize by default) without reading a whole file into
+// memory first (with --parallel, chunks that arrive ahead of an earlier one
+// are held until it has been written), so piping a large file
+// into another command, or redirecting it to a local one, works the same
+// way it would with cat.
+type CmdSimpleFSRead struct {
+	libkb.Contextified
+	paths           []string
+	continueOnError bool
+	chunkSize       int64
+	parallel        int
+}
+
+func newCmdSimpleFSRead(cl *libcmdline.CommandLine, g *libkb.GlobalContext) cli.Command {
+	return cli.Command{
+		Name:         "read",
+		Usage:        "Read one or more KBFS files to stdout",
+		ArgumentHelp: "<path> [path...]",
+		Action: func(c *cli.Context) {
+			cl.ChooseCommand(&CmdSimpleFSRead{Contextified: libkb.NewContextified(g)}, "read", c)
+		},
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "continue-on-error",
+				Usage: "Keep reading the remaining paths if one fails, instead of aborting",
+			},
+			cli.StringFlag{
+				Name:  "chunk-size",
+				Usage: "Bytes requested per SimpleFSRead call (default 128KiB)",
+			},
+			cli.StringFlag{
+				Name:  "parallel",
+				Usage: "Issue this many ranged SimpleFSRead calls concurrently for a file, reassembling them in order (default 1, no parallelism)",
+			},
+		},
+	}
+}
+
+func (c *CmdSimpleFSRead) ParseArgv(ctx *cli.Context) error {
+	if len(ctx.Args()) == 0 {
+		return errors.New("fs read takes at least one argument: <path> [path...]")
+	}
+	c.paths = ctx.Args()
+	c.continueOnError = ctx.Bool("continue-on-error")
+
+	chunkSize, err := parseChunkSize(ctx.String("chunk-size"), simpleFSReadChunkSize)
+	if err != nil {
+		return err
+	}
+	c.chunkSize = chunkSize
+
+	parallel, err := parseParallelism(ctx.String("parallel"))
+	if err != nil {
+		return err
+	}
+	c.parallel = parallel
+	return nil
+}
+
+func (c *CmdSimpleFSRead) Run() (err error) {
+	defer func() {
+		err = fsFinishError(c.G(), err)
+	}()
+
+	cli, err := GetSimpleFSClient(c.G())
+	if err != nil {
+		return err
+	}
+	ctx := context.TODO()
+
+	for _, p := range c.paths {
+		if readErr := c.readOne(ctx, cli, p); readErr != nil {
+			if !c.continueOnError {
+				return readErr
+			}
+			fmt.Fprintf(os.Stderr, "fs read: %s: %s\n", p, readErr)
+			err = readErr
+		}
+	}
+
+	return err
+}
+
+// readOne streams the contents of a single KBFS path to stdout, in
+// c.chunkSize chunks. With c.parallel > 1 and a file big enough to split
+// into more than one chunk, the chunks are fetched concurrently and
+// reassembled in order instead of being read one at a time.
+func (c *CmdSimpleFSRead) readOne(ctx context.Context, cli keybase1.SimpleFSInterface, arg string) error {
+	path := simpleFSPathFromArg(arg)
+	if !simpleFSIsKbfs(path) {
+		return fmt.Errorf("fs read source must be a /keybase/... path: %q", arg)
+	}
+
+	dirent, err := cli.SimpleFSStat(ctx, path)
+	if err != nil {
+		return err
+	}
+	if dirent.DirentType == keybase1.DirentType_DIR {
+		return fmt.Errorf("%q is a directory", arg)
+	}
+
+	opid, err := cli.SimpleFSMakeOpid(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = cli.SimpleFSClose(ctx, opid) }()
+
+	if err := cli.SimpleFSOpen(ctx, keybase1.SimpleFSOpenArg{
+		OpID:  opid,
+		Dest:  path,
+		Flags: keybase1.OpenFlags_READ | keybase1.OpenFlags_EXISTING,
+	}); err != nil {
+		return err
+	}
+
+	if c.parallel > 1 && int64(dirent.Size) > c.chunkSize {
+		return simpleFSReadAllParallel(ctx, cli, opid, os.Stdout, int64(dirent.Size), c.chunkSize, c.parallel, nil)
+	}
+
+	var offset int64
+	for {
+		content, err := cli.SimpleFSRead(ctx, keybase1.SimpleFSReadArg{
+			OpID:   opid,
+			Offset: offset,
+			Size:   int(c.chunkSize),
+		})
+		if err != nil {
+			return err
+		}
+		if len(content.Data) == 0 {
+			return nil
+		}
+		if _, err := os.Stdout.Write(content.Data); err != nil {
+			return err
+		}
+		offset += int64(len(content.Data))
+	}
+}
+
+func (c *CmdSimpleFSRead) GetUsage() libkb.Usage {
+	return libkb.Usage{
+		Config: true,
+		API:    true,
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// fakeSimpleFSReadClient is a minimal keybase1.SimpleFSInterface backed by
+// an in-memory file table, just enough to drive CmdSimpleFSRead.readOne:
+// stat,
+// open, repeated read in chunks, and close.
+type fakeSimpleFSReadClient struct {
+	keybase1.SimpleFSInterface // panics on anything not overridden below
+
+	files map[string][]byte // kbfs path -> contents
+	dirs  map[string]bool   // kbfs path -> is a directory
+
+	statErrs map[string]error
+	openErrs map[string]error
+
+	nextOpID int
+	open     map[keybase1.OpID]string // opid -> kbfs path
+}
+
+func newFakeSimpleFSReadClient() *fakeSimpleFSReadClient {
+	return &fakeSimpleFSReadClient{
+		files:    map[string][]byte{},
+		dirs:     map[string]bool{},
+		statErrs: map[string]error{},
+		openErrs: map[string]error{},
+		open:     map[keybase1.OpID]string{},
+	}
+}
+
+func (f *fakeSimpleFSReadClient) SimpleFSStat(ctx context.Context, path keybase1.Path) (keybase1.Dirent, error) {
+	p := path.Kbfs()
+	if err := f.statErrs[p]; err != nil {
+		return keybase1.Dirent{}, err
+	}
+	if f.dirs[p] {
+		return keybase1.Dirent{Name: p, DirentType: keybase1.DirentType_DIR}, nil
+	}
+	if _, ok := f.files[p]; !ok {
+		return keybase1.Dirent{}, errors.New("no such file or directory")
+	}
+	return keybase1.Dirent{Name: p, DirentType: keybase1.DirentType_FILE}, nil
+}
+
+func (f *fakeSimpleFSReadClient) SimpleFSMakeOpid(ctx context.Context) (keybase1.OpID, error) {
+	f.nextOpID++
+	var opid keybase1.OpID
+	opid[0] = byte(f.nextOpID)
+	return opid, nil
+}
+
+func (f *fakeSimpleFSReadClient) SimpleFSClose(ctx context.Context, opid keybase1.OpID) error {
+	delete(f.open, opid)
+	return nil
+}
+
+func (f *fakeSimpleFSReadClient) SimpleFSOpen(ctx context.Context, arg keybase1.SimpleFSOpenArg) error {
+	p := arg.Dest.Kbfs()
+	if err := f.openErrs[p]; err != nil {
+		return err
+	}
+	f.open[arg.OpID] = p
+	return nil
+}
+
+func (f *fakeSimpleFSReadClient) SimpleFSRead(ctx context.Context, arg keybase1.SimpleFSReadArg) (keybase1.FileContent, error) {
+	p := f.open[arg.OpID]
+	data := f.files[p]
+	if arg.Offset >= int64(len(data)) {
+		return keybase1.FileContent{}, nil
+	}
+	end := arg.Offset + int64(arg.Size)
+	if end > int64(len(data)) {
+		end = int64(len(data))
+	}
+	return keybase1.FileContent{Data: data[arg.Offset:end]}, nil
+}
+
+func newTestSimpleFSRead() *CmdSimpleFSRead {
+	return &CmdSimpleFSRead{chunkSize: simpleFSReadChunkSize, parallel: 1}
+}
+
+func TestSimpleFSReadOneConcatenatesInOrder(t *testing.T) {
+	fake := newFakeSimpleFSReadClient()
+	fake.files["/keybase/private/alice/a.txt"] = []byte("aaa")
+	fake.files["/keybase/private/alice/b.txt"] = []byte("bbb")
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	origStdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = origStdout }()
+
+	ctx := context.Background()
+	if err := newTestSimpleFSRead().readOne(ctx, fake, "/keybase/private/alice/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := newTestSimpleFSRead().readOne(ctx, fake, "/keybase/private/alice/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(r); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "aaabbb"; got != want {
+		t.Fatalf("expected concatenated output %q, got %q", want, got)
+	}
+}
+
+func TestSimpleFSReadOneRejectsDirectories(t *testing.T) {
+	fake := newFakeSimpleFSReadClient()
+	fake.dirs["/keybase/private/alice"] = true
+
+	err := newTestSimpleFSRead().readOne(context.Background(), fake, "/keybase/private/alice")
+	if err == nil {
+		t.Fatal("expected an error reading a directory")
+	}
+}
+
+func TestSimpleFSReadOneRejectsLocalPaths(t *testing.T) {
+	fake := newFakeSimpleFSReadClient()
+	err := newTestSimpleFSRead().readOne(context.Background(), fake, "/tmp/not-kbfs")
+	if err == nil {
+		t.Fatal("expected an error reading a non-kbfs path")
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"container/heap"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/cli"
+	"github.com/keybase/client/go/libcmdline"
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// simpleFSRecentDefaultLimit is --limit's default for `fs recent`.
+const simpleFSRecentDefaultLimit = 20
+
+// CmdSimpleFSRecent is the 'fs recent' command. It recursively walks a TLF
+// with simpleFSWalk and prints the --limit most recently modified files,
+// newest first. Rather than collecting every entry under the TLF and
+// sorting the whole thing, it streams entries through a bounded min-heap
+// keyed by mtime, so memory use stays proportional to --limit instead of
+// to the size of the tree.
+type CmdSimpleFSRecent struct {
+	libkb.Contextified
+	path  string
+	limit int
+	since time.Duration
+}
+
+func newCmdSimpleFSRecent(cl *libcmdline.CommandLine, g *libkb.GlobalContext) cli.Command {
+	return cli.Command{
+		Name:         "recent",
+		Usage:        "List the most recently modified files under a TLF",
+		ArgumentHelp: "<path>",
+		Action: func(c *cli.Context) {
+			cl.ChooseCommand(&CmdSimpleFSRecent{Contextified: libkb.NewContextified(g)}, "recent", c)
+		},
+		Flags: []cli.Flag{
+			cli.IntFlag{
+				Name:  "limit",
+				Value: simpleFSRecentDefaultLimit,
+				Usage: "How many files to list",
+			},
+			cli.StringFlag{
+				Name:  "since",
+				Usage: "Only consider files modified within this long (e.g. 30d, 12h)",
+			},
+		},
+	}
+}
+
+func (c *CmdSimpleFSRecent) ParseArgv(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		return fmt.Errorf("fs recent takes one argument: <path>")
+	}
+	c.path = ctx.Args()[0]
+
+	c.limit = ctx.Int("limit")
+	if c.limit <= 0 {
+		c.limit = simpleFSRecentDefaultLimit
+	}
+
+	if s := ctx.String("since"); s != "" {
+		d, err := simpleFSParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("--since: %s", err)
+		}
+		c.since = d
+	}
+
+	return nil
+}
+
+func (c *CmdSimpleFSRecent) Run() (err error) {
+	defer func() {
+		err = fsFinishError(c.G(), err)
+	}()
+
+	cli, err := GetSimpleFSClient(c.G())
+	if err != nil {
+		return err
+	}
+
+	ctx := context.TODO()
+	lister := &simpleFSRPCLister{ctx: ctx, cli: cli}
+
+	var cutoff time.Time
+	if c.since > 0 {
+		cutoff = time.Now().Add(-c.since)
+	}
+
+	h := &simpleFSRecentHeap{}
+	root := simpleFSPathFromArg(c.path)
+	warn := func(format string, args ...interface{}) {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+	}
+	fn := func(dir keybase1.Path, entry keybase1.Dirent) error {
+		if entry.DirentType == keybase1.DirentType_DIR {
+			return nil
+		}
+		if !cutoff.IsZero() && entry.Time.Time().Before(cutoff) {
+			return nil
+		}
+
+		heap.Push(h, simpleFSRecentEntry{path: simpleFSChildPath(dir, entry.Name), entry: entry})
+		if h.Len() > c.limit {
+			heap.Pop(h)
+		}
+		return nil
+	}
+
+	if err := simpleFSWalk(ctx, lister, root, simpleFSWalkOptions{}, warn, fn); err != nil {
+		return err
+	}
+
+	entries := []simpleFSRecentEntry(*h)
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].entry.Time.Time().After(entries[j].entry.Time.Time())
+	})
+	for _, e := range entries {
+		fmt.Fprintf(os.Stdout, "%s\t%s\n", e.entry.Time.Time().Format(time.RFC3339), simpleFSPathString(e.path))
+	}
+
+	return nil
+}
+
+// simpleFSRecentEntry is one candidate tracked by CmdSimpleFSRecent's
+// top-N heap: the entry itself, plus its full path (entry.Name alone
+// doesn't say where in the tree it was found).
+type simpleFSRecentEntry struct {
+	path  keybase1.Path
+	entry keybase1.Dirent
+}
+
+// simpleFSRecentHeap is a container/heap.Interface min-heap ordered by
+// mtime, oldest first. CmdSimpleFSRecent keeps it trimmed to --limit
+// entries, so the root is always the oldest entry still in the running --
+// exactly the one to evict when a newer entry is found.
+type simpleFSRecentHeap []simpleFSRecentEntry
+
+func (h simpleFSRecentHeap) Len() int { return len(h) }
+func (h simpleFSRecentHeap) Less(i, j int) bool {
+	return h[i].entry.Time.Time().Before(h[j].entry.Time.Time())
+}
+func (h simpleFSRecentHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *simpleFSRecentHeap) Push(x interface{}) {
+	*h = append(*h, x.(simpleFSRecentEntry))
+}
+
+func (h *simpleFSRecentHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+// simpleFSRPCLister is the real simpleFSLister backing CmdSimpleFSRecent,
+// driving the SimpleFS RPCs the same way CmdSimpleFSList does: make an
+// opid, kick off SimpleFSList, and poll SimpleFSReadList until KBFS
+// reports the listing complete.
+//
+// There is no RPC for resolving a symlink to a canonical path, so
+// Canonicalize just returns the path unchanged. That's fine here: fs
+// recent runs with simpleFSWalkOptions{} (FollowSymlinks false), so
+// Canonicalize is only ever used for cycle detection among real
+// directories, which can't loop.
+type simpleFSRPCLister struct {
+	ctx context.Context
+	cli keybase1.SimpleFSInterface
+}
+
+func (l *simpleFSRPCLister) List(ctx context.Context, dir keybase1.Path) ([]keybase1.Dirent, error) {
+	opid, err := l.cli.SimpleFSMakeOpid(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = l.cli.SimpleFSClose(ctx, opid) }()
+
+	if err := l.cli.SimpleFSList(ctx, keybase1.SimpleFSListArg{OpID: opid, Path: dir}); err != nil {
+		return nil, err
+	}
+
+	for {
+		res, err := l.cli.SimpleFSReadList(ctx, opid)
+		if err != nil {
+			return nil, err
+		}
+		if res.Progress >= 100 {
+			return res.Entries, nil
+		}
+		time.Sleep(simpleFSListPollInterval)
+	}
+}
+
+func (l *simpleFSRPCLister) Canonicalize(ctx context.Context, p keybase1.Path) (string, error) {
+	return simpleFSPathString(p), nil
+}
+
+func (c *CmdSimpleFSRecent) GetUsage() libkb.Usage {
+	return libkb.Usage{
+		Config: true,
+		API:    true,
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"container/heap"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// simpleFSRecentWalk drives simpleFSWalk over lister the same way
+// CmdSimpleFSRecent.Run does, returning the top `limit` entries modified
+// within `since` of now (zero `since` means no cutoff), newest first. It
+// exists so the heap-trimming and --since logic in Run can be exercised
+// against a fake tree without going through the real SimpleFS RPCs.
+func simpleFSRecentWalk(root keybase1.Path, lister simpleFSLister, limit int, since time.Duration) ([]simpleFSRecentEntry, error) {
+	var cutoff time.Time
+	if since > 0 {
+		cutoff = time.Now().Add(-since)
+	}
+
+	h := &simpleFSRecentHeap{}
+	fn := func(dir keybase1.Path, entry keybase1.Dirent) error {
+		if entry.DirentType == keybase1.DirentType_DIR {
+			return nil
+		}
+		if !cutoff.IsZero() && entry.Time.Time().Before(cutoff) {
+			return nil
+		}
+		heap.Push(h, simpleFSRecentEntry{path: simpleFSChildPath(dir, entry.Name), entry: entry})
+		if h.Len() > limit {
+			heap.Pop(h)
+		}
+		return nil
+	}
+
+	err := simpleFSWalk(context.Background(), lister, root, simpleFSWalkOptions{}, func(string, ...interface{}) {}, fn)
+	return []simpleFSRecentEntry(*h), err
+}
+
+func mtime(secondsAgo int) keybase1.Time {
+	return keybase1.ToTime(time.Now().Add(-time.Duration(secondsAgo) * time.Second))
+}
+
+func TestSimpleFSRecentTopN(t *testing.T) {
+	lister := &fakeLister{
+		dirs: map[string][]keybase1.Dirent{
+			"/keybase/private/alice": {
+				{Name: "a.txt", DirentType: keybase1.DirentType_FILE, Time: mtime(500)},
+				{Name: "b.txt", DirentType: keybase1.DirentType_FILE, Time: mtime(10)},
+				{Name: "sub", DirentType: keybase1.DirentType_DIR},
+			},
+			"/keybase/private/alice/sub": {
+				{Name: "c.txt", DirentType: keybase1.DirentType_FILE, Time: mtime(200)},
+				{Name: "d.txt", DirentType: keybase1.DirentType_FILE, Time: mtime(50)},
+			},
+		},
+	}
+
+	root := keybase1.NewPathWithKbfs("/keybase/private/alice")
+	entries, err := simpleFSRecentWalk(root, lister, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries with limit 2, got %d", len(entries))
+	}
+
+	names := map[string]bool{}
+	for _, e := range entries {
+		names[e.entry.Name] = true
+	}
+	if !names["b.txt"] || !names["d.txt"] {
+		t.Fatalf("expected the two most recently modified files (b.txt, d.txt), got %v", names)
+	}
+}
+
+func TestSimpleFSRecentSince(t *testing.T) {
+	lister := &fakeLister{
+		dirs: map[string][]keybase1.Dirent{
+			"/keybase/private/alice": {
+				{Name: "old.txt", DirentType: keybase1.DirentType_FILE, Time: mtime(1000)},
+				{Name: "new.txt", DirentType: keybase1.DirentType_FILE, Time: mtime(5)},
+			},
+		},
+	}
+
+	root := keybase1.NewPathWithKbfs("/keybase/private/alice")
+	entries, err := simpleFSRecentWalk(root, lister, 20, 1*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].entry.Name != "new.txt" {
+		t.Fatalf("expected --since to filter out old.txt, got %v", entries)
+	}
+}
+
+func TestSimpleFSRecentHeapStaysBounded(t *testing.T) {
+	dirs := map[string][]keybase1.Dirent{}
+	var root []keybase1.Dirent
+	for i := 0; i < 1000; i++ {
+		root = append(root, keybase1.Dirent{
+			Name:       "file.txt",
+			DirentType: keybase1.DirentType_FILE,
+			Time:       mtime(1000 - i),
+		})
+	}
+	dirs["/keybase/private/alice"] = root
+	lister := &fakeLister{dirs: dirs}
+
+	h := &simpleFSRecentHeap{}
+	const limit = 10
+	fn := func(dir keybase1.Path, entry keybase1.Dirent) error {
+		heap.Push(h, simpleFSRecentEntry{path: simpleFSChildPath(dir, entry.Name), entry: entry})
+		if h.Len() > limit {
+			heap.Pop(h)
+		}
+		if h.Len() > limit {
+			t.Fatalf("heap grew beyond limit %d mid-walk", limit)
+		}
+		return nil
+	}
+
+	rootPath := keybase1.NewPathWithKbfs("/keybase/private/alice")
+	if err := simpleFSWalk(context.Background(), lister, rootPath, simpleFSWalkOptions{}, func(string, ...interface{}) {}, fn); err != nil {
+		t.Fatal(err)
+	}
+	if h.Len() != limit {
+		t.Fatalf("expected final heap size %d, got %d", limit, h.Len())
+	}
+}
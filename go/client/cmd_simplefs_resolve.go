@@ -0,0 +1,102 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/cli"
+	"github.com/keybase/client/go/libcmdline"
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// CmdSimpleFSResolve is the 'fs resolve' command. It prints the canonical
+// TLF name a /keybase/private or /keybase/public path resolves to, along
+// with any identity breaks, without touching KBFS at all -- it's built on
+// the same keybase1.TlfInterface RPCs that BoxMessage's key finder and the
+// chat conversation resolver use to canonicalize TLF names.
+type CmdSimpleFSResolve struct {
+	libkb.Contextified
+	tlfName string
+	private bool
+}
+
+func newCmdSimpleFSResolve(cl *libcmdline.CommandLine, g *libkb.GlobalContext) cli.Command {
+	return cli.Command{
+		Name:         "resolve",
+		Usage:        "Show what a TLF name canonicalizes to",
+		ArgumentHelp: "<path>",
+		Action: func(c *cli.Context) {
+			cl.ChooseCommand(&CmdSimpleFSResolve{Contextified: libkb.NewContextified(g)}, "resolve", c)
+		},
+	}
+}
+
+func (c *CmdSimpleFSResolve) ParseArgv(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		return errors.New("fs resolve takes one argument: <path>")
+	}
+
+	folder, err := ParseTLF(ctx.Args()[0])
+	if err != nil {
+		return err
+	}
+	c.tlfName = folder.Name
+	c.private = folder.Private
+	return nil
+}
+
+func (c *CmdSimpleFSResolve) Run() (err error) {
+	defer func() {
+		err = fsFinishError(c.G(), err)
+	}()
+
+	tlfClient, err := GetTlfClient(c.G())
+	if err != nil {
+		return err
+	}
+
+	cname, err := simpleFSResolveTLF(context.TODO(), tlfClient, c.tlfName, c.private)
+	if err != nil {
+		return err
+	}
+
+	visibility := "public"
+	if c.private {
+		visibility = "private"
+	}
+	fmt.Fprintf(os.Stdout, "%s/%s\n", visibility, cname.CanonicalName)
+
+	for _, tlfBreak := range cname.Breaks.Breaks {
+		fmt.Fprintf(os.Stdout, "warning: %s has broken identity proofs\n", tlfBreak.User.Username)
+	}
+
+	return nil
+}
+
+// simpleFSResolveTLF canonicalizes tlfName against the TLF client, the same
+// RPC the chat conversation resolver and BoxMessage's key finder use to turn
+// assertions in a TLF name into resolved usernames.
+func simpleFSResolveTLF(ctx context.Context, cli keybase1.TlfInterface, tlfName string, private bool) (keybase1.CanonicalTLFNameAndIDWithBreaks, error) {
+	query := keybase1.TLFQuery{
+		TlfName:          tlfName,
+		IdentifyBehavior: keybase1.TLFIdentifyBehavior_CHAT_CLI,
+	}
+	if private {
+		return cli.CompleteAndCanonicalizePrivateTlfName(ctx, query)
+	}
+	return cli.PublicCanonicalTLFNameAndID(ctx, query)
+}
+
+func (c *CmdSimpleFSResolve) GetUsage() libkb.Usage {
+	return libkb.Usage{
+		Config: true,
+		API:    true,
+	}
+}
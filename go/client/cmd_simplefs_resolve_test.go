@@ -0,0 +1,93 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// fakeTlfResolveClient is a minimal keybase1.TlfInterface that records which
+// RPC was called and returns a canned result.
+type fakeTlfResolveClient struct {
+	keybase1.TlfInterface // panics on anything not overridden below
+
+	calledPrivate bool
+	calledPublic  bool
+	query         keybase1.TLFQuery
+	result        keybase1.CanonicalTLFNameAndIDWithBreaks
+}
+
+func (f *fakeTlfResolveClient) CompleteAndCanonicalizePrivateTlfName(ctx context.Context, query keybase1.TLFQuery) (keybase1.CanonicalTLFNameAndIDWithBreaks, error) {
+	f.calledPrivate = true
+	f.query = query
+	return f.result, nil
+}
+
+func (f *fakeTlfResolveClient) PublicCanonicalTLFNameAndID(ctx context.Context, query keybase1.TLFQuery) (keybase1.CanonicalTLFNameAndIDWithBreaks, error) {
+	f.calledPublic = true
+	f.query = query
+	return f.result, nil
+}
+
+func TestSimpleFSResolveTLFPrivate(t *testing.T) {
+	fake := &fakeTlfResolveClient{
+		result: keybase1.CanonicalTLFNameAndIDWithBreaks{
+			CanonicalName: "alice,bob",
+		},
+	}
+
+	cname, err := simpleFSResolveTLF(context.Background(), fake, "alice@twitter,bob", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fake.calledPrivate || fake.calledPublic {
+		t.Error("expected CompleteAndCanonicalizePrivateTlfName to be called for a private TLF")
+	}
+	if fake.query.TlfName != "alice@twitter,bob" {
+		t.Errorf("expected query to carry the original TLF name, got %q", fake.query.TlfName)
+	}
+	if cname.CanonicalName != "alice,bob" {
+		t.Errorf("expected canonical name %q, got %q", "alice,bob", cname.CanonicalName)
+	}
+}
+
+func TestSimpleFSResolveTLFPublic(t *testing.T) {
+	fake := &fakeTlfResolveClient{
+		result: keybase1.CanonicalTLFNameAndIDWithBreaks{
+			CanonicalName: "alice,bob",
+		},
+	}
+
+	if _, err := simpleFSResolveTLF(context.Background(), fake, "alice,bob", false); err != nil {
+		t.Fatal(err)
+	}
+	if !fake.calledPublic || fake.calledPrivate {
+		t.Error("expected PublicCanonicalTLFNameAndID to be called for a public TLF")
+	}
+}
+
+func TestSimpleFSResolveTLFNameFromPath(t *testing.T) {
+	folder, err := ParseTLF("/keybase/private/alice@twitter,bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !folder.Private {
+		t.Error("expected path under /keybase/private to be resolved as private")
+	}
+	if folder.Name != "alice@twitter,bob" {
+		t.Errorf("expected tlfName %q, got %q", "alice@twitter,bob", folder.Name)
+	}
+
+	folder, err = ParseTLF("/keybase/public/alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if folder.Private {
+		t.Error("expected path under /keybase/public to be resolved as public")
+	}
+}
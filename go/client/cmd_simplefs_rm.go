@@ -0,0 +1,319 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/cli"
+	"github.com/keybase/client/go/libcmdline"
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// simpleFSTrashDirName is the per-TLF directory CmdSimpleFSRemove moves
+// soft-deleted files into instead of hard-deleting them (e.g.
+// /keybase/private/alice,bob/.trash). Trash lives inside the TLF, so it's
+// visible to, and shared by, everyone with access to it, same as
+// everything else in KBFS.
+//
+// There is no automatic expiry: an entry sits in .trash until a writer
+// deletes it with `fs rm --permanent`, restores it with `fs undo`, or
+// simply removes the .trash directory directly.
+const simpleFSTrashDirName = ".trash"
+
+// CmdSimpleFSRemove is the 'fs rm' command. By default it soft-deletes its
+// argument by moving it into its TLF's .trash directory (see
+// simpleFSTrashDirName), recording the move in a local undo log so `fs
+// undo` can put it back. --permanent skips the trash and hard-deletes via
+// SimpleFSRemove instead.
+type CmdSimpleFSRemove struct {
+	libkb.Contextified
+	path      string
+	permanent bool
+	summary   bool
+	quiet     bool
+	dryRun    bool
+}
+
+func newCmdSimpleFSRemove(cl *libcmdline.CommandLine, g *libkb.GlobalContext) cli.Command {
+	return cli.Command{
+		Name:         "rm",
+		Usage:        "Remove a file, moving it to its TLF's trash unless --permanent is given",
+		ArgumentHelp: "<path>",
+		Action: func(c *cli.Context) {
+			cl.ChooseCommand(&CmdSimpleFSRemove{Contextified: libkb.NewContextified(g)}, "rm", c)
+		},
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "permanent",
+				Usage: "Delete immediately instead of moving to trash; not undoable",
+			},
+			cli.BoolFlag{
+				Name:  "summary",
+				Usage: "Print a one-line summary (items removed, failures, elapsed time) to stderr when done",
+			},
+			cli.BoolFlag{
+				Name:  "quiet",
+				Usage: "Suppress the --summary report",
+			},
+			cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Resolve the target and print what would happen, without removing or moving anything",
+			},
+		},
+	}
+}
+
+func (c *CmdSimpleFSRemove) ParseArgv(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		return fmt.Errorf("fs rm takes one argument: <path>")
+	}
+	c.path = ctx.Args()[0]
+	c.permanent = ctx.Bool("permanent")
+	c.summary = ctx.Bool("summary")
+	c.quiet = ctx.Bool("quiet")
+	c.dryRun = ctx.Bool("dry-run")
+	return nil
+}
+
+func (c *CmdSimpleFSRemove) Run() (err error) {
+	start := time.Now()
+	defer func() {
+		if c.dryRun {
+			err = fsFinishError(c.G(), err)
+			return
+		}
+		if c.summary {
+			printSimpleFSOpSummary(simpleFSOpSummary{
+				Verb:  "removed",
+				Start: start,
+				Err:   err,
+			}, c.quiet)
+		}
+		err = fsFinishError(c.G(), err)
+	}()
+
+	fsClient, err := GetSimpleFSClient(c.G())
+	if err != nil {
+		return err
+	}
+
+	ctx := context.TODO()
+	target := simpleFSPathFromArg(c.path)
+
+	if c.dryRun {
+		return c.printDryRunPlan(target)
+	}
+
+	if c.permanent {
+		return simpleFSRemovePermanently(ctx, fsClient, target)
+	}
+
+	trashDest, err := simpleFSSoftDelete(ctx, fsClient, simpleFSUndoLogPath(c.G()), target, time.Now())
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stdout, "moved to %s (use `keybase fs undo` to restore)\n", simpleFSPathString(trashDest))
+	return nil
+}
+
+// printDryRunPlan prints what Run would do to target without calling
+// SimpleFSRemove or SimpleFSMove: simpleFSTrashPathFor is pure, so a
+// soft-delete's trash destination can be resolved and shown the same way a
+// permanent delete's target can, with neither one touching the daemon.
+func (c *CmdSimpleFSRemove) printDryRunPlan(target keybase1.Path) error {
+	if c.permanent {
+		fmt.Fprintf(os.Stdout, "would remove %s\n", simpleFSDescribePath(target))
+		return nil
+	}
+
+	_, trashDest, ok := simpleFSTrashPathFor(target, time.Now())
+	if !ok {
+		return fmt.Errorf("fs rm: trash is only supported for KBFS paths; pass --permanent to delete %q directly", simpleFSPathString(target))
+	}
+	fmt.Fprintf(os.Stdout, "would move %s to %s\n", simpleFSDescribePath(target), simpleFSDescribePath(trashDest))
+	return nil
+}
+
+// simpleFSSoftDelete moves target into its TLF's trash directory (see
+// simpleFSTrashPathFor) and records the move in the undo log at logPath, so
+// it can be undone later. It returns the path target was moved to.
+func simpleFSSoftDelete(ctx context.Context, cli keybase1.SimpleFSInterface, logPath string, target keybase1.Path, now time.Time) (keybase1.Path, error) {
+	trashDir, trashDest, ok := simpleFSTrashPathFor(target, now)
+	if !ok {
+		return keybase1.Path{}, fmt.Errorf("fs rm: trash is only supported for KBFS paths; pass --permanent to delete %q directly", simpleFSPathString(target))
+	}
+
+	if err := simpleFSMkdirAll(ctx, cli, trashDir.Kbfs()); err != nil {
+		return keybase1.Path{}, err
+	}
+
+	opid, err := cli.SimpleFSMakeOpid(ctx)
+	if err != nil {
+		return keybase1.Path{}, err
+	}
+	defer func() { _ = cli.SimpleFSClose(ctx, opid) }()
+
+	if err := cli.SimpleFSMove(ctx, keybase1.SimpleFSMoveArg{OpID: opid, Src: target, Dest: trashDest}); err != nil {
+		return keybase1.Path{}, err
+	}
+	if err := cli.SimpleFSWait(ctx, opid); err != nil {
+		return keybase1.Path{}, err
+	}
+
+	entry := simpleFSUndoEntry{Src: target, Dest: trashDest, Time: now}
+	if err := simpleFSAppendUndoEntry(logPath, entry); err != nil {
+		return keybase1.Path{}, err
+	}
+
+	return trashDest, nil
+}
+
+// simpleFSRemovePermanently hard-deletes target via SimpleFSRemove,
+// bypassing the trash entirely.
+func simpleFSRemovePermanently(ctx context.Context, cli keybase1.SimpleFSInterface, target keybase1.Path) error {
+	opid, err := cli.SimpleFSMakeOpid(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = cli.SimpleFSClose(ctx, opid) }()
+
+	if err := cli.SimpleFSRemove(ctx, keybase1.SimpleFSRemoveArg{OpID: opid, Path: target}); err != nil {
+		return err
+	}
+	return cli.SimpleFSWait(ctx, opid)
+}
+
+// simpleFSTrashPathFor returns target's TLF's trash directory, and the
+// path target should be moved to within it to soft-delete it. Trash is
+// only defined for KBFS paths scoped to a TLF -- local filesystem paths,
+// and KBFS paths above TLF level (e.g. /keybase/private itself), have no
+// TLF to root a shared trash directory in, so ok is false for them.
+func simpleFSTrashPathFor(target keybase1.Path, now time.Time) (trashDir, trashDest keybase1.Path, ok bool) {
+	if !simpleFSIsKbfs(target) {
+		return keybase1.Path{}, keybase1.Path{}, false
+	}
+
+	rel := strings.TrimPrefix(path.Clean(target.Kbfs()), kbfsPathPrefix)
+	parts := strings.Split(rel, "/")
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return keybase1.Path{}, keybase1.Path{}, false
+	}
+
+	tlfRoot := path.Join(kbfsPathPrefix, parts[0], parts[1])
+	trashDir = keybase1.NewPathWithKbfs(path.Join(tlfRoot, simpleFSTrashDirName))
+
+	// The timestamp prefix keeps repeated deletes of same-named files
+	// from colliding in .trash.
+	name := fmt.Sprintf("%d-%s", now.UnixNano(), path.Base(rel))
+	trashDest = keybase1.NewPathWithKbfs(path.Join(trashDir.Kbfs(), name))
+	return trashDir, trashDest, true
+}
+
+// simpleFSUndoEntry is one soft-delete recorded in the undo log: where the
+// file used to be, and where CmdSimpleFSRemove moved it to in .trash.
+type simpleFSUndoEntry struct {
+	Src  keybase1.Path `json:"src"`
+	Dest keybase1.Path `json:"dest"`
+	Time time.Time     `json:"time"`
+}
+
+// simpleFSUndoLogPath is where CmdSimpleFSRemove records soft-deletes for
+// CmdSimpleFSUndo to read back. Like the session file, it's local state
+// specific to this machine, not synced through KBFS -- `fs undo` only
+// covers deletes made from here.
+func simpleFSUndoLogPath(g *libkb.GlobalContext) string {
+	return filepath.Join(g.Env.GetDataDir(), "fs-trash-undo.json")
+}
+
+// simpleFSReadUndoLog reads the undo log at logPath, oldest entry first.
+// A log that doesn't exist yet reads as empty rather than an error.
+func simpleFSReadUndoLog(logPath string) ([]simpleFSUndoEntry, error) {
+	data, err := ioutil.ReadFile(logPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []simpleFSUndoEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// simpleFSWriteUndoLog overwrites the undo log at logPath with entries.
+func simpleFSWriteUndoLog(logPath string, entries []simpleFSUndoEntry) error {
+	if err := libkb.MakeParentDirs(logPath); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(logPath, data, libkb.PermFile)
+}
+
+// simpleFSAppendUndoEntry appends entry to the undo log at logPath.
+func simpleFSAppendUndoEntry(logPath string, entry simpleFSUndoEntry) error {
+	entries, err := simpleFSReadUndoLog(logPath)
+	if err != nil {
+		return err
+	}
+	entries = append(entries, entry)
+	return simpleFSWriteUndoLog(logPath, entries)
+}
+
+// simpleFSPeekUndoEntry returns the most recently recorded entry from the
+// undo log at logPath without removing it. ok is false if the log is
+// empty. Callers that might fail to act on the entry should peek first
+// and only pop it with simpleFSPopUndoEntry once they've succeeded, so a
+// failure leaves the entry in place for a retry.
+func simpleFSPeekUndoEntry(logPath string) (entry simpleFSUndoEntry, ok bool, err error) {
+	entries, err := simpleFSReadUndoLog(logPath)
+	if err != nil {
+		return simpleFSUndoEntry{}, false, err
+	}
+	if len(entries) == 0 {
+		return simpleFSUndoEntry{}, false, nil
+	}
+	return entries[len(entries)-1], true, nil
+}
+
+// simpleFSPopUndoEntry removes and returns the most recently recorded
+// entry from the undo log at logPath. ok is false if the log is empty.
+func simpleFSPopUndoEntry(logPath string) (entry simpleFSUndoEntry, ok bool, err error) {
+	entries, err := simpleFSReadUndoLog(logPath)
+	if err != nil {
+		return simpleFSUndoEntry{}, false, err
+	}
+	if len(entries) == 0 {
+		return simpleFSUndoEntry{}, false, nil
+	}
+	entry = entries[len(entries)-1]
+	entries = entries[:len(entries)-1]
+	if err := simpleFSWriteUndoLog(logPath, entries); err != nil {
+		return simpleFSUndoEntry{}, false, err
+	}
+	return entry, true, nil
+}
+
+func (c *CmdSimpleFSRemove) GetUsage() libkb.Usage {
+	return libkb.Usage{
+		Config: true,
+		API:    true,
+	}
+}
@@ -0,0 +1,358 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// fakeSimpleFSRmClient is a minimal keybase1.SimpleFSInterface that records
+// the opens, moves, and removes made against it, so rm/undo can be
+// exercised without a real SimpleFS daemon.
+type fakeSimpleFSRmClient struct {
+	keybase1.SimpleFSInterface // panics on anything not overridden below
+
+	existing  map[string]bool
+	moveArgs  []keybase1.SimpleFSMoveArg
+	removeArg keybase1.SimpleFSRemoveArg
+	removed   bool
+	moveErr   error // if set, every SimpleFSMove fails with this error
+}
+
+func newFakeSimpleFSRmClient() *fakeSimpleFSRmClient {
+	return &fakeSimpleFSRmClient{existing: map[string]bool{}}
+}
+
+func (f *fakeSimpleFSRmClient) SimpleFSMakeOpid(ctx context.Context) (keybase1.OpID, error) {
+	return keybase1.OpID{}, nil
+}
+
+func (f *fakeSimpleFSRmClient) SimpleFSClose(ctx context.Context, opid keybase1.OpID) error {
+	return nil
+}
+
+func (f *fakeSimpleFSRmClient) SimpleFSWait(ctx context.Context, opid keybase1.OpID) error {
+	return nil
+}
+
+func (f *fakeSimpleFSRmClient) SimpleFSOpen(ctx context.Context, arg keybase1.SimpleFSOpenArg) error {
+	f.existing[arg.Dest.Kbfs()] = true
+	return nil
+}
+
+func (f *fakeSimpleFSRmClient) SimpleFSMove(ctx context.Context, arg keybase1.SimpleFSMoveArg) error {
+	f.moveArgs = append(f.moveArgs, arg)
+	return f.moveErr
+}
+
+func (f *fakeSimpleFSRmClient) SimpleFSRemove(ctx context.Context, arg keybase1.SimpleFSRemoveArg) error {
+	f.removeArg = arg
+	f.removed = true
+	return nil
+}
+
+func tempUndoLogPath(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "simplefs-undo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	return filepath.Join(dir, "fs-trash-undo.json")
+}
+
+func TestSimpleFSSoftDeleteMovesToTrashAndRecordsUndoEntry(t *testing.T) {
+	fake := newFakeSimpleFSRmClient()
+	logPath := tempUndoLogPath(t)
+	target := keybase1.NewPathWithKbfs("/keybase/private/alice,bob/report.txt")
+
+	now := time.Unix(1600000000, 0)
+	trashDest, err := simpleFSSoftDelete(context.Background(), fake, logPath, target, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantDir := "/keybase/private/alice,bob/.trash"
+	if !fake.existing[wantDir] {
+		t.Errorf("expected trash directory %q to be created, created: %v", wantDir, fake.existing)
+	}
+
+	if len(fake.moveArgs) != 1 {
+		t.Fatalf("expected exactly one SimpleFSMove call, got %d", len(fake.moveArgs))
+	}
+	if fake.moveArgs[0].Src.Kbfs() != target.Kbfs() {
+		t.Errorf("expected move src %q, got %q", target.Kbfs(), fake.moveArgs[0].Src.Kbfs())
+	}
+	if fake.moveArgs[0].Dest.Kbfs() != trashDest.Kbfs() {
+		t.Errorf("expected move dest %q, got %q", trashDest.Kbfs(), fake.moveArgs[0].Dest.Kbfs())
+	}
+
+	entries, err := simpleFSReadUndoLog(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected one undo entry, got %d", len(entries))
+	}
+	if entries[0].Src.Kbfs() != target.Kbfs() || entries[0].Dest.Kbfs() != trashDest.Kbfs() {
+		t.Errorf("unexpected undo entry: %+v", entries[0])
+	}
+}
+
+func TestSimpleFSSoftDeleteRejectsNonKbfsPaths(t *testing.T) {
+	fake := newFakeSimpleFSRmClient()
+	logPath := tempUndoLogPath(t)
+	target := keybase1.NewPathWithLocal("/tmp/report.txt")
+
+	if _, err := simpleFSSoftDelete(context.Background(), fake, logPath, target, time.Now()); err == nil {
+		t.Fatal("expected an error for a local path, got nil")
+	}
+	if len(fake.moveArgs) != 0 {
+		t.Errorf("expected no SimpleFSMove calls, got %d", len(fake.moveArgs))
+	}
+}
+
+func TestSimpleFSUndoLastRestoresMostRecentEntry(t *testing.T) {
+	fake := newFakeSimpleFSRmClient()
+	logPath := tempUndoLogPath(t)
+
+	first := keybase1.NewPathWithKbfs("/keybase/private/alice/one.txt")
+	second := keybase1.NewPathWithKbfs("/keybase/private/alice/two.txt")
+
+	if _, err := simpleFSSoftDelete(context.Background(), fake, logPath, first, time.Unix(1, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := simpleFSSoftDelete(context.Background(), fake, logPath, second, time.Unix(2, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	src, ok, err := simpleFSUndoLast(context.Background(), fake, logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("expected ok=true")
+	}
+	if src.Kbfs() != second.Kbfs() {
+		t.Errorf("expected undo to restore %q (the most recent delete), got %q", second.Kbfs(), src.Kbfs())
+	}
+
+	if len(fake.moveArgs) != 3 {
+		t.Fatalf("expected 3 SimpleFSMove calls (2 deletes + 1 undo), got %d", len(fake.moveArgs))
+	}
+	last := fake.moveArgs[2]
+	if last.Src.Kbfs() == second.Kbfs() || last.Dest.Kbfs() != second.Kbfs() {
+		t.Errorf("expected undo to move from trash back to %q, got src=%q dest=%q", second.Kbfs(), last.Src.Kbfs(), last.Dest.Kbfs())
+	}
+
+	entries, err := simpleFSReadUndoLog(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected one remaining undo entry, got %d", len(entries))
+	}
+	if entries[0].Src.Kbfs() != first.Kbfs() {
+		t.Errorf("expected remaining entry to be for %q, got %q", first.Kbfs(), entries[0].Src.Kbfs())
+	}
+}
+
+func TestSimpleFSUndoLastWithEmptyLog(t *testing.T) {
+	fake := newFakeSimpleFSRmClient()
+	logPath := tempUndoLogPath(t)
+
+	_, ok, err := simpleFSUndoLast(context.Background(), fake, logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Error("expected ok=false for an empty undo log")
+	}
+	if len(fake.moveArgs) != 0 {
+		t.Errorf("expected no SimpleFSMove calls, got %d", len(fake.moveArgs))
+	}
+}
+
+func TestSimpleFSUndoLastLeavesEntryOnMoveFailure(t *testing.T) {
+	fake := newFakeSimpleFSRmClient()
+	logPath := tempUndoLogPath(t)
+	target := keybase1.NewPathWithKbfs("/keybase/private/alice/report.txt")
+
+	if _, err := simpleFSSoftDelete(context.Background(), fake, logPath, target, time.Unix(1, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	fake.moveErr = errors.New("simulated move failure")
+	if _, ok, err := simpleFSUndoLast(context.Background(), fake, logPath); err == nil {
+		t.Fatal("expected an error when the restoring move fails")
+	} else if ok {
+		t.Error("expected ok=false when the restoring move fails")
+	}
+
+	entries, err := simpleFSReadUndoLog(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected the undo entry to survive a failed move, got %d entries", len(entries))
+	}
+	if entries[0].Src.Kbfs() != target.Kbfs() {
+		t.Errorf("expected surviving entry to be for %q, got %q", target.Kbfs(), entries[0].Src.Kbfs())
+	}
+
+	fake.moveErr = nil
+	src, ok, err := simpleFSUndoLast(context.Background(), fake, logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || src.Kbfs() != target.Kbfs() {
+		t.Fatalf("expected a retried undo to succeed and restore %q, got ok=%v src=%q", target.Kbfs(), ok, src.Kbfs())
+	}
+	if entries, err := simpleFSReadUndoLog(logPath); err != nil {
+		t.Fatal(err)
+	} else if len(entries) != 0 {
+		t.Fatalf("expected the undo log to be empty after the retry succeeds, got %d entries", len(entries))
+	}
+}
+
+func TestSimpleFSRemovePermanentlyBypassesTrash(t *testing.T) {
+	fake := newFakeSimpleFSRmClient()
+	target := keybase1.NewPathWithKbfs("/keybase/private/alice/report.txt")
+
+	if err := simpleFSRemovePermanently(context.Background(), fake, target); err != nil {
+		t.Fatal(err)
+	}
+
+	if !fake.removed {
+		t.Fatal("expected SimpleFSRemove to be called")
+	}
+	if fake.removeArg.Path.Kbfs() != target.Kbfs() {
+		t.Errorf("expected SimpleFSRemove path %q, got %q", target.Kbfs(), fake.removeArg.Path.Kbfs())
+	}
+	if len(fake.moveArgs) != 0 {
+		t.Errorf("expected no SimpleFSMove calls for a permanent delete, got %d", len(fake.moveArgs))
+	}
+}
+
+func TestSimpleFSTrashPathFor(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+
+	t.Run("kbfs path", func(t *testing.T) {
+		target := keybase1.NewPathWithKbfs("/keybase/private/alice,bob/docs/report.txt")
+		trashDir, trashDest, ok := simpleFSTrashPathFor(target, now)
+		if !ok {
+			t.Fatal("expected ok=true")
+		}
+		if trashDir.Kbfs() != "/keybase/private/alice,bob/.trash" {
+			t.Errorf("unexpected trash dir: %q", trashDir.Kbfs())
+		}
+		wantDest := "/keybase/private/alice,bob/.trash/1600000000000000000-report.txt"
+		if trashDest.Kbfs() != wantDest {
+			t.Errorf("expected trash dest %q, got %q", wantDest, trashDest.Kbfs())
+		}
+	})
+
+	t.Run("local path", func(t *testing.T) {
+		if _, _, ok := simpleFSTrashPathFor(keybase1.NewPathWithLocal("/tmp/report.txt"), now); ok {
+			t.Error("expected ok=false for a local path")
+		}
+	})
+
+	t.Run("kbfs path above TLF level", func(t *testing.T) {
+		if _, _, ok := simpleFSTrashPathFor(keybase1.NewPathWithKbfs("/keybase/private"), now); ok {
+			t.Error("expected ok=false for a path above TLF level")
+		}
+	})
+}
+
+func TestSimpleFSRemoveDryRunPermanentPrintsPlan(t *testing.T) {
+	fake := newFakeSimpleFSRmClient()
+	c := &CmdSimpleFSRemove{dryRun: true, permanent: true}
+	target := keybase1.NewPathWithKbfs("/keybase/private/alice/report.txt")
+
+	var runErr error
+	out := captureStdout(t, func() {
+		runErr = c.printDryRunPlan(target)
+	})
+	if runErr != nil {
+		t.Fatal(runErr)
+	}
+	if fake.removed || len(fake.moveArgs) != 0 {
+		t.Errorf("expected no mutating RPCs, got removed=%v moveArgs=%v", fake.removed, fake.moveArgs)
+	}
+	want := "would remove kbfs:/keybase/private/alice/report.txt\n"
+	if out != want {
+		t.Errorf("got output %q, want %q", out, want)
+	}
+}
+
+func TestSimpleFSRemoveDryRunSoftDeletePrintsTrashDestination(t *testing.T) {
+	c := &CmdSimpleFSRemove{dryRun: true}
+	target := keybase1.NewPathWithKbfs("/keybase/private/alice,bob/docs/report.txt")
+
+	var runErr error
+	out := captureStdout(t, func() {
+		runErr = c.printDryRunPlan(target)
+	})
+	if runErr != nil {
+		t.Fatal(runErr)
+	}
+	if !strings.HasPrefix(out, "would move kbfs:/keybase/private/alice,bob/docs/report.txt to kbfs:/keybase/private/alice,bob/.trash/") {
+		t.Errorf("got output %q", out)
+	}
+}
+
+func TestSimpleFSRemoveDryRunSoftDeleteRejectsLocalPath(t *testing.T) {
+	c := &CmdSimpleFSRemove{dryRun: true}
+	if err := c.printDryRunPlan(keybase1.NewPathWithLocal("/tmp/report.txt")); err == nil {
+		t.Fatal("expected an error for a local path without --permanent")
+	}
+}
+
+func TestSimpleFSUndoLogRoundTrip(t *testing.T) {
+	logPath := tempUndoLogPath(t)
+
+	entries, err := simpleFSReadUndoLog(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected a missing log to read as empty, got %d entries", len(entries))
+	}
+
+	entry := simpleFSUndoEntry{
+		Src:  keybase1.NewPathWithKbfs("/keybase/private/alice/a.txt"),
+		Dest: keybase1.NewPathWithKbfs("/keybase/private/alice/.trash/a.txt"),
+		Time: time.Unix(42, 0),
+	}
+	if err := simpleFSAppendUndoEntry(logPath, entry); err != nil {
+		t.Fatal(err)
+	}
+
+	got, ok, err := simpleFSPopUndoEntry(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("expected ok=true")
+	}
+	if got.Src.Kbfs() != entry.Src.Kbfs() || got.Dest.Kbfs() != entry.Dest.Kbfs() {
+		t.Errorf("unexpected popped entry: %+v", got)
+	}
+
+	if _, ok, err := simpleFSPopUndoEntry(logPath); err != nil {
+		t.Fatal(err)
+	} else if ok {
+		t.Error("expected log to be empty after popping its only entry")
+	}
+}
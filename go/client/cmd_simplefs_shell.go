@@ -0,0 +1,201 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/cli"
+	"github.com/keybase/client/go/libcmdline"
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// CmdSimpleFSShell is the 'fs shell' command: an interactive REPL for
+// browsing KBFS, so exploring a tree doesn't mean re-typing a full
+// /keybase/... path on every invocation of `fs ls`/`fs cat`/etc.
+//
+// It keeps one piece of state, the current working directory, and
+// resolves relative paths typed at the prompt against it via
+// makeSimpleFSPath. Each built-in command is dispatched to the existing
+// CmdSimpleFS* struct's Run method with its fields set directly (the
+// shell has no cli.Context to drive ParseArgv), so behavior matches
+// running the equivalent `fs ...` command from a real shell.
+//
+// There's no tab completion at the prompt itself: `fs complete` exists now
+// for bash/zsh to call, but wiring readline-style completion into this
+// REPL's own input loop is a separate piece of work, out of scope here.
+type CmdSimpleFSShell struct {
+	libkb.Contextified
+	cwd string
+}
+
+func newCmdSimpleFSShell(cl *libcmdline.CommandLine, g *libkb.GlobalContext) cli.Command {
+	return cli.Command{
+		Name:  "shell",
+		Usage: "Start an interactive shell for browsing KBFS",
+		Action: func(c *cli.Context) {
+			cl.ChooseCommand(&CmdSimpleFSShell{Contextified: libkb.NewContextified(g)}, "shell", c)
+		},
+	}
+}
+
+func (c *CmdSimpleFSShell) ParseArgv(ctx *cli.Context) error {
+	if len(ctx.Args()) != 0 {
+		return fmt.Errorf("fs shell takes no arguments")
+	}
+	return nil
+}
+
+// makeSimpleFSPath resolves arg against cwd the way a unix shell resolves
+// a relative path against its cwd: an absolute KBFS path (one starting
+// with kbfsPathPrefix) is used as-is, and anything else is joined onto
+// cwd. Anything that isn't a KBFS path at all (e.g. a local filesystem
+// path given as a `cp` destination) is left alone, so it still reaches
+// simpleFSPathFromArg unchanged.
+func makeSimpleFSPath(cwd, arg string) string {
+	if strings.HasPrefix(arg, kbfsPathPrefix) {
+		return path.Clean(arg)
+	}
+	if strings.HasPrefix(arg, "/") || !strings.HasPrefix(cwd, kbfsPathPrefix) {
+		return arg
+	}
+	return path.Clean(path.Join(cwd, arg))
+}
+
+func (c *CmdSimpleFSShell) Run() (err error) {
+	defer func() {
+		err = fsFinishError(c.G(), err)
+	}()
+
+	c.cwd = kbfsPathPrefix
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Fprintf(os.Stdout, "%s $ ", c.cwd)
+		if !scanner.Scan() {
+			fmt.Fprintln(os.Stdout)
+			return scanner.Err()
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		cmd, args := fields[0], fields[1:]
+
+		if cmd == "exit" || cmd == "quit" {
+			return nil
+		}
+		if err := c.dispatch(cmd, args); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", cmd, err)
+		}
+	}
+}
+
+func (c *CmdSimpleFSShell) dispatch(cmd string, args []string) error {
+	switch cmd {
+	case "pwd":
+		fmt.Fprintln(os.Stdout, c.cwd)
+		return nil
+	case "cd":
+		return c.cd(args)
+	case "ls":
+		return c.ls(args)
+	case "cat":
+		return c.cat(args)
+	case "cp":
+		return c.cp(args)
+	case "rm":
+		return c.rm(args)
+	case "help":
+		fmt.Fprintln(os.Stdout, "commands: cd, pwd, ls, cat, cp, rm, help, exit")
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q (try \"help\")", cmd)
+	}
+}
+
+func (c *CmdSimpleFSShell) cd(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("cd takes one argument: <path>")
+	}
+	target := makeSimpleFSPath(c.cwd, args[0])
+
+	fsClient, err := GetSimpleFSClient(c.G())
+	if err != nil {
+		return err
+	}
+	dirent, err := fsClient.SimpleFSStat(context.TODO(), simpleFSPathFromArg(target))
+	if err != nil {
+		return err
+	}
+	if dirent.DirentType != keybase1.DirentType_DIR {
+		return fmt.Errorf("%s is not a directory", target)
+	}
+	c.cwd = target
+	return nil
+}
+
+func (c *CmdSimpleFSShell) ls(args []string) error {
+	target := c.cwd
+	if len(args) == 1 {
+		target = makeSimpleFSPath(c.cwd, args[0])
+	} else if len(args) > 1 {
+		return fmt.Errorf("ls takes at most one argument: [path]")
+	}
+	return (&CmdSimpleFSList{
+		Contextified: c.Contextified,
+		path:         target,
+		pageSize:     100,
+	}).Run()
+}
+
+func (c *CmdSimpleFSShell) cat(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("cat takes at least one argument: <path> [path...]")
+	}
+	paths := make([]string, len(args))
+	for i, a := range args {
+		paths[i] = makeSimpleFSPath(c.cwd, a)
+	}
+	return (&CmdSimpleFSRead{
+		Contextified: c.Contextified,
+		paths:        paths,
+		chunkSize:    simpleFSReadChunkSize,
+		parallel:     1,
+	}).Run()
+}
+
+func (c *CmdSimpleFSShell) cp(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("cp takes two arguments: <source> <destination>")
+	}
+	return (&CmdSimpleFSCopy{
+		Contextified: c.Contextified,
+		src:          makeSimpleFSPath(c.cwd, args[0]),
+		dest:         makeSimpleFSPath(c.cwd, args[1]),
+	}).Run()
+}
+
+func (c *CmdSimpleFSShell) rm(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("rm takes one argument: <path>")
+	}
+	return (&CmdSimpleFSRemove{
+		Contextified: c.Contextified,
+		path:         makeSimpleFSPath(c.cwd, args[0]),
+	}).Run()
+}
+
+func (c *CmdSimpleFSShell) GetUsage() libkb.Usage {
+	return libkb.Usage{
+		Config: true,
+		API:    true,
+	}
+}
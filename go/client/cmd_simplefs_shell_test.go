@@ -0,0 +1,61 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import "testing"
+
+func TestMakeSimpleFSPath(t *testing.T) {
+	cases := []struct {
+		name string
+		cwd  string
+		arg  string
+		want string
+	}{
+		{
+			name: "relative against kbfs cwd",
+			cwd:  "/keybase/private/alice",
+			arg:  "docs",
+			want: "/keybase/private/alice/docs",
+		},
+		{
+			name: "dot dot against kbfs cwd",
+			cwd:  "/keybase/private/alice/docs",
+			arg:  "..",
+			want: "/keybase/private/alice",
+		},
+		{
+			name: "absolute kbfs path ignores cwd",
+			cwd:  "/keybase/private/alice",
+			arg:  "/keybase/public/bob/report.txt",
+			want: "/keybase/public/bob/report.txt",
+		},
+		{
+			name: "absolute kbfs path is cleaned",
+			cwd:  "/keybase/private/alice",
+			arg:  "/keybase/public/bob/../bob/report.txt",
+			want: "/keybase/public/bob/report.txt",
+		},
+		{
+			name: "local absolute path is left alone",
+			cwd:  "/keybase/private/alice",
+			arg:  "/tmp/report.txt",
+			want: "/tmp/report.txt",
+		},
+		{
+			name: "relative path against local cwd is left alone",
+			cwd:  "/tmp",
+			arg:  "report.txt",
+			want: "report.txt",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := makeSimpleFSPath(c.cwd, c.arg)
+			if got != c.want {
+				t.Errorf("makeSimpleFSPath(%q, %q) = %q, want %q", c.cwd, c.arg, got, c.want)
+			}
+		})
+	}
+}
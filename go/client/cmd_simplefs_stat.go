@@ -0,0 +1,227 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/cli"
+	"github.com/keybase/client/go/libcmdline"
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// CmdSimpleFSStat is the 'fs stat' command, which prints metadata about a
+// single local or KBFS path, including a sniffed content type for regular
+// files. Of the attributes `fs chmod` can set, the "type" line reflects
+// the one KBFS tracks: "executable file" vs plain "file" -- for a local
+// path, that's derived from the owner/group/other execute bits instead.
+type CmdSimpleFSStat struct {
+	libkb.Contextified
+	path       string
+	noSniff    bool
+	showCrypto bool
+	json       bool
+}
+
+func newCmdSimpleFSStat(cl *libcmdline.CommandLine, g *libkb.GlobalContext) cli.Command {
+	return cli.Command{
+		Name:         "stat",
+		Usage:        "Show metadata for a KBFS path",
+		ArgumentHelp: "<path>",
+		Action: func(c *cli.Context) {
+			cl.ChooseCommand(&CmdSimpleFSStat{Contextified: libkb.NewContextified(g)}, "stat", c)
+		},
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "no-sniff",
+				Usage: "Skip sampling the file to detect its content type",
+			},
+			cli.BoolFlag{
+				Name:  "show-crypto",
+				Usage: "Show the path's encryption-at-rest status and TLF key generation",
+			},
+			cli.BoolFlag{
+				Name:  "json",
+				Usage: "Print the result as JSON instead of formatted text",
+			},
+		},
+	}
+}
+
+func (c *CmdSimpleFSStat) ParseArgv(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		return errors.New("fs stat takes one argument: <path>")
+	}
+	c.path = ctx.Args()[0]
+	c.noSniff = ctx.Bool("no-sniff")
+	c.showCrypto = ctx.Bool("show-crypto")
+	c.json = ctx.Bool("json")
+	return nil
+}
+
+func (c *CmdSimpleFSStat) Run() (err error) {
+	defer func() {
+		err = fsFinishError(c.G(), err)
+	}()
+
+	cli, err := GetSimpleFSClient(c.G())
+	if err != nil {
+		return err
+	}
+	ctx := context.TODO()
+
+	path := simpleFSPathFromArg(c.path)
+	dirent, err := simpleFSStatDirent(ctx, cli, path)
+	if err != nil {
+		return err
+	}
+
+	var contentType string
+	if !c.noSniff {
+		contentType, err = simpleFSDetectContentType(ctx, cli, path, dirent)
+		if err != nil {
+			return err
+		}
+	}
+
+	var crypto *simpleFSCryptoInfo
+	if c.showCrypto {
+		tlfClient, err := GetTlfClient(c.G())
+		if err != nil {
+			return err
+		}
+		info, err := simpleFSStatCrypto(ctx, tlfInterfaceCryptoResolver{cli: tlfClient}, simpleFSPathString(path))
+		if err != nil {
+			return err
+		}
+		crypto = &info
+	}
+
+	if c.json {
+		return printSimpleFSJSON(newSimpleFSJSONStat(dirent, contentType, crypto))
+	}
+
+	fmt.Fprintf(os.Stdout, "name:\t%s\n", dirent.Name)
+	fmt.Fprintf(os.Stdout, "type:\t%s\n", simpleFSDirentTypeString(dirent.DirentType))
+	fmt.Fprintf(os.Stdout, "size:\t%d\n", dirent.Size)
+	if contentType != "" {
+		fmt.Fprintf(os.Stdout, "content-type:\t%s\n", contentType)
+	}
+	if crypto != nil {
+		printSimpleFSCryptoInfo(*crypto)
+	}
+
+	return nil
+}
+
+// simpleFSCryptoInfo is what `fs stat --show-crypto` reports about a
+// path's encryption-at-rest: whether it's encrypted, and if so, the
+// highest TLF key generation this device currently holds for it.
+// SimpleFSStat's Dirent carries no crypto metadata of its own, so this is
+// derived the same way `fs info`'s rekey check is -- from the TLF's crypt
+// keys -- rather than from anything the stat RPC itself returns.
+type simpleFSCryptoInfo struct {
+	Encrypted     bool
+	KeyGeneration int    // meaningful only when Encrypted
+	Unavailable   string // non-empty: crypto status couldn't be determined, and why
+}
+
+// simpleFSCryptoResolver fetches a TLF's crypt keys, abstracting
+// keybase1.TlfInterface so simpleFSStatCrypto can be tested against a
+// fake instead of a real RPC client.
+type simpleFSCryptoResolver interface {
+	CryptKeys(ctx context.Context, tlfName string) ([]keybase1.CryptKey, error)
+}
+
+// tlfInterfaceCryptoResolver is the real simpleFSCryptoResolver backing
+// `fs stat --show-crypto`, built on the same keybase1.TlfInterface RPC
+// `fs info`'s rekey check already uses.
+type tlfInterfaceCryptoResolver struct {
+	cli keybase1.TlfInterface
+}
+
+func (r tlfInterfaceCryptoResolver) CryptKeys(ctx context.Context, tlfName string) ([]keybase1.CryptKey, error) {
+	res, err := r.cli.CryptKeys(ctx, keybase1.TLFQuery{
+		TlfName:          tlfName,
+		IdentifyBehavior: keybase1.TLFIdentifyBehavior_CHAT_CLI,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.CryptKeys, nil
+}
+
+// simpleFSStatCrypto determines the encryption-at-rest status fs stat
+// --show-crypto reports for kbfsPath. Public TLFs are never encrypted --
+// the same zero key chat's Boxer uses for public messages -- so those are
+// answered without an RPC call. Private and team TLFs get their real
+// crypt key generations from resolver. If kbfsPath isn't a KBFS path, or
+// the TLF's crypt keys can't be fetched, the result says so via
+// Unavailable instead of guessing.
+func simpleFSStatCrypto(ctx context.Context, resolver simpleFSCryptoResolver, kbfsPath string) (simpleFSCryptoInfo, error) {
+	tlfType, tlfName, ok := simpleFSPathTLFType(kbfsPath)
+	if !ok {
+		return simpleFSCryptoInfo{Unavailable: "not a KBFS path with a TLF component"}, nil
+	}
+	if tlfType == "public" {
+		return simpleFSCryptoInfo{Encrypted: false}, nil
+	}
+
+	keys, err := resolver.CryptKeys(ctx, tlfName)
+	if err != nil {
+		return simpleFSCryptoInfo{Unavailable: fmt.Sprintf("could not fetch crypt keys: %s", err)}, nil
+	}
+	if len(keys) == 0 {
+		return simpleFSCryptoInfo{Unavailable: "no crypt keys available for this TLF"}, nil
+	}
+
+	maxGen := keys[0].KeyGeneration
+	for _, k := range keys[1:] {
+		if k.KeyGeneration > maxGen {
+			maxGen = k.KeyGeneration
+		}
+	}
+	return simpleFSCryptoInfo{Encrypted: true, KeyGeneration: maxGen}, nil
+}
+
+// printSimpleFSCryptoInfo renders a simpleFSCryptoInfo the way `fs stat
+// --show-crypto` prints it.
+func printSimpleFSCryptoInfo(info simpleFSCryptoInfo) {
+	if info.Unavailable != "" {
+		fmt.Fprintf(os.Stdout, "crypto:\tnot available (%s)\n", info.Unavailable)
+		return
+	}
+	if !info.Encrypted {
+		fmt.Fprintln(os.Stdout, "encrypted:\tno")
+		return
+	}
+	fmt.Fprintln(os.Stdout, "encrypted:\tyes")
+	fmt.Fprintf(os.Stdout, "key-generation:\t%d\n", info.KeyGeneration)
+}
+
+// simpleFSDirentTypeString renders a DirentType the way `fs stat` prints it.
+func simpleFSDirentTypeString(t keybase1.DirentType) string {
+	switch t {
+	case keybase1.DirentType_DIR:
+		return "directory"
+	case keybase1.DirentType_SYM:
+		return "symlink"
+	case keybase1.DirentType_EXEC:
+		return "executable file"
+	default:
+		return "file"
+	}
+}
+
+func (c *CmdSimpleFSStat) GetUsage() libkb.Usage {
+	return libkb.Usage{
+		Config: true,
+		API:    true,
+	}
+}
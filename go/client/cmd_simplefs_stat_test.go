@@ -0,0 +1,279 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+func TestSimpleFSListFormatEntryMimeType(t *testing.T) {
+	fake := newFakeSimpleFSReadClient()
+	fake.files["/keybase/private/alice/pic.png"] = []byte("\x89PNG\r\n\x1a\n" + "restofpngdata")
+	fake.dirs["/keybase/private/alice/sub"] = true
+
+	dir := keybase1.NewPathWithKbfs("/keybase/private/alice")
+
+	c := &CmdSimpleFSList{mimeType: true}
+	fileEntry := keybase1.Dirent{Name: "pic.png", DirentType: keybase1.DirentType_FILE}
+	line, err := c.formatEntry(context.Background(), fake, dir, fileEntry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "pic.png\t0\timage/png"; line != want {
+		t.Fatalf("formatEntry() = %q, want %q", line, want)
+	}
+
+	dirEntry := keybase1.Dirent{Name: "sub", DirentType: keybase1.DirentType_DIR}
+	line, err = c.formatEntry(context.Background(), fake, dir, dirEntry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "sub/"; line != want {
+		t.Fatalf("formatEntry() = %q, want %q", line, want)
+	}
+}
+
+func TestSimpleFSListFormatEntryWithoutMimeType(t *testing.T) {
+	fake := newFakeSimpleFSReadClient()
+	dir := keybase1.NewPathWithKbfs("/keybase/private/alice")
+
+	c := &CmdSimpleFSList{mimeType: false}
+	entry := keybase1.Dirent{Name: "pic.png", DirentType: keybase1.DirentType_FILE}
+	line, err := c.formatEntry(context.Background(), fake, dir, entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "pic.png\t0"; line != want {
+		t.Fatalf("formatEntry() = %q, want %q", line, want)
+	}
+}
+
+// fakeCryptoResolver is an in-memory simpleFSCryptoResolver for testing
+// simpleFSStatCrypto without a real keybase1.TlfInterface.
+type fakeCryptoResolver struct {
+	keys map[string][]keybase1.CryptKey
+	err  error
+}
+
+func (f *fakeCryptoResolver) CryptKeys(ctx context.Context, tlfName string) ([]keybase1.CryptKey, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.keys[tlfName], nil
+}
+
+func TestSimpleFSStatCryptoPublicTlfIsNeverEncrypted(t *testing.T) {
+	resolver := &fakeCryptoResolver{}
+	info, err := simpleFSStatCrypto(context.Background(), resolver, "/keybase/public/alice/report.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Encrypted {
+		t.Error("expected a public TLF to report Encrypted=false")
+	}
+	if info.Unavailable != "" {
+		t.Errorf("expected no Unavailable reason, got %q", info.Unavailable)
+	}
+}
+
+func TestSimpleFSStatCryptoPrivateTlfReportsHighestKeyGeneration(t *testing.T) {
+	resolver := &fakeCryptoResolver{
+		keys: map[string][]keybase1.CryptKey{
+			"alice,bob": {
+				{KeyGeneration: 1},
+				{KeyGeneration: 3},
+				{KeyGeneration: 2},
+			},
+		},
+	}
+	info, err := simpleFSStatCrypto(context.Background(), resolver, "/keybase/private/alice,bob/docs/report.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Encrypted {
+		t.Fatal("expected Encrypted=true")
+	}
+	if info.KeyGeneration != 3 {
+		t.Errorf("got KeyGeneration %d, want 3", info.KeyGeneration)
+	}
+}
+
+func TestSimpleFSStatCryptoReportsUnavailableOnResolverError(t *testing.T) {
+	resolver := &fakeCryptoResolver{err: errors.New("no crypt keys for you")}
+	info, err := simpleFSStatCrypto(context.Background(), resolver, "/keybase/private/alice/report.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Unavailable == "" {
+		t.Error("expected a non-empty Unavailable reason")
+	}
+}
+
+func TestSimpleFSStatCryptoReportsUnavailableForNonKbfsPath(t *testing.T) {
+	resolver := &fakeCryptoResolver{}
+	info, err := simpleFSStatCrypto(context.Background(), resolver, "/tmp/report.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Unavailable == "" {
+		t.Error("expected a non-empty Unavailable reason for a local path")
+	}
+}
+
+// TestSimpleFSJSONStatGolden compares newSimpleFSJSONStat's marshaled
+// output to a fixed expected document, per the request that added --json:
+// "golden tests comparing the JSON output to a fixed expected document".
+func TestSimpleFSJSONStatGolden(t *testing.T) {
+	dirent := keybase1.Dirent{
+		Name:       "report.txt",
+		Size:       128,
+		DirentType: keybase1.DirentType_FILE,
+		Time:       keybase1.ToTime(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)),
+	}
+	crypto := &simpleFSCryptoInfo{Encrypted: true, KeyGeneration: 3}
+
+	stat := newSimpleFSJSONStat(dirent, "text/plain", crypto)
+	out, err := json.MarshalIndent(stat, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{
+  "name": "report.txt",
+  "type": "file",
+  "size": 128,
+  "time": "2020-01-02T03:04:05Z",
+  "content_type": "text/plain",
+  "crypto": {
+    "encrypted": true,
+    "key_generation": 3
+  }
+}`
+	if string(out) != want {
+		t.Errorf("fs stat --json output mismatch:\ngot:\n%s\nwant:\n%s", out, want)
+	}
+}
+
+// TestSimpleFSJSONStatGoldenOmitsUnsetFields covers the --no-sniff,
+// non---show-crypto case: content_type and crypto should be omitted
+// entirely, not printed as "" and null.
+func TestSimpleFSJSONStatGoldenOmitsUnsetFields(t *testing.T) {
+	dirent := keybase1.Dirent{Name: "sub", DirentType: keybase1.DirentType_DIR}
+
+	stat := newSimpleFSJSONStat(dirent, "", nil)
+	out, err := json.MarshalIndent(stat, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{
+  "name": "sub",
+  "type": "directory",
+  "size": 0,
+  "time": "0001-01-01T00:00:00Z"
+}`
+	if string(out) != want {
+		t.Errorf("fs stat --json output mismatch:\ngot:\n%s\nwant:\n%s", out, want)
+	}
+}
+
+func TestSimpleFSStatDirentLocalFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "simplefsstat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filePath := filepath.Join(dir, "report.txt")
+	if err := ioutil.WriteFile(filePath, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dirent, err := simpleFSStatDirent(context.Background(), nil, keybase1.NewPathWithLocal(filePath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dirent.Name != "report.txt" {
+		t.Errorf("got Name %q, want %q", dirent.Name, "report.txt")
+	}
+	if dirent.Size != 5 {
+		t.Errorf("got Size %d, want 5", dirent.Size)
+	}
+	if dirent.DirentType != keybase1.DirentType_FILE {
+		t.Errorf("got DirentType %v, want FILE", dirent.DirentType)
+	}
+}
+
+func TestSimpleFSStatDirentLocalDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "simplefsstat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	dirent, err := simpleFSStatDirent(context.Background(), nil, keybase1.NewPathWithLocal(sub))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dirent.Name != "sub" {
+		t.Errorf("got Name %q, want %q", dirent.Name, "sub")
+	}
+	if dirent.DirentType != keybase1.DirentType_DIR {
+		t.Errorf("got DirentType %v, want DIR", dirent.DirentType)
+	}
+}
+
+func TestSimpleFSStatDirentLocalMissingPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "simplefsstat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	missing := filepath.Join(dir, "does-not-exist")
+	_, err = simpleFSStatDirent(context.Background(), nil, keybase1.NewPathWithLocal(missing))
+	if err == nil {
+		t.Fatal("expected an error for a missing local path")
+	}
+	if !os.IsNotExist(err) {
+		t.Errorf("expected a not-exist error, got %v", err)
+	}
+}
+
+func TestSimpleFSPathTLFType(t *testing.T) {
+	cases := []struct {
+		path        string
+		wantType    string
+		wantTlfName string
+		wantOk      bool
+	}{
+		{"/keybase/private/alice,bob/docs/report.txt", "private", "alice,bob", true},
+		{"/keybase/public/alice", "public", "alice", true},
+		{"/keybase/team/acme/docs", "team", "acme", true},
+		{"/keybase/private", "", "", false},
+		{"/tmp/report.txt", "", "", false},
+	}
+	for _, c := range cases {
+		tlfType, tlfName, ok := simpleFSPathTLFType(c.path)
+		if tlfType != c.wantType || tlfName != c.wantTlfName || ok != c.wantOk {
+			t.Errorf("simpleFSPathTLFType(%q) = (%q, %q, %v), want (%q, %q, %v)",
+				c.path, tlfType, tlfName, ok, c.wantType, c.wantTlfName, c.wantOk)
+		}
+	}
+}
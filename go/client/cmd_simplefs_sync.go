@@ -0,0 +1,356 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/cli"
+	"github.com/keybase/client/go/libcmdline"
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// CmdSimpleFSSync is the 'fs sync' command. It makes <destination> match
+// <source>: every file under source that's missing or changed at the
+// destination is copied over, and with --delete, every destination file
+// that no longer has a counterpart in source is removed. Either side may
+// be local or KBFS, in any combination, the same as `fs cp`.
+type CmdSimpleFSSync struct {
+	libkb.Contextified
+	src       string
+	dest      string
+	delete    bool
+	checksum  bool
+	hashAlgo  simpleFSChecksumAlgorithm
+	hashCache map[string][]byte
+	dryRun    bool
+}
+
+func newCmdSimpleFSSync(cl *libcmdline.CommandLine, g *libkb.GlobalContext) cli.Command {
+	return cli.Command{
+		Name:         "sync",
+		Usage:        "Make a destination tree match a source tree",
+		ArgumentHelp: "<source> <destination>",
+		Action: func(c *cli.Context) {
+			cl.ChooseCommand(&CmdSimpleFSSync{Contextified: libkb.NewContextified(g)}, "sync", c)
+		},
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "delete",
+				Usage: "Remove destination files that no longer exist in source",
+			},
+			cli.BoolFlag{
+				Name:  "checksum",
+				Usage: "Decide whether a file changed by comparing content checksums instead of size and mtime (slower, but correct when mtimes aren't reliable)",
+			},
+			cli.StringFlag{
+				Name:  "checksum-algorithm",
+				Usage: "Hash algorithm to use with --checksum: sha256 (default), sha512, or md5 (not cryptographically secure; for matching external manifests only)",
+			},
+			cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Print the planned actions without copying or deleting anything",
+			},
+		},
+	}
+}
+
+func (c *CmdSimpleFSSync) ParseArgv(ctx *cli.Context) error {
+	if len(ctx.Args()) != 2 {
+		return errors.New("fs sync takes two arguments: <source> <destination>")
+	}
+	c.src = ctx.Args()[0]
+	c.dest = ctx.Args()[1]
+	c.delete = ctx.Bool("delete")
+	c.checksum = ctx.Bool("checksum")
+	c.dryRun = ctx.Bool("dry-run")
+
+	algo, err := parseChecksumAlgorithm(ctx.String("checksum-algorithm"), func(msg string) {
+		c.G().Log.Warning(msg)
+	})
+	if err != nil {
+		return err
+	}
+	c.hashAlgo = algo
+	return nil
+}
+
+func (c *CmdSimpleFSSync) Run() (err error) {
+	defer func() {
+		err = fsFinishError(c.G(), err)
+	}()
+
+	fsClient, err := GetSimpleFSClient(c.G())
+	if err != nil {
+		return err
+	}
+
+	ctx := context.TODO()
+	lister := &simpleFSChecksumLister{ctx: ctx, cli: fsClient}
+	warn := func(format string, args ...interface{}) {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+	}
+
+	actions, err := simpleFSSyncPlan(ctx, lister, lister,
+		simpleFSPathFromArg(c.src), simpleFSPathFromArg(c.dest),
+		c.checksum, c.contentsEqual(fsClient), c.delete, warn)
+	if err != nil {
+		return err
+	}
+
+	for _, action := range actions {
+		if err := c.apply(ctx, fsClient, action); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply executes action, or just prints it without doing anything for
+// --dry-run.
+func (c *CmdSimpleFSSync) apply(ctx context.Context, fsClient keybase1.SimpleFSInterface, action simpleFSSyncAction) error {
+	switch action.Kind {
+	case simpleFSSyncActionCopy:
+		verb := "copy"
+		if c.dryRun {
+			verb = "would copy"
+		}
+		fmt.Fprintf(os.Stdout, "%s %s -> %s\n", verb, simpleFSPathString(action.Src), simpleFSPathString(action.Dest))
+		if c.dryRun {
+			return nil
+		}
+		return c.copyOne(ctx, fsClient, action.Src, action.Dest)
+	case simpleFSSyncActionDelete:
+		verb := "delete"
+		if c.dryRun {
+			verb = "would delete"
+		}
+		fmt.Fprintf(os.Stdout, "%s %s\n", verb, simpleFSPathString(action.Dest))
+		if c.dryRun {
+			return nil
+		}
+		return c.deleteOne(ctx, fsClient, action.Dest)
+	default:
+		return fmt.Errorf("fs sync: unknown action kind %q", action.Kind)
+	}
+}
+
+// copyOne copies src to dest, creating dest's parent directories first --
+// a sync's whole point is to reproduce source's layout at destination, so
+// unlike `fs cp`, there's no --make-parents opt-out.
+func (c *CmdSimpleFSSync) copyOne(ctx context.Context, fsClient keybase1.SimpleFSInterface, src, dest keybase1.Path) error {
+	if err := simpleFSMkdirParent(ctx, fsClient, dest); err != nil {
+		return err
+	}
+	if !simpleFSIsKbfs(dest) {
+		if err := simpleFSLocalMkdirParent(dest.Local(), true); err != nil {
+			return err
+		}
+	}
+	return simpleFSCopyOnce(ctx, fsClient, src, dest, 0, newSimpleFSProgressPrinter(true))
+}
+
+// deleteOne removes dest, which only ever names a destination-side file
+// sync found no source counterpart for. Unlike `fs rm`, this bypasses the
+// trash: a sync's whole point is to reproduce source's contents exactly,
+// so --delete needs to behave like `rsync --delete`, not like a
+// user-initiated, undoable removal.
+func (c *CmdSimpleFSSync) deleteOne(ctx context.Context, fsClient keybase1.SimpleFSInterface, dest keybase1.Path) error {
+	if !simpleFSIsKbfs(dest) {
+		return os.Remove(dest.Local())
+	}
+	return simpleFSRemovePermanently(ctx, fsClient, dest)
+}
+
+// contentsEqual returns the --checksum comparison simpleFSSyncPlan calls
+// when a file exists on both sides: src and dest have identical content
+// if their digests, computed via fsClient, match. It caches each path's
+// digest for the run, the same as `fs cp --checksum` does, in case the
+// same path is ever hashed twice.
+func (c *CmdSimpleFSSync) contentsEqual(fsClient keybase1.SimpleFSInterface) func(ctx context.Context, src, dest keybase1.Path) (bool, error) {
+	return func(ctx context.Context, src, dest keybase1.Path) (bool, error) {
+		srcHash, err := c.hashOf(ctx, fsClient, src)
+		if err != nil {
+			return false, err
+		}
+		destHash, err := c.hashOf(ctx, fsClient, dest)
+		if err != nil {
+			return false, err
+		}
+		return bytes.Equal(srcHash, destHash), nil
+	}
+}
+
+func (c *CmdSimpleFSSync) hashOf(ctx context.Context, fsClient keybase1.SimpleFSInterface, p keybase1.Path) ([]byte, error) {
+	key := simpleFSPathCacheKey(p)
+	if h, ok := c.hashCache[key]; ok {
+		return h, nil
+	}
+
+	h, err := simpleFSStreamHash(ctx, fsClient, p, c.hashAlgo)
+	if err != nil {
+		return nil, err
+	}
+	if c.hashCache == nil {
+		c.hashCache = make(map[string][]byte)
+	}
+	c.hashCache[key] = h
+	return h, nil
+}
+
+// simpleFSSyncActionKind identifies what a simpleFSSyncAction does.
+type simpleFSSyncActionKind string
+
+const (
+	simpleFSSyncActionCopy   simpleFSSyncActionKind = "copy"
+	simpleFSSyncActionDelete simpleFSSyncActionKind = "delete"
+)
+
+// simpleFSSyncAction is one step of a sync plan: copy Src to Dest, or
+// delete Dest (Src is unset for a delete).
+type simpleFSSyncAction struct {
+	Kind    simpleFSSyncActionKind
+	RelPath string
+	Src     keybase1.Path
+	Dest    keybase1.Path
+}
+
+// simpleFSSyncTreeEntry is one file found while walking a sync source or
+// destination tree: its full path, and the Dirent simpleFSWalk reported
+// for it.
+type simpleFSSyncTreeEntry struct {
+	Path   keybase1.Path
+	Dirent keybase1.Dirent
+}
+
+// simpleFSSyncPlan walks srcRoot and destRoot (each via its own lister, so
+// one tree can be local while the other is KBFS) and returns the actions
+// needed to make destRoot match srcRoot: a copy for every source file
+// that's missing or changed at the destination, and, if delete is true, a
+// delete for every destination file with no source counterpart.
+//
+// Without --checksum, "changed" is decided by simpleFSSyncNeedsCopy
+// (size and mtime). With --checksum, contentsEqual is consulted instead --
+// it's injected rather than computed here so tests can plan a sync
+// without any real file content to hash.
+func simpleFSSyncPlan(ctx context.Context, srcLister, destLister simpleFSLister, srcRoot, destRoot keybase1.Path,
+	checksum bool, contentsEqual func(ctx context.Context, src, dest keybase1.Path) (bool, error),
+	delete bool, warn func(format string, args ...interface{})) ([]simpleFSSyncAction, error) {
+
+	srcTree, err := simpleFSSyncCollectTree(ctx, srcLister, srcRoot, warn)
+	if err != nil {
+		return nil, err
+	}
+	destTree, err := simpleFSSyncCollectTree(ctx, destLister, destRoot, warn)
+	if err != nil {
+		return nil, err
+	}
+
+	var actions []simpleFSSyncAction
+	for _, rel := range sortedKeys(srcTree) {
+		srcEntry := srcTree[rel]
+		destEntry, destExists := destTree[rel]
+
+		needsCopy := !destExists
+		if destExists {
+			if checksum {
+				same, err := contentsEqual(ctx, srcEntry.Path, destEntry.Path)
+				if err != nil {
+					return nil, err
+				}
+				needsCopy = !same
+			} else {
+				needsCopy = simpleFSSyncNeedsCopy(srcEntry.Dirent, destEntry.Dirent)
+			}
+		}
+
+		if needsCopy {
+			actions = append(actions, simpleFSSyncAction{
+				Kind:    simpleFSSyncActionCopy,
+				RelPath: rel,
+				Src:     srcEntry.Path,
+				Dest:    simpleFSChildPath(destRoot, rel),
+			})
+		}
+	}
+
+	if delete {
+		for _, rel := range sortedKeys(destTree) {
+			if _, ok := srcTree[rel]; ok {
+				continue
+			}
+			actions = append(actions, simpleFSSyncAction{
+				Kind:    simpleFSSyncActionDelete,
+				RelPath: rel,
+				Dest:    destTree[rel].Path,
+			})
+		}
+	}
+
+	return actions, nil
+}
+
+// simpleFSSyncNeedsCopy reports whether src's size or mtime differ from
+// dest's, the non-checksum test for whether a file changed.
+func simpleFSSyncNeedsCopy(src, dest keybase1.Dirent) bool {
+	return src.Size != dest.Size || !src.Time.Time().Equal(dest.Time.Time())
+}
+
+// simpleFSSyncCollectTree walks every file under root (directories
+// themselves aren't tracked; their existence is implied by their files'
+// paths, and CmdSimpleFSSync.copyOne creates them as needed) and
+// returns a map from each file's path relative to root to its
+// simpleFSSyncTreeEntry. Symlinks are skipped with a warning -- sync
+// doesn't have a defined behavior for them yet.
+func simpleFSSyncCollectTree(ctx context.Context, lister simpleFSLister, root keybase1.Path, warn func(format string, args ...interface{})) (map[string]simpleFSSyncTreeEntry, error) {
+	rootStr := simpleFSPathString(root)
+	tree := map[string]simpleFSSyncTreeEntry{}
+
+	fn := func(dir keybase1.Path, entry keybase1.Dirent) error {
+		if entry.DirentType == keybase1.DirentType_DIR {
+			return nil
+		}
+		child := simpleFSChildPath(dir, entry.Name)
+		if entry.DirentType == keybase1.DirentType_SYM {
+			warn("fs sync: skipping symlink %s", simpleFSPathString(child))
+			return nil
+		}
+
+		rel := strings.TrimPrefix(strings.TrimPrefix(simpleFSPathString(child), rootStr), "/")
+		tree[rel] = simpleFSSyncTreeEntry{Path: child, Dirent: entry}
+		return nil
+	}
+
+	if err := simpleFSWalk(ctx, lister, root, simpleFSWalkOptions{}, warn, fn); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+// sortedKeys returns tree's keys in sorted order, so simpleFSSyncPlan's
+// output (and thus `fs sync`'s printed actions) is deterministic instead
+// of following Go's randomized map iteration order.
+func sortedKeys(tree map[string]simpleFSSyncTreeEntry) []string {
+	keys := make([]string, 0, len(tree))
+	for k := range tree {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (c *CmdSimpleFSSync) GetUsage() libkb.Usage {
+	return libkb.Usage{
+		Config: true,
+		API:    true,
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// fakeSimpleFSSyncLister is an in-memory simpleFSLister over a fixed tree,
+// keyed by each directory's path string, for exercising simpleFSSyncPlan
+// without a real SimpleFS daemon or local filesystem.
+type fakeSimpleFSSyncLister struct {
+	entries map[string][]keybase1.Dirent
+}
+
+func (l *fakeSimpleFSSyncLister) List(ctx context.Context, dir keybase1.Path) ([]keybase1.Dirent, error) {
+	return l.entries[simpleFSPathString(dir)], nil
+}
+
+func (l *fakeSimpleFSSyncLister) Canonicalize(ctx context.Context, p keybase1.Path) (string, error) {
+	return simpleFSPathString(p), nil
+}
+
+func syncTestFile(name string, size int, t time.Time) keybase1.Dirent {
+	return keybase1.Dirent{Name: name, Size: size, DirentType: keybase1.DirentType_FILE, Time: keybase1.ToTime(t)}
+}
+
+var (
+	syncT1 = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	syncT2 = time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+)
+
+func noWarn(format string, args ...interface{}) {}
+
+func TestSimpleFSSyncPlanInitialFullSync(t *testing.T) {
+	src := &fakeSimpleFSSyncLister{entries: map[string][]keybase1.Dirent{
+		"/keybase/private/alice/src": {
+			syncTestFile("a.txt", 3, syncT1),
+			{Name: "sub", DirentType: keybase1.DirentType_DIR},
+		},
+		"/keybase/private/alice/src/sub": {
+			syncTestFile("b.txt", 4, syncT1),
+		},
+	}}
+	dest := &fakeSimpleFSSyncLister{entries: map[string][]keybase1.Dirent{
+		"/keybase/private/alice/dest": nil,
+	}}
+
+	actions, err := simpleFSSyncPlan(context.Background(), src, dest,
+		keybase1.NewPathWithKbfs("/keybase/private/alice/src"),
+		keybase1.NewPathWithKbfs("/keybase/private/alice/dest"),
+		false, nil, false, noWarn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(actions) != 2 {
+		t.Fatalf("got %d actions, want 2: %+v", len(actions), actions)
+	}
+	for _, a := range actions {
+		if a.Kind != simpleFSSyncActionCopy {
+			t.Errorf("action %+v: want a copy", a)
+		}
+	}
+	want := map[string]bool{"a.txt": true, "sub/b.txt": true}
+	for _, a := range actions {
+		if !want[a.RelPath] {
+			t.Errorf("unexpected RelPath %q", a.RelPath)
+		}
+	}
+}
+
+func TestSimpleFSSyncPlanIncrementalOnlyCopiesChangedFile(t *testing.T) {
+	src := &fakeSimpleFSSyncLister{entries: map[string][]keybase1.Dirent{
+		"/keybase/private/alice/src": {
+			syncTestFile("a.txt", 3, syncT1),
+			syncTestFile("b.txt", 5, syncT2), // changed: was size 4 at syncT1
+		},
+	}}
+	dest := &fakeSimpleFSSyncLister{entries: map[string][]keybase1.Dirent{
+		"/keybase/private/alice/dest": {
+			syncTestFile("a.txt", 3, syncT1), // unchanged
+			syncTestFile("b.txt", 4, syncT1), // stale
+		},
+	}}
+
+	actions, err := simpleFSSyncPlan(context.Background(), src, dest,
+		keybase1.NewPathWithKbfs("/keybase/private/alice/src"),
+		keybase1.NewPathWithKbfs("/keybase/private/alice/dest"),
+		false, nil, false, noWarn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(actions) != 1 {
+		t.Fatalf("got %d actions, want 1: %+v", len(actions), actions)
+	}
+	if actions[0].Kind != simpleFSSyncActionCopy || actions[0].RelPath != "b.txt" {
+		t.Errorf("got %+v, want a copy of b.txt", actions[0])
+	}
+}
+
+func TestSimpleFSSyncPlanNoChangesIsANoOp(t *testing.T) {
+	src := &fakeSimpleFSSyncLister{entries: map[string][]keybase1.Dirent{
+		"/keybase/private/alice/src": {syncTestFile("a.txt", 3, syncT1)},
+	}}
+	dest := &fakeSimpleFSSyncLister{entries: map[string][]keybase1.Dirent{
+		"/keybase/private/alice/dest": {syncTestFile("a.txt", 3, syncT1)},
+	}}
+
+	actions, err := simpleFSSyncPlan(context.Background(), src, dest,
+		keybase1.NewPathWithKbfs("/keybase/private/alice/src"),
+		keybase1.NewPathWithKbfs("/keybase/private/alice/dest"),
+		false, nil, false, noWarn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != 0 {
+		t.Fatalf("got %d actions, want 0: %+v", len(actions), actions)
+	}
+}
+
+func TestSimpleFSSyncPlanDelete(t *testing.T) {
+	src := &fakeSimpleFSSyncLister{entries: map[string][]keybase1.Dirent{
+		"/keybase/private/alice/src": {syncTestFile("a.txt", 3, syncT1)},
+	}}
+	dest := &fakeSimpleFSSyncLister{entries: map[string][]keybase1.Dirent{
+		"/keybase/private/alice/dest": {
+			syncTestFile("a.txt", 3, syncT1),
+			syncTestFile("extra.txt", 9, syncT1), // no longer in source
+		},
+	}}
+
+	// Without --delete, the extra destination file is left alone.
+	actions, err := simpleFSSyncPlan(context.Background(), src, dest,
+		keybase1.NewPathWithKbfs("/keybase/private/alice/src"),
+		keybase1.NewPathWithKbfs("/keybase/private/alice/dest"),
+		false, nil, false, noWarn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != 0 {
+		t.Fatalf("without --delete: got %d actions, want 0: %+v", len(actions), actions)
+	}
+
+	// With --delete, it's planned for removal.
+	actions, err = simpleFSSyncPlan(context.Background(), src, dest,
+		keybase1.NewPathWithKbfs("/keybase/private/alice/src"),
+		keybase1.NewPathWithKbfs("/keybase/private/alice/dest"),
+		false, nil, true, noWarn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != 1 {
+		t.Fatalf("with --delete: got %d actions, want 1: %+v", len(actions), actions)
+	}
+	if actions[0].Kind != simpleFSSyncActionDelete || actions[0].RelPath != "extra.txt" {
+		t.Errorf("got %+v, want a delete of extra.txt", actions[0])
+	}
+	if simpleFSPathString(actions[0].Dest) != "/keybase/private/alice/dest/extra.txt" {
+		t.Errorf("got delete target %q, want /keybase/private/alice/dest/extra.txt", simpleFSPathString(actions[0].Dest))
+	}
+}
+
+func TestSimpleFSSyncNeedsCopy(t *testing.T) {
+	base := syncTestFile("a.txt", 3, syncT1)
+
+	if simpleFSSyncNeedsCopy(base, base) {
+		t.Error("identical dirents should not need a copy")
+	}
+
+	sizeChanged := syncTestFile("a.txt", 4, syncT1)
+	if !simpleFSSyncNeedsCopy(sizeChanged, base) {
+		t.Error("a size change should need a copy")
+	}
+
+	timeChanged := syncTestFile("a.txt", 3, syncT2)
+	if !simpleFSSyncNeedsCopy(timeChanged, base) {
+		t.Error("an mtime change should need a copy")
+	}
+}
+
+func TestSimpleFSSyncPlanChecksumModeComparesContent(t *testing.T) {
+	// Same size and mtime on both sides, but --checksum says the content
+	// differs, so it should still be copied.
+	src := &fakeSimpleFSSyncLister{entries: map[string][]keybase1.Dirent{
+		"/keybase/private/alice/src": {syncTestFile("a.txt", 3, syncT1)},
+	}}
+	dest := &fakeSimpleFSSyncLister{entries: map[string][]keybase1.Dirent{
+		"/keybase/private/alice/dest": {syncTestFile("a.txt", 3, syncT1)},
+	}}
+
+	contentsEqual := func(ctx context.Context, srcPath, destPath keybase1.Path) (bool, error) {
+		return false, nil
+	}
+
+	actions, err := simpleFSSyncPlan(context.Background(), src, dest,
+		keybase1.NewPathWithKbfs("/keybase/private/alice/src"),
+		keybase1.NewPathWithKbfs("/keybase/private/alice/dest"),
+		true, contentsEqual, false, noWarn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != 1 || actions[0].Kind != simpleFSSyncActionCopy {
+		t.Fatalf("got %+v, want one copy despite matching size/mtime", actions)
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// captureSimpleFSOpSummary runs f with os.Stderr redirected to a pipe and
+// returns everything f wrote to it.
+func captureSimpleFSOpSummary(t *testing.T, f func()) string {
+	orig := os.Stderr
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stderr = w
+
+	f()
+
+	w.Close()
+	os.Stderr = orig
+
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestPrintSimpleFSOpSummarySuccess(t *testing.T) {
+	start := time.Now().Add(-time.Second)
+	out := captureSimpleFSOpSummary(t, func() {
+		printSimpleFSOpSummary(simpleFSOpSummary{Verb: "copied", Start: start}, false)
+	})
+
+	if !strings.HasPrefix(out, "1 copied, unknown bytes, 0 failures, ") {
+		t.Fatalf("unexpected summary line: %q", out)
+	}
+}
+
+func TestPrintSimpleFSOpSummaryFailure(t *testing.T) {
+	out := captureSimpleFSOpSummary(t, func() {
+		printSimpleFSOpSummary(simpleFSOpSummary{
+			Verb:  "removed",
+			Start: time.Now(),
+			Err:   errors.New("boom"),
+		}, false)
+	})
+
+	if !strings.HasPrefix(out, "0 removed, unknown bytes, 1 failures, ") {
+		t.Fatalf("unexpected summary line: %q", out)
+	}
+	if !strings.Contains(out, "boom") {
+		t.Fatalf("expected error message in summary output, got %q", out)
+	}
+}
+
+func TestPrintSimpleFSOpSummaryBytesKnown(t *testing.T) {
+	out := captureSimpleFSOpSummary(t, func() {
+		printSimpleFSOpSummary(simpleFSOpSummary{
+			Verb:             "copied",
+			Start:            time.Now(),
+			BytesTransferred: 1234,
+			BytesKnown:       true,
+		}, false)
+	})
+
+	if !strings.HasPrefix(out, "1 copied, 1234 bytes, 0 failures, ") {
+		t.Fatalf("unexpected summary line: %q", out)
+	}
+}
+
+func TestPrintSimpleFSOpSummaryQuietSuppressesOutput(t *testing.T) {
+	out := captureSimpleFSOpSummary(t, func() {
+		printSimpleFSOpSummary(simpleFSOpSummary{Verb: "copied", Start: time.Now()}, true)
+	})
+
+	if out != "" {
+		t.Fatalf("expected no output when quiet, got %q", out)
+	}
+}
+
+func TestSimpleFSDetectContentType(t *testing.T) {
+	cases := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"png", []byte("\x89PNG\r\n\x1a\n" + "restofpngdata"), "image/png"},
+		{"gif", []byte("GIF89a" + "restofgifdata"), "image/gif"},
+		{"plain text", []byte("just some plain text\n"), "text/plain; charset=utf-8"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fake := newFakeSimpleFSReadClient()
+			fake.files["/keybase/private/alice/f"] = c.data
+
+			path := keybase1.NewPathWithKbfs("/keybase/private/alice/f")
+			dirent := keybase1.Dirent{Name: "f", DirentType: keybase1.DirentType_FILE}
+
+			got, err := simpleFSDetectContentType(context.Background(), fake, path, dirent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != c.want {
+				t.Fatalf("simpleFSDetectContentType() = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestSimpleFSDetectContentTypeSkipsDirsAndSymlinks(t *testing.T) {
+	fake := newFakeSimpleFSReadClient()
+	path := keybase1.NewPathWithKbfs("/keybase/private/alice")
+
+	for _, dt := range []keybase1.DirentType{keybase1.DirentType_DIR, keybase1.DirentType_SYM} {
+		dirent := keybase1.Dirent{Name: "alice", DirentType: dt}
+		got, err := simpleFSDetectContentType(context.Background(), fake, path, dirent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != "" {
+			t.Fatalf("expected no content type for DirentType %v, got %q", dt, got)
+		}
+	}
+}
+
+// countingSimpleFSStatClient is a keybase1.SimpleFSInterface whose
+// SimpleFSStat tracks how many calls are in flight at once (to verify
+// simpleFSBatchStat actually parallelizes, and respects its concurrency
+// bound) and returns an error for any path listed in failPaths.
+type countingSimpleFSStatClient struct {
+	keybase1.SimpleFSInterface // panics on anything not overridden below
+
+	failPaths map[string]bool
+
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+}
+
+func (f *countingSimpleFSStatClient) SimpleFSStat(ctx context.Context, p keybase1.Path) (keybase1.Dirent, error) {
+	f.mu.Lock()
+	f.inFlight++
+	if f.inFlight > f.maxInFlight {
+		f.maxInFlight = f.inFlight
+	}
+	f.mu.Unlock()
+
+	// Give other goroutines a chance to pile up, so maxInFlight reflects
+	// real overlap instead of accidental serialization.
+	time.Sleep(5 * time.Millisecond)
+
+	f.mu.Lock()
+	f.inFlight--
+	f.mu.Unlock()
+
+	kbfsPath := p.Kbfs()
+	if f.failPaths[kbfsPath] {
+		return keybase1.Dirent{}, fmt.Errorf("stat failed for %s", kbfsPath)
+	}
+	return keybase1.Dirent{Name: kbfsPath, Size: len(kbfsPath)}, nil
+}
+
+func TestSimpleFSBatchStatAssociatesResultsByPath(t *testing.T) {
+	fake := &countingSimpleFSStatClient{failPaths: map[string]bool{}}
+
+	var paths []keybase1.Path
+	for i := 0; i < 20; i++ {
+		paths = append(paths, keybase1.NewPathWithKbfs(fmt.Sprintf("/keybase/private/alice/f%d", i)))
+	}
+
+	results := simpleFSBatchStat(context.Background(), fake, paths)
+	if len(results) != len(paths) {
+		t.Fatalf("got %d results, want %d", len(results), len(paths))
+	}
+	for i, res := range results {
+		if res.Path != paths[i] {
+			t.Fatalf("result %d has path %+v, want %+v", i, res.Path, paths[i])
+		}
+		if res.Err != nil {
+			t.Fatalf("result %d: unexpected error: %v", i, res.Err)
+		}
+		if res.Dirent.Name != paths[i].Kbfs() {
+			t.Fatalf("result %d: dirent for wrong path: got %q, want %q", i, res.Dirent.Name, paths[i].Kbfs())
+		}
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if fake.maxInFlight <= 1 {
+		t.Errorf("expected simpleFSBatchStat to run stats concurrently, but max in flight was %d", fake.maxInFlight)
+	}
+	if fake.maxInFlight > simpleFSBatchStatConcurrency {
+		t.Errorf("max in flight %d exceeded concurrency bound %d", fake.maxInFlight, simpleFSBatchStatConcurrency)
+	}
+}
+
+func TestSimpleFSBatchStatPerEntryErrors(t *testing.T) {
+	goodPath := keybase1.NewPathWithKbfs("/keybase/private/alice/good")
+	badPath := keybase1.NewPathWithKbfs("/keybase/private/alice/bad")
+	fake := &countingSimpleFSStatClient{failPaths: map[string]bool{badPath.Kbfs(): true}}
+
+	results := simpleFSBatchStat(context.Background(), fake, []keybase1.Path{goodPath, badPath})
+
+	if results[0].Err != nil {
+		t.Errorf("expected no error for %s, got %v", goodPath.Kbfs(), results[0].Err)
+	}
+	if results[1].Err == nil {
+		t.Errorf("expected an error for %s", badPath.Kbfs())
+	}
+}
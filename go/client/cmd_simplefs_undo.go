@@ -0,0 +1,100 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/cli"
+	"github.com/keybase/client/go/libcmdline"
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// CmdSimpleFSUndo is the 'fs undo' command. It restores the most recent
+// soft delete CmdSimpleFSRemove recorded in the local undo log, moving the
+// file back from .trash to where it was removed from.
+type CmdSimpleFSUndo struct {
+	libkb.Contextified
+}
+
+func newCmdSimpleFSUndo(cl *libcmdline.CommandLine, g *libkb.GlobalContext) cli.Command {
+	return cli.Command{
+		Name:  "undo",
+		Usage: "Restore the last file removed by `fs rm` (without --permanent)",
+		Action: func(c *cli.Context) {
+			cl.ChooseCommand(&CmdSimpleFSUndo{Contextified: libkb.NewContextified(g)}, "undo", c)
+		},
+	}
+}
+
+func (c *CmdSimpleFSUndo) ParseArgv(ctx *cli.Context) error {
+	if len(ctx.Args()) != 0 {
+		return fmt.Errorf("fs undo takes no arguments")
+	}
+	return nil
+}
+
+func (c *CmdSimpleFSUndo) Run() (err error) {
+	defer func() {
+		err = fsFinishError(c.G(), err)
+	}()
+
+	fsClient, err := GetSimpleFSClient(c.G())
+	if err != nil {
+		return err
+	}
+
+	src, ok, err := simpleFSUndoLast(context.TODO(), fsClient, simpleFSUndoLogPath(c.G()))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("fs undo: nothing to undo")
+	}
+
+	fmt.Fprintf(os.Stdout, "restored %s\n", simpleFSPathString(src))
+	return nil
+}
+
+// simpleFSUndoLast moves the most recent entry in the undo log at logPath
+// back from .trash to where it was removed from, and only then pops the
+// entry off the log. ok is false if the log was empty, in which case
+// there is nothing to undo. If the restoring move fails, the entry is
+// left in the log so the undo can be retried.
+func simpleFSUndoLast(ctx context.Context, cli keybase1.SimpleFSInterface, logPath string) (src keybase1.Path, ok bool, err error) {
+	entry, ok, err := simpleFSPeekUndoEntry(logPath)
+	if err != nil || !ok {
+		return keybase1.Path{}, false, err
+	}
+
+	opid, err := cli.SimpleFSMakeOpid(ctx)
+	if err != nil {
+		return keybase1.Path{}, false, err
+	}
+	defer func() { _ = cli.SimpleFSClose(ctx, opid) }()
+
+	if err := cli.SimpleFSMove(ctx, keybase1.SimpleFSMoveArg{OpID: opid, Src: entry.Dest, Dest: entry.Src}); err != nil {
+		return keybase1.Path{}, false, err
+	}
+	if err := cli.SimpleFSWait(ctx, opid); err != nil {
+		return keybase1.Path{}, false, err
+	}
+
+	if _, _, err := simpleFSPopUndoEntry(logPath); err != nil {
+		return keybase1.Path{}, false, err
+	}
+
+	return entry.Src, true, nil
+}
+
+func (c *CmdSimpleFSUndo) GetUsage() libkb.Usage {
+	return libkb.Usage{
+		Config: true,
+		API:    true,
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"path"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// simpleFSLister abstracts listing a single directory and resolving a path
+// to a canonical, symlink-free key, so that the walker below can be driven
+// either by the real SimpleFS RPCs or, in tests, by a fake in-memory tree.
+type simpleFSLister interface {
+	List(ctx context.Context, dir keybase1.Path) ([]keybase1.Dirent, error)
+
+	// Canonicalize resolves any symlinks in p and returns a cleaned path
+	// that two different paths referring to the same directory will
+	// agree on. It is what lets simpleFSWalk notice that it has already
+	// descended into a directory under a different name.
+	Canonicalize(ctx context.Context, p keybase1.Path) (string, error)
+}
+
+// simpleFSWalkOptions configures simpleFSWalk.
+type simpleFSWalkOptions struct {
+	// FollowSymlinks controls whether the walker descends into entries of
+	// type DirentType_SYM. It is off by default: following an
+	// unrecognized symlink is how a recursive walk turns into an infinite
+	// loop.
+	FollowSymlinks bool
+}
+
+// simpleFSWalkFunc is called once per entry encountered by simpleFSWalk,
+// with the path of the directory containing it.
+type simpleFSWalkFunc func(dir keybase1.Path, entry keybase1.Dirent) error
+
+// simpleFSWalk recursively visits every entry under root using lister,
+// calling fn for each one. It is the shared cycle-guarded walker that
+// recursive fs commands (find, recent, info, checksum -r, sync, and mv -r's
+// verification) drive their own logic through via fn, rather than each
+// re-implementing recursion and symlink handling. du is the exception: it
+// keeps its own recursive helper, simpleFSDuSize, to feed subtotals back up.
+//
+// Before descending into a directory (or, with FollowSymlinks, a
+// symlink), the walk resolves its canonical path via lister.Canonicalize
+// and checks whether that path is already an ancestor of the current
+// descent. If so, it calls warn with a description of the cycle and skips
+// it instead of recursing.
+func simpleFSWalk(ctx context.Context, lister simpleFSLister, root keybase1.Path,
+	opts simpleFSWalkOptions, warn func(format string, args ...interface{}), fn simpleFSWalkFunc) error {
+	return simpleFSWalkRecurse(ctx, lister, root, opts, map[string]bool{}, warn, fn)
+}
+
+func simpleFSWalkRecurse(ctx context.Context, lister simpleFSLister, dir keybase1.Path,
+	opts simpleFSWalkOptions, ancestors map[string]bool, warn func(format string, args ...interface{}),
+	fn simpleFSWalkFunc) error {
+
+	canon, err := lister.Canonicalize(ctx, dir)
+	if err != nil {
+		return err
+	}
+	if ancestors[canon] {
+		warn("fs: cycle detected at %q; skipping", canon)
+		return nil
+	}
+	ancestors[canon] = true
+	defer delete(ancestors, canon)
+
+	entries, err := lister.List(ctx, dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := fn(dir, entry); err != nil {
+			return err
+		}
+
+		descend := entry.DirentType == keybase1.DirentType_DIR ||
+			(entry.DirentType == keybase1.DirentType_SYM && opts.FollowSymlinks)
+		if !descend {
+			continue
+		}
+
+		child := simpleFSChildPath(dir, entry.Name)
+		if err := simpleFSWalkRecurse(ctx, lister, child, opts, ancestors, warn, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// simpleFSChildPath builds the path for a child named name inside dir,
+// preserving dir's path type (local vs. KBFS).
+func simpleFSChildPath(dir keybase1.Path, name string) keybase1.Path {
+	if simpleFSIsKbfs(dir) {
+		return keybase1.NewPathWithKbfs(path.Join(dir.Kbfs(), name))
+	}
+	return keybase1.NewPathWithLocal(path.Join(dir.Local(), name))
+}
@@ -0,0 +1,130 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"fmt"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// fakeLister is an in-memory simpleFSLister for testing simpleFSWalk. It's
+// keyed by path string, with an explicit symlinks table so tests can set
+// up a symlink whose target resolves (via Canonicalize) to one of its own
+// ancestors, the way a real KBFS readlink would.
+type fakeLister struct {
+	dirs     map[string][]keybase1.Dirent
+	symlinks map[string]string // symlink path -> canonical target path
+}
+
+func (f *fakeLister) List(ctx context.Context, dir keybase1.Path) ([]keybase1.Dirent, error) {
+	return f.dirs[simpleFSPathString(dir)], nil
+}
+
+func (f *fakeLister) Canonicalize(ctx context.Context, p keybase1.Path) (string, error) {
+	s := simpleFSPathString(p)
+	if target, ok := f.symlinks[s]; ok {
+		return target, nil
+	}
+	return s, nil
+}
+
+func TestSimpleFSWalkSkipsSelfReferentialSymlink(t *testing.T) {
+	// /keybase/private/alice/
+	//   loop -> symlink back to /keybase/private/alice (itself)
+	//   real.txt
+	lister := &fakeLister{
+		dirs: map[string][]keybase1.Dirent{
+			"/keybase/private/alice": {
+				{Name: "loop", DirentType: keybase1.DirentType_SYM},
+				{Name: "real.txt", DirentType: keybase1.DirentType_FILE},
+			},
+		},
+		symlinks: map[string]string{
+			"/keybase/private/alice/loop": "/keybase/private/alice",
+		},
+	}
+
+	var warnings []string
+	warn := func(format string, args ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}
+
+	var visited []string
+	fn := func(dir keybase1.Path, entry keybase1.Dirent) error {
+		visited = append(visited, entry.Name)
+		return nil
+	}
+
+	root := keybase1.NewPathWithKbfs("/keybase/private/alice")
+	if err := simpleFSWalk(context.Background(), lister, root, simpleFSWalkOptions{FollowSymlinks: true}, warn, fn); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(visited) != 2 {
+		t.Fatalf("expected to visit 2 entries, got %v", visited)
+	}
+	if len(warnings) != 1 {
+		t.Fatalf("expected exactly one cycle warning, got %v", warnings)
+	}
+}
+
+func TestSimpleFSWalkDoesNotDescendSymlinksByDefault(t *testing.T) {
+	lister := &fakeLister{
+		dirs: map[string][]keybase1.Dirent{
+			"/keybase/private/alice": {
+				{Name: "loop", DirentType: keybase1.DirentType_SYM},
+			},
+		},
+		symlinks: map[string]string{
+			"/keybase/private/alice/loop": "/keybase/private/alice",
+		},
+	}
+
+	var warnings []string
+	warn := func(format string, args ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}
+
+	root := keybase1.NewPathWithKbfs("/keybase/private/alice")
+	err := simpleFSWalk(context.Background(), lister, root, simpleFSWalkOptions{}, warn,
+		func(dir keybase1.Path, entry keybase1.Dirent) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 0 {
+		t.Fatalf("expected no warnings when not following symlinks, got %v", warnings)
+	}
+}
+
+func TestSimpleFSWalkDescendsRealDirectories(t *testing.T) {
+	lister := &fakeLister{
+		dirs: map[string][]keybase1.Dirent{
+			"/keybase/private/alice": {
+				{Name: "sub", DirentType: keybase1.DirentType_DIR},
+			},
+			"/keybase/private/alice/sub": {
+				{Name: "file.txt", DirentType: keybase1.DirentType_FILE},
+			},
+		},
+	}
+
+	var visited []string
+	fn := func(dir keybase1.Path, entry keybase1.Dirent) error {
+		visited = append(visited, entry.Name)
+		return nil
+	}
+
+	root := keybase1.NewPathWithKbfs("/keybase/private/alice")
+	if err := simpleFSWalk(context.Background(), lister, root, simpleFSWalkOptions{}, func(string, ...interface{}) {}, fn); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(visited) != 2 {
+		t.Fatalf("expected to visit 2 entries, got %v", visited)
+	}
+}
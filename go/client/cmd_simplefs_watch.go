@@ -0,0 +1,296 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/cli"
+	"github.com/keybase/client/go/libcmdline"
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/go-framed-msgpack-rpc/rpc"
+)
+
+// simpleFSWatchEventNames maps the FSNotificationType values `fs watch`
+// treats as filesystem changes to the event name it reports. Everything
+// else FSActivity can carry (encrypting, signing, rekeying, a connection
+// status change, ...) is internal KBFS chatter rather than a change to a
+// file, so notifications with those types never reach a watcher.
+var simpleFSWatchEventNames = map[keybase1.FSNotificationType]string{
+	keybase1.FSNotificationType_FILE_CREATED:  "created",
+	keybase1.FSNotificationType_FILE_MODIFIED: "modified",
+	keybase1.FSNotificationType_FILE_DELETED:  "deleted",
+	keybase1.FSNotificationType_FILE_RENAMED:  "renamed",
+}
+
+// simpleFSWatchEvent is the --json representation of a single change `fs
+// watch` reports.
+type simpleFSWatchEvent struct {
+	Type     string `json:"type"`
+	Filename string `json:"filename"`
+	Public   bool   `json:"public"`
+}
+
+// CmdSimpleFSWatch is the 'fs watch' command. It subscribes to the
+// service's push notification stream for KBFS activity and prints
+// create/modify/delete/rename events as they happen, so scripts can react
+// to KBFS changes without polling.
+//
+// KBFS notifications aren't scoped to a path at subscription time -- the
+// service pushes every FSActivity event to every subscriber -- so [path]
+// and --glob are applied locally, against each event's Filename, before
+// it's printed. --interval debounces a burst of rapid changes to the same
+// file (e.g. a large write split across several FSActivity events) into a
+// single printed event instead of one per underlying notification.
+type CmdSimpleFSWatch struct {
+	libkb.Contextified
+	path     string
+	glob     string
+	jsonOut  bool
+	interval time.Duration
+}
+
+func newCmdSimpleFSWatch(cl *libcmdline.CommandLine, g *libkb.GlobalContext) cli.Command {
+	return cli.Command{
+		Name:         "watch",
+		Usage:        "Watch for file create/modify/delete events, until interrupted",
+		ArgumentHelp: "[path]",
+		Action: func(c *cli.Context) {
+			cl.ChooseCommand(&CmdSimpleFSWatch{Contextified: libkb.NewContextified(g)}, "watch", c)
+		},
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "glob",
+				Usage: "Only print events for filenames matching this glob pattern",
+			},
+			cli.BoolFlag{
+				Name:  "json",
+				Usage: "Print each event as a JSON object, one per line, instead of text",
+			},
+			cli.DurationFlag{
+				Name:  "interval",
+				Usage: "Debounce window: coalesce repeated events for the same file into one, printed once this long has passed without another (0 disables debouncing, printing every event immediately)",
+			},
+		},
+	}
+}
+
+func (c *CmdSimpleFSWatch) ParseArgv(ctx *cli.Context) error {
+	if len(ctx.Args()) > 1 {
+		return fmt.Errorf("fs watch takes at most one argument: [path]")
+	}
+	c.path = ctx.Args().First()
+	c.glob = ctx.String("glob")
+	c.jsonOut = ctx.Bool("json")
+	c.interval = ctx.Duration("interval")
+	if c.interval < 0 {
+		return fmt.Errorf("fs watch --interval may not be negative")
+	}
+	return nil
+}
+
+func (c *CmdSimpleFSWatch) Run() (err error) {
+	defer func() {
+		err = fsFinishError(c.G(), err)
+	}()
+
+	events := make(chan keybase1.FSNotification)
+	protocols := []rpc.Protocol{
+		keybase1.NotifyFSProtocol(&simpleFSWatchDisplay{events: events}),
+	}
+	if err := RegisterProtocols(protocols); err != nil {
+		return err
+	}
+
+	notifyCli, err := GetNotifyCtlClient(c.G())
+	if err != nil {
+		return err
+	}
+	ctx := context.TODO()
+	if err := notifyCli.SetNotifications(ctx, keybase1.NotificationChannels{Kbfs: true}); err != nil {
+		return err
+	}
+	// Unsubscribe on the way out, whether we're leaving because of Ctrl-C
+	// or an error, so the daemon stops pushing events to a client that's
+	// no longer listening.
+	defer func() { _ = notifyCli.SetNotifications(ctx, keybase1.NotificationChannels{}) }()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+
+	enc := json.NewEncoder(os.Stdout)
+	debouncer := newSimpleFSWatchDebouncer()
+	flush := func() error {
+		for _, pending := range debouncer.Flush() {
+			if err := c.printEvent(enc, pending.eventType, pending.n); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var timer *time.Timer
+	var timerCh <-chan time.Time
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	for {
+		select {
+		case n := <-events:
+			eventType, ok := c.match(n)
+			if !ok {
+				continue
+			}
+			if c.interval <= 0 {
+				if err := c.printEvent(enc, eventType, n); err != nil {
+					return err
+				}
+				continue
+			}
+			debouncer.Add(eventType, n)
+			if timer == nil {
+				timer = time.NewTimer(c.interval)
+			} else {
+				timer.Reset(c.interval)
+			}
+			timerCh = timer.C
+		case <-timerCh:
+			timerCh = nil
+			if err := flush(); err != nil {
+				return err
+			}
+		case <-sigCh:
+			return nil
+		}
+	}
+}
+
+// match reports whether n is a create/modify/delete/rename event that
+// passes c's path and --glob filters, returning the event name to print
+// for it.
+func (c *CmdSimpleFSWatch) match(n keybase1.FSNotification) (eventType string, ok bool) {
+	if n.StatusCode != keybase1.FSStatusCode_FINISH {
+		return "", false
+	}
+	eventType, ok = simpleFSWatchEventNames[n.NotificationType]
+	if !ok {
+		return "", false
+	}
+	if c.path != "" && !strings.Contains(n.Filename, c.path) {
+		return "", false
+	}
+	if c.glob != "" {
+		matched, err := filepath.Match(c.glob, filepath.Base(n.Filename))
+		if err != nil || !matched {
+			return "", false
+		}
+	}
+	return eventType, true
+}
+
+// simpleFSWatchPendingEvent is a single coalesced event held by
+// simpleFSWatchDebouncer, pairing the event name match already computed
+// with the notification it came from.
+type simpleFSWatchPendingEvent struct {
+	eventType string
+	n         keybase1.FSNotification
+}
+
+// simpleFSWatchDebouncer coalesces a burst of rapid-fire notifications for
+// the same filename into a single event, the way `tail -f`-style watchers
+// collapse several quick writes to one file into one line instead of
+// printing every one. It doesn't drive its own timer -- Run resets a timer
+// on every Add and calls Flush once --interval has passed without
+// another -- so the debounce window itself stays easy to test without a
+// real clock.
+type simpleFSWatchDebouncer struct {
+	order   []string
+	pending map[string]simpleFSWatchPendingEvent
+}
+
+func newSimpleFSWatchDebouncer() *simpleFSWatchDebouncer {
+	return &simpleFSWatchDebouncer{pending: map[string]simpleFSWatchPendingEvent{}}
+}
+
+// Add records the latest event for n.Filename, overwriting whatever was
+// already pending for that file without changing its place in the flush
+// order, since it's still the same burst of changes.
+func (d *simpleFSWatchDebouncer) Add(eventType string, n keybase1.FSNotification) {
+	if _, ok := d.pending[n.Filename]; !ok {
+		d.order = append(d.order, n.Filename)
+	}
+	d.pending[n.Filename] = simpleFSWatchPendingEvent{eventType: eventType, n: n}
+}
+
+// Flush returns every pending event in the order its filename first
+// became pending during this burst, then clears the debouncer for the
+// next one.
+func (d *simpleFSWatchDebouncer) Flush() []simpleFSWatchPendingEvent {
+	events := make([]simpleFSWatchPendingEvent, 0, len(d.order))
+	for _, filename := range d.order {
+		events = append(events, d.pending[filename])
+	}
+	d.order = nil
+	d.pending = map[string]simpleFSWatchPendingEvent{}
+	return events
+}
+
+func (c *CmdSimpleFSWatch) printEvent(enc *json.Encoder, eventType string, n keybase1.FSNotification) error {
+	if c.jsonOut {
+		return enc.Encode(simpleFSWatchEvent{
+			Type:     eventType,
+			Filename: n.Filename,
+			Public:   n.PublicTopLevelFolder,
+		})
+	}
+	_, err := fmt.Fprintf(os.Stdout, "%s\t%s\n", eventType, n.Filename)
+	return err
+}
+
+func (c *CmdSimpleFSWatch) GetUsage() libkb.Usage {
+	return libkb.Usage{
+		Config: true,
+		API:    true,
+	}
+}
+
+// simpleFSWatchDisplay implements keybase1.NotifyFSInterface, forwarding
+// FSActivity notifications to CmdSimpleFSWatch.Run and discarding every
+// other KBFS notification it's handed (sync status and the edit-list and
+// sync-status request/response pairs, none of which describe a file
+// change).
+type simpleFSWatchDisplay struct {
+	events chan keybase1.FSNotification
+}
+
+func (d *simpleFSWatchDisplay) FSActivity(ctx context.Context, n keybase1.FSNotification) error {
+	d.events <- n
+	return nil
+}
+
+func (d *simpleFSWatchDisplay) FSSyncActivity(ctx context.Context, status keybase1.FSPathSyncStatus) error {
+	return nil
+}
+
+func (d *simpleFSWatchDisplay) FSEditListResponse(ctx context.Context, arg keybase1.FSEditListResponseArg) error {
+	return nil
+}
+
+func (d *simpleFSWatchDisplay) FSSyncStatusResponse(ctx context.Context, arg keybase1.FSSyncStatusResponseArg) error {
+	return nil
+}
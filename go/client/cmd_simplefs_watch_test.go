@@ -0,0 +1,141 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+func TestSimpleFSWatchMatchFiltersByTypeAndStatus(t *testing.T) {
+	c := &CmdSimpleFSWatch{}
+
+	// Only FINISH events for file create/modify/delete/rename are events.
+	if _, ok := c.match(keybase1.FSNotification{
+		NotificationType: keybase1.FSNotificationType_FILE_CREATED,
+		StatusCode:       keybase1.FSStatusCode_START,
+	}); ok {
+		t.Fatal("expected a START notification to be filtered out")
+	}
+	if _, ok := c.match(keybase1.FSNotification{
+		NotificationType: keybase1.FSNotificationType_ENCRYPTING,
+		StatusCode:       keybase1.FSStatusCode_FINISH,
+		Filename:         "foo.txt",
+	}); ok {
+		t.Fatal("expected an internal notification type to be filtered out")
+	}
+
+	eventType, ok := c.match(keybase1.FSNotification{
+		NotificationType: keybase1.FSNotificationType_FILE_MODIFIED,
+		StatusCode:       keybase1.FSStatusCode_FINISH,
+		Filename:         "foo.txt",
+	})
+	if !ok || eventType != "modified" {
+		t.Fatalf("expected a modified event, got %q ok=%v", eventType, ok)
+	}
+}
+
+func TestSimpleFSWatchMatchFiltersByPathAndGlob(t *testing.T) {
+	c := &CmdSimpleFSWatch{path: "alice/docs"}
+	n := keybase1.FSNotification{
+		NotificationType: keybase1.FSNotificationType_FILE_CREATED,
+		StatusCode:       keybase1.FSStatusCode_FINISH,
+	}
+
+	n.Filename = "alice/docs/report.txt"
+	if _, ok := c.match(n); !ok {
+		t.Fatal("expected a path under the watched path to match")
+	}
+
+	n.Filename = "alice/photos/cat.png"
+	if _, ok := c.match(n); ok {
+		t.Fatal("expected a path outside the watched path to be filtered out")
+	}
+
+	c = &CmdSimpleFSWatch{glob: "*.txt"}
+	n.Filename = "alice/photos/cat.png"
+	if _, ok := c.match(n); ok {
+		t.Fatal("expected a non-matching glob to be filtered out")
+	}
+	n.Filename = "alice/docs/report.txt"
+	if _, ok := c.match(n); !ok {
+		t.Fatal("expected a matching glob to match")
+	}
+}
+
+func TestSimpleFSWatchPrintEventJSON(t *testing.T) {
+	c := &CmdSimpleFSWatch{jsonOut: true}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	if err := c.printEvent(enc, "created", keybase1.FSNotification{
+		Filename:             "alice/docs/report.txt",
+		PublicTopLevelFolder: true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var evt simpleFSWatchEvent
+	if err := json.Unmarshal(buf.Bytes(), &evt); err != nil {
+		t.Fatalf("output wasn't valid JSON: %v", err)
+	}
+	if evt.Type != "created" || evt.Filename != "alice/docs/report.txt" || !evt.Public {
+		t.Fatalf("unexpected event: %+v", evt)
+	}
+}
+
+func TestSimpleFSWatchDebouncerCoalescesRapidChangesForTheSameFile(t *testing.T) {
+	d := newSimpleFSWatchDebouncer()
+	d.Add("created", keybase1.FSNotification{Filename: "a.txt"})
+	d.Add("modified", keybase1.FSNotification{Filename: "a.txt"})
+	d.Add("modified", keybase1.FSNotification{Filename: "a.txt"})
+
+	events := d.Flush()
+	if len(events) != 1 {
+		t.Fatalf("expected a single coalesced event, got %d: %+v", len(events), events)
+	}
+	if events[0].eventType != "modified" {
+		t.Errorf("expected the latest event type to win, got %q", events[0].eventType)
+	}
+}
+
+func TestSimpleFSWatchDebouncerPreservesArrivalOrderAcrossFiles(t *testing.T) {
+	d := newSimpleFSWatchDebouncer()
+	d.Add("created", keybase1.FSNotification{Filename: "a.txt"})
+	d.Add("created", keybase1.FSNotification{Filename: "b.txt"})
+	d.Add("modified", keybase1.FSNotification{Filename: "a.txt"}) // a.txt keeps its original slot
+	d.Add("deleted", keybase1.FSNotification{Filename: "c.txt"})
+
+	events := d.Flush()
+	if len(events) != 3 {
+		t.Fatalf("expected 3 coalesced events, got %d: %+v", len(events), events)
+	}
+	wantFilenames := []string{"a.txt", "b.txt", "c.txt"}
+	wantTypes := []string{"modified", "created", "deleted"}
+	for i, evt := range events {
+		if evt.n.Filename != wantFilenames[i] || evt.eventType != wantTypes[i] {
+			t.Errorf("event %d: got (%s, %s), want (%s, %s)", i, evt.eventType, evt.n.Filename, wantTypes[i], wantFilenames[i])
+		}
+	}
+}
+
+func TestSimpleFSWatchDebouncerFlushClearsPendingState(t *testing.T) {
+	d := newSimpleFSWatchDebouncer()
+	d.Add("created", keybase1.FSNotification{Filename: "a.txt"})
+	if len(d.Flush()) != 1 {
+		t.Fatal("expected the first flush to return the pending event")
+	}
+	if events := d.Flush(); len(events) != 0 {
+		t.Fatalf("expected an empty debouncer after flushing, got %+v", events)
+	}
+
+	d.Add("modified", keybase1.FSNotification{Filename: "a.txt"})
+	events := d.Flush()
+	if len(events) != 1 || events[0].eventType != "modified" {
+		t.Fatalf("expected a fresh event after the prior flush, got %+v", events)
+	}
+}
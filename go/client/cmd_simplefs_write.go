@@ -0,0 +1,258 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/cli"
+	"github.com/keybase/client/go/libcmdline"
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// simpleFSStdinArg is the <local source> value that tells CmdSimpleFSWrite
+// to read its content from stdin instead of a named file, matching the
+// convention of most unix tools that stream stdin/stdout.
+const simpleFSStdinArg = "-"
+
+// CmdSimpleFSWrite is the 'fs write' command, which copies a local file, or
+// stdin (given "-" or no source at all), into KBFS.
+type CmdSimpleFSWrite struct {
+	libkb.Contextified
+	src         string
+	dest        string
+	makeParents bool
+	onlyNew     bool
+	atomic      bool
+	quiet       bool
+	chunkSize   int64
+	parallel    int
+}
+
+func newCmdSimpleFSWrite(cl *libcmdline.CommandLine, g *libkb.GlobalContext) cli.Command {
+	return cli.Command{
+		Name:         "write",
+		Usage:        "Write a local file, or stdin, into KBFS",
+		ArgumentHelp: "[local source | -] <kbfs destination>",
+		Action: func(c *cli.Context) {
+			cl.ChooseCommand(&CmdSimpleFSWrite{Contextified: libkb.NewContextified(g)}, "write", c)
+		},
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "make-parents",
+				Usage: "Create missing destination parent directories",
+			},
+			cli.BoolFlag{
+				Name:  "n, only-new",
+				Usage: "Fail instead of writing if the destination already exists (create-exclusive; the existence check races against concurrent writers, so it's best-effort, not a true atomic guarantee)",
+			},
+			cli.BoolFlag{
+				Name:  "atomic",
+				Usage: "Write to a temporary file in the destination's directory and rename into place, so readers never observe a partially-written destination",
+			},
+			cli.BoolFlag{
+				Name:  "quiet",
+				Usage: "Suppress the progress display",
+			},
+			cli.StringFlag{
+				Name:  "chunk-size",
+				Usage: "Bytes sent per SimpleFSWrite call (default 128KiB)",
+			},
+			cli.StringFlag{
+				Name:  "parallel",
+				Usage: "Issue this many ranged SimpleFSWrite calls concurrently for a local source file (default 1, no parallelism; ignored when reading from stdin)",
+			},
+		},
+	}
+}
+
+func (c *CmdSimpleFSWrite) ParseArgv(ctx *cli.Context) error {
+	switch len(ctx.Args()) {
+	case 1:
+		// <kbfs destination> alone means "read from stdin", the same as
+		// passing "-" explicitly.
+		c.src = simpleFSStdinArg
+		c.dest = ctx.Args()[0]
+	case 2:
+		c.src = ctx.Args()[0]
+		c.dest = ctx.Args()[1]
+	default:
+		return errors.New("fs write takes one or two arguments: [local source] <kbfs destination>")
+	}
+	c.makeParents = ctx.Bool("make-parents")
+	c.onlyNew = ctx.Bool("only-new")
+	c.atomic = ctx.Bool("atomic")
+	c.quiet = ctx.Bool("quiet")
+
+	chunkSize, err := parseChunkSize(ctx.String("chunk-size"), simpleFSCopyWriteChunkSize)
+	if err != nil {
+		return err
+	}
+	c.chunkSize = chunkSize
+
+	parallel, err := parseParallelism(ctx.String("parallel"))
+	if err != nil {
+		return err
+	}
+	c.parallel = parallel
+	return nil
+}
+
+func (c *CmdSimpleFSWrite) Run() (err error) {
+	start := time.Now()
+	defer func() {
+		simpleFSRecordOp(simpleFSOpsLogPath(c.G()), fmt.Sprintf("write %s -> %s", c.src, c.dest), start, err)
+		err = fsFinishError(c.G(), err)
+	}()
+
+	cli, err := GetSimpleFSClient(c.G())
+	if err != nil {
+		return err
+	}
+
+	dest := simpleFSPathFromArg(c.dest)
+	if !simpleFSIsKbfs(dest) {
+		return errors.New("fs write destination must be a /keybase/... path")
+	}
+
+	ctx := context.TODO()
+	if c.makeParents {
+		if err := simpleFSMkdirParent(ctx, cli, dest); err != nil {
+			return err
+		}
+	}
+
+	if err := c.checkOnlyNew(ctx, cli, dest); err != nil {
+		return err
+	}
+
+	r, totalBytes, closeR, err := c.openSrc()
+	if err != nil {
+		return err
+	}
+	defer closeR()
+
+	printer := newSimpleFSProgressPrinter(c.quiet)
+
+	writeFn := func(dest keybase1.Path) error {
+		if ra, ok := r.(io.ReaderAt); ok && c.parallel > 1 && totalBytes > c.chunkSize {
+			return c.writeAllParallel(ctx, cli, dest, ra, totalBytes, printer)
+		}
+		return simpleFSWriteAll(ctx, cli, dest, r, totalBytes, c.chunkSize, printer)
+	}
+
+	if c.atomic {
+		err = simpleFSAtomicPublish(ctx, cli, dest, time.Now(), writeFn)
+	} else {
+		err = writeFn(dest)
+	}
+	if err != nil {
+		if !c.makeParents && isSimpleFSMissingParentErr(err) {
+			return errDestParentMissing(c.dest)
+		}
+		return err
+	}
+	return nil
+}
+
+// openSrc returns the content CmdSimpleFSWrite should stream into dest: c.src
+// itself, or stdin when c.src is "-", along with its size if known (0 for
+// stdin, where a pipe's length can't be known up front) and a closer that's
+// safe to call unconditionally -- stdin is never actually closed, so a
+// caller piping into more than one command isn't affected by this one
+// finishing first.
+func (c *CmdSimpleFSWrite) openSrc() (r io.Reader, totalBytes int64, closeR func(), err error) {
+	if c.src == simpleFSStdinArg {
+		return os.Stdin, 0, func() {}, nil
+	}
+
+	f, err := os.Open(c.src)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	if info, err := f.Stat(); err == nil {
+		totalBytes = info.Size()
+	}
+	return f, totalBytes, func() { _ = f.Close() }, nil
+}
+
+// simpleFSWriteAll opens dest for writing, replacing any existing content,
+// and streams all of r into it in chunkSize chunks, driving printer with
+// totalBytes (0 if unknown) as it goes.
+func simpleFSWriteAll(ctx context.Context, cli keybase1.SimpleFSInterface, dest keybase1.Path, r io.Reader, totalBytes, chunkSize int64, printer simpleFSProgressUpdater) error {
+	opid, err := cli.SimpleFSMakeOpid(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = cli.SimpleFSClose(ctx, opid) }()
+
+	if err := cli.SimpleFSOpen(ctx, keybase1.SimpleFSOpenArg{
+		OpID:  opid,
+		Dest:  dest,
+		Flags: keybase1.OpenFlags_WRITE | keybase1.OpenFlags_REPLACE,
+	}); err != nil {
+		return err
+	}
+
+	_, err = simpleFSStreamWrite(ctx, cli, opid, 0, r, int(chunkSize), func(written int64) {
+		printer.Update(written, totalBytes)
+	})
+	printer.Finish()
+	return err
+}
+
+// writeAllParallel opens dest for writing, replacing any existing content,
+// and writes all of r into it as c.chunkSize chunks, up to c.parallel of
+// them in flight at once, driving printer with totalBytes as it goes.
+func (c *CmdSimpleFSWrite) writeAllParallel(ctx context.Context, cli keybase1.SimpleFSInterface, dest keybase1.Path, r io.ReaderAt, totalBytes int64, printer simpleFSProgressUpdater) error {
+	opid, err := cli.SimpleFSMakeOpid(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = cli.SimpleFSClose(ctx, opid) }()
+
+	if err := cli.SimpleFSOpen(ctx, keybase1.SimpleFSOpenArg{
+		OpID:  opid,
+		Dest:  dest,
+		Flags: keybase1.OpenFlags_WRITE | keybase1.OpenFlags_REPLACE,
+	}); err != nil {
+		return err
+	}
+
+	err = simpleFSWriteAllParallel(ctx, cli, opid, r, totalBytes, c.chunkSize, c.parallel, func(written int64) {
+		printer.Update(written, totalBytes)
+	})
+	printer.Finish()
+	return err
+}
+
+// checkOnlyNew enforces --only-new's create-exclusive semantics: if it's
+// set and dest already exists, the write is refused rather than silently
+// overwriting it. The existence check (SimpleFSStat) happens as close to
+// the write as this client can manage, but a backend without true O_EXCL
+// support can still race a concurrent creator in between, so this is
+// best-effort rather than atomic.
+func (c *CmdSimpleFSWrite) checkOnlyNew(ctx context.Context, cli keybase1.SimpleFSInterface, dest keybase1.Path) error {
+	if !c.onlyNew {
+		return nil
+	}
+	if simpleFSExists(ctx, cli, dest) {
+		return fmt.Errorf("fs write: %s already exists (refusing to overwrite due to --only-new)", c.dest)
+	}
+	return nil
+}
+
+func (c *CmdSimpleFSWrite) GetUsage() libkb.Usage {
+	return libkb.Usage{
+		Config: true,
+		API:    true,
+	}
+}
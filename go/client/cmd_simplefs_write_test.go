@@ -0,0 +1,133 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// fakeSimpleFSStatClient is a minimal keybase1.SimpleFSInterface whose
+// SimpleFSStat succeeds (dest exists) or fails (dest doesn't exist),
+// depending on exists.
+type fakeSimpleFSStatClient struct {
+	keybase1.SimpleFSInterface // panics on anything not overridden below
+
+	exists bool
+}
+
+func (f *fakeSimpleFSStatClient) SimpleFSStat(ctx context.Context, p keybase1.Path) (keybase1.Dirent, error) {
+	if f.exists {
+		return keybase1.Dirent{}, nil
+	}
+	return keybase1.Dirent{}, errors.New("file does not exist")
+}
+
+func TestSimpleFSWriteOnlyNewRefusesExistingDestination(t *testing.T) {
+	c := &CmdSimpleFSWrite{onlyNew: true, dest: "/keybase/private/alice/file.txt"}
+	dest := simpleFSPathFromArg(c.dest)
+
+	err := c.checkOnlyNew(context.Background(), &fakeSimpleFSStatClient{exists: true}, dest)
+	if err == nil {
+		t.Fatal("expected --only-new to refuse an existing destination")
+	}
+}
+
+func TestSimpleFSWriteOnlyNewAllowsNewDestination(t *testing.T) {
+	c := &CmdSimpleFSWrite{onlyNew: true, dest: "/keybase/private/alice/file.txt"}
+	dest := simpleFSPathFromArg(c.dest)
+
+	err := c.checkOnlyNew(context.Background(), &fakeSimpleFSStatClient{exists: false}, dest)
+	if err != nil {
+		t.Fatalf("expected --only-new to allow a non-existing destination, got %v", err)
+	}
+}
+
+func TestSimpleFSWriteWithoutOnlyNewIgnoresExistingDestination(t *testing.T) {
+	c := &CmdSimpleFSWrite{dest: "/keybase/private/alice/file.txt"}
+	dest := simpleFSPathFromArg(c.dest)
+
+	err := c.checkOnlyNew(context.Background(), &fakeSimpleFSStatClient{exists: true}, dest)
+	if err != nil {
+		t.Fatalf("expected the default (no --only-new) to allow overwriting an existing destination, got %v", err)
+	}
+}
+
+// TestSimpleFSWriteAllStreamsStdinByteForByte pipes a multi-megabyte stream
+// through simpleFSWriteAll the same way "cat big.bin | keybase fs write
+// /keybase/.../big.bin" would, and checks the bytes SimpleFSWrite received
+// match what went in, to catch any chunking bug that corrupts or truncates
+// binary content.
+func TestSimpleFSWriteAllStreamsStdinByteForByte(t *testing.T) {
+	content := make([]byte, 3*simpleFSCopyWriteChunkSize+12345)
+	rand.New(rand.NewSource(1)).Read(content)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_, _ = w.Write(content)
+		w.Close()
+	}()
+
+	fake := &fakeSimpleFSCopyWriteClient{}
+	dest := simpleFSPathFromArg("/keybase/private/alice/big.bin")
+	if err := simpleFSWriteAll(context.Background(), fake, dest, r, 0, simpleFSCopyWriteChunkSize, newSimpleFSProgressPrinter(true)); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(fake.written.Bytes(), content) {
+		t.Fatalf("written content does not match: got %d bytes, want %d bytes", fake.written.Len(), len(content))
+	}
+}
+
+func TestSimpleFSWriteOpenSrcDashReadsStdinWithoutClosingIt(t *testing.T) {
+	origStdin := os.Stdin
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdin = r
+	defer func() { os.Stdin = origStdin }()
+
+	go func() {
+		_, _ = w.Write([]byte("hello from stdin"))
+		w.Close()
+	}()
+
+	c := &CmdSimpleFSWrite{src: simpleFSStdinArg}
+	got, totalBytes, closeR, err := c.openSrc()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != os.Stdin {
+		t.Error("expected openSrc to return os.Stdin for src \"-\"")
+	}
+	if totalBytes != 0 {
+		t.Errorf("expected totalBytes 0 for stdin, got %d", totalBytes)
+	}
+
+	data, err := ioutil.ReadAll(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello from stdin" {
+		t.Errorf("got %q", data)
+	}
+
+	closeR()
+	if _, err := os.Stdin.Read(make([]byte, 1)); err != io.EOF {
+		t.Error("expected os.Stdin to remain open (at EOF, not closed) after closeR")
+	}
+}
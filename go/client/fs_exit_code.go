@@ -0,0 +1,123 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/keybase/client/go/chat"
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// Exit codes for `keybase fs` subcommands. These let scripts wrapping the
+// CLI distinguish failure categories instead of just success/failure, so
+// keep the numbering stable once shipped.
+const (
+	FSExitCodeOK               = keybase1.ExitCode(0)
+	FSExitCodeGenericError     = keybase1.ExitCode(1)
+	FSExitCodeNotFound         = keybase1.ExitCode(2)
+	FSExitCodePermissionNeeded = keybase1.ExitCode(3)
+	FSExitCodeTimeout          = keybase1.ExitCode(4)
+	FSExitCodePartialTransfer  = keybase1.ExitCode(5)
+	FSExitCodeRekeyNeeded      = keybase1.ExitCode(6)
+)
+
+// fsExitCodeForError classifies err into one of the FSExitCode* categories
+// above. The SimpleFS RPC boundary in this tree doesn't carry typed errors,
+// so—like isSimpleFSExistsErr and isSimpleFSMissingParentErr—this falls
+// back to sniffing the error message for local and KBFS-side failures.
+// chat.UnboxingError is checked first since it *is* typed: a rekey-needed
+// error gets its own dedicated code so scripts (and fsRekeyMessage) can
+// treat it as actionable rather than a generic permission failure; any
+// other permanent failure means the data can't be read without new keys
+// (permission needed), while a transient one means the read should be
+// retried.
+func fsExitCodeForError(err error) keybase1.ExitCode {
+	if err == nil {
+		return FSExitCodeOK
+	}
+
+	if uerr, ok := err.(chat.UnboxingError); ok {
+		if isRekeyNeededErr(uerr.Inner()) {
+			return FSExitCodeRekeyNeeded
+		}
+		if uerr.IsPermanent() {
+			return FSExitCodePermissionNeeded
+		}
+		return FSExitCodeTimeout
+	}
+
+	if os.IsNotExist(err) || isSimpleFSMissingParentErr(err) {
+		return FSExitCodeNotFound
+	}
+	if os.IsPermission(err) {
+		return FSExitCodePermissionNeeded
+	}
+
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "not found"), strings.Contains(msg, "no such file"), strings.Contains(msg, "does not exist"):
+		return FSExitCodeNotFound
+	case strings.Contains(msg, "permission"), strings.Contains(msg, "access denied"), strings.Contains(msg, "rekey"):
+		return FSExitCodePermissionNeeded
+	case strings.Contains(msg, "timeout"), strings.Contains(msg, "timed out"), strings.Contains(msg, "deadline exceeded"):
+		return FSExitCodeTimeout
+	case strings.Contains(msg, "partial"):
+		return FSExitCodePartialTransfer
+	}
+
+	return FSExitCodeGenericError
+}
+
+// isRekeyNeededErr reports whether err itself is one of the libkb
+// rekey-needed error types; it doesn't unwrap a chat.UnboxingError, so
+// fsExitCodeForError passes it the unboxing error's Inner().
+func isRekeyNeededErr(err error) bool {
+	switch err.(type) {
+	case libkb.NeedSelfRekeyError, libkb.NeedOtherRekeyError:
+		return true
+	default:
+		return false
+	}
+}
+
+// fsRekeyMessage returns an actionable message for a rekey-needed error, or
+// "" if err isn't one. fs subcommands should print this instead of err's
+// generic message when fsExitCodeForError(err) is FSExitCodeRekeyNeeded.
+func fsRekeyMessage(err error) string {
+	var inner error
+	if uerr, ok := err.(chat.UnboxingError); ok {
+		inner = uerr.Inner()
+	} else {
+		inner = err
+	}
+
+	switch e := inner.(type) {
+	case libkb.NeedSelfRekeyError:
+		return fmt.Sprintf("%q needs to be rekeyed; run `keybase rekey` or open it on a provisioned device", e.Tlf)
+	case libkb.NeedOtherRekeyError:
+		return fmt.Sprintf("%q needs to be rekeyed by one of its other members; run `keybase rekey` or open it on a provisioned device", e.Tlf)
+	}
+	return ""
+}
+
+// fsFinishError is called from the deferred error handler of every fs
+// subcommand's Run(). It sets the process exit code for err and, for a
+// rekey-needed error, rewrites err to the actionable message from
+// fsRekeyMessage so that's what gets printed instead of the generic
+// "transient unboxing error: ..." text.
+func fsFinishError(g *libkb.GlobalContext, err error) error {
+	if err == nil {
+		return nil
+	}
+	g.ExitCode = fsExitCodeForError(err)
+	if msg := fsRekeyMessage(err); msg != "" {
+		return errors.New(msg)
+	}
+	return err
+}
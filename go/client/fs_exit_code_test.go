@@ -0,0 +1,121 @@
+// Copyright 2016 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/keybase/client/go/chat"
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+func TestFSExitCodeForError(t *testing.T) {
+	_, statErr := os.Stat("/no/such/file/or/directory/really")
+
+	cases := []struct {
+		name string
+		err  error
+		want keybase1.ExitCode
+	}{
+		{"nil", nil, FSExitCodeOK},
+		{"generic", errors.New("something went wrong"), FSExitCodeGenericError},
+		{"os not exist", statErr, FSExitCodeNotFound},
+		{"missing parent", errDestParentMissing("/keybase/private/alice/foo"), FSExitCodeNotFound},
+		{"not found message", errors.New("no such file or directory"), FSExitCodeNotFound},
+		{"permission message", errors.New("permission denied"), FSExitCodePermissionNeeded},
+		{"rekey message", errors.New("rekey needed to decrypt this folder"), FSExitCodePermissionNeeded},
+		{"timeout message", errors.New("context deadline exceeded"), FSExitCodeTimeout},
+		{"partial message", errors.New("partial transfer: 3 of 5 files copied"), FSExitCodePartialTransfer},
+		{"permanent unboxing error", chat.NewPermanentUnboxingError(errors.New("bad key")), FSExitCodePermissionNeeded},
+		{"transient unboxing error", chat.NewTransientUnboxingError(context.DeadlineExceeded), FSExitCodeTimeout},
+		{
+			"self rekey needed, transient",
+			chat.NewTransientUnboxingError(libkb.NeedSelfRekeyError{Tlf: "alice,bob"}),
+			FSExitCodeRekeyNeeded,
+		},
+		{
+			"other rekey needed, permanent",
+			chat.NewPermanentUnboxingError(libkb.NeedOtherRekeyError{Tlf: "alice,bob"}),
+			FSExitCodeRekeyNeeded,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := fsExitCodeForError(c.err); got != c.want {
+				t.Errorf("fsExitCodeForError(%v) = %v, want %v", c.err, got, c.want)
+			}
+		})
+	}
+}
+
+func TestFSRekeyMessage(t *testing.T) {
+	cases := []struct {
+		name     string
+		err      error
+		wantText string // substring the message must contain, "" if no message expected
+	}{
+		{"generic error", errors.New("boom"), ""},
+		{
+			"self rekey, wrapped in a transient unboxing error",
+			chat.NewTransientUnboxingError(libkb.NeedSelfRekeyError{Tlf: "alice,bob"}),
+			"alice,bob",
+		},
+		{
+			"other rekey, wrapped in a permanent unboxing error",
+			chat.NewPermanentUnboxingError(libkb.NeedOtherRekeyError{Tlf: "alice,bob,charlie"}),
+			"alice,bob,charlie",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			msg := fsRekeyMessage(c.err)
+			if c.wantText == "" {
+				if msg != "" {
+					t.Fatalf("expected no rekey message, got %q", msg)
+				}
+				return
+			}
+			if msg == "" {
+				t.Fatal("expected a rekey message, got none")
+			}
+			if !containsRekeyAction(msg) {
+				t.Fatalf("expected message to be actionable, got %q", msg)
+			}
+			if !strings.Contains(msg, c.wantText) {
+				t.Fatalf("expected message to contain %q, got %q", c.wantText, msg)
+			}
+		})
+	}
+}
+
+func containsRekeyAction(msg string) bool {
+	return strings.Contains(msg, "rekey")
+}
+
+func TestFSFinishErrorRewritesRekeyErrors(t *testing.T) {
+	tc := libkb.SetupTest(t, "fs_exit_code", 1)
+	defer tc.Cleanup()
+
+	injected := chat.NewTransientUnboxingError(libkb.NeedSelfRekeyError{Tlf: "alice,bob", Msg: "need self rekey"})
+	err := fsFinishError(tc.G, injected)
+	if err == nil {
+		t.Fatal("expected a non-nil error")
+	}
+	if err.Error() == injected.Error() {
+		t.Fatal("expected the rekey error to be rewritten to an actionable message")
+	}
+	if !containsRekeyAction(err.Error()) {
+		t.Fatalf("expected an actionable rekey message, got %q", err.Error())
+	}
+	if tc.G.ExitCode != FSExitCodeRekeyNeeded {
+		t.Fatalf("expected exit code %v, got %v", FSExitCodeRekeyNeeded, tc.G.ExitCode)
+	}
+}
@@ -677,6 +677,14 @@ func (v VerificationError) Error() string {
 
 //=============================================================================
 
+type NoncanonicalSignatureError struct{}
+
+func (n NoncanonicalSignatureError) Error() string {
+	return "Signature's S component is not in canonical (reduced) form"
+}
+
+//=============================================================================
+
 type NoKeyringsError struct{}
 
 func (k NoKeyringsError) Error() string {
@@ -1180,9 +1180,9 @@ func (mc *MerkleClient) LastRootToSigJSON() (ret *jsonw.Wrapper, err error) {
 }
 
 // Can return (nil, nil) if no root is known.
-func (mc *MerkleClient) LastRootInfo() (*chat1.MerkleRoot, error) {
+func (mc *MerkleClient) LastRootInfo(ctx context.Context) (*chat1.MerkleRoot, error) {
 	// Lazy-init, only when needed.
-	err := mc.init(context.TODO())
+	err := mc.init(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -19,6 +19,40 @@ import (
 
 type NaclSignature [ed25519.SignatureSize]byte
 
+// ed25519GroupOrder is the little-endian encoding of the order of the
+// ed25519 base point (the same L used internally by agl/ed25519's scalar
+// reduction). A signature's S component -- the second 32 bytes of a
+// NaclSignature -- is only unique modulo this order: S and S+L verify
+// identically, since scalar multiplication by either produces the same
+// curve point. hasCanonicalS rejects any S that isn't already reduced
+// below L, closing off that malleability rather than relying on callers
+// to detect a re-encoded duplicate some other way (e.g. body-hash
+// uniqueness).
+var ed25519GroupOrder = [32]byte{
+	0xed, 0xd3, 0xf5, 0x5c, 0x1a, 0x63, 0x12, 0x58,
+	0xd6, 0x9c, 0xf7, 0xa2, 0xde, 0xf9, 0xde, 0x14,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x10,
+}
+
+// hasCanonicalS reports whether sig's S component is already reduced
+// modulo ed25519GroupOrder, i.e. whether sig is the unique canonical
+// encoding of its (R, S mod L) pair rather than one of the other S+k*L
+// variants that verify identically.
+func (sig NaclSignature) hasCanonicalS() bool {
+	s := sig[32:]
+	for i := 31; i >= 0; i-- {
+		switch {
+		case s[i] > ed25519GroupOrder[i]:
+			return false
+		case s[i] < ed25519GroupOrder[i]:
+			return true
+		}
+	}
+	// s == ed25519GroupOrder exactly, which is not < the order.
+	return false
+}
+
 type NaclSigInfo struct {
 	Kid      keybase1.BinaryKID `codec:"key"`
 	Payload  []byte             `codec:"payload,omitempty"`
@@ -487,6 +521,10 @@ func (s NaclSigInfo) Verify() (*NaclSigningKeyPublic, error) {
 		return nil, BadKeyError{}
 	}
 
+	if !s.Sig.hasCanonicalS() {
+		return nil, NoncanonicalSignatureError{}
+	}
+
 	switch s.Version {
 	case 0, 1:
 		if !key.Verify(s.Payload, &s.Sig) {
@@ -278,3 +278,71 @@ func TestNaclPrefixedSigs(t *testing.T) {
 		t.Fatal("expected a BadSignaturePrefixError")
 	}
 }
+
+func TestNaclSignatureHasCanonicalS(t *testing.T) {
+	var zero NaclSignature
+	if !zero.hasCanonicalS() {
+		t.Fatal("S = 0 should be canonical")
+	}
+
+	var maxCanonical NaclSignature
+	copy(maxCanonical[32:], ed25519GroupOrder[:])
+	maxCanonical[32]-- // ed25519GroupOrder - 1, the largest canonical S
+	if !maxCanonical.hasCanonicalS() {
+		t.Fatal("S = order - 1 should be canonical")
+	}
+
+	var atOrder NaclSignature
+	copy(atOrder[32:], ed25519GroupOrder[:])
+	if atOrder.hasCanonicalS() {
+		t.Fatal("S = order should not be canonical")
+	}
+
+	var pastOrder NaclSignature
+	copy(pastOrder[32:], ed25519GroupOrder[:])
+	pastOrder[63]++ // order's top byte plus one, clearly past the order
+	if pastOrder.hasCanonicalS() {
+		t.Fatal("S = order + 2^248 should not be canonical")
+	}
+}
+
+// Ed25519 verification checks R and S against the curve equation, but S
+// is only meaningful modulo the base point's order: given a valid
+// signature (R, S), (R, S+order) verifies identically, since scalar
+// multiplication by either produces the same point. Test that Verify
+// rejects that re-encoded duplicate instead of accepting it as a second
+// valid signature over the same message and key.
+func TestNaclSigVerifyRejectsMalleatedSignature(t *testing.T) {
+	keyPair, err := GenerateNaclSigningKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := []byte("test message")
+	sig, err := keyPair.SignV2(msg, SignaturePrefixChat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := sig.Verify(); err != nil {
+		t.Fatalf("canonical signature unexpectedly failed to verify: %s", err)
+	}
+
+	// Add the group order to S, carrying across bytes, to build a second
+	// signature that's mathematically equivalent to the original but
+	// isn't in canonical form.
+	var carry uint16
+	for i := 32; i < 64; i++ {
+		sum := uint16(sig.Sig[i]) + uint16(ed25519GroupOrder[i-32]) + carry
+		sig.Sig[i] = byte(sum)
+		carry = sum >> 8
+	}
+
+	_, err = sig.Verify()
+	if err == nil {
+		t.Fatal("expected the malleated (non-canonical) signature to be rejected")
+	}
+	if _, ok := err.(NoncanonicalSignatureError); !ok {
+		t.Fatalf("expected a NoncanonicalSignatureError, got %T: %s", err, err)
+	}
+}
@@ -220,18 +220,19 @@ type OutboxInfo struct {
 }
 
 type MessageClientHeader struct {
-	Conv         ConversationIDTriple     `codec:"conv" json:"conv"`
-	TlfName      string                   `codec:"tlfName" json:"tlfName"`
-	TlfPublic    bool                     `codec:"tlfPublic" json:"tlfPublic"`
-	MessageType  MessageType              `codec:"messageType" json:"messageType"`
-	Supersedes   MessageID                `codec:"supersedes" json:"supersedes"`
-	Deletes      []MessageID              `codec:"deletes" json:"deletes"`
-	Prev         []MessagePreviousPointer `codec:"prev" json:"prev"`
-	Sender       gregor1.UID              `codec:"sender" json:"sender"`
-	SenderDevice gregor1.DeviceID         `codec:"senderDevice" json:"senderDevice"`
-	MerkleRoot   *MerkleRoot              `codec:"merkleRoot,omitempty" json:"merkleRoot,omitempty"`
-	OutboxID     *OutboxID                `codec:"outboxID,omitempty" json:"outboxID,omitempty"`
-	OutboxInfo   *OutboxInfo              `codec:"outboxInfo,omitempty" json:"outboxInfo,omitempty"`
+	Conv              ConversationIDTriple     `codec:"conv" json:"conv"`
+	TlfName           string                   `codec:"tlfName" json:"tlfName"`
+	TlfPublic         bool                     `codec:"tlfPublic" json:"tlfPublic"`
+	MessageType       MessageType              `codec:"messageType" json:"messageType"`
+	Supersedes        MessageID                `codec:"supersedes" json:"supersedes"`
+	Deletes           []MessageID              `codec:"deletes" json:"deletes"`
+	Prev              []MessagePreviousPointer `codec:"prev" json:"prev"`
+	Sender            gregor1.UID              `codec:"sender" json:"sender"`
+	SenderDevice      gregor1.DeviceID         `codec:"senderDevice" json:"senderDevice"`
+	MerkleRoot        *MerkleRoot              `codec:"merkleRoot,omitempty" json:"merkleRoot,omitempty"`
+	OutboxID          *OutboxID                `codec:"outboxID,omitempty" json:"outboxID,omitempty"`
+	OutboxInfo        *OutboxInfo              `codec:"outboxInfo,omitempty" json:"outboxInfo,omitempty"`
+	EphemeralLifetime *gregor1.DurationMsec    `codec:"ephemeralLifetime,omitempty" json:"ephemeralLifetime,omitempty"`
 }
 
 type EncryptedData struct {
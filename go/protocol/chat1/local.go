@@ -567,11 +567,44 @@ type HeaderPlaintextV1 struct {
 	HeaderSignature *SignatureInfo           `codec:"headerSignature,omitempty" json:"headerSignature,omitempty"`
 }
 
+type HeaderPlaintextV2 struct {
+	Conv            ConversationIDTriple     `codec:"conv" json:"conv"`
+	TlfName         string                   `codec:"tlfName" json:"tlfName"`
+	TlfPublic       bool                     `codec:"tlfPublic" json:"tlfPublic"`
+	MessageType     MessageType              `codec:"messageType" json:"messageType"`
+	Prev            []MessagePreviousPointer `codec:"prev" json:"prev"`
+	Sender          gregor1.UID              `codec:"sender" json:"sender"`
+	SenderDevice    gregor1.DeviceID         `codec:"senderDevice" json:"senderDevice"`
+	BodyHash        Hash                     `codec:"bodyHash" json:"bodyHash"`
+	OutboxInfo      *OutboxInfo              `codec:"outboxInfo,omitempty" json:"outboxInfo,omitempty"`
+	OutboxID        *OutboxID                `codec:"outboxID,omitempty" json:"outboxID,omitempty"`
+	HeaderSignature *SignatureInfo           `codec:"headerSignature,omitempty" json:"headerSignature,omitempty"`
+}
+
+// HeaderPlaintextV3 is identical to HeaderPlaintextV2 except for the
+// addition of EphemeralLifetime, which marks a message as exploding: once
+// EphemeralLifetime has elapsed since the server accepted the message,
+// unboxing suppresses its body (see Boxer.UnboxMessage).
+type HeaderPlaintextV3 struct {
+	Conv              ConversationIDTriple     `codec:"conv" json:"conv"`
+	TlfName           string                   `codec:"tlfName" json:"tlfName"`
+	TlfPublic         bool                     `codec:"tlfPublic" json:"tlfPublic"`
+	MessageType       MessageType              `codec:"messageType" json:"messageType"`
+	Prev              []MessagePreviousPointer `codec:"prev" json:"prev"`
+	Sender            gregor1.UID              `codec:"sender" json:"sender"`
+	SenderDevice      gregor1.DeviceID         `codec:"senderDevice" json:"senderDevice"`
+	BodyHash          Hash                     `codec:"bodyHash" json:"bodyHash"`
+	OutboxInfo        *OutboxInfo              `codec:"outboxInfo,omitempty" json:"outboxInfo,omitempty"`
+	OutboxID          *OutboxID                `codec:"outboxID,omitempty" json:"outboxID,omitempty"`
+	HeaderSignature   *SignatureInfo           `codec:"headerSignature,omitempty" json:"headerSignature,omitempty"`
+	EphemeralLifetime *gregor1.DurationMsec    `codec:"ephemeralLifetime,omitempty" json:"ephemeralLifetime,omitempty"`
+}
+
 type HeaderPlaintext struct {
 	Version__ HeaderPlaintextVersion      `codec:"version" json:"version"`
 	V1__      *HeaderPlaintextV1          `codec:"v1,omitempty" json:"v1,omitempty"`
-	V2__      *HeaderPlaintextUnsupported `codec:"v2,omitempty" json:"v2,omitempty"`
-	V3__      *HeaderPlaintextUnsupported `codec:"v3,omitempty" json:"v3,omitempty"`
+	V2__      *HeaderPlaintextV2          `codec:"v2,omitempty" json:"v2,omitempty"`
+	V3__      *HeaderPlaintextV3          `codec:"v3,omitempty" json:"v3,omitempty"`
 	V4__      *HeaderPlaintextUnsupported `codec:"v4,omitempty" json:"v4,omitempty"`
 	V5__      *HeaderPlaintextUnsupported `codec:"v5,omitempty" json:"v5,omitempty"`
 	V6__      *HeaderPlaintextUnsupported `codec:"v6,omitempty" json:"v6,omitempty"`
@@ -647,22 +680,22 @@ func (o HeaderPlaintext) V1() HeaderPlaintextV1 {
 	return *o.V1__
 }
 
-func (o HeaderPlaintext) V2() HeaderPlaintextUnsupported {
+func (o HeaderPlaintext) V2() HeaderPlaintextV2 {
 	if o.Version__ != HeaderPlaintextVersion_V2 {
 		panic("wrong case accessed")
 	}
 	if o.V2__ == nil {
-		return HeaderPlaintextUnsupported{}
+		return HeaderPlaintextV2{}
 	}
 	return *o.V2__
 }
 
-func (o HeaderPlaintext) V3() HeaderPlaintextUnsupported {
+func (o HeaderPlaintext) V3() HeaderPlaintextV3 {
 	if o.Version__ != HeaderPlaintextVersion_V3 {
 		panic("wrong case accessed")
 	}
 	if o.V3__ == nil {
-		return HeaderPlaintextUnsupported{}
+		return HeaderPlaintextV3{}
 	}
 	return *o.V3__
 }
@@ -744,14 +777,14 @@ func NewHeaderPlaintextWithV1(v HeaderPlaintextV1) HeaderPlaintext {
 	}
 }
 
-func NewHeaderPlaintextWithV2(v HeaderPlaintextUnsupported) HeaderPlaintext {
+func NewHeaderPlaintextWithV2(v HeaderPlaintextV2) HeaderPlaintext {
 	return HeaderPlaintext{
 		Version__: HeaderPlaintextVersion_V2,
 		V2__:      &v,
 	}
 }
 
-func NewHeaderPlaintextWithV3(v HeaderPlaintextUnsupported) HeaderPlaintext {
+func NewHeaderPlaintextWithV3(v HeaderPlaintextV3) HeaderPlaintext {
 	return HeaderPlaintext{
 		Version__: HeaderPlaintextVersion_V3,
 		V3__:      &v,
@@ -867,11 +900,19 @@ type BodyPlaintextV1 struct {
 	MessageBody MessageBody `codec:"messageBody" json:"messageBody"`
 }
 
+type BodyPlaintextV2 struct {
+	MessageBody MessageBody `codec:"messageBody" json:"messageBody"`
+}
+
+type BodyPlaintextV3 struct {
+	MessageBody MessageBody `codec:"messageBody" json:"messageBody"`
+}
+
 type BodyPlaintext struct {
 	Version__ BodyPlaintextVersion      `codec:"version" json:"version"`
 	V1__      *BodyPlaintextV1          `codec:"v1,omitempty" json:"v1,omitempty"`
-	V2__      *BodyPlaintextUnsupported `codec:"v2,omitempty" json:"v2,omitempty"`
-	V3__      *BodyPlaintextUnsupported `codec:"v3,omitempty" json:"v3,omitempty"`
+	V2__      *BodyPlaintextV2          `codec:"v2,omitempty" json:"v2,omitempty"`
+	V3__      *BodyPlaintextV3          `codec:"v3,omitempty" json:"v3,omitempty"`
 	V4__      *BodyPlaintextUnsupported `codec:"v4,omitempty" json:"v4,omitempty"`
 	V5__      *BodyPlaintextUnsupported `codec:"v5,omitempty" json:"v5,omitempty"`
 	V6__      *BodyPlaintextUnsupported `codec:"v6,omitempty" json:"v6,omitempty"`
@@ -947,22 +988,22 @@ func (o BodyPlaintext) V1() BodyPlaintextV1 {
 	return *o.V1__
 }
 
-func (o BodyPlaintext) V2() BodyPlaintextUnsupported {
+func (o BodyPlaintext) V2() BodyPlaintextV2 {
 	if o.Version__ != BodyPlaintextVersion_V2 {
 		panic("wrong case accessed")
 	}
 	if o.V2__ == nil {
-		return BodyPlaintextUnsupported{}
+		return BodyPlaintextV2{}
 	}
 	return *o.V2__
 }
 
-func (o BodyPlaintext) V3() BodyPlaintextUnsupported {
+func (o BodyPlaintext) V3() BodyPlaintextV3 {
 	if o.Version__ != BodyPlaintextVersion_V3 {
 		panic("wrong case accessed")
 	}
 	if o.V3__ == nil {
-		return BodyPlaintextUnsupported{}
+		return BodyPlaintextV3{}
 	}
 	return *o.V3__
 }
@@ -1044,14 +1085,14 @@ func NewBodyPlaintextWithV1(v BodyPlaintextV1) BodyPlaintext {
 	}
 }
 
-func NewBodyPlaintextWithV2(v BodyPlaintextUnsupported) BodyPlaintext {
+func NewBodyPlaintextWithV2(v BodyPlaintextV2) BodyPlaintext {
 	return BodyPlaintext{
 		Version__: BodyPlaintextVersion_V2,
 		V2__:      &v,
 	}
 }
 
-func NewBodyPlaintextWithV3(v BodyPlaintextUnsupported) BodyPlaintext {
+func NewBodyPlaintextWithV3(v BodyPlaintextV3) BodyPlaintext {
 	return BodyPlaintext{
 		Version__: BodyPlaintextVersion_V3,
 		V3__:      &v,
@@ -1139,6 +1180,63 @@ func (e MessageUnboxedState) String() string {
 	return ""
 }
 
+type AssuranceLevel int
+
+const (
+	AssuranceLevel_FULL    AssuranceLevel = 0
+	AssuranceLevel_REDUCED AssuranceLevel = 1
+	AssuranceLevel_SUSPECT AssuranceLevel = 2
+)
+
+var AssuranceLevelMap = map[string]AssuranceLevel{
+	"FULL":    0,
+	"REDUCED": 1,
+	"SUSPECT": 2,
+}
+
+var AssuranceLevelRevMap = map[AssuranceLevel]string{
+	0: "FULL",
+	1: "REDUCED",
+	2: "SUSPECT",
+}
+
+func (e AssuranceLevel) String() string {
+	if v, ok := AssuranceLevelRevMap[e]; ok {
+		return v
+	}
+	return ""
+}
+
+type SenderDeviceType int
+
+const (
+	SenderDeviceType_UNKNOWN SenderDeviceType = 0
+	SenderDeviceType_DESKTOP SenderDeviceType = 1
+	SenderDeviceType_MOBILE  SenderDeviceType = 2
+	SenderDeviceType_PAPER   SenderDeviceType = 3
+)
+
+var SenderDeviceTypeMap = map[string]SenderDeviceType{
+	"UNKNOWN": 0,
+	"DESKTOP": 1,
+	"MOBILE":  2,
+	"PAPER":   3,
+}
+
+var SenderDeviceTypeRevMap = map[SenderDeviceType]string{
+	0: "UNKNOWN",
+	1: "DESKTOP",
+	2: "MOBILE",
+	3: "PAPER",
+}
+
+func (e SenderDeviceType) String() string {
+	if v, ok := SenderDeviceTypeRevMap[e]; ok {
+		return v
+	}
+	return ""
+}
+
 type MessageUnboxedValid struct {
 	ClientHeader          MessageClientHeader `codec:"clientHeader" json:"clientHeader"`
 	ServerHeader          MessageServerHeader `codec:"serverHeader" json:"serverHeader"`
@@ -1146,25 +1244,42 @@ type MessageUnboxedValid struct {
 	SenderUsername        string              `codec:"senderUsername" json:"senderUsername"`
 	SenderDeviceName      string              `codec:"senderDeviceName" json:"senderDeviceName"`
 	SenderDeviceType      string              `codec:"senderDeviceType" json:"senderDeviceType"`
+	SenderDeviceTypeEnum  SenderDeviceType    `codec:"senderDeviceTypeEnum" json:"senderDeviceTypeEnum"`
 	HeaderHash            Hash                `codec:"headerHash" json:"headerHash"`
 	HeaderSignature       *SignatureInfo      `codec:"headerSignature,omitempty" json:"headerSignature,omitempty"`
 	SenderDeviceRevokedAt *gregor1.Time       `codec:"senderDeviceRevokedAt,omitempty" json:"senderDeviceRevokedAt,omitempty"`
+	HasMerkleRoot         bool                `codec:"hasMerkleRoot" json:"hasMerkleRoot"`
+	OutboxIDMismatch      bool                `codec:"outboxIDMismatch" json:"outboxIDMismatch"`
+	AssuranceLevel        AssuranceLevel      `codec:"assuranceLevel" json:"assuranceLevel"`
+	HeaderSignedBytes     []byte              `codec:"headerSignedBytes" json:"headerSignedBytes"`
+	IsEphemeral           bool                `codec:"isEphemeral" json:"isEphemeral"`
+	Etime                 *gregor1.Time       `codec:"etime,omitempty" json:"etime,omitempty"`
 }
 
 type MessageUnboxedErrorType int
 
 const (
-	MessageUnboxedErrorType_MISC                MessageUnboxedErrorType = 0
-	MessageUnboxedErrorType_BADVERSION_CRITICAL MessageUnboxedErrorType = 1
-	MessageUnboxedErrorType_BADVERSION          MessageUnboxedErrorType = 2
-	MessageUnboxedErrorType_IDENTIFY            MessageUnboxedErrorType = 3
+	MessageUnboxedErrorType_MISC                 MessageUnboxedErrorType = 0
+	MessageUnboxedErrorType_BADVERSION_CRITICAL  MessageUnboxedErrorType = 1
+	MessageUnboxedErrorType_BADVERSION           MessageUnboxedErrorType = 2
+	MessageUnboxedErrorType_IDENTIFY             MessageUnboxedErrorType = 3
+	MessageUnboxedErrorType_ABSENT               MessageUnboxedErrorType = 4
+	MessageUnboxedErrorType_SENDERKEYNOTACTIVE   MessageUnboxedErrorType = 5
+	MessageUnboxedErrorType_TLFIDMISMATCH        MessageUnboxedErrorType = 6
+	MessageUnboxedErrorType_SENDERDEVICEMISMATCH MessageUnboxedErrorType = 7
+	MessageUnboxedErrorType_SUSPICIOUSMERKLEROOT MessageUnboxedErrorType = 8
 )
 
 var MessageUnboxedErrorTypeMap = map[string]MessageUnboxedErrorType{
-	"MISC":                0,
-	"BADVERSION_CRITICAL": 1,
-	"BADVERSION":          2,
-	"IDENTIFY":            3,
+	"MISC":                 0,
+	"BADVERSION_CRITICAL":  1,
+	"BADVERSION":           2,
+	"IDENTIFY":             3,
+	"ABSENT":               4,
+	"SENDERKEYNOTACTIVE":   5,
+	"TLFIDMISMATCH":        6,
+	"SENDERDEVICEMISMATCH": 7,
+	"SUSPICIOUSMERKLEROOT": 8,
 }
 
 var MessageUnboxedErrorTypeRevMap = map[MessageUnboxedErrorType]string{
@@ -1172,6 +1287,11 @@ var MessageUnboxedErrorTypeRevMap = map[MessageUnboxedErrorType]string{
 	1: "BADVERSION_CRITICAL",
 	2: "BADVERSION",
 	3: "IDENTIFY",
+	4: "ABSENT",
+	5: "SENDERKEYNOTACTIVE",
+	6: "TLFIDMISMATCH",
+	7: "SENDERDEVICEMISMATCH",
+	8: "SUSPICIOUSMERKLEROOT",
 }
 
 func (e MessageUnboxedErrorType) String() string {
@@ -1181,12 +1301,57 @@ func (e MessageUnboxedErrorType) String() string {
 	return ""
 }
 
+type UnboxingErrorCode int
+
+const (
+	UnboxingErrorCode_UNKNOWN            UnboxingErrorCode = 0
+	UnboxingErrorCode_BADSIGNATURE       UnboxingErrorCode = 1
+	UnboxingErrorCode_UNSUPPORTEDVERSION UnboxingErrorCode = 2
+	UnboxingErrorCode_KEYNOTFOUND        UnboxingErrorCode = 3
+	UnboxingErrorCode_DEVICEKEYMISMATCH  UnboxingErrorCode = 4
+	UnboxingErrorCode_FUTUREMERKLEROOT   UnboxingErrorCode = 5
+)
+
+var UnboxingErrorCodeMap = map[string]UnboxingErrorCode{
+	"UNKNOWN":            0,
+	"BADSIGNATURE":       1,
+	"UNSUPPORTEDVERSION": 2,
+	"KEYNOTFOUND":        3,
+	"DEVICEKEYMISMATCH":  4,
+	"FUTUREMERKLEROOT":   5,
+}
+
+var UnboxingErrorCodeRevMap = map[UnboxingErrorCode]string{
+	0: "UNKNOWN",
+	1: "BADSIGNATURE",
+	2: "UNSUPPORTEDVERSION",
+	3: "KEYNOTFOUND",
+	4: "DEVICEKEYMISMATCH",
+	5: "FUTUREMERKLEROOT",
+}
+
+func (e UnboxingErrorCode) String() string {
+	if v, ok := UnboxingErrorCodeRevMap[e]; ok {
+		return v
+	}
+	return ""
+}
+
+type UnboxingErrorDetails struct {
+	Code               UnboxingErrorCode `codec:"code" json:"code"`
+	RejectedKID        []byte            `codec:"rejectedKID,omitempty" json:"rejectedKID,omitempty"`
+	UnsupportedVersion *int              `codec:"unsupportedVersion,omitempty" json:"unsupportedVersion,omitempty"`
+	ClaimedMerkleSeqno *int64            `codec:"claimedMerkleSeqno,omitempty" json:"claimedMerkleSeqno,omitempty"`
+	CachedMerkleSeqno  *int64            `codec:"cachedMerkleSeqno,omitempty" json:"cachedMerkleSeqno,omitempty"`
+}
+
 type MessageUnboxedError struct {
 	ErrType     MessageUnboxedErrorType `codec:"errType" json:"errType"`
 	ErrMsg      string                  `codec:"errMsg" json:"errMsg"`
 	MessageID   MessageID               `codec:"messageID" json:"messageID"`
 	MessageType MessageType             `codec:"messageType" json:"messageType"`
 	Ctime       gregor1.Time            `codec:"ctime" json:"ctime"`
+	Details     *UnboxingErrorDetails   `codec:"details,omitempty" json:"details,omitempty"`
 }
 
 type MessageUnboxed struct {
@@ -10,11 +10,23 @@ import (
 )
 
 type MessageBoxed struct {
-	ServerHeader     *MessageServerHeader `codec:"serverHeader,omitempty" json:"serverHeader,omitempty"`
-	ClientHeader     MessageClientHeader  `codec:"clientHeader" json:"clientHeader"`
-	HeaderCiphertext EncryptedData        `codec:"headerCiphertext" json:"headerCiphertext"`
-	BodyCiphertext   EncryptedData        `codec:"bodyCiphertext" json:"bodyCiphertext"`
-	KeyGeneration    int                  `codec:"keyGeneration" json:"keyGeneration"`
+	ServerHeader         *MessageServerHeader  `codec:"serverHeader,omitempty" json:"serverHeader,omitempty"`
+	ClientHeader         MessageClientHeader   `codec:"clientHeader" json:"clientHeader"`
+	HeaderCiphertext     EncryptedData         `codec:"headerCiphertext" json:"headerCiphertext"`
+	BodyCiphertext       EncryptedData         `codec:"bodyCiphertext" json:"bodyCiphertext"`
+	BodyCiphertextChunks []BodyCiphertextChunk `codec:"bodyCiphertextChunks" json:"bodyCiphertextChunks"`
+	KeyGeneration        int                   `codec:"keyGeneration" json:"keyGeneration"`
+}
+
+type BodyCiphertextChunkHeader struct {
+	Index int  `codec:"index" json:"index"`
+	Total int  `codec:"total" json:"total"`
+	Hash  Hash `codec:"hash" json:"hash"`
+}
+
+type BodyCiphertextChunk struct {
+	Header     BodyCiphertextChunkHeader `codec:"header" json:"header"`
+	Ciphertext EncryptedData             `codec:"ciphertext" json:"ciphertext"`
 }
 
 type ThreadViewBoxed struct {